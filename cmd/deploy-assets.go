@@ -2,32 +2,128 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"io"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"time"
 
+	"github.com/mrshanahan/deploy-assets/pkg/gc"
 	"github.com/mrshanahan/deploy-assets/pkg/manifest"
 	"github.com/mrshanahan/deploy-assets/pkg/runner"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "gc" {
+		runGC(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "rollback" {
+		runRollback(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "restore" {
+		runRestore(os.Args[2:])
+		return
+	}
+
 	var manifestParam *string = flag.String("manifest", "", "local manifest to use for deployment")
 	var debugParam *bool = flag.Bool("debug", false, "Enables debug logging")
 	var dryRunParam *bool = flag.Bool("dry-run", false, "Performs a dry run (no actual copies)")
 	var continueOnErrorParam *bool = flag.Bool("continue-on-error", false, "If a particular asset fails, continue with remaining")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags]\n       %s gc [flags]\n       %s rollback [flags]\n       %s restore [flags]\n\nFlags:\n", os.Args[0], os.Args[0], os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
 	if *debugParam {
 		slog.SetLogLoggerLevel(slog.LevelDebug)
 	}
 
-	if *manifestParam == "" {
+	manifest := loadManifest(*manifestParam)
+	summary, err := runner.Execute(manifest, *dryRunParam, *continueOnErrorParam)
+	summary.Write(os.Stdout)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+func runGC(args []string) {
+	flags := flag.NewFlagSet("gc", flag.ExitOnError)
+	var manifestParam *string = flags.String("manifest", "", "local manifest whose locations & transports to clean up")
+	var debugParam *bool = flags.Bool("debug", false, "Enables debug logging")
+	var dryRunParam *bool = flags.Bool("dry-run", false, "Lists what would be removed without removing it")
+	var olderThanParam *time.Duration = flags.Duration("older-than", 24*time.Hour, "Only remove what hasn't been modified for this long")
+	flags.Parse(args)
+
+	if *debugParam {
+		slog.SetLogLoggerLevel(slog.LevelDebug)
+	}
+
+	manifest := loadManifest(*manifestParam)
+	if err := gc.Collect(manifest, *olderThanParam, *dryRunParam); err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+func runRollback(args []string) {
+	flags := flag.NewFlagSet("rollback", flag.ExitOnError)
+	var manifestParam *string = flags.String("manifest", "", "local manifest the asset is deployed by")
+	var assetParam *string = flags.String("asset", "", "name of the asset to roll back")
+	var toParam *string = flags.String("to", "", "id of the release to make current again")
+	var debugParam *bool = flags.Bool("debug", false, "Enables debug logging")
+	var dryRunParam *bool = flags.Bool("dry-run", false, "Shows what would be switched without switching it")
+	flags.Parse(args)
+
+	if *debugParam {
+		slog.SetLogLoggerLevel(slog.LevelDebug)
+	}
+	if *assetParam == "" || *toParam == "" {
+		slog.Error("-asset & -to params required")
+		os.Exit(1)
+	}
+
+	manifest := loadManifest(*manifestParam)
+	if err := runner.Rollback(manifest, *assetParam, *toParam, *dryRunParam); err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+func runRestore(args []string) {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	var manifestParam *string = flags.String("manifest", "", "local manifest the asset is deployed by")
+	var assetParam *string = flags.String("asset", "", "name of the asset to restore")
+	var fromParam *string = flags.String("from", "", "id of the backup to restore (defaults to the latest)")
+	var debugParam *bool = flags.Bool("debug", false, "Enables debug logging")
+	var dryRunParam *bool = flags.Bool("dry-run", false, "Lists what would be restored without restoring it")
+	flags.Parse(args)
+
+	if *debugParam {
+		slog.SetLogLoggerLevel(slog.LevelDebug)
+	}
+	if *assetParam == "" {
+		slog.Error("-asset param required")
+		os.Exit(1)
+	}
+
+	manifest := loadManifest(*manifestParam)
+	if err := runner.Restore(manifest, *assetParam, *fromParam, *dryRunParam); err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+func loadManifest(manifestFilePath string) *manifest.Manifest {
+	if manifestFilePath == "" {
 		slog.Error("-manifest param required")
 		os.Exit(1)
 	}
 
-	manifestFilePath := *manifestParam
 	manifestFile, err := os.Open(manifestFilePath)
 	if err != nil {
 		slog.Error("failed to open manifest file", "path", manifestFilePath, "err", err)
@@ -52,14 +148,10 @@ func main() {
 		os.Exit(1)
 	}
 
-	manifest, err := manifest.BuildManifest(manifestDir, parsedManifest)
+	m, err := manifest.BuildManifest(manifestDir, parsedManifest)
 	if err != nil {
 		slog.Error("failed to configure application from manifest", "path", manifestFilePath, "err", err)
 		os.Exit(1)
 	}
-
-	if err := runner.Execute(manifest, *dryRunParam, *continueOnErrorParam); err != nil {
-		slog.Error(err.Error())
-		os.Exit(1)
-	}
+	return m
 }
@@ -0,0 +1,335 @@
+// Package s3client is a minimal S3 API client covering the handful of object
+// operations used by the s3 transport, signed with SigV4.
+package s3client
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parts must be at least 5 MiB (other than the last), & there can be at most
+// 10,000 of them, so this covers objects up to ~78 GiB.
+const DefaultPartSize int64 = 8 * 1024 * 1024
+
+type Client struct {
+	endpoint   *url.URL
+	pathStyle  bool
+	region     string
+	creds      Credentials
+	httpClient *http.Client
+	// Size of the parts that Upload splits large objects into.
+	PartSize int64
+}
+
+// Creates a client for the given endpoint. With no endpoint, the regional AWS
+// endpoint is used with virtual-hosted-style addressing (bucket in the host);
+// other endpoints, like MinIO, get path-style addressing (bucket in the path),
+// which S3-compatible stores support more consistently.
+func New(endpoint string, region string, creds Credentials) (*Client, error) {
+	pathStyle := true
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+		pathStyle = false
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint %s: %w", endpoint, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid S3 endpoint %s: scheme must be http or https", endpoint)
+	}
+	return &Client{
+		endpoint:   u,
+		pathStyle:  pathStyle,
+		region:     region,
+		creds:      creds,
+		httpClient: &http.Client{},
+		PartSize:   DefaultPartSize,
+	}, nil
+}
+
+func (c *Client) Region() string { return c.region }
+
+// ResponseError is returned for any non-2xx response from S3.
+type ResponseError struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *ResponseError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("S3 request failed with status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("S3 request failed with status %d: %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+func (c *Client) HeadBucket(bucket string) error {
+	resp, err := c.do(http.MethodHead, bucket, "", nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (c *Client) PutObject(bucket, key string, body []byte) error {
+	resp, err := c.do(http.MethodPut, bucket, key, nil, body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Caller must close the returned body.
+func (c *Client) GetObject(bucket, key string) (io.ReadCloser, error) {
+	resp, err := c.do(http.MethodGet, bucket, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (c *Client) DeleteObject(bucket, key string) error {
+	resp, err := c.do(http.MethodDelete, bucket, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+type Object struct {
+	Key          string
+	LastModified time.Time
+	Size         int64
+}
+
+// Lists every object whose key starts with prefix, across as many pages as
+// it takes.
+func (c *Client) ListObjects(bucket, prefix string) ([]Object, error) {
+	objects := []Object{}
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := c.do(http.MethodGet, bucket, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Contents              []Object
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return nil, fmt.Errorf("failed to parse object listing: %w", err)
+		}
+		objects = append(objects, result.Contents...)
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// Uploads everything read from r, without needing to know its size up front.
+// Anything that fits in a single part is sent with a plain PUT; otherwise it
+// goes through a multipart upload, which is aborted if anything fails.
+func (c *Client) Upload(bucket, key string, r io.Reader) error {
+	first, err := readPart(r, c.PartSize)
+	if err != nil {
+		return err
+	}
+	if int64(len(first)) < c.PartSize {
+		return c.PutObject(bucket, key, first)
+	}
+
+	uploadID, err := c.createMultipartUpload(bucket, key)
+	if err != nil {
+		return err
+	}
+	parts := []completedPart{}
+	part := first
+	for partNumber := 1; len(part) > 0; partNumber++ {
+		etag, err := c.uploadPart(bucket, key, uploadID, partNumber, part)
+		if err != nil {
+			c.abortMultipartUpload(bucket, key, uploadID)
+			return err
+		}
+		parts = append(parts, completedPart{partNumber, etag})
+
+		if part, err = readPart(r, c.PartSize); err != nil {
+			c.abortMultipartUpload(bucket, key, uploadID)
+			return err
+		}
+	}
+	if err := c.completeMultipartUpload(bucket, key, uploadID, parts); err != nil {
+		c.abortMultipartUpload(bucket, key, uploadID)
+		return err
+	}
+	return nil
+}
+
+// Returns a URL anyone can use to GET the object until it expires.
+func (c *Client) PresignGetObject(bucket, key string, expires time.Duration) string {
+	return PresignURL(http.MethodGet, c.objectURL(bucket, key, nil), c.creds, c.region, expires, time.Now()).String()
+}
+
+// Returns a URL anyone can use to PUT the object until it expires.
+func (c *Client) PresignPutObject(bucket, key string, expires time.Duration) string {
+	return PresignURL(http.MethodPut, c.objectURL(bucket, key, nil), c.creds, c.region, expires, time.Now()).String()
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+func (c *Client) createMultipartUpload(bucket, key string) (string, error) {
+	resp, err := c.do(http.MethodPost, bucket, key, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to parse multipart upload response: %w", err)
+	}
+	return result.UploadID, nil
+}
+
+func (c *Client) uploadPart(bucket, key, uploadID string, partNumber int, body []byte) (string, error) {
+	query := url.Values{"partNumber": {strconv.Itoa(partNumber)}, "uploadId": {uploadID}}
+	resp, err := c.do(http.MethodPut, bucket, key, query, body)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload part %d: %w", partNumber, err)
+	}
+	resp.Body.Close()
+	return resp.Header.Get("ETag"), nil
+}
+
+func (c *Client) completeMultipartUpload(bucket, key, uploadID string, parts []completedPart) error {
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(http.MethodPost, bucket, key, url.Values{"uploadId": {uploadID}}, body)
+	if err != nil {
+		return fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+	defer resp.Body.Close()
+	// S3 can report a failure with a 200 once it has started responding, so
+	// the body needs checking too.
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(respBody, []byte("<Error>")) {
+		return parseResponseError(resp.StatusCode, respBody)
+	}
+	return nil
+}
+
+func (c *Client) abortMultipartUpload(bucket, key, uploadID string) {
+	resp, err := c.do(http.MethodDelete, bucket, key, url.Values{"uploadId": {uploadID}}, nil)
+	if err != nil {
+		slog.Warn("failed to abort multipart upload", "bucket", bucket, "key", key, "upload-id", uploadID, "err", err)
+		return
+	}
+	resp.Body.Close()
+}
+
+func (c *Client) objectURL(bucket, key string, query url.Values) *url.URL {
+	u := *c.endpoint
+	path := strings.TrimRight(u.Path, "/")
+	if c.pathStyle {
+		path += "/" + bucket
+	} else {
+		u.Host = bucket + "." + u.Host
+	}
+	if key != "" {
+		path += "/" + key
+	}
+	if path == "" {
+		path = "/"
+	}
+	u.Path = path
+	// Sending the path encoded exactly as it's signed avoids any mismatch
+	// with Go's own (slightly more lenient) escaping.
+	u.RawPath = uriEncode(path, false)
+	u.RawQuery = canonicalQueryString(query)
+	return &u
+}
+
+func (c *Client) do(method, bucket, key string, query url.Values, body []byte) (*http.Response, error) {
+	u := c.objectURL(bucket, key, query)
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	payloadHash := EmptyPayloadHash
+	if len(body) > 0 {
+		sum := sha256.Sum256(body)
+		payloadHash = hex.EncodeToString(sum[:])
+	} else {
+		req.Body = http.NoBody
+	}
+	SignRequest(req, c.creds, c.region, payloadHash, time.Now())
+
+	slog.Debug("sending S3 request", "method", method, "url", u.Redacted(), "size", len(body))
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, parseResponseError(resp.StatusCode, respBody)
+	}
+	return resp, nil
+}
+
+func parseResponseError(statusCode int, body []byte) error {
+	respErr := &ResponseError{StatusCode: statusCode}
+	if len(body) > 0 {
+		// Error bodies aren't always XML (e.g. HEAD responses have no body), in
+		// which case only the status is reported.
+		xml.Unmarshal(body, respErr)
+	}
+	return respErr
+}
+
+// Reads up to size bytes, returning fewer only at the end of r.
+func readPart(r io.Reader, size int64) ([]byte, error) {
+	buf := make([]byte, size)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return buf[:n], nil
+}
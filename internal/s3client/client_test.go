@@ -0,0 +1,151 @@
+package s3client_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mrshanahan/deploy-assets/internal/s3client"
+	"github.com/mrshanahan/deploy-assets/internal/s3test"
+)
+
+func newTestClient(t *testing.T, server *s3test.Server) *s3client.Client {
+	client, err := s3client.New(server.URL, s3test.Region, server.Credentials())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	return client
+}
+
+func TestObjectOperations(t *testing.T) {
+	server := s3test.NewServer(t, "bucket")
+	client := newTestClient(t, server)
+
+	if err := client.HeadBucket("bucket"); err != nil {
+		t.Errorf("expected bucket to exist: %v", err)
+	}
+	var respErr *s3client.ResponseError
+	if err := client.HeadBucket("missing"); !errors.As(err, &respErr) || respErr.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for missing bucket, got %v", err)
+	}
+
+	key := "dir/some file+name.txt"
+	if err := client.PutObject("bucket", key, []byte("contents")); err != nil {
+		t.Fatalf("failed to put object: %v", err)
+	}
+	body, err := client.GetObject("bucket", key)
+	if err != nil {
+		t.Fatalf("failed to get object: %v", err)
+	}
+	data, _ := io.ReadAll(body)
+	body.Close()
+	if string(data) != "contents" {
+		t.Errorf("expected %q, got %q", "contents", string(data))
+	}
+
+	if err := client.DeleteObject("bucket", key); err != nil {
+		t.Errorf("failed to delete object: %v", err)
+	}
+	if _, prs := server.Object("bucket", key); prs {
+		t.Errorf("expected object to be deleted")
+	}
+	if _, err := client.GetObject("bucket", key); !errors.As(err, &respErr) || respErr.Code != "NoSuchKey" {
+		t.Errorf("expected NoSuchKey for deleted object, got %v", err)
+	}
+}
+
+func TestListObjects(t *testing.T) {
+	server := s3test.NewServer(t, "bucket")
+	client := newTestClient(t, server)
+	for _, key := range []string{"deploy/transfer-b", "deploy/transfer-a", "other/transfer-c"} {
+		if err := client.PutObject("bucket", key, []byte(key)); err != nil {
+			t.Fatalf("failed to put object: %v", err)
+		}
+	}
+	modTime := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	server.SetModTime("bucket", "deploy/transfer-a", modTime)
+
+	objects, err := client.ListObjects("bucket", "deploy/")
+	if err != nil {
+		t.Fatalf("failed to list objects: %v", err)
+	}
+	if len(objects) != 2 || objects[0].Key != "deploy/transfer-a" || objects[1].Key != "deploy/transfer-b" {
+		t.Fatalf("expected the two objects under the prefix, got %v", objects)
+	}
+	if !objects[0].LastModified.Equal(modTime) || objects[0].Size != int64(len("deploy/transfer-a")) {
+		t.Errorf("unexpected object details: %+v", objects[0])
+	}
+}
+
+func TestUpload(t *testing.T) {
+	server := s3test.NewServer(t, "bucket")
+	client := newTestClient(t, server)
+	client.PartSize = 1024
+
+	var tests = []struct {
+		name      string
+		size      int
+		multipart bool
+	}{
+		{"empty", 0, false},
+		{"smaller than a part", 1023, false},
+		{"exactly one part", 1024, true},
+		{"several parts", 3*1024 + 5, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(s *testing.T) {
+			data := make([]byte, test.size)
+			rand.Read(data)
+			requestsBefore := len(server.Requests())
+			if err := client.Upload("bucket", test.name, bytes.NewReader(data)); err != nil {
+				s.Fatalf("upload failed: %v", err)
+			}
+			actual, prs := server.Object("bucket", test.name)
+			if !prs || !bytes.Equal(actual, data) {
+				s.Errorf("uploaded object doesn't match (%d bytes vs %d)", len(actual), len(data))
+			}
+			requests := len(server.Requests()) - requestsBefore
+			if test.multipart && requests < 3 {
+				s.Errorf("expected a multipart upload, but only %d requests were made", requests)
+			} else if !test.multipart && requests != 1 {
+				s.Errorf("expected a single PUT, but %d requests were made", requests)
+			}
+		})
+	}
+}
+
+func TestPresignedURLs(t *testing.T) {
+	server := s3test.NewServer(t, "bucket")
+	client := newTestClient(t, server)
+
+	req, _ := http.NewRequest(http.MethodPut, client.PresignPutObject("bucket", "key", time.Minute), bytes.NewReader([]byte("presigned")))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("presigned PUT failed: %v %v", resp, err)
+	}
+	resp.Body.Close()
+
+	resp, err = http.Get(client.PresignGetObject("bucket", "key", time.Minute))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("presigned GET failed: %v %v", resp, err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(data) != "presigned" {
+		t.Errorf("expected %q, got %q", "presigned", string(data))
+	}
+}
+
+func TestRejectsBadCredentials(t *testing.T) {
+	server := s3test.NewServer(t, "bucket")
+	client, _ := s3client.New(server.URL, s3test.Region, s3client.Credentials{AccessKeyID: s3test.AccessKeyID, SecretAccessKey: "wrong"})
+	var respErr *s3client.ResponseError
+	if err := client.PutObject("bucket", "key", []byte("x")); !errors.As(err, &respErr) || respErr.Code != "SignatureDoesNotMatch" {
+		t.Errorf("expected signature mismatch, got %v", err)
+	}
+}
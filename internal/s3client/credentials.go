@@ -0,0 +1,146 @@
+package s3client
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const DefaultRegion = "us-east-1"
+
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// Resolves credentials & region the same way the AWS CLI does for the common
+// cases: AWS_ACCESS_KEY_ID et al. from the environment, unless a profile is
+// named explicitly (by argument, AWS_PROFILE or the older AWS_DEFAULT_PROFILE),
+// in which case it's read from the shared credentials & config files. An
+// explicit region wins over AWS_REGION/AWS_DEFAULT_REGION, which win over the
+// profile's region.
+func LoadConfig(profile string, region string) (Credentials, string, error) {
+	envProfile := os.Getenv("AWS_PROFILE")
+	if envProfile == "" {
+		envProfile = os.Getenv("AWS_DEFAULT_PROFILE")
+	}
+	var creds Credentials
+	if profile == "" && envProfile == "" && os.Getenv("AWS_ACCESS_KEY_ID") != "" {
+		creds = Credentials{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}
+		if creds.SecretAccessKey == "" {
+			return Credentials{}, "", fmt.Errorf("AWS_ACCESS_KEY_ID is set but AWS_SECRET_ACCESS_KEY is not")
+		}
+	}
+
+	if profile == "" {
+		profile = envProfile
+	}
+	if profile == "" {
+		profile = "default"
+	}
+
+	credentialsFile, configFile, err := sharedFilePaths()
+	if err != nil {
+		return Credentials{}, "", err
+	}
+	credentialsSections, err := readSharedFile(credentialsFile)
+	if err != nil {
+		return Credentials{}, "", err
+	}
+	configSections, err := readSharedFile(configFile)
+	if err != nil {
+		return Credentials{}, "", err
+	}
+	// Profiles in the config file are named "profile <name>", other than the
+	// default profile.
+	configProfile := configSections["profile "+profile]
+	if profile == "default" {
+		configProfile = configSections["default"]
+	}
+
+	if creds.AccessKeyID == "" {
+		for _, section := range []map[string]string{credentialsSections[profile], configProfile} {
+			if section["aws_access_key_id"] != "" {
+				creds = Credentials{
+					AccessKeyID:     section["aws_access_key_id"],
+					SecretAccessKey: section["aws_secret_access_key"],
+					SessionToken:    section["aws_session_token"],
+				}
+				break
+			}
+		}
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return Credentials{}, "", fmt.Errorf("no AWS credentials found in environment or for profile '%s' in %s or %s", profile, credentialsFile, configFile)
+	}
+
+	for _, r := range []string{region, os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), configProfile["region"], DefaultRegion} {
+		if r != "" {
+			region = r
+			break
+		}
+	}
+	return creds, region, nil
+}
+
+func sharedFilePaths() (string, string, error) {
+	credentialsFile, configFile := os.Getenv("AWS_SHARED_CREDENTIALS_FILE"), os.Getenv("AWS_CONFIG_FILE")
+	if credentialsFile != "" && configFile != "" {
+		return credentialsFile, configFile, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to find home directory for AWS config: %w", err)
+	}
+	if credentialsFile == "" {
+		credentialsFile = filepath.Join(home, ".aws", "credentials")
+	}
+	if configFile == "" {
+		configFile = filepath.Join(home, ".aws", "config")
+	}
+	return credentialsFile, configFile, nil
+}
+
+// Parses an AWS shared config/credentials file into sections of key-value
+// pairs. A missing file is treated as empty.
+func readSharedFile(path string) (map[string]map[string]string, error) {
+	sections := make(map[string]map[string]string)
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return sections, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var current map[string]string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			current = make(map[string]string)
+			sections[name] = current
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !found || current == nil {
+			continue
+		}
+		current[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return sections, nil
+}
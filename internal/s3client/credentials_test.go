@@ -0,0 +1,92 @@
+package s3client
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func setSharedFiles(t *testing.T, credentials, config string) {
+	dir := t.TempDir()
+	credentialsPath, configPath := filepath.Join(dir, "credentials"), filepath.Join(dir, "config")
+	os.WriteFile(credentialsPath, []byte(credentials), 0600)
+	os.WriteFile(configPath, []byte(config), 0600)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", credentialsPath)
+	t.Setenv("AWS_CONFIG_FILE", configPath)
+	for _, v := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_PROFILE", "AWS_DEFAULT_PROFILE", "AWS_REGION", "AWS_DEFAULT_REGION"} {
+		t.Setenv(v, "")
+	}
+}
+
+const testCredentialsFile = `
+[default]
+aws_access_key_id = DEFAULTKEY
+aws_secret_access_key = defaultsecret
+
+# comment
+[deploy]
+aws_access_key_id=DEPLOYKEY
+aws_secret_access_key=deploysecret
+aws_session_token=deploytoken
+`
+
+const testConfigFile = `
+[default]
+region = eu-west-1
+
+[profile deploy]
+region = ap-southeast-2
+
+[profile config-only]
+aws_access_key_id = CONFIGKEY
+aws_secret_access_key = configsecret
+`
+
+func TestLoadConfig(t *testing.T) {
+	var tests = []struct {
+		name           string
+		env            map[string]string
+		profile        string
+		region         string
+		expectedKey    string
+		expectedToken  string
+		expectedRegion string
+	}{
+		{"default profile", nil, "", "", "DEFAULTKEY", "", "eu-west-1"},
+		{"named profile", nil, "deploy", "", "DEPLOYKEY", "deploytoken", "ap-southeast-2"},
+		{"profile from env", map[string]string{"AWS_PROFILE": "deploy"}, "", "", "DEPLOYKEY", "deploytoken", "ap-southeast-2"},
+		{"credentials in config file", nil, "config-only", "", "CONFIGKEY", "", "us-east-1"},
+		{"legacy profile env var", map[string]string{"AWS_DEFAULT_PROFILE": "deploy"}, "", "", "DEPLOYKEY", "deploytoken", "ap-southeast-2"},
+		{"env credentials", map[string]string{"AWS_ACCESS_KEY_ID": "ENVKEY", "AWS_SECRET_ACCESS_KEY": "envsecret"}, "", "", "ENVKEY", "", "eu-west-1"},
+		{"explicit profile beats env credentials", map[string]string{"AWS_ACCESS_KEY_ID": "ENVKEY", "AWS_SECRET_ACCESS_KEY": "envsecret"}, "deploy", "", "DEPLOYKEY", "deploytoken", "ap-southeast-2"},
+		{"region from env", map[string]string{"AWS_DEFAULT_REGION": "ca-central-1"}, "deploy", "", "DEPLOYKEY", "deploytoken", "ca-central-1"},
+		{"explicit region", map[string]string{"AWS_REGION": "ca-central-1"}, "", "us-west-2", "DEFAULTKEY", "", "us-west-2"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(s *testing.T) {
+			setSharedFiles(s, testCredentialsFile, testConfigFile)
+			for k, v := range test.env {
+				s.Setenv(k, v)
+			}
+			creds, region, err := LoadConfig(test.profile, test.region)
+			if err != nil {
+				s.Fatalf("unexpected error: %v", err)
+			}
+			if creds.AccessKeyID != test.expectedKey || creds.SessionToken != test.expectedToken || region != test.expectedRegion {
+				s.Errorf("expected key=%s token=%s region=%s, got key=%s token=%s region=%s",
+					test.expectedKey, test.expectedToken, test.expectedRegion, creds.AccessKeyID, creds.SessionToken, region)
+			}
+		})
+	}
+}
+
+func TestLoadConfigMissingCredentials(t *testing.T) {
+	setSharedFiles(t, "", "")
+	if _, _, err := LoadConfig("", ""); err == nil {
+		t.Errorf("expected error with no credentials available")
+	}
+	if _, _, err := LoadConfig("nonexistent", ""); err == nil {
+		t.Errorf("expected error for unknown profile")
+	}
+}
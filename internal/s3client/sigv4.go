@@ -0,0 +1,303 @@
+package s3client
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AWS Signature Version 4, as described in:
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-authenticating-requests.html
+
+const (
+	signingAlgorithm = "AWS4-HMAC-SHA256"
+	signingService   = "s3"
+	amzDateFormat    = "20060102T150405Z"
+	shortDateFormat  = "20060102"
+
+	// Payload hash used when the body isn't hashed up front, e.g. for
+	// presigned URLs or bodies that are streamed.
+	UnsignedPayload = "UNSIGNED-PAYLOAD"
+	// Hash of an empty body.
+	EmptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// Signs req in place by adding the x-amz-* & Authorization headers. The
+// request's Host header (or URL host) & every header already set on it are
+// included in the signature.
+func SignRequest(req *http.Request, creds Credentials, region string, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format(amzDateFormat)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := canonicalHeaders(req)
+	signedHeaders := signedHeaderNames(headers)
+	canonicalRequest := buildCanonicalRequest(req.Method, req.URL, headers, signedHeaders, payloadHash)
+	scope := credentialScope(now, region)
+	signature := computeSignature(creds.SecretAccessKey, now, region, buildStringToSign(amzDate, scope, canonicalRequest))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		signingAlgorithm, creds.AccessKeyID, scope, strings.Join(signedHeaders, ";"), signature))
+}
+
+// Returns a copy of u that grants the bearer the given method on the object
+// until the expiry elapses, without any further credentials. Only the host
+// header is signed, so any client can use the URL as-is.
+func PresignURL(method string, u *url.URL, creds Credentials, region string, expires time.Duration, now time.Time) *url.URL {
+	amzDate := now.UTC().Format(amzDateFormat)
+	scope := credentialScope(now, region)
+
+	presigned := *u
+	query := presigned.Query()
+	query.Set("X-Amz-Algorithm", signingAlgorithm)
+	query.Set("X-Amz-Credential", creds.AccessKeyID+"/"+scope)
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", fmt.Sprintf("%d", int64(expires.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+	if creds.SessionToken != "" {
+		query.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+	presigned.RawQuery = canonicalQueryString(query)
+
+	headers := map[string]string{"host": presigned.Host}
+	canonicalRequest := buildCanonicalRequest(method, &presigned, headers, []string{"host"}, UnsignedPayload)
+	signature := computeSignature(creds.SecretAccessKey, now, region, buildStringToSign(amzDate, scope, canonicalRequest))
+
+	presigned.RawQuery += "&X-Amz-Signature=" + signature
+	return &presigned
+}
+
+// Recomputes the signature of a request signed by SignRequest or a URL from
+// PresignURL using the given secret, for servers verifying requests.
+func VerifyRequest(req *http.Request, secretAccessKey string) error {
+	query := req.URL.Query()
+	if query.Get("X-Amz-Signature") != "" {
+		return verifyPresignedRequest(req, secretAccessKey)
+	}
+
+	auth := req.Header.Get("Authorization")
+	credential, signedHeaders, signature, err := parseAuthorizationHeader(auth)
+	if err != nil {
+		return err
+	}
+	now, region, err := parseCredentialScope(credential, req.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return err
+	}
+
+	allHeaders := canonicalHeaders(req)
+	headers := make(map[string]string)
+	for _, h := range signedHeaders {
+		headers[h] = allHeaders[h]
+	}
+	canonicalRequest := buildCanonicalRequest(req.Method, req.URL, headers, signedHeaders, req.Header.Get("X-Amz-Content-Sha256"))
+	expected := computeSignature(secretAccessKey, now, region, buildStringToSign(req.Header.Get("X-Amz-Date"), credentialScope(now, region), canonicalRequest))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
+
+func verifyPresignedRequest(req *http.Request, secretAccessKey string) error {
+	query := req.URL.Query()
+	signature := query.Get("X-Amz-Signature")
+	amzDate := query.Get("X-Amz-Date")
+	now, region, err := parseCredentialScope(query.Get("X-Amz-Credential"), amzDate)
+	if err != nil {
+		return err
+	}
+	var expiresSeconds int64
+	if _, err := fmt.Sscanf(query.Get("X-Amz-Expires"), "%d", &expiresSeconds); err != nil {
+		return fmt.Errorf("invalid X-Amz-Expires: %w", err)
+	}
+	if time.Now().After(now.Add(time.Duration(expiresSeconds) * time.Second)) {
+		return fmt.Errorf("presigned URL has expired")
+	}
+
+	query.Del("X-Amz-Signature")
+	unsigned := *req.URL
+	unsigned.RawQuery = canonicalQueryString(query)
+	signedHeaders := strings.Split(query.Get("X-Amz-SignedHeaders"), ";")
+	allHeaders := canonicalHeaders(req)
+	headers := make(map[string]string)
+	for _, h := range signedHeaders {
+		headers[h] = allHeaders[h]
+	}
+	canonicalRequest := buildCanonicalRequest(req.Method, &unsigned, headers, signedHeaders, UnsignedPayload)
+	expected := computeSignature(secretAccessKey, now, region, buildStringToSign(amzDate, credentialScope(now, region), canonicalRequest))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
+
+func parseAuthorizationHeader(auth string) (string, []string, string, error) {
+	rest, found := strings.CutPrefix(auth, signingAlgorithm+" ")
+	if !found {
+		return "", nil, "", fmt.Errorf("unsupported authorization: %s", auth)
+	}
+	var credential, signature string
+	var signedHeaders []string
+	for _, part := range strings.Split(rest, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "Credential":
+			credential = value
+		case "SignedHeaders":
+			signedHeaders = strings.Split(value, ";")
+		case "Signature":
+			signature = value
+		}
+	}
+	if credential == "" || signedHeaders == nil || signature == "" {
+		return "", nil, "", fmt.Errorf("incomplete authorization: %s", auth)
+	}
+	return credential, signedHeaders, signature, nil
+}
+
+// Credential is <access key>/<date>/<region>/s3/aws4_request.
+func parseCredentialScope(credential string, amzDate string) (time.Time, string, error) {
+	comps := strings.Split(credential, "/")
+	if len(comps) != 5 {
+		return time.Time{}, "", fmt.Errorf("invalid credential scope: %s", credential)
+	}
+	now, err := time.Parse(amzDateFormat, amzDate)
+	if err != nil {
+		return time.Time{}, "", fmt.Errorf("invalid date %s: %w", amzDate, err)
+	}
+	if now.Format(shortDateFormat) != comps[1] {
+		return time.Time{}, "", fmt.Errorf("credential scope date %s does not match request date %s", comps[1], amzDate)
+	}
+	return now, comps[2], nil
+}
+
+func credentialScope(now time.Time, region string) string {
+	return fmt.Sprintf("%s/%s/%s/aws4_request", now.UTC().Format(shortDateFormat), region, signingService)
+}
+
+func canonicalHeaders(req *http.Request) map[string]string {
+	headers := make(map[string]string)
+	for name, values := range req.Header {
+		trimmed := make([]string, len(values))
+		for i, v := range values {
+			trimmed[i] = strings.Join(strings.Fields(v), " ")
+		}
+		headers[strings.ToLower(name)] = strings.Join(trimmed, ",")
+	}
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers["host"] = host
+	return headers
+}
+
+func signedHeaderNames(headers map[string]string) []string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		// Hop-by-hop headers may be rewritten in transit & so can't be signed.
+		if name == "user-agent" || name == "content-length" || name == "accept-encoding" || name == "authorization" {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func buildCanonicalRequest(method string, u *url.URL, headers map[string]string, signedHeaders []string, payloadHash string) string {
+	var b strings.Builder
+	b.WriteString(method)
+	b.WriteByte('\n')
+	b.WriteString(canonicalURI(u))
+	b.WriteByte('\n')
+	b.WriteString(canonicalQueryString(u.Query()))
+	b.WriteByte('\n')
+	for _, name := range signedHeaders {
+		b.WriteString(name)
+		b.WriteByte(':')
+		b.WriteString(headers[name])
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+	b.WriteString(strings.Join(signedHeaders, ";"))
+	b.WriteByte('\n')
+	b.WriteString(payloadHash)
+	return b.String()
+}
+
+func buildStringToSign(amzDate string, scope string, canonicalRequest string) string {
+	return strings.Join([]string{signingAlgorithm, amzDate, scope, hashHex([]byte(canonicalRequest))}, "\n")
+}
+
+func computeSignature(secretAccessKey string, now time.Time, region string, stringToSign string) string {
+	key := hmacSHA256([]byte("AWS4"+secretAccessKey), now.UTC().Format(shortDateFormat))
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, signingService)
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// S3 paths are signed as-is apart from URI-encoding, without normalizing
+// away empty or dot segments.
+func canonicalURI(u *url.URL) string {
+	path := u.Path
+	if path == "" {
+		return "/"
+	}
+	return uriEncode(path, false)
+}
+
+func canonicalQueryString(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := []string{}
+	for _, k := range keys {
+		values := append([]string{}, query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			pairs = append(pairs, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// Percent-encodes everything other than unreserved characters (and '/' when
+// encoding a path), as SigV4 requires. url.QueryEscape doesn't quite match,
+// since it encodes spaces as '+' & leaves '~' alone only sometimes.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,275 @@
+// Package s3test provides an in-memory, S3-compatible HTTP server for tests.
+// It supports path-style object PUT/GET/HEAD/DELETE, HEAD on buckets, object
+// listings & multipart uploads, & rejects requests that aren't correctly
+// signed.
+package s3test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mrshanahan/deploy-assets/internal/s3client"
+)
+
+const (
+	AccessKeyID     = "AKIAS3TESTEXAMPLE"
+	SecretAccessKey = "s3test/secret/EXAMPLEKEY"
+	Region          = "us-test-1"
+)
+
+type Server struct {
+	URL string
+
+	server  *httptest.Server
+	lock    sync.Mutex
+	buckets map[string]map[string][]byte
+	// Modification times by bucket & key.
+	modified map[string]map[string]time.Time
+	uploads  map[string]map[int][]byte
+	nextID   int
+	// Every request the server has handled, as "METHOD /path?query".
+	requests []string
+}
+
+// Starts a server with the given buckets, which is shut down when the test
+// completes.
+func NewServer(t testing.TB, buckets ...string) *Server {
+	s := &Server{
+		buckets:  make(map[string]map[string][]byte),
+		modified: make(map[string]map[string]time.Time),
+		uploads:  make(map[string]map[int][]byte),
+	}
+	for _, b := range buckets {
+		s.buckets[b] = make(map[string][]byte)
+		s.modified[b] = make(map[string]time.Time)
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	s.URL = s.server.URL
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+// Credentials accepted by the server.
+func (s *Server) Credentials() s3client.Credentials {
+	return s3client.Credentials{AccessKeyID: AccessKeyID, SecretAccessKey: SecretAccessKey}
+}
+
+// Sets AWS_* environment variables so that s3client.LoadConfig picks up
+// credentials accepted by the server, for the duration of the test.
+func (s *Server) SetEnv(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", AccessKeyID)
+	t.Setenv("AWS_SECRET_ACCESS_KEY", SecretAccessKey)
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	t.Setenv("AWS_PROFILE", "")
+	t.Setenv("AWS_DEFAULT_PROFILE", "")
+	t.Setenv("AWS_REGION", Region)
+}
+
+// Returns the object's contents & whether it exists.
+func (s *Server) Object(bucket, key string) ([]byte, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	data, prs := s.buckets[bucket][key]
+	return data, prs
+}
+
+func (s *Server) PutObject(bucket, key string, data []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.buckets[bucket][key] = data
+	s.modified[bucket][key] = time.Now()
+}
+
+// Backdates (or forwards) the object's modification time.
+func (s *Server) SetModTime(bucket, key string, modTime time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.modified[bucket][key] = modTime
+}
+
+// Returns the keys of every object in the bucket, sorted.
+func (s *Server) Keys(bucket string) []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	keys := []string{}
+	for k := range s.buckets[bucket] {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *Server) Requests() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string{}, s.requests...)
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	s.requests = append(s.requests, fmt.Sprintf("%s %s", r.Method, r.URL.RequestURI()))
+	s.lock.Unlock()
+
+	if err := s3client.VerifyRequest(r, SecretAccessKey); err != nil {
+		writeError(w, http.StatusForbidden, "SignatureDoesNotMatch", err.Error())
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	objects, prs := s.buckets[bucket]
+	if !prs {
+		writeError(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+
+	query := r.URL.Query()
+	switch {
+	case key == "" && r.Method == http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+	case key == "" && r.Method == http.MethodGet && query.Get("list-type") == "2":
+		type object struct {
+			Key          string
+			LastModified string
+			Size         int
+		}
+		result := struct {
+			XMLName     xml.Name `xml:"ListBucketResult"`
+			Name        string
+			Prefix      string
+			IsTruncated bool
+			Contents    []object
+		}{Name: bucket, Prefix: query.Get("prefix")}
+		for k, data := range objects {
+			if strings.HasPrefix(k, result.Prefix) {
+				result.Contents = append(result.Contents, object{k, s.modified[bucket][k].UTC().Format("2006-01-02T15:04:05.000Z"), len(data)})
+			}
+		}
+		sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+		writeXML(w, result)
+	case key == "":
+		writeError(w, http.StatusNotImplemented, "NotImplemented", "bucket operations are not supported")
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		s.nextID++
+		uploadID := strconv.Itoa(s.nextID)
+		s.uploads[uploadID] = make(map[int][]byte)
+		writeXML(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Bucket   string
+			Key      string
+			UploadId string
+		}{Bucket: bucket, Key: key, UploadId: uploadID})
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		parts, prs := s.uploads[query.Get("uploadId")]
+		if !prs {
+			writeError(w, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist")
+			return
+		}
+		partNumber, err := strconv.Atoi(query.Get("partNumber"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "InvalidArgument", "invalid part number")
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		parts[partNumber] = data
+		w.Header().Set("ETag", etag(data))
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		uploadID := query.Get("uploadId")
+		parts, prs := s.uploads[uploadID]
+		if !prs {
+			writeError(w, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist")
+			return
+		}
+		var complete struct {
+			Parts []struct {
+				PartNumber int
+				ETag       string
+			} `xml:"Part"`
+		}
+		body, _ := io.ReadAll(r.Body)
+		if err := xml.Unmarshal(body, &complete); err != nil {
+			writeError(w, http.StatusBadRequest, "MalformedXML", err.Error())
+			return
+		}
+		var data bytes.Buffer
+		for i, p := range complete.Parts {
+			part, prs := parts[p.PartNumber]
+			if !prs || p.PartNumber != i+1 || p.ETag != etag(part) {
+				writeError(w, http.StatusBadRequest, "InvalidPart", fmt.Sprintf("part %d is missing or does not match", p.PartNumber))
+				return
+			}
+			data.Write(part)
+		}
+		objects[key] = data.Bytes()
+		s.modified[bucket][key] = time.Now()
+		delete(s.uploads, uploadID)
+		writeXML(w, struct {
+			XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+			Bucket  string
+			Key     string
+		}{Bucket: bucket, Key: key})
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(s.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		objects[key] = data
+		s.modified[bucket][key] = time.Now()
+		w.Header().Set("ETag", etag(data))
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		data, prs := objects[key]
+		if !prs {
+			writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist")
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("ETag", etag(data))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	case r.Method == http.MethodDelete:
+		delete(objects, key)
+		delete(s.modified[bucket], key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusNotImplemented, "NotImplemented", fmt.Sprintf("%s is not supported", r.Method))
+	}
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func writeXML(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code string, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string
+		Message string
+	}{Code: code, Message: message})
+}
@@ -0,0 +1,112 @@
+package sshtest
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Handles the global requests for remote port forwarding (RFC 4254 section
+// 7.1): the server listens on the requested address & opens a
+// "forwarded-tcpip" channel back to the client for each connection.
+func (s *Server) handleGlobalRequests(conn *ssh.ServerConn, reqs <-chan *ssh.Request) {
+	var lock sync.Mutex
+	forwards := make(map[string]net.Listener)
+	defer func() {
+		lock.Lock()
+		defer lock.Unlock()
+		for _, l := range forwards {
+			l.Close()
+		}
+	}()
+
+	for req := range reqs {
+		switch req.Type {
+		case "tcpip-forward":
+			var payload struct {
+				BindAddr string
+				BindPort uint32
+			}
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			listener, err := net.Listen("tcp", net.JoinHostPort(payload.BindAddr, strconv.Itoa(int(payload.BindPort))))
+			if err != nil {
+				slog.Debug("sshtest: failed to listen for forward", "err", err)
+				req.Reply(false, nil)
+				continue
+			}
+			port := uint32(listener.Addr().(*net.TCPAddr).Port)
+			lock.Lock()
+			forwards[forwardKey(payload.BindAddr, port)] = listener
+			lock.Unlock()
+			req.Reply(true, ssh.Marshal(struct{ Port uint32 }{port}))
+			go s.acceptForwarded(conn, listener, payload.BindAddr, port)
+		case "cancel-tcpip-forward":
+			var payload struct {
+				BindAddr string
+				BindPort uint32
+			}
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			lock.Lock()
+			key := forwardKey(payload.BindAddr, payload.BindPort)
+			listener, prs := forwards[key]
+			delete(forwards, key)
+			lock.Unlock()
+			if prs {
+				listener.Close()
+			}
+			req.Reply(prs, nil)
+		default:
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+		}
+	}
+}
+
+func (s *Server) acceptForwarded(conn *ssh.ServerConn, listener net.Listener, bindAddr string, bindPort uint32) {
+	for {
+		c, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer c.Close()
+			origin := c.RemoteAddr().(*net.TCPAddr)
+			channel, reqs, err := conn.OpenChannel("forwarded-tcpip", ssh.Marshal(struct {
+				Addr       string
+				Port       uint32
+				OriginAddr string
+				OriginPort uint32
+			}{bindAddr, bindPort, origin.IP.String(), uint32(origin.Port)}))
+			if err != nil {
+				slog.Debug("sshtest: client rejected forwarded connection", "err", err)
+				return
+			}
+			defer channel.Close()
+			go ssh.DiscardRequests(reqs)
+
+			go func() {
+				io.Copy(channel, c)
+				channel.CloseWrite()
+			}()
+			// The connection is closed once the client is done with the
+			// channel, which also ends the copy above.
+			io.Copy(c, channel)
+		}()
+	}
+}
+
+func forwardKey(addr string, port uint32) string {
+	return fmt.Sprintf("%s:%d", addr, port)
+}
@@ -0,0 +1,268 @@
+// Package sshtest provides an in-process SSH server for tests. Commands run
+// through a real shell on the local machine (starting in a temporary root
+// directory), & files can be copied in either direction with scp, in both its
+// legacy & SFTP-based modes. Remote port forwards are supported too.
+package sshtest
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+type Server struct {
+	// Directory commands start in & relative SFTP paths are resolved against.
+	Root string
+	// Directory prepended to PATH for commands run by the server. Tests can
+	// drop fake executables here; a pass-through `sudo` is installed by default.
+	BinDir string
+	// Path to an unencrypted private key accepted by the server.
+	KeyFile string
+	// Extra environment variables (KEY=VALUE) for commands run by the server.
+	Env []string
+
+	listener      net.Listener
+	config        *ssh.ServerConfig
+	authorizedKey ssh.PublicKey
+	lock          sync.Mutex
+	conns         []*ssh.ServerConn
+	wg            sync.WaitGroup
+}
+
+// Starts a server listening on localhost, with a fresh client key authorized.
+// The server is shut down when the test completes.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+	dir := t.TempDir()
+	s := &Server{
+		Root:    filepath.Join(dir, "root"),
+		BinDir:  filepath.Join(dir, "bin"),
+		KeyFile: filepath.Join(dir, "id_ed25519"),
+		Env:     []string{},
+	}
+	for _, d := range []string{s.Root, s.BinDir} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatalf("failed to create directory %s: %v", d, err)
+		}
+	}
+	if err := s.WriteExecutable("sudo", "#!/bin/bash\nexec \"$@\"\n"); err != nil {
+		t.Fatalf("failed to create fake sudo: %v", err)
+	}
+
+	clientPub, clientKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate client key: %v", err)
+	}
+	block, err := ssh.MarshalPrivateKey(clientKey, "sshtest")
+	if err != nil {
+		t.Fatalf("failed to marshal client key: %v", err)
+	}
+	if err := os.WriteFile(s.KeyFile, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatalf("failed to write client key: %v", err)
+	}
+	authorizedKey, err := ssh.NewPublicKey(clientPub)
+	if err != nil {
+		t.Fatalf("failed to convert client key: %v", err)
+	}
+	s.SetAuthorizedKey(authorizedKey)
+
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate host key: %v", err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatalf("failed to create host key signer: %v", err)
+	}
+	s.config = &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			s.lock.Lock()
+			defer s.lock.Unlock()
+			if s.authorizedKey != nil && string(key.Marshal()) == string(s.authorizedKey.Marshal()) {
+				return &ssh.Permissions{}, nil
+			}
+			return nil, fmt.Errorf("unauthorized key for %s", conn.User())
+		},
+	}
+	s.config.AddHostKey(hostSigner)
+
+	s.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	s.wg.Add(1)
+	go s.acceptLoop()
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Address (host:port) the server is listening on.
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *Server) Host() string {
+	host, _, _ := net.SplitHostPort(s.Addr())
+	return host
+}
+
+func (s *Server) Port() string {
+	_, port, _ := net.SplitHostPort(s.Addr())
+	return port
+}
+
+// Replaces the single public key the server accepts.
+func (s *Server) SetAuthorizedKey(key ssh.PublicKey) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.authorizedKey = key
+}
+
+// Writes an executable into BinDir, shadowing any command of the same name.
+func (s *Server) WriteExecutable(name string, contents string) error {
+	return os.WriteFile(filepath.Join(s.BinDir, name), []byte(contents), 0755)
+}
+
+func (s *Server) Close() {
+	s.listener.Close()
+	s.lock.Lock()
+	for _, c := range s.conns {
+		c.Close()
+	}
+	s.lock.Unlock()
+	s.wg.Wait()
+}
+
+func (s *Server) acceptLoop() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handleConn(conn)
+		}()
+	}
+}
+
+func (s *Server) handleConn(conn net.Conn) {
+	serverConn, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		slog.Debug("sshtest: handshake failed", "err", err)
+		conn.Close()
+		return
+	}
+	s.lock.Lock()
+	s.conns = append(s.conns, serverConn)
+	s.lock.Unlock()
+
+	go s.handleGlobalRequests(serverConn, reqs)
+	for newChan := range chans {
+		if newChan.ChannelType() != "session" {
+			newChan.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+		channel, requests, err := newChan.Accept()
+		if err != nil {
+			continue
+		}
+		go s.handleSession(channel, requests)
+	}
+}
+
+func (s *Server) handleSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+	env := []string{}
+	for req := range requests {
+		switch req.Type {
+		case "env":
+			var kv struct{ Name, Value string }
+			if err := ssh.Unmarshal(req.Payload, &kv); err == nil {
+				env = append(env, fmt.Sprintf("%s=%s", kv.Name, kv.Value))
+			}
+			req.Reply(true, nil)
+		case "exec":
+			var payload struct{ Command string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			go ssh.DiscardRequests(requests)
+			status := s.runCommand(channel, payload.Command, env)
+			sendExitStatus(channel, status)
+			return
+		case "subsystem":
+			var payload struct{ Name string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil || payload.Name != "sftp" {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			go ssh.DiscardRequests(requests)
+			if err := serveSFTP(channel, s.Root); err != nil && !errors.Is(err, io.EOF) {
+				slog.Debug("sshtest: sftp session failed", "err", err)
+			}
+			sendExitStatus(channel, 0)
+			return
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+func (s *Server) runCommand(channel ssh.Channel, command string, env []string) uint32 {
+	cmd := exec.Command("bash", "-c", command)
+	cmd.Dir = s.Root
+	cmd.Env = append(os.Environ(), fmt.Sprintf("PATH=%s:%s", s.BinDir, os.Getenv("PATH")))
+	cmd.Env = append(cmd.Env, s.Env...)
+	cmd.Env = append(cmd.Env, env...)
+	cmd.Stdout = channel
+	cmd.Stderr = channel.Stderr()
+
+	// Not assigning the channel to cmd.Stdin directly, since Wait would then
+	// block until the client closes its side even if the command ignores stdin.
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return 255
+	}
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(channel.Stderr(), "failed to start command: %v\n", err)
+		return 127
+	}
+	go func() {
+		io.Copy(stdin, channel)
+		stdin.Close()
+	}()
+
+	err = cmd.Wait()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() >= 0 {
+		return uint32(exitErr.ExitCode())
+	} else if err != nil {
+		return 255
+	}
+	return 0
+}
+
+func sendExitStatus(channel ssh.Channel, status uint32) {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, status)
+	channel.SendRequest("exit-status", false, payload)
+}
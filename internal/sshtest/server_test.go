@@ -0,0 +1,148 @@
+package sshtest
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mrshanahan/deploy-assets/internal/sshclient"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestExecuteCommand(t *testing.T) {
+	server := NewServer(t)
+	server.Env = append(server.Env, "SSHTEST_GREETING=hello")
+	client, err := sshclient.CreateSshClient(server.Addr(), "tester", server.KeyFile, "")
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("failed to open session: %v", err)
+	}
+	stdout, err := session.Output("echo $SSHTEST_GREETING; pwd; sudo whoami >/dev/null && echo elevated")
+	if err != nil {
+		t.Fatalf("failed to run command: %v", err)
+	}
+	expected := "hello\n" + server.Root + "\nelevated\n"
+	if string(stdout) != expected {
+		t.Errorf("expected output %q, got %q", expected, string(stdout))
+	}
+
+	session, err = client.NewSession()
+	if err != nil {
+		t.Fatalf("failed to open session: %v", err)
+	}
+	err = session.Run("exit 7")
+	exitErr, ok := err.(*ssh.ExitError)
+	if !ok || exitErr.ExitStatus() != 7 {
+		t.Errorf("expected exit status 7, got %v", err)
+	}
+}
+
+func TestRejectsUnauthorizedKey(t *testing.T) {
+	server := NewServer(t)
+	otherKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	otherPublicKey, err := ssh.NewPublicKey(otherKey)
+	if err != nil {
+		t.Fatalf("failed to convert key: %v", err)
+	}
+	server.SetAuthorizedKey(otherPublicKey)
+
+	if client, err := sshclient.CreateSshClient(server.Addr(), "tester", server.KeyFile, ""); err == nil {
+		client.Close()
+		t.Errorf("expected connection with unauthorized key to fail")
+	}
+}
+
+func TestScp(t *testing.T) {
+	if _, err := exec.LookPath("scp"); err != nil {
+		t.Skip("scp not found on path")
+	}
+
+	var tests = []struct {
+		name  string
+		flags []string
+	}{
+		{"sftp", []string{}},
+		{"legacy", []string{"-O"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(s *testing.T) {
+			server := NewServer(s)
+			srcPath := filepath.Join(s.TempDir(), "payload.txt")
+			if err := os.WriteFile(srcPath, []byte("payload\n"), 0644); err != nil {
+				s.Fatalf("failed to write source file: %v", err)
+			}
+			dstDir := filepath.Join(server.Root, "uploads")
+			if err := os.Mkdir(dstDir, 0755); err != nil {
+				s.Fatalf("failed to create destination dir: %v", err)
+			}
+
+			args := append(test.flags,
+				"-i", server.KeyFile,
+				"-o", "BatchMode=yes",
+				"-o", "StrictHostKeyChecking=no",
+				"-o", "UserKnownHostsFile=/dev/null",
+				"-P", server.Port(),
+				srcPath, "tester@"+server.Host()+":"+dstDir)
+			if out, err := exec.Command("scp", args...).CombinedOutput(); err != nil {
+				s.Fatalf("scp failed: %v (output: %s)", err, strings.TrimSpace(string(out)))
+			}
+
+			actual, err := os.ReadFile(filepath.Join(dstDir, "payload.txt"))
+			if err != nil {
+				s.Fatalf("failed to read copied file: %v", err)
+			}
+			if string(actual) != "payload\n" {
+				s.Errorf("expected copied contents %q, got %q", "payload\n", string(actual))
+			}
+		})
+	}
+}
+
+func TestRemotePortForward(t *testing.T) {
+	server := NewServer(t)
+	client, err := sshclient.CreateSshClient(server.Addr(), "tester", server.KeyFile, "")
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	listener, err := client.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen on server: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("forwarded\n"))
+	}()
+
+	// Connect from the server's side, as a command run over SSH would.
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect to forwarded port: %v", err)
+	}
+	defer conn.Close()
+	actual, err := io.ReadAll(conn)
+	if err != nil || string(actual) != "forwarded\n" {
+		t.Errorf("expected to read through the forward, got %q (err: %v)", string(actual), err)
+	}
+}
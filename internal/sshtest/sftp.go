@@ -0,0 +1,508 @@
+package sshtest
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// A minimal SFTP (version 3) server, covering what OpenSSH's scp & sftp
+// clients need to upload & download files. See
+// https://datatracker.ietf.org/doc/html/draft-ietf-secsh-filexfer-02
+
+const (
+	sshFxpInit     = 1
+	sshFxpVersion  = 2
+	sshFxpOpen     = 3
+	sshFxpClose    = 4
+	sshFxpRead     = 5
+	sshFxpWrite    = 6
+	sshFxpLstat    = 7
+	sshFxpFstat    = 8
+	sshFxpSetstat  = 9
+	sshFxpFsetstat = 10
+	sshFxpOpendir  = 11
+	sshFxpReaddir  = 12
+	sshFxpRemove   = 13
+	sshFxpMkdir    = 14
+	sshFxpRmdir    = 15
+	sshFxpRealpath = 16
+	sshFxpStat     = 17
+	sshFxpRename   = 18
+	sshFxpStatus   = 101
+	sshFxpHandle   = 102
+	sshFxpData     = 103
+	sshFxpName     = 104
+	sshFxpAttrs    = 105
+
+	sshFxOk               = 0
+	sshFxEOF              = 1
+	sshFxNoSuchFile       = 2
+	sshFxPermissionDenied = 3
+	sshFxFailure          = 4
+	sshFxOpUnsupported    = 8
+
+	sshFileXferAttrSize        = 0x1
+	sshFileXferAttrUIDGID      = 0x2
+	sshFileXferAttrPermissions = 0x4
+	sshFileXferAttrACModTime   = 0x8
+	sshFileXferAttrExtended    = 0x80000000
+
+	sshFxfRead   = 0x1
+	sshFxfWrite  = 0x2
+	sshFxfAppend = 0x4
+	sshFxfCreat  = 0x8
+	sshFxfTrunc  = 0x10
+	sshFxfExcl   = 0x20
+)
+
+type sftpServer struct {
+	rw         io.ReadWriter
+	root       string
+	handles    map[string]*sftpHandle
+	nextHandle int
+}
+
+type sftpHandle struct {
+	file    *os.File
+	entries []fs.DirEntry
+	dirPath string
+	dirDone bool
+}
+
+func serveSFTP(rw io.ReadWriter, root string) error {
+	s := &sftpServer{rw, root, map[string]*sftpHandle{}, 0}
+	defer func() {
+		for _, h := range s.handles {
+			if h.file != nil {
+				h.file.Close()
+			}
+		}
+	}()
+	for {
+		packet, err := s.readPacket()
+		if err != nil {
+			return err
+		}
+		if err := s.handlePacket(packet); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *sftpServer) readPacket() (*sftpBuffer, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(s.rw, header); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header)
+	if length > 1<<20 {
+		return nil, fmt.Errorf("sftp packet too large: %d", length)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.rw, body); err != nil {
+		return nil, err
+	}
+	return &sftpBuffer{body}, nil
+}
+
+func (s *sftpServer) writePacket(typ byte, build func(b *sftpBuilder)) error {
+	b := &sftpBuilder{}
+	b.byte(typ)
+	build(b)
+	packet := make([]byte, 4, 4+len(b.data))
+	binary.BigEndian.PutUint32(packet, uint32(len(b.data)))
+	_, err := s.rw.Write(append(packet, b.data...))
+	return err
+}
+
+func (s *sftpServer) status(id uint32, code uint32, msg string) error {
+	return s.writePacket(sshFxpStatus, func(b *sftpBuilder) {
+		b.uint32(id)
+		b.uint32(code)
+		b.string(msg)
+		b.string("")
+	})
+}
+
+func (s *sftpServer) errorStatus(id uint32, err error) error {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return s.status(id, sshFxNoSuchFile, err.Error())
+	case errors.Is(err, fs.ErrPermission):
+		return s.status(id, sshFxPermissionDenied, err.Error())
+	default:
+		return s.status(id, sshFxFailure, err.Error())
+	}
+}
+
+func (s *sftpServer) resolve(path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(s.root, path)
+}
+
+func (s *sftpServer) newHandle(h *sftpHandle) string {
+	s.nextHandle += 1
+	name := fmt.Sprintf("%d", s.nextHandle)
+	s.handles[name] = h
+	return name
+}
+
+func (s *sftpServer) handlePacket(p *sftpBuffer) error {
+	typ := p.byte()
+	if typ == sshFxpInit {
+		return s.writePacket(sshFxpVersion, func(b *sftpBuilder) { b.uint32(3) })
+	}
+
+	id := p.uint32()
+	switch typ {
+	case sshFxpRealpath:
+		path := s.resolve(p.string())
+		return s.writePacket(sshFxpName, func(b *sftpBuilder) {
+			b.uint32(id)
+			b.uint32(1)
+			b.string(path)
+			b.string(path)
+			b.uint32(0)
+		})
+	case sshFxpStat, sshFxpLstat:
+		path := s.resolve(p.string())
+		var info fs.FileInfo
+		var err error
+		if typ == sshFxpStat {
+			info, err = os.Stat(path)
+		} else {
+			info, err = os.Lstat(path)
+		}
+		if err != nil {
+			return s.errorStatus(id, err)
+		}
+		return s.attrs(id, info)
+	case sshFxpFstat:
+		h, prs := s.handles[p.string()]
+		if !prs || h.file == nil {
+			return s.status(id, sshFxFailure, "invalid handle")
+		}
+		info, err := h.file.Stat()
+		if err != nil {
+			return s.errorStatus(id, err)
+		}
+		return s.attrs(id, info)
+	case sshFxpOpen:
+		path := s.resolve(p.string())
+		pflags := p.uint32()
+		attrs := p.attrs()
+		flags := 0
+		switch {
+		case pflags&sshFxfRead != 0 && pflags&sshFxfWrite != 0:
+			flags |= os.O_RDWR
+		case pflags&sshFxfWrite != 0:
+			flags |= os.O_WRONLY
+		default:
+			flags |= os.O_RDONLY
+		}
+		if pflags&sshFxfAppend != 0 {
+			flags |= os.O_APPEND
+		}
+		if pflags&sshFxfCreat != 0 {
+			flags |= os.O_CREATE
+		}
+		if pflags&sshFxfTrunc != 0 {
+			flags |= os.O_TRUNC
+		}
+		if pflags&sshFxfExcl != 0 {
+			flags |= os.O_EXCL
+		}
+		perm := os.FileMode(0644)
+		if attrs.flags&sshFileXferAttrPermissions != 0 {
+			perm = os.FileMode(attrs.permissions & 0777)
+		}
+		f, err := os.OpenFile(path, flags, perm)
+		if err != nil {
+			return s.errorStatus(id, err)
+		}
+		return s.handle(id, s.newHandle(&sftpHandle{file: f}))
+	case sshFxpOpendir:
+		path := s.resolve(p.string())
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return s.errorStatus(id, err)
+		}
+		return s.handle(id, s.newHandle(&sftpHandle{entries: entries, dirPath: path}))
+	case sshFxpReaddir:
+		h, prs := s.handles[p.string()]
+		if !prs || h.dirPath == "" {
+			return s.status(id, sshFxFailure, "invalid handle")
+		}
+		if h.dirDone {
+			return s.status(id, sshFxEOF, "")
+		}
+		h.dirDone = true
+		infos := []fs.FileInfo{}
+		for _, e := range h.entries {
+			if info, err := e.Info(); err == nil {
+				infos = append(infos, info)
+			}
+		}
+		return s.writePacket(sshFxpName, func(b *sftpBuilder) {
+			b.uint32(id)
+			b.uint32(uint32(len(infos)))
+			for _, info := range infos {
+				b.string(info.Name())
+				b.string(fmt.Sprintf("%s 1 0 0 %d %s %s", info.Mode(), info.Size(), info.ModTime().Format("Jan _2 15:04"), info.Name()))
+				b.attrs(info)
+			}
+		})
+	case sshFxpClose:
+		name := p.string()
+		h, prs := s.handles[name]
+		if !prs {
+			return s.status(id, sshFxFailure, "invalid handle")
+		}
+		delete(s.handles, name)
+		if h.file != nil {
+			if err := h.file.Close(); err != nil {
+				return s.errorStatus(id, err)
+			}
+		}
+		return s.status(id, sshFxOk, "")
+	case sshFxpRead:
+		h, prs := s.handles[p.string()]
+		offset, length := p.uint64(), p.uint32()
+		if !prs || h.file == nil {
+			return s.status(id, sshFxFailure, "invalid handle")
+		}
+		data := make([]byte, min(length, 1<<16))
+		n, err := h.file.ReadAt(data, int64(offset))
+		if n == 0 && errors.Is(err, io.EOF) {
+			return s.status(id, sshFxEOF, "")
+		} else if n == 0 && err != nil {
+			return s.errorStatus(id, err)
+		}
+		return s.writePacket(sshFxpData, func(b *sftpBuilder) {
+			b.uint32(id)
+			b.bytes(data[:n])
+		})
+	case sshFxpWrite:
+		h, prs := s.handles[p.string()]
+		offset, data := p.uint64(), p.bytes()
+		if !prs || h.file == nil {
+			return s.status(id, sshFxFailure, "invalid handle")
+		}
+		if _, err := h.file.WriteAt(data, int64(offset)); err != nil {
+			return s.errorStatus(id, err)
+		}
+		return s.status(id, sshFxOk, "")
+	case sshFxpSetstat, sshFxpFsetstat:
+		var path string
+		if typ == sshFxpSetstat {
+			path = s.resolve(p.string())
+		} else {
+			h, prs := s.handles[p.string()]
+			if !prs || h.file == nil {
+				return s.status(id, sshFxFailure, "invalid handle")
+			}
+			path = h.file.Name()
+		}
+		attrs := p.attrs()
+		if err := attrs.apply(path); err != nil {
+			return s.errorStatus(id, err)
+		}
+		return s.status(id, sshFxOk, "")
+	case sshFxpMkdir:
+		path := s.resolve(p.string())
+		attrs := p.attrs()
+		perm := os.FileMode(0755)
+		if attrs.flags&sshFileXferAttrPermissions != 0 {
+			perm = os.FileMode(attrs.permissions & 0777)
+		}
+		if err := os.Mkdir(path, perm); err != nil {
+			return s.errorStatus(id, err)
+		}
+		return s.status(id, sshFxOk, "")
+	case sshFxpRemove, sshFxpRmdir:
+		if err := os.Remove(s.resolve(p.string())); err != nil {
+			return s.errorStatus(id, err)
+		}
+		return s.status(id, sshFxOk, "")
+	case sshFxpRename:
+		oldPath, newPath := s.resolve(p.string()), s.resolve(p.string())
+		if err := os.Rename(oldPath, newPath); err != nil {
+			return s.errorStatus(id, err)
+		}
+		return s.status(id, sshFxOk, "")
+	default:
+		return s.status(id, sshFxOpUnsupported, fmt.Sprintf("unsupported request type %d", typ))
+	}
+}
+
+func (s *sftpServer) handle(id uint32, name string) error {
+	return s.writePacket(sshFxpHandle, func(b *sftpBuilder) {
+		b.uint32(id)
+		b.string(name)
+	})
+}
+
+func (s *sftpServer) attrs(id uint32, info fs.FileInfo) error {
+	return s.writePacket(sshFxpAttrs, func(b *sftpBuilder) {
+		b.uint32(id)
+		b.attrs(info)
+	})
+}
+
+type sftpAttrs struct {
+	flags       uint32
+	size        uint64
+	uid, gid    uint32
+	permissions uint32
+	atime       uint32
+	mtime       uint32
+}
+
+func (a *sftpAttrs) apply(path string) error {
+	if a.flags&sshFileXferAttrSize != 0 {
+		if err := os.Truncate(path, int64(a.size)); err != nil {
+			return err
+		}
+	}
+	if a.flags&sshFileXferAttrPermissions != 0 {
+		if err := os.Chmod(path, os.FileMode(a.permissions&0777)); err != nil {
+			return err
+		}
+	}
+	if a.flags&sshFileXferAttrACModTime != 0 {
+		if err := os.Chtimes(path, time.Unix(int64(a.atime), 0), time.Unix(int64(a.mtime), 0)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type sftpBuffer struct {
+	data []byte
+}
+
+func (b *sftpBuffer) byte() byte {
+	if len(b.data) < 1 {
+		return 0
+	}
+	v := b.data[0]
+	b.data = b.data[1:]
+	return v
+}
+
+func (b *sftpBuffer) uint32() uint32 {
+	if len(b.data) < 4 {
+		b.data = nil
+		return 0
+	}
+	v := binary.BigEndian.Uint32(b.data)
+	b.data = b.data[4:]
+	return v
+}
+
+func (b *sftpBuffer) uint64() uint64 {
+	if len(b.data) < 8 {
+		b.data = nil
+		return 0
+	}
+	v := binary.BigEndian.Uint64(b.data)
+	b.data = b.data[8:]
+	return v
+}
+
+func (b *sftpBuffer) bytes() []byte {
+	n := b.uint32()
+	if uint32(len(b.data)) < n {
+		b.data = nil
+		return nil
+	}
+	v := b.data[:n]
+	b.data = b.data[n:]
+	return v
+}
+
+func (b *sftpBuffer) string() string {
+	return string(b.bytes())
+}
+
+func (b *sftpBuffer) attrs() *sftpAttrs {
+	a := &sftpAttrs{flags: b.uint32()}
+	if a.flags&sshFileXferAttrSize != 0 {
+		a.size = b.uint64()
+	}
+	if a.flags&sshFileXferAttrUIDGID != 0 {
+		a.uid, a.gid = b.uint32(), b.uint32()
+	}
+	if a.flags&sshFileXferAttrPermissions != 0 {
+		a.permissions = b.uint32()
+	}
+	if a.flags&sshFileXferAttrACModTime != 0 {
+		a.atime, a.mtime = b.uint32(), b.uint32()
+	}
+	if a.flags&sshFileXferAttrExtended != 0 {
+		count := b.uint32()
+		for i := uint32(0); i < count; i++ {
+			b.string()
+			b.string()
+		}
+	}
+	return a
+}
+
+type sftpBuilder struct {
+	data []byte
+}
+
+func (b *sftpBuilder) byte(v byte) {
+	b.data = append(b.data, v)
+}
+
+func (b *sftpBuilder) uint32(v uint32) {
+	b.data = binary.BigEndian.AppendUint32(b.data, v)
+}
+
+func (b *sftpBuilder) uint64(v uint64) {
+	b.data = binary.BigEndian.AppendUint64(b.data, v)
+}
+
+func (b *sftpBuilder) bytes(v []byte) {
+	b.uint32(uint32(len(v)))
+	b.data = append(b.data, v...)
+}
+
+func (b *sftpBuilder) string(v string) {
+	b.bytes([]byte(v))
+}
+
+func (b *sftpBuilder) attrs(info fs.FileInfo) {
+	mode := uint32(info.Mode().Perm())
+	switch {
+	case info.IsDir():
+		mode |= syscall.S_IFDIR
+	case info.Mode()&fs.ModeSymlink != 0:
+		mode |= syscall.S_IFLNK
+	case info.Mode().IsRegular():
+		mode |= syscall.S_IFREG
+	}
+	var uid, gid uint32
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		uid, gid = st.Uid, st.Gid
+	}
+	mtime := uint32(info.ModTime().Unix())
+	b.uint32(sshFileXferAttrSize | sshFileXferAttrUIDGID | sshFileXferAttrPermissions | sshFileXferAttrACModTime)
+	b.uint64(uint64(info.Size()))
+	b.uint32(uid)
+	b.uint32(gid)
+	b.uint32(mode)
+	b.uint32(mtime)
+	b.uint32(mtime)
+}
@@ -2,8 +2,10 @@ package util
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
-	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -14,10 +16,15 @@ func GetTimestampedFileName(prefix string) string {
 	return name
 }
 
-func GetTempFilePath(prefix string) string {
-	name := GetTimestampedFileName(prefix)
-	path := filepath.Join("/tmp", name)
-	return path
+// Produces a name of the form <prefix>-<timestamp>-<random>. The random suffix
+// keeps concurrent runs (or multiple calls within the same clock tick) from
+// colliding on the same path.
+func GetRandomFileName(prefix string) string {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		panic(fmt.Sprintf("failed to read random bytes: %v", err))
+	}
+	return fmt.Sprintf("%s-%s", GetTimestampedFileName(prefix), hex.EncodeToString(suffix))
 }
 
 func Keys[K comparable, V any](m map[K]V) []K {
@@ -61,6 +68,12 @@ func (s Set[T]) AsSlice() []T {
 	return Keys(s.m)
 }
 
+func (s Set[T]) Add(xs ...T) {
+	for _, x := range xs {
+		s.m[x] = true
+	}
+}
+
 // func (s Set[T]) Union(xs ...T)
 
 func Map[S any, T any](xs []S, f func(S) T) []T {
@@ -126,6 +139,38 @@ func HumanReadableSize(bytes int64) string {
 	return fmt.Sprintf("%.1f%s", coeff, units[unitIdx])
 }
 
+// Parses a size like that returned by HumanReadableSize, e.g. "64MiB", into
+// bytes. Units are powers of 1024; "K", "M", "G" & "T" are accepted as
+// shorthand for "KiB" etc., & a bare number is in bytes.
+func ParseSize(size string) (int64, error) {
+	units := map[string]int64{
+		"":    1,
+		"B":   1,
+		"K":   1 << 10,
+		"KiB": 1 << 10,
+		"M":   1 << 20,
+		"MiB": 1 << 20,
+		"G":   1 << 30,
+		"GiB": 1 << 30,
+		"T":   1 << 40,
+		"TiB": 1 << 40,
+	}
+	trimmed := strings.TrimSpace(size)
+	numEnd := strings.IndexFunc(trimmed, func(r rune) bool { return r < '0' || r > '9' })
+	if numEnd < 0 {
+		numEnd = len(trimmed)
+	}
+	coeff, err := strconv.ParseInt(trimmed[:numEnd], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size '%s': expected a whole number followed by an optional unit, e.g. 64MiB", size)
+	}
+	unit, prs := units[strings.TrimSpace(trimmed[numEnd:])]
+	if !prs {
+		return 0, fmt.Errorf("invalid size '%s': unrecognized unit '%s'", size, trimmed[numEnd:])
+	}
+	return coeff * unit, nil
+}
+
 // dropCR & the definition of ScanLinesOrUntil were basically copied verbatim
 // from the golang source: https://github.com/golang/go/blob/master/src/bufio/scan.go#L341-L369
 
@@ -2,6 +2,7 @@ package util
 
 import (
 	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -38,3 +39,55 @@ func TestHumanReadableSize(t *testing.T) {
 	}
 
 }
+
+func TestParseSize(t *testing.T) {
+	var tests = []struct {
+		size      string
+		expected  int64
+		expectErr bool
+	}{
+		{"0", 0, false},
+		{"100", 100, false},
+		{"100B", 100, false},
+		{"1KiB", 1024, false},
+		{"1K", 1024, false},
+		{"64MiB", 64 * 1024 * 1024, false},
+		{"64 M", 64 * 1024 * 1024, false},
+		{"2GiB", 2 * 1024 * 1024 * 1024, false},
+		{"1TiB", 1024 * 1024 * 1024 * 1024, false},
+		{"", 0, true},
+		{"MiB", 0, true},
+		{"1.5MiB", 0, true},
+		{"-1MiB", 0, true},
+		{"10MB", 0, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.size, func(s *testing.T) {
+			actual, err := ParseSize(test.size)
+			if test.expectErr {
+				if err == nil {
+					s.Errorf("expected error, got %d", actual)
+				}
+			} else if err != nil {
+				s.Errorf("expected %d, got error %v", test.expected, err)
+			} else if actual != test.expected {
+				s.Errorf("expected %d, got %d", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestGetRandomFileName(t *testing.T) {
+	seen := NewSet[string]()
+	for i := 0; i < 100; i++ {
+		name := GetRandomFileName("foo")
+		if !strings.HasPrefix(name, "foo-") {
+			t.Errorf("expected name to start with 'foo-', got %s", name)
+		}
+		if seen.Contains(name) {
+			t.Errorf("duplicate name generated: %s", name)
+		}
+		seen.Add(name)
+	}
+}
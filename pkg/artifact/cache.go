@@ -0,0 +1,115 @@
+package artifact
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"sync"
+
+	"github.com/mrshanahan/deploy-assets/pkg/config"
+)
+
+// Caches the artifacts an asset produces on its source for the duration of a
+// run, so that fanning it out to several destinations produces (& with a
+// reusing transport, stages) each artifact exactly once. Artifacts live in the
+// source's staging directory under a name derived from their id, & are
+// removed, along with anything transports staged for them, by Close once the
+// last destination has been synced.
+func NewCache() *Cache {
+	return &Cache{entries: make(map[cacheKey]*cacheEntry)}
+}
+
+type Cache struct {
+	lock    sync.Mutex
+	entries map[cacheKey]*cacheEntry
+	order   []cacheKey
+}
+
+type cacheKey struct {
+	location string
+	id       string
+}
+
+type cacheEntry struct {
+	src  config.Executor
+	dir  string
+	path string
+	err  error
+	// Transports that may have kept something staged for the artifact.
+	transports []config.ReusingTransport
+}
+
+func (c *Cache) Get(src config.Executor, id string, name string, produce func(path string) error) (string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	key := cacheKey{src.Name(), id}
+	if e, prs := c.entries[key]; prs {
+		if e.err == nil {
+			slog.Debug("reusing artifact", "location", src.Name(), "id", id, "path", e.path)
+		}
+		return e.path, e.err
+	}
+
+	stagingDir, err := src.StagingDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(id))
+	dir := filepath.Join(stagingDir, "artifact-"+hex.EncodeToString(sum[:8]))
+	e := &cacheEntry{src: src, dir: dir, path: filepath.Join(dir, name)}
+	c.entries[key] = e
+	c.order = append(c.order, key)
+
+	if _, stderr, err := src.ExecuteCommand("mkdir", "-p", dir); err != nil {
+		e.err = fmt.Errorf("failed to create artifact directory %s on %s (stderr: %s): %w", dir, src.Name(), stderr, err)
+	} else if err := produce(e.path); err != nil {
+		e.err = err
+	}
+	// A failure is remembered too, rather than retried for every destination.
+	return e.path, e.err
+}
+
+func (c *Cache) Transfer(transport config.Transport, src config.Executor, path string, dst config.Executor, dstPath string) error {
+	reusing, ok := transport.(config.ReusingTransport)
+	if !ok {
+		return transport.TransferFile(src, path, dst, dstPath)
+	}
+
+	c.lock.Lock()
+	for _, e := range c.entries {
+		if e.src == src && e.path == path {
+			// Comparing interfaces is fine here; transports are pointers.
+			found := false
+			for _, t := range e.transports {
+				found = found || t == reusing
+			}
+			if !found {
+				e.transports = append(e.transports, reusing)
+			}
+		}
+	}
+	c.lock.Unlock()
+	return reusing.TransferFileReusing(src, path, dst, dstPath)
+}
+
+// Releases what transports staged for each artifact & removes the artifacts,
+// in the order they were produced.
+func (c *Cache) Close() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, key := range c.order {
+		e := c.entries[key]
+		for _, t := range e.transports {
+			t.Release(e.src, e.path)
+		}
+		if stdout, stderr, err := e.src.ExecuteCommand("rm", "-rf", e.dir); err != nil {
+			slog.Warn("failed to remove artifact", "location", e.src.Name(), "dir", e.dir, "stdout", stdout, "stderr", stderr, "err", err)
+		}
+	}
+	c.entries = make(map[cacheKey]*cacheEntry)
+	c.order = nil
+}
@@ -0,0 +1,109 @@
+package artifact
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mrshanahan/deploy-assets/pkg/config"
+	"github.com/mrshanahan/deploy-assets/pkg/executor"
+)
+
+// Copies files locally, counting what it's asked to do.
+type countingTransport struct {
+	transfers int
+	reused    int
+	released  []string
+}
+
+func (t *countingTransport) Yaml(indent int) string { return "" }
+
+func (t *countingTransport) Validate(exec config.Executor) error { return nil }
+
+func (t *countingTransport) TransferFile(src config.Executor, srcPath string, dst config.Executor, dstPath string) error {
+	t.transfers++
+	_, _, err := dst.ExecuteCommand("cp", srcPath, dstPath)
+	return err
+}
+
+func (t *countingTransport) TransferFileReusing(src config.Executor, srcPath string, dst config.Executor, dstPath string) error {
+	t.reused++
+	_, _, err := dst.ExecuteCommand("cp", srcPath, dstPath)
+	return err
+}
+
+func (t *countingTransport) Release(src config.Executor, srcPath string) {
+	t.released = append(t.released, srcPath)
+}
+
+func TestCache(t *testing.T) {
+	src := executor.NewLocalExecutor("src", t.TempDir(), 0)
+	defer src.Close()
+	transport := &countingTransport{}
+	cache := NewCache()
+
+	produced := 0
+	produce := func(path string) error {
+		produced++
+		return os.WriteFile(path, []byte("artifact\n"), 0644)
+	}
+	paths := []string{}
+	for _, name := range []string{"web1", "web2", "web3"} {
+		path, err := cache.Get(src, "image@sha256:1234", "image.tar", produce)
+		if err != nil {
+			t.Fatalf("failed to get artifact: %v", err)
+		}
+		dst := executor.NewLocalExecutor(name, t.TempDir(), 0)
+		defer dst.Close()
+		dstPath := filepath.Join(t.TempDir(), "image.tar")
+		if err := cache.Transfer(transport, src, path, dst, dstPath); err != nil {
+			t.Fatalf("failed to transfer artifact to %s: %v", name, err)
+		}
+		if actual, _ := os.ReadFile(dstPath); string(actual) != "artifact\n" {
+			t.Errorf("unexpected contents transferred to %s: %q", name, string(actual))
+		}
+		paths = append(paths, path)
+	}
+	if _, err := cache.Get(src, "image@sha256:5678", "image.tar", produce); err != nil {
+		t.Fatalf("failed to get artifact: %v", err)
+	}
+
+	if produced != 2 {
+		t.Errorf("expected each artifact to be produced once, got %d productions", produced)
+	}
+	if paths[0] != paths[1] || paths[1] != paths[2] {
+		t.Errorf("expected every destination to get the same artifact, got %v", paths)
+	}
+	if transport.reused != 3 || transport.transfers != 0 {
+		t.Errorf("expected 3 reusing transfers & no others, got %d & %d", transport.reused, transport.transfers)
+	}
+
+	cache.Close()
+	if len(transport.released) != 1 || transport.released[0] != paths[0] {
+		t.Errorf("expected the transferred artifact to be released once, got %v", transport.released)
+	}
+	if _, err := os.Stat(filepath.Dir(paths[0])); !os.IsNotExist(err) {
+		t.Errorf("expected artifact to be removed on close")
+	}
+}
+
+func TestCacheRemembersFailures(t *testing.T) {
+	src := executor.NewLocalExecutor("src", t.TempDir(), 0)
+	defer src.Close()
+	cache := NewCache()
+	defer cache.Close()
+
+	attempts := 0
+	produce := func(path string) error {
+		attempts++
+		return os.ErrPermission
+	}
+	for range 2 {
+		if _, err := cache.Get(src, "image@sha256:1234", "image.tar", produce); err == nil {
+			t.Errorf("expected failed production to fail")
+		}
+	}
+	if attempts != 1 {
+		t.Errorf("expected a failed artifact not to be produced again, got %d attempts", attempts)
+	}
+}
@@ -2,7 +2,10 @@ package config
 
 import (
 	"fmt"
+	"io"
+	"net"
 	"strings"
+	"time"
 
 	"github.com/mrshanahan/deploy-assets/internal/util"
 )
@@ -14,14 +17,76 @@ type Executor interface {
 	ExecuteCommandInDir(workingDir string, name string, args ...string) (string, string, error)
 	ExecuteShell(cmd string) (string, string, error)
 	ExecuteShellInDir(workingDir string, cmd string) (string, string, error)
+	// Runs a shell command with its stdin & stdout attached to the given
+	// streams instead of buffered, so that data can be passed through without
+	// landing in memory or on disk. Either stream may be nil. Returns the
+	// command's stderr.
+	ExecuteShellStreaming(cmd string, stdin io.Reader, stdout io.Writer) (string, error)
+	// Returns the per-run working directory for this location, creating it
+	// on first use. It lives under the location's staging_dir and is removed
+	// as a unit by Close.
+	StagingDir() (string, error)
 	Close()
 }
 
+// Implemented by executors that reach their location over SSH, so that
+// transports can open their own connections to it with the same credentials.
+type SSHExecutor interface {
+	SSHTarget() SSHTarget
+}
+
+type SSHTarget struct {
+	Addr          string
+	User          string
+	KeyPath       string
+	KeyPassphrase string
+	RunElevated   bool
+}
+
+// Implemented by executors that can accept connections at their location &
+// forward them back to this process, e.g. with an SSH remote port forward.
+type PortForwarder interface {
+	// Listens on the given address at the location. Connections made to it
+	// there are returned by the listener's Accept.
+	ListenRemote(addr string) (net.Listener, error)
+}
+
+// Implemented by executors whose location has a cap on how fast files may be
+// transferred to & from it.
+type BandwidthLimiter interface {
+	// Returns the limit in bytes per second, or 0 if there isn't one.
+	BandwidthLimit() int64
+}
+
+// Implemented by executors that stage files under a directory of their own
+// (which deploy-assets creates its per-run directories in).
+type StagingRooter interface {
+	StagingRoot() string
+}
+
 type SyncConfig struct {
 	SrcExecutor Executor
 	DstExecutor Executor
 	Transport   Transport
 	DryRun      bool
+	// Shared by every destination of the asset, so that artifacts produced
+	// on the source are only produced once. May be nil, in which case
+	// providers produce their artifacts for each destination.
+	Artifacts ArtifactCache
+}
+
+// Holds artifacts (e.g. image tarballs & file packages) produced on a source
+// location for as long as they might be delivered to another destination.
+type ArtifactCache interface {
+	// Returns the path on src of the artifact identified by id, calling
+	// produce to write it there (as name, in a directory of its own) the first
+	// time it's asked for. The id should change whenever the artifact's
+	// contents would.
+	Get(src Executor, id string, name string, produce func(path string) error) (string, error)
+	// Transfers an artifact returned by Get, letting the transport keep
+	// anything it staged for it (e.g. an uploaded object) for the transfers
+	// to other destinations that follow.
+	Transfer(transport Transport, src Executor, path string, dst Executor, dstPath string) error
 }
 
 type SyncResult int
@@ -30,18 +95,65 @@ const (
 	SYNC_RESULT_NOCHANGE SyncResult = iota
 	SYNC_RESULT_CREATED
 	SYNC_RESULT_UPDATED
+	// Files were only removed from the destination.
+	SYNC_RESULT_DELETED
 )
 
+func (r SyncResult) String() string {
+	switch r {
+	case SYNC_RESULT_NOCHANGE:
+		return "nochange"
+	case SYNC_RESULT_CREATED:
+		return "created"
+	case SYNC_RESULT_UPDATED:
+		return "updated"
+	case SYNC_RESULT_DELETED:
+		return "deleted"
+	default:
+		return fmt.Sprintf("SyncResult(%d)", int(r))
+	}
+}
+
 type Provider interface {
 	Name() string
 	Yaml(depth int) string
 	Sync(config SyncConfig) (SyncResult, error)
 }
 
+// Implemented by providers that need more of a location than the commands
+// every location is expected to have.
+type ValidatingProvider interface {
+	Provider
+	// Returns an error if the provider can't sync to or from the location.
+	Validate(exec Executor) error
+}
+
+// Implemented by providers that deploy into releases kept side by side on the
+// destination, only one of which is current at a time.
+type ReleaseProvider interface {
+	Provider
+	// Makes the release with the given id current again on dst.
+	Rollback(dst Executor, id string, dryRun bool) error
+	// Whether the release with the given id is still on dst.
+	HasRelease(dst Executor, id string) (bool, error)
+}
+
+// Implemented by providers that can back up what they overwrite on the
+// destination before syncing.
+type BackupProvider interface {
+	Provider
+	// Undoes the sync that took the backup with the given id on dst, or the
+	// latest backup if id is empty.
+	Restore(dst Executor, id string, dryRun bool) error
+}
+
 type ProviderConfig struct {
-	Provider     Provider
-	Src          string
-	Dst          string
+	Provider Provider
+	Src      string
+	Dst      []string
+	// Transports to use for this asset, in order of preference. When empty,
+	// one is picked by the manifest's routes.
+	Transports   []string
 	PostCommands []*PostCommand
 }
 
@@ -56,14 +168,26 @@ func (c *ProviderConfig) Yaml(indent int) string {
 	if len(postCommandYamlLines) > 0 {
 		postCommandYaml = "\n" + strings.Join(postCommandYamlLines, "\n")
 	}
+	var dstYaml string
+	if len(c.Dst) == 1 {
+		dstYaml = c.Dst[0]
+	} else {
+		dstYaml = "[" + strings.Join(c.Dst, ", ") + "]"
+	}
+	transportYaml := ""
+	if len(c.Transports) == 1 {
+		transportYaml = fmt.Sprintf("\n%stransport: %s", subpropIndent, c.Transports[0])
+	} else if len(c.Transports) > 1 {
+		transportYaml = fmt.Sprintf("\n%stransport: [%s]", subpropIndent, strings.Join(c.Transports, ", "))
+	}
 	return fmt.Sprintf(
 		`%s- src: %s
-%sdst: %s
+%sdst: %s%s
 %sprovider:
 %s
 %spost_commands:%s`,
 		mainIndent, c.Src,
-		subpropIndent, c.Dst,
+		subpropIndent, dstYaml, transportYaml,
 		subpropIndent, c.Provider.Yaml(indent+2+util.TabsToIndent(1)),
 		subpropIndent, postCommandYaml)
 }
@@ -88,3 +212,68 @@ type Transport interface {
 	Yaml(depth int) string
 	TransferFile(src Executor, srcPath string, dst Executor, dstPath string) error
 }
+
+// Implemented by transports that only work between certain pairs of locations,
+// even if they work on each location alone.
+type PairTransport interface {
+	Transport
+	ValidatePair(src Executor, dst Executor) error
+}
+
+// Implemented by transports that stage files somewhere between the two
+// locations, so that a file sent to several destinations is only staged once.
+type ReusingTransport interface {
+	Transport
+	// Like TransferFile, but keeps whatever was staged for srcPath on src to
+	// reuse in later calls, until Release is called for it.
+	TransferFileReusing(src Executor, srcPath string, dst Executor, dstPath string) error
+	Release(src Executor, srcPath string)
+}
+
+// Implemented by transports that can copy files straight to their final paths,
+// sending only what differs from the files already there, so that providers
+// don't have to package them up first.
+type DirectTransport interface {
+	Transport
+	// Copies each of the given paths, relative to srcDir, to the same path
+	// under dstDir, creating directories as needed. Returns an error wrapping
+	// errors.ErrUnsupported if it can't between these two locations.
+	SyncFiles(src Executor, srcDir string, dst Executor, dstDir string, relativePaths []string) error
+}
+
+// Implemented by transports (& the wrappers around them, via Unwrap) that
+// leave things behind when a run doesn't finish, e.g. objects in a bucket.
+type GarbageCollector interface {
+	// Returns what's been left behind by earlier runs since before cutoff,
+	// looking on the given locations if that's where it'd be.
+	FindGarbage(locations []Executor, cutoff time.Time) ([]Garbage, error)
+}
+
+// Something deploy-assets created & left behind.
+type Garbage struct {
+	// Location or bucket it's in.
+	Location   string
+	Path       string
+	ModifiedAt time.Time
+	Remove     func() error
+}
+
+// Implemented by transports (& the wrappers around them, via Unwrap) that
+// verify the files they transfer once they've arrived.
+type VerifyingTransport interface {
+	// Returns the files verified since the last call, in the order they were
+	// verified.
+	TakeVerified() []VerifiedFile
+}
+
+// A file whose SHA-256 on the destination was found to match the source's.
+type VerifiedFile struct {
+	// On the destination.
+	Path   string
+	SHA256 string
+}
+
+// Implemented by transports that wrap another.
+type WrappingTransport interface {
+	Unwrap() Transport
+}
@@ -36,7 +36,7 @@ func TestProviderConfigYamlDefault(t *testing.T) {
 	c := &ProviderConfig{
 		Provider: &testProvider{"foobar", 5},
 		Src:      "hither",
-		Dst:      "thither",
+		Dst:      []string{"thither"},
 		PostCommands: []*PostCommand{
 			{
 				Command: "systemctl restart foobar.service",
@@ -72,7 +72,7 @@ func TestProviderConfigYamlDeep(t *testing.T) {
 	c := &ProviderConfig{
 		Provider: &testProvider{"foobar", 5},
 		Src:      "hither",
-		Dst:      "thither",
+		Dst:      []string{"thither"},
 		PostCommands: []*PostCommand{
 			{
 				Command: "systemctl restart foobar.service",
@@ -108,7 +108,7 @@ func TestProviderConfigYamlNoCommands(t *testing.T) {
 	c := &ProviderConfig{
 		Provider:     &testProvider{"foobar", 5},
 		Src:          "hither",
-		Dst:          "thither",
+		Dst:          []string{"thither"},
 		PostCommands: []*PostCommand{},
 	}
 
@@ -126,3 +126,26 @@ func TestProviderConfigYamlNoCommands(t *testing.T) {
 		t.Errorf("yaml contents not equal:\nexpected:\n=======\n%s\n=======\ngot:\n=======\n%s\n=======", expected, actual)
 	}
 }
+
+func TestProviderConfigYamlMultipleDst(t *testing.T) {
+	c := &ProviderConfig{
+		Provider:     &testProvider{"foobar", 5},
+		Src:          "hither",
+		Dst:          []string{"*", "!yon"},
+		PostCommands: []*PostCommand{},
+	}
+
+	expected :=
+		`- src: hither
+  dst: [*, !yon]
+  provider:
+      file:
+          name: foobar
+          doodads: 5
+  post_commands:`
+
+	actual := c.Yaml(0)
+	if expected != actual {
+		t.Errorf("yaml contents not equal:\nexpected:\n=======\n%s\n=======\ngot:\n=======\n%s\n=======", expected, actual)
+	}
+}
@@ -0,0 +1,55 @@
+package executor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mrshanahan/deploy-assets/pkg/config"
+)
+
+// Matches the names of per-run staging directories, as made by
+// util.GetRandomFileName (whose timestamp starts with the year).
+const StagingDirPattern = StagingDirPrefix + "-[0-9][0-9][0-9][0-9]-*"
+
+// Returns the entries directly under dir on the location whose names match
+// one of the given glob patterns & that haven't been modified since cutoff,
+// each removed with `rm -rf`. A missing dir has none.
+func FindGarbage(exec config.Executor, dir string, patterns []string, cutoff time.Time) ([]config.Garbage, error) {
+	names := []string{}
+	for _, p := range patterns {
+		names = append(names, "-name "+ShellQuote(p))
+	}
+	cmd := fmt.Sprintf(`[ -d %s ] || exit 0; find %s -mindepth 1 -maxdepth 1 \( %s \) ! -newermt '@%d' -printf '%%T@ %%p\n'`,
+		ShellQuote(dir), ShellQuote(dir), strings.Join(names, " -o "), cutoff.Unix())
+	stdout, stderr, err := exec.ExecuteShell(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search %s on %s (stderr: %s): %w", dir, exec.Name(), strings.TrimSpace(stderr), err)
+	}
+
+	garbage := []config.Garbage{}
+	for _, line := range strings.Split(strings.TrimSpace(stdout), "\n") {
+		modTime, path, found := strings.Cut(line, " ")
+		if !found {
+			continue
+		}
+		seconds, _, _ := strings.Cut(modTime, ".")
+		unix, err := strconv.ParseInt(seconds, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse modification time of %s on %s: %w", path, exec.Name(), err)
+		}
+		garbage = append(garbage, config.Garbage{
+			Location:   exec.Name(),
+			Path:       path,
+			ModifiedAt: time.Unix(unix, 0),
+			Remove: func() error {
+				if _, stderr, err := exec.ExecuteCommand("rm", "-rf", path); err != nil {
+					return fmt.Errorf("failed to remove %s on %s (stderr: %s): %w", path, exec.Name(), strings.TrimSpace(stderr), err)
+				}
+				return nil
+			},
+		})
+	}
+	return garbage, nil
+}
@@ -0,0 +1,38 @@
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFindGarbage(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "it's staging")
+	old := time.Now().Add(-48 * time.Hour)
+	for _, name := range []string{"deploy-assets-2024-old", "deploy-assets-2024-new", "unrelated"} {
+		if err := os.MkdirAll(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatalf("failed to create %s: %v", name, err)
+		}
+	}
+	for _, name := range []string{"deploy-assets-2024-old", "unrelated"} {
+		if err := os.Chtimes(filepath.Join(dir, name), old, old); err != nil {
+			t.Fatalf("failed to set times on %s: %v", name, err)
+		}
+	}
+
+	exec := NewLocalExecutor("local", t.TempDir(), 0)
+	defer exec.Close()
+	garbage, err := FindGarbage(exec, dir, []string{StagingDirPattern}, time.Now().Add(-24*time.Hour))
+	if err != nil {
+		t.Fatalf("failed to find garbage: %v", err)
+	}
+	if len(garbage) != 1 || garbage[0].Path != filepath.Join(dir, "deploy-assets-2024-old") {
+		t.Errorf("expected only the old staging dir, got %+v", garbage)
+	}
+
+	garbage, err = FindGarbage(exec, filepath.Join(dir, "missing"), []string{StagingDirPattern}, time.Now())
+	if err != nil || len(garbage) != 0 {
+		t.Errorf("expected no garbage in a missing dir, got %+v (err: %v)", garbage, err)
+	}
+}
@@ -5,8 +5,11 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/mrshanahan/deploy-assets/internal/util"
@@ -14,21 +17,32 @@ import (
 )
 
 type localExecutor struct {
-	name string
+	name           string
+	stagingRoot    string
+	stagingDir     string
+	stagingLock    sync.Mutex
+	bandwidthLimit int64
 }
 
-func NewLocalExecutor(name string) config.Executor {
-	return &localExecutor{name}
+func NewLocalExecutor(name string, stagingRoot string, bandwidthLimit int64) config.Executor {
+	if stagingRoot == "" {
+		stagingRoot = DefaultStagingRoot
+	}
+	return &localExecutor{name: name, stagingRoot: stagingRoot, bandwidthLimit: bandwidthLimit}
 }
 
 func (e *localExecutor) Name() string { return e.name }
 
+func (e *localExecutor) BandwidthLimit() int64 { return e.bandwidthLimit }
+
 func (e *localExecutor) Yaml(indent int) string {
 	return fmt.Sprintf(
 		`%slocal:
-%sname: %s`,
+%sname: %s
+%sstaging_dir: %s`,
 		util.YamlIndentString(indent),
-		util.YamlIndentString(indent+util.TabsToIndent(1)), e.name)
+		util.YamlIndentString(indent+util.TabsToIndent(1)), e.name,
+		util.YamlIndentString(indent+util.TabsToIndent(1)), e.stagingRoot)
 }
 
 func (e *localExecutor) ExecuteCommandInDir(workingDir string, name string, args ...string) (string, string, error) {
@@ -105,4 +119,46 @@ func (e *localExecutor) ExecuteShellInDir(workingDir string, cmd string) (string
 	return e.ExecuteCommandInDir(workingDir, "bash", "-c", cmd)
 }
 
-func (e *localExecutor) Close() {}
+func (e *localExecutor) ExecuteShellStreaming(cmd string, stdin io.Reader, stdout io.Writer) (string, error) {
+	command := exec.Command("bash", "-c", cmd)
+	command.Stdin = stdin
+	command.Stdout = stdout
+	stderrBuilder := &strings.Builder{}
+	command.Stderr = stderrBuilder
+
+	err := command.Run()
+	stderr := stderrBuilder.String()
+	slog.Debug("executed local streaming command", "cmd", cmd, "stderr", stderr, "err", err)
+	return stderr, err
+}
+
+func (e *localExecutor) StagingRoot() string { return e.stagingRoot }
+
+func (e *localExecutor) StagingDir() (string, error) {
+	e.stagingLock.Lock()
+	defer e.stagingLock.Unlock()
+
+	if e.stagingDir != "" {
+		return e.stagingDir, nil
+	}
+	dir := filepath.Join(e.stagingRoot, util.GetRandomFileName(StagingDirPrefix))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create staging directory %s: %w", dir, err)
+	}
+	slog.Debug("created staging directory", "location", e.name, "dir", dir)
+	e.stagingDir = dir
+	return dir, nil
+}
+
+func (e *localExecutor) Close() {
+	e.stagingLock.Lock()
+	defer e.stagingLock.Unlock()
+
+	if e.stagingDir == "" {
+		return
+	}
+	if err := os.RemoveAll(e.stagingDir); err != nil {
+		slog.Warn("failed to remove staging directory", "location", e.name, "dir", e.stagingDir, "err", err)
+	}
+	e.stagingDir = ""
+}
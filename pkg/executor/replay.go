@@ -0,0 +1,336 @@
+package executor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/mrshanahan/deploy-assets/internal/util"
+	"github.com/mrshanahan/deploy-assets/pkg/config"
+)
+
+// Fixture is the on-disk format shared by RecordingExecutor & ReplayExecutor.
+type Fixture struct {
+	Name         string         `json:"name"`
+	StagingDir   string         `json:"staging_dir"`
+	Interactions []*Interaction `json:"interactions"`
+}
+
+// Interaction is a single command run through an executor. Exactly one of
+// Argv (ExecuteCommand*) or Shell (ExecuteShell*) is set. Streamed commands
+// record whatever they wrote to stdout, but not what they read from stdin.
+type Interaction struct {
+	WorkingDir string   `json:"working_dir,omitempty"`
+	Argv       []string `json:"argv,omitempty"`
+	Shell      string   `json:"shell,omitempty"`
+	Streamed   bool     `json:"streamed,omitempty"`
+	Stdout     string   `json:"stdout"`
+	Stderr     string   `json:"stderr"`
+	ExitStatus int      `json:"exit_status"`
+	Error      string   `json:"error,omitempty"`
+}
+
+func LoadFixture(path string) (*Fixture, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fixture := &Fixture{}
+	if err := json.Unmarshal(raw, fixture); err != nil {
+		return nil, fmt.Errorf("failed to parse fixture %s: %w", path, err)
+	}
+	return fixture, nil
+}
+
+func (f *Fixture) Save(path string) error {
+	raw, err := json.MarshalIndent(f, "", "    ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(raw, '\n'), 0644)
+}
+
+// Names produced by util.GetRandomFileName differ between runs, so commands
+// are compared after replacing each distinct name with a placeholder numbered
+// in order of first appearance.
+var randomNamePatt *regexp.Regexp = regexp.MustCompile(`\d{4}-\d{2}-\d{2}T\d{6}(\.\d+)?(Z|[+-]\d{4})-[0-9a-f]{8}`)
+
+type nameNormalizer struct {
+	names map[string]string
+}
+
+func newNameNormalizer() *nameNormalizer {
+	return &nameNormalizer{make(map[string]string)}
+}
+
+func (n *nameNormalizer) normalize(s string) string {
+	return randomNamePatt.ReplaceAllStringFunc(s, func(m string) string {
+		placeholder, prs := n.names[m]
+		if !prs {
+			placeholder = fmt.Sprintf("<random-%d>", len(n.names)+1)
+			n.names[m] = placeholder
+		}
+		return placeholder
+	})
+}
+
+func (n *nameNormalizer) normalizeInteraction(i *Interaction) *Interaction {
+	normalized := *i
+	normalized.WorkingDir = n.normalize(i.WorkingDir)
+	normalized.Shell = n.normalize(i.Shell)
+	if i.Argv != nil {
+		normalized.Argv = make([]string, len(i.Argv))
+		for j, a := range i.Argv {
+			normalized.Argv[j] = n.normalize(a)
+		}
+	}
+	return &normalized
+}
+
+func (i *Interaction) String() string {
+	cmd := i.Shell
+	if i.Argv != nil {
+		cmd = strings.Join(i.Argv, " ")
+	}
+	if i.WorkingDir != "" {
+		return fmt.Sprintf("(in %s) %s", i.WorkingDir, cmd)
+	}
+	return cmd
+}
+
+// ReplayedExitError stands in for the executor-specific error of a command
+// that exited non-zero when it was recorded.
+type ReplayedExitError struct {
+	ExitStatus int
+}
+
+func (e *ReplayedExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.ExitStatus)
+}
+
+// RecordingExecutor wraps another executor & captures every command it runs,
+// along with the command's output, into a fixture written on Close.
+type RecordingExecutor struct {
+	inner       config.Executor
+	fixturePath string
+	fixture     *Fixture
+	lock        sync.Mutex
+}
+
+func NewRecordingExecutor(inner config.Executor, fixturePath string) *RecordingExecutor {
+	return &RecordingExecutor{
+		inner:       inner,
+		fixturePath: fixturePath,
+		fixture:     &Fixture{Name: inner.Name(), Interactions: []*Interaction{}},
+	}
+}
+
+func (e *RecordingExecutor) Name() string { return e.inner.Name() }
+
+func (e *RecordingExecutor) Yaml(indent int) string { return e.inner.Yaml(indent) }
+
+func (e *RecordingExecutor) ExecuteCommand(name string, args ...string) (string, string, error) {
+	return e.ExecuteCommandInDir("", name, args...)
+}
+
+func (e *RecordingExecutor) ExecuteCommandInDir(workingDir string, name string, args ...string) (string, string, error) {
+	stdout, stderr, err := e.inner.ExecuteCommandInDir(workingDir, name, args...)
+	e.record(&Interaction{WorkingDir: workingDir, Argv: append([]string{name}, args...)}, stdout, stderr, err)
+	return stdout, stderr, err
+}
+
+func (e *RecordingExecutor) ExecuteShell(cmd string) (string, string, error) {
+	return e.ExecuteShellInDir("", cmd)
+}
+
+func (e *RecordingExecutor) ExecuteShellInDir(workingDir string, cmd string) (string, string, error) {
+	stdout, stderr, err := e.inner.ExecuteShellInDir(workingDir, cmd)
+	e.record(&Interaction{WorkingDir: workingDir, Shell: cmd}, stdout, stderr, err)
+	return stdout, stderr, err
+}
+
+func (e *RecordingExecutor) ExecuteShellStreaming(cmd string, stdin io.Reader, stdout io.Writer) (string, error) {
+	stdoutBuilder := &strings.Builder{}
+	var teeStdout io.Writer = stdoutBuilder
+	if stdout != nil {
+		teeStdout = io.MultiWriter(stdout, stdoutBuilder)
+	}
+	stderr, err := e.inner.ExecuteShellStreaming(cmd, stdin, teeStdout)
+	e.record(&Interaction{Shell: cmd, Streamed: true}, stdoutBuilder.String(), stderr, err)
+	return stderr, err
+}
+
+func (e *RecordingExecutor) StagingDir() (string, error) {
+	dir, err := e.inner.StagingDir()
+	if err == nil {
+		e.lock.Lock()
+		e.fixture.StagingDir = dir
+		e.lock.Unlock()
+	}
+	return dir, err
+}
+
+func (e *RecordingExecutor) Close() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if err := e.fixture.Save(e.fixturePath); err != nil {
+		slog.Error("failed to save recorded fixture", "location", e.Name(), "path", e.fixturePath, "err", err)
+	}
+	e.inner.Close()
+}
+
+func (e *RecordingExecutor) record(i *Interaction, stdout, stderr string, err error) {
+	i.Stdout, i.Stderr = stdout, stderr
+	if err != nil {
+		var exitCoder interface{ ExitCode() int }
+		var exitStatuser interface{ ExitStatus() int }
+		if errors.As(err, &exitCoder) {
+			i.ExitStatus = exitCoder.ExitCode()
+		} else if errors.As(err, &exitStatuser) {
+			i.ExitStatus = exitStatuser.ExitStatus()
+		} else {
+			i.ExitStatus = -1
+			i.Error = err.Error()
+		}
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.fixture.Interactions = append(e.fixture.Interactions, i)
+}
+
+// ReplayExecutor serves the interactions of a recorded fixture back without
+// running anything. Each recorded interaction is used at most once, but not
+// necessarily in the recorded order, since callers often iterate over maps.
+// Any command without a matching recorded interaction fails.
+type ReplayExecutor struct {
+	fixture    *Fixture
+	expected   []*Interaction
+	consumed   []bool
+	normalizer *nameNormalizer
+	unexpected []string
+	lock       sync.Mutex
+}
+
+func NewReplayExecutor(fixturePath string) (*ReplayExecutor, error) {
+	fixture, err := LoadFixture(fixturePath)
+	if err != nil {
+		return nil, err
+	}
+	recordedNormalizer := newNameNormalizer()
+	expected := []*Interaction{}
+	for _, i := range fixture.Interactions {
+		expected = append(expected, recordedNormalizer.normalizeInteraction(i))
+	}
+	return &ReplayExecutor{
+		fixture:    fixture,
+		expected:   expected,
+		consumed:   make([]bool, len(expected)),
+		normalizer: newNameNormalizer(),
+		unexpected: []string{},
+	}, nil
+}
+
+func (e *ReplayExecutor) Name() string { return e.fixture.Name }
+
+func (e *ReplayExecutor) Yaml(indent int) string {
+	return fmt.Sprintf(
+		`%sreplay:
+%sname: %s`,
+		util.YamlIndentString(indent),
+		util.YamlIndentString(indent+util.TabsToIndent(1)), e.fixture.Name)
+}
+
+func (e *ReplayExecutor) ExecuteCommand(name string, args ...string) (string, string, error) {
+	return e.ExecuteCommandInDir("", name, args...)
+}
+
+func (e *ReplayExecutor) ExecuteCommandInDir(workingDir string, name string, args ...string) (string, string, error) {
+	return e.replay(&Interaction{WorkingDir: workingDir, Argv: append([]string{name}, args...)})
+}
+
+func (e *ReplayExecutor) ExecuteShell(cmd string) (string, string, error) {
+	return e.ExecuteShellInDir("", cmd)
+}
+
+func (e *ReplayExecutor) ExecuteShellInDir(workingDir string, cmd string) (string, string, error) {
+	return e.replay(&Interaction{WorkingDir: workingDir, Shell: cmd})
+}
+
+func (e *ReplayExecutor) ExecuteShellStreaming(cmd string, stdin io.Reader, stdout io.Writer) (string, error) {
+	if stdin != nil {
+		io.Copy(io.Discard, stdin)
+	}
+	recordedStdout, stderr, err := e.replay(&Interaction{Shell: cmd, Streamed: true})
+	if stdout != nil && recordedStdout != "" {
+		if _, writeErr := io.WriteString(stdout, recordedStdout); writeErr != nil && err == nil {
+			err = writeErr
+		}
+	}
+	return stderr, err
+}
+
+func (e *ReplayExecutor) StagingDir() (string, error) {
+	if e.fixture.StagingDir == "" {
+		return "", fmt.Errorf("no staging directory recorded for location '%s'", e.fixture.Name)
+	}
+	return e.fixture.StagingDir, nil
+}
+
+func (e *ReplayExecutor) Close() {}
+
+// Returns an error describing any commands that had no recorded counterpart
+// and any recorded interactions that were never requested.
+func (e *ReplayExecutor) Verify() error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	errs := []error{}
+	for _, u := range e.unexpected {
+		errs = append(errs, fmt.Errorf("unexpected command: %s", u))
+	}
+	for i, consumed := range e.consumed {
+		if !consumed {
+			errs = append(errs, fmt.Errorf("recorded command was never executed: %s", e.expected[i]))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (e *ReplayExecutor) replay(actual *Interaction) (string, string, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	normalized := e.normalizer.normalizeInteraction(actual)
+	for i, expected := range e.expected {
+		if e.consumed[i] ||
+			expected.WorkingDir != normalized.WorkingDir ||
+			expected.Shell != normalized.Shell ||
+			expected.Streamed != normalized.Streamed ||
+			!reflect.DeepEqual(expected.Argv, normalized.Argv) {
+			continue
+		}
+		e.consumed[i] = true
+
+		recorded := e.fixture.Interactions[i]
+		slog.Debug("replaying command", "location", e.fixture.Name, "cmd", recorded.String(), "exit-status", recorded.ExitStatus)
+		var err error
+		if recorded.Error != "" {
+			err = errors.New(recorded.Error)
+		} else if recorded.ExitStatus != 0 {
+			err = &ReplayedExitError{recorded.ExitStatus}
+		}
+		return recorded.Stdout, recorded.Stderr, err
+	}
+
+	e.unexpected = append(e.unexpected, actual.String())
+	return "", "", fmt.Errorf("unexpected command for location '%s': %s", e.fixture.Name, actual)
+}
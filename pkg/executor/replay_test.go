@@ -0,0 +1,130 @@
+package executor
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mrshanahan/deploy-assets/internal/util"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	dir := t.TempDir()
+	fixturePath := filepath.Join(dir, "fixture.json")
+
+	recorder := NewRecordingExecutor(NewLocalExecutor("local", dir, 0), fixturePath)
+	stagingDir, err := recorder.StagingDir()
+	if err != nil {
+		t.Fatalf("failed to create staging dir: %v", err)
+	}
+	recordedTempPath := filepath.Join(stagingDir, util.GetRandomFileName("test"))
+	recorder.ExecuteCommand("mkdir", "-p", recordedTempPath)
+	recorder.ExecuteShell("echo foo; echo bar >&2")
+	recorder.ExecuteShellInDir(dir, "exit 3")
+	recorder.Close()
+
+	replayer, err := NewReplayExecutor(fixturePath)
+	if err != nil {
+		t.Fatalf("failed to load fixture: %v", err)
+	}
+	if replayer.Name() != "local" {
+		t.Errorf("expected name 'local', got '%s'", replayer.Name())
+	}
+	replayedStagingDir, err := replayer.StagingDir()
+	if err != nil || replayedStagingDir != stagingDir {
+		t.Errorf("expected staging dir %s, got %s (err: %v)", stagingDir, replayedStagingDir, err)
+	}
+
+	// Replayed commands don't need to follow the recorded order, & names
+	// generated afresh should line up with the recorded ones.
+	stdout, stderr, err := replayer.ExecuteShellInDir(dir, "exit 3")
+	if err == nil || err.Error() != "exit status 3" || stdout != "" || stderr != "" {
+		t.Errorf("expected exit status 3 with no output, got stdout=%q stderr=%q err=%v", stdout, stderr, err)
+	}
+	replayedTempPath := filepath.Join(stagingDir, util.GetRandomFileName("test"))
+	if _, _, err := replayer.ExecuteCommand("mkdir", "-p", replayedTempPath); err != nil {
+		t.Errorf("expected mkdir to replay successfully: %v", err)
+	}
+	stdout, stderr, err = replayer.ExecuteShell("echo foo; echo bar >&2")
+	if err != nil || stdout != "foo\n" || stderr != "bar\n" {
+		t.Errorf("expected recorded output, got stdout=%q stderr=%q err=%v", stdout, stderr, err)
+	}
+
+	if err := replayer.Verify(); err != nil {
+		t.Errorf("expected all interactions to be consumed: %v", err)
+	}
+}
+
+func TestReplayUnexpectedCommands(t *testing.T) {
+	dir := t.TempDir()
+	fixturePath := filepath.Join(dir, "fixture.json")
+	fixture := &Fixture{
+		Name: "remote",
+		Interactions: []*Interaction{
+			{Argv: []string{"true"}},
+			{Shell: "echo once", Stdout: "once\n"},
+		},
+	}
+	if err := fixture.Save(fixturePath); err != nil {
+		t.Fatalf("failed to save fixture: %v", err)
+	}
+
+	replayer, err := NewReplayExecutor(fixturePath)
+	if err != nil {
+		t.Fatalf("failed to load fixture: %v", err)
+	}
+	if _, _, err := replayer.ExecuteShell("echo once"); err != nil {
+		t.Errorf("expected first call to replay: %v", err)
+	}
+	if _, _, err := replayer.ExecuteShell("echo once"); err == nil {
+		t.Errorf("expected second call to fail since the interaction was consumed")
+	}
+	if _, _, err := replayer.ExecuteCommand("rm", "-rf", "/"); err == nil {
+		t.Errorf("expected unrecorded command to fail")
+	}
+	if _, err := replayer.StagingDir(); err == nil {
+		t.Errorf("expected missing staging dir to fail")
+	}
+
+	err = replayer.Verify()
+	if err == nil {
+		t.Fatalf("expected verification to fail")
+	}
+	for _, msg := range []string{"unexpected command: echo once", "unexpected command: rm -rf /", "never executed: true"} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("expected verification error to contain '%s', but did not: %v", msg, err)
+		}
+	}
+}
+
+func TestRecordAndReplayStreaming(t *testing.T) {
+	dir := t.TempDir()
+	fixturePath := filepath.Join(dir, "fixture.json")
+
+	recorder := NewRecordingExecutor(NewLocalExecutor("local", dir, 0), fixturePath)
+	recorded := &strings.Builder{}
+	if _, err := recorder.ExecuteShellStreaming("tr a-z A-Z", strings.NewReader("streamed\n"), recorded); err != nil {
+		t.Fatalf("failed to run streaming command: %v", err)
+	}
+	recorder.Close()
+	if recorded.String() != "STREAMED\n" {
+		t.Errorf("expected recorder to pass output through, got %q", recorded.String())
+	}
+
+	replayer, err := NewReplayExecutor(fixturePath)
+	if err != nil {
+		t.Fatalf("failed to load fixture: %v", err)
+	}
+	// Streamed & buffered commands are recorded separately, even if the
+	// command line is the same.
+	if _, _, err := replayer.ExecuteShell("tr a-z A-Z"); err == nil {
+		t.Errorf("expected buffered command not to match streamed interaction")
+	}
+	replayed := &strings.Builder{}
+	if _, err := replayer.ExecuteShellStreaming("tr a-z A-Z", strings.NewReader("ignored"), replayed); err != nil {
+		t.Errorf("expected streaming command to replay: %v", err)
+	}
+	if replayed.String() != "STREAMED\n" {
+		t.Errorf("expected recorded output, got %q", replayed.String())
+	}
+}
@@ -7,7 +7,10 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"net"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/mrshanahan/deploy-assets/internal/sshclient"
@@ -19,19 +22,43 @@ import (
 type sshClient struct {
 	name        string
 	client      *ssh.Client
+	target      config.SSHTarget
 	runElevated bool
+	stagingRoot string
+	stagingDir  string
+	stagingLock sync.Mutex
+
+	bandwidthLimit int64
 }
 
-func NewSSHExecutor(name string, addr string, user string, keyPath string, keyPassphrase string, runElevated bool) (config.Executor, error) {
+func NewSSHExecutor(name string, addr string, user string, keyPath string, keyPassphrase string, runElevated bool, stagingRoot string, bandwidthLimit int64) (config.Executor, error) {
 	client, err := sshclient.CreateSshClient(addr, user, keyPath, keyPassphrase)
 	if err != nil {
 		return nil, err
 	}
-	return &sshClient{name, client, runElevated}, nil
+	if stagingRoot == "" {
+		stagingRoot = DefaultStagingRoot
+	}
+	target := config.SSHTarget{
+		Addr:          addr,
+		User:          user,
+		KeyPath:       keyPath,
+		KeyPassphrase: keyPassphrase,
+		RunElevated:   runElevated,
+	}
+	return &sshClient{name: name, client: client, target: target, runElevated: runElevated, stagingRoot: stagingRoot, bandwidthLimit: bandwidthLimit}, nil
 }
 
 func (c *sshClient) Name() string { return c.name }
 
+func (c *sshClient) SSHTarget() config.SSHTarget { return c.target }
+
+func (c *sshClient) BandwidthLimit() int64 { return c.bandwidthLimit }
+
+func (c *sshClient) ListenRemote(addr string) (net.Listener, error) {
+	return c.client.Listen("tcp", addr)
+}
+
 func (c *sshClient) Yaml(indent int) string {
 	propIndent := util.YamlIndentString(indent + util.TabsToIndent(1))
 	return fmt.Sprintf(
@@ -39,12 +66,14 @@ func (c *sshClient) Yaml(indent int) string {
 %sname: %s
 %saddr: %v
 %suser: %s
-%srun_elevated: %t`,
+%srun_elevated: %t
+%sstaging_dir: %s`,
 		util.YamlIndentString(indent),
 		propIndent, c.name,
 		propIndent, c.client.RemoteAddr(),
 		propIndent, c.client.User(),
-		propIndent, c.runElevated)
+		propIndent, c.runElevated,
+		propIndent, c.stagingRoot)
 }
 
 func (c *sshClient) ExecuteCommand(name string, args ...string) (string, string, error) {
@@ -56,9 +85,7 @@ func (c *sshClient) ExecuteCommandInDir(workingDir string, name string, args ...
 	s.WriteString(name)
 	for _, a := range args {
 		s.WriteRune(' ')
-		s.WriteRune('\'')
-		s.WriteString(a)
-		s.WriteRune('\'')
+		s.WriteString(ShellQuote(a))
 	}
 	return c.runCommandInSession(workingDir, s.String())
 }
@@ -71,49 +98,126 @@ func (c *sshClient) ExecuteShell(cmd string) (string, string, error) {
 	return c.runCommandInSession("", cmd)
 }
 
+// The staging directory is created & removed directly through the session
+// rather than via runCommandInSession, since the latter keeps its own scripts
+// in the staging directory.
+func (c *sshClient) StagingRoot() string { return c.stagingRoot }
+
+func (c *sshClient) StagingDir() (string, error) {
+	c.stagingLock.Lock()
+	defer c.stagingLock.Unlock()
+
+	if c.stagingDir != "" {
+		return c.stagingDir, nil
+	}
+	dir := filepath.Join(c.stagingRoot, util.GetRandomFileName(StagingDirPrefix))
+	stdout, stderr, err := c.executeCommand("mkdir -p " + ShellQuote(dir))
+	if err != nil {
+		slog.Error("failed to create staging directory", "executor", "ssh", "name", c.name, "dir", dir, "stdout", stdout, "stderr", stderr, "err", err)
+		return "", fmt.Errorf("failed to create staging directory %s: %w", dir, err)
+	}
+	slog.Debug("created staging directory", "location", c.name, "dir", dir)
+	c.stagingDir = dir
+	return dir, nil
+}
+
 func (c *sshClient) Close() {
+	c.stagingLock.Lock()
+	defer c.stagingLock.Unlock()
+
+	if c.stagingDir != "" {
+		// Elevated commands may have left root-owned files behind, so cleanup
+		// needs the same privileges.
+		cmd := "rm -rf " + ShellQuote(c.stagingDir)
+		if c.runElevated {
+			cmd = "sudo " + cmd
+		}
+		if stdout, stderr, err := c.executeCommand(cmd); err != nil {
+			slog.Warn("failed to remove staging directory", "location", c.name, "dir", c.stagingDir, "stdout", stdout, "stderr", stderr, "err", err)
+		}
+		c.stagingDir = ""
+	}
 	c.client.Close()
 }
 
 // TODO: Can we make this more efficient? I.e. re-using sessions
 func (c *sshClient) runCommandInSession(workingDir string, cmd string) (string, string, error) {
-	// TODO: Create single folder for all these files & then delete them
+	stagingDir, err := c.StagingDir()
+	if err != nil {
+		return "", "", err
+	}
 
 	if workingDir != "" {
-		// TODO: Correctly escape working dir argument
-		cmd = fmt.Sprintf("cd '%s' && %s", workingDir, cmd)
+		cmd = fmt.Sprintf("cd %s && %s", ShellQuote(workingDir), cmd)
+	}
+
+	runCmd, cleanup, err := c.createScript(stagingDir, cmd)
+	if err != nil {
+		return "", "", err
 	}
+	defer cleanup()
 
+	stdout, stderr, err := c.executeCommandWithLogging(runCmd)
+	slog.Debug("executed ssh command", "cmd", cmd, "stdout", stdout, "stderr", stderr, "err", err)
+	return stdout, stderr, err
+}
+
+func (c *sshClient) ExecuteShellStreaming(cmd string, stdin io.Reader, stdout io.Writer) (string, error) {
+	stagingDir, err := c.StagingDir()
+	if err != nil {
+		return "", err
+	}
+	runCmd, cleanup, err := c.createScript(stagingDir, cmd)
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+
+	session, err := c.client.NewSession()
+	if err != nil {
+		slog.Error("failed to create ssh session", "name", c.name, "run-elevated", c.runElevated)
+		return "", err
+	}
+	defer session.Close()
+
+	var stderrBuffer bytes.Buffer
+	session.Stdin = stdin
+	session.Stdout = stdout
+	session.Stderr = &stderrBuffer
+	err = session.Run(runCmd)
+	stderr := stderrBuffer.String()
+	slog.Debug("executed ssh streaming command", "cmd", cmd, "stderr", stderr, "err", err)
+	return stderr, err
+}
+
+// Writes the command to a script in the staging directory, returning the
+// command that runs it (elevated if configured) & a function that removes it.
+// Going through a script sidesteps quoting the command for the remote shell.
+func (c *sshClient) createScript(stagingDir string, cmd string) (string, func(), error) {
 	slog.Debug("executing ssh command", "cmd", cmd)
-	scriptPathBase64 := util.GetTempFilePath("deploy-assets-ssh-b64")
+	scriptPathBase64 := filepath.Join(stagingDir, util.GetRandomFileName("ssh-b64"))
 	scriptContentsBase64 := base64.StdEncoding.EncodeToString([]byte(cmd))
-	stdout, stderr, err := c.executeCommand(fmt.Sprintf("echo '%s' > %s", scriptContentsBase64, scriptPathBase64))
+	stdout, stderr, err := c.executeCommand(fmt.Sprintf("echo '%s' > %s", scriptContentsBase64, ShellQuote(scriptPathBase64)))
 	if err != nil {
 		slog.Error("failed to create temp execution file", "executor", "ssh", "name", c.name, "run-elevated", c.runElevated, "stdout", stdout, "stderr", stderr, "err", err)
-		return "", "", err
+		return "", nil, err
 	}
-	defer c.executeCommand(fmt.Sprintf("rm %s", scriptPathBase64))
+	defer c.executeCommand("rm " + ShellQuote(scriptPathBase64))
 
 	// TODO: Check for base64 utility/use another workaround
-	scriptPath := util.GetTempFilePath("deploy-assets-ssh")
-	stdout, stderr, err = c.executeCommand(fmt.Sprintf("cat %s | base64 -d > %s", scriptPathBase64, scriptPath))
+	scriptPath := filepath.Join(stagingDir, util.GetRandomFileName("ssh"))
+	stdout, stderr, err = c.executeCommand(fmt.Sprintf("cat %s | base64 -d > %s", ShellQuote(scriptPathBase64), ShellQuote(scriptPath)))
 	if err != nil {
 		slog.Error("failed to create temp execution file", "executor", "ssh", "name", c.name, "run-elevated", c.runElevated, "stdout", stdout, "stderr", stderr, "err", err)
-		return "", "", err
+		return "", nil, err
 	}
-	defer c.executeCommand(fmt.Sprintf("rm %s", scriptPath))
+	cleanup := func() { c.executeCommand("rm " + ShellQuote(scriptPath)) }
 
 	// TODO: Option for shell
-	var runCmd string
 	if c.runElevated {
-		runCmd = fmt.Sprintf("sudo bash %s", scriptPath)
-	} else {
-		runCmd = fmt.Sprintf("bash %s", scriptPath)
+		return "sudo bash " + ShellQuote(scriptPath), cleanup, nil
 	}
-
-	stdout, stderr, err = c.executeCommandWithLogging(runCmd)
-	slog.Debug("executed ssh command", "cmd", cmd, "stdout", stdout, "stderr", stderr, "err", err)
-	return stdout, stderr, err
+	return "bash " + ShellQuote(scriptPath), cleanup, nil
 }
 
 func (c *sshClient) executeCommand(cmd string) (string, string, error) {
@@ -0,0 +1,41 @@
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mrshanahan/deploy-assets/internal/sshtest"
+)
+
+func TestSSHExecuteCommandQuoting(t *testing.T) {
+	server := sshtest.NewServer(t)
+	// Staging happens under the root, so it's quoted as well.
+	stagingRoot := filepath.Join(t.TempDir(), "it's staging")
+	exec, err := NewSSHExecutor("remote", server.Addr(), "deployer", server.KeyFile, "", false, stagingRoot, 0)
+	if err != nil {
+		t.Fatalf("failed to connect to test server: %v", err)
+	}
+	defer exec.Close()
+
+	dir := filepath.Join(t.TempDir(), "it's here")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "don't $touch"), []byte("contents"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	stdout, stderr, err := exec.ExecuteCommandInDir(dir, "cat", "don't $touch")
+	if err != nil {
+		t.Fatalf("failed to run command (stderr: %s): %v", stderr, err)
+	}
+	if stdout != "contents" {
+		t.Errorf("expected file contents, got %q", stdout)
+	}
+
+	exec.Close()
+	if entries, _ := os.ReadDir(stagingRoot); len(entries) != 0 {
+		t.Errorf("expected staging directory to be removed on close, found %d entries", len(entries))
+	}
+}
@@ -1,18 +1,69 @@
 package executor
 
 import (
-	"encoding/base64"
 	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
 
-	"github.com/mrshanahan/deploy-assets/internal/util"
+	"github.com/mrshanahan/deploy-assets/pkg/config"
 )
 
-func createCommandScript(cmd string, exec func(string) error) (string, error) {
-	scriptPathBase64 := util.GetTempFilePath("deploy-assets-execute-b64")
-	scriptContentsBase64 := base64.StdEncoding.EncodeToString([]byte(cmd))
-	createScriptCmd := fmt.Sprintf("echo '%s' | base64 -d > \"%s\"", scriptContentsBase64, scriptPathBase64)
-	if err := exec(createScriptCmd); err != nil {
-		return "", err
+const (
+	DefaultStagingRoot string = "/tmp"
+	StagingDirPrefix   string = "deploy-assets"
+)
+
+// Quotes s as a single shell word, so that it can be spliced into a command
+// whatever characters it contains (including single quotes).
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Runs cmd on the location with the paths as its trailing arguments, writing
+// its output to stdout if given. The paths are handed to xargs on stdin,
+// separated by NULs, so that there can be any number of them & they can
+// contain any character (including newlines).
+func Xargs(exec config.Executor, cmd string, paths []string, stdout io.Writer) error {
+	stdin := strings.NewReader(strings.Join(paths, "\x00") + "\x00")
+	if stderr, err := exec.ExecuteShellStreaming("xargs -0 "+cmd+" --", stdin, stdout); err != nil {
+		return fmt.Errorf("failed to run %s on %s (stderr: %s): %w", cmd, exec.Name(), strings.TrimSpace(stderr), err)
+	}
+	return nil
+}
+
+// Returns the SHA-256 of each of the files on the location (relative to dir,
+// if given), keyed by path.
+func SHA256Sums(exec config.Executor, dir string, paths []string) (map[string]string, error) {
+	relative := make(map[string]string)
+	fullPaths := []string{}
+	for _, p := range paths {
+		fullPath := filepath.Join(dir, p)
+		relative[fullPath] = p
+		fullPaths = append(fullPaths, fullPath)
+	}
+	var stdout strings.Builder
+	if err := Xargs(exec, "sha256sum", fullPaths, &stdout); err != nil {
+		return nil, err
+	}
+
+	sums := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(stdout.String()), "\n") {
+		// Text & binary mode are marked by a space & a '*' respectively.
+		sum, path, found := strings.Cut(line, " ")
+		if !found || len(path) == 0 {
+			continue
+		}
+		path = path[1:]
+		// sha256sum escapes paths containing backslashes or newlines, & marks
+		// their lines with a leading backslash.
+		if strings.HasPrefix(sum, "\\") {
+			sum = sum[1:]
+			path = strings.NewReplacer("\\\\", "\\", "\\n", "\n").Replace(path)
+		}
+		if p, prs := relative[path]; prs {
+			sums[p] = sum
+		}
 	}
-	return scriptPathBase64, nil
+	return sums, nil
 }
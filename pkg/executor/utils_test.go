@@ -0,0 +1,65 @@
+package executor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestShellQuote(t *testing.T) {
+	exec := NewLocalExecutor("local", "", 0)
+	defer exec.Close()
+	for _, s := range []string{"plain", "with space", "it's", "''", "$HOME `id` \"x\"", "with\nnewline", ""} {
+		stdout, stderr, err := exec.ExecuteShell("printf '%s' " + ShellQuote(s))
+		if err != nil {
+			t.Fatalf("failed to echo %q (stderr: %s): %v", s, stderr, err)
+		}
+		if stdout != s {
+			t.Errorf("expected %q, got %q", s, stdout)
+		}
+	}
+}
+
+func TestSHA256Sums(t *testing.T) {
+	dir := t.TempDir()
+	contents := map[string]string{
+		"plain":            "foo",
+		"with space":       "bar",
+		"with\\backslash":  "baz",
+		"with\nnewline":    "qux",
+		"sub/nested  file": "quux",
+	}
+	paths := []string{}
+	for p, c := range contents {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, p)), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, p), []byte(c), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", p, err)
+		}
+		paths = append(paths, p)
+	}
+
+	exec := NewLocalExecutor("local", dir, 0)
+	defer exec.Close()
+	sums, err := SHA256Sums(exec, dir, paths)
+	if err != nil {
+		t.Fatalf("failed to checksum files: %v", err)
+	}
+	if len(sums) != len(contents) {
+		t.Errorf("expected %d checksums, got %v", len(contents), sums)
+	}
+	for p, c := range contents {
+		sum := sha256.Sum256([]byte(c))
+		if expected := hex.EncodeToString(sum[:]); sums[p] != expected {
+			t.Errorf("expected sha256 %s for %q, got %s", expected, p, sums[p])
+		}
+	}
+
+	sums, err = SHA256Sums(exec, "", []string{filepath.Join(dir, "plain")})
+	if err != nil || sums[filepath.Join(dir, "plain")] == "" {
+		t.Errorf("expected checksum keyed by absolute path, got %v (err: %v)", sums, err)
+	}
+}
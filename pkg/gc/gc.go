@@ -0,0 +1,102 @@
+package gc
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/mrshanahan/deploy-assets/internal/util"
+	"github.com/mrshanahan/deploy-assets/pkg/config"
+	"github.com/mrshanahan/deploy-assets/pkg/executor"
+	"github.com/mrshanahan/deploy-assets/pkg/manifest"
+)
+
+// Where the scp transport staged files before staging directories were
+// per-location.
+const legacyScpDir = "/tmp/deploy-assets"
+
+// Removes what runs that crashed or were interrupted left behind & that hasn't
+// been touched for olderThan: per-run staging directories on every location,
+// along with anything the manifest's transports left (e.g. objects in an s3
+// bucket, or chunks of unfinished transfers). With dryRun, it's only listed.
+func Collect(m *manifest.Manifest, olderThan time.Duration, dryRun bool) error {
+	for _, e := range util.Values(m.Executors) {
+		defer e.Close()
+	}
+
+	cutoff := time.Now().Add(-olderThan)
+	locations := util.Map(m.Locations, func(n string) config.Executor { return m.Executors[n] })
+	garbage := []config.Garbage{}
+	errs := []error{}
+	for _, l := range locations {
+		found, err := findStagingGarbage(l, cutoff)
+		garbage = append(garbage, found...)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	// Auto transports have none of their own, but may have built some of those
+	// they pick from.
+	transportNames := []string{}
+	for _, name := range m.TransportOrder {
+		for _, n := range append([]string{name}, m.Transports[name].Prefer...) {
+			if !slices.Contains(transportNames, n) {
+				transportNames = append(transportNames, n)
+			}
+		}
+	}
+	for _, name := range transportNames {
+		for t := m.Transports[name].Transport; t != nil; t = unwrap(t) {
+			if collector, ok := t.(config.GarbageCollector); ok {
+				found, err := collector.FindGarbage(locations, cutoff)
+				garbage = append(garbage, found...)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("transport %s: %w", name, err))
+				}
+			}
+		}
+	}
+
+	removed := 0
+	for _, g := range garbage {
+		age := time.Since(g.ModifiedAt).Truncate(time.Second)
+		if dryRun {
+			slog.Info("DRY RUN: removing", "location", g.Location, "path", g.Path, "age", age)
+			continue
+		}
+		slog.Info("removing", "location", g.Location, "path", g.Path, "age", age)
+		if err := g.Remove(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		removed++
+	}
+	if dryRun {
+		slog.Info("DRY RUN: garbage collection finished", "found", len(garbage), "older-than", olderThan)
+	} else {
+		slog.Info("garbage collection finished", "found", len(garbage), "removed", removed, "older-than", olderThan)
+	}
+	return errors.Join(errs...)
+}
+
+func findStagingGarbage(l config.Executor, cutoff time.Time) ([]config.Garbage, error) {
+	stagingRoot := executor.DefaultStagingRoot
+	if rooter, ok := l.(config.StagingRooter); ok {
+		stagingRoot = rooter.StagingRoot()
+	}
+	garbage, err := executor.FindGarbage(l, stagingRoot, []string{executor.StagingDirPattern}, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	found, err := executor.FindGarbage(l, legacyScpDir, []string{"scp_*"}, cutoff)
+	return append(garbage, found...), err
+}
+
+func unwrap(t config.Transport) config.Transport {
+	if wrapping, ok := t.(config.WrappingTransport); ok {
+		return wrapping.Unwrap()
+	}
+	return nil
+}
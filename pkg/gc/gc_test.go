@@ -0,0 +1,138 @@
+package gc
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mrshanahan/deploy-assets/internal/s3test"
+	"github.com/mrshanahan/deploy-assets/pkg/manifest"
+)
+
+func buildManifest(t *testing.T, raw map[string]any) *manifest.Manifest {
+	manifestBytes, err := json.Marshal(raw)
+	if err != nil {
+		t.Fatalf("failed to serialize manifest: %v", err)
+	}
+	root, err := manifest.ParseManifest(manifestBytes)
+	if err != nil {
+		t.Fatalf("failed to parse manifest: %v", err)
+	}
+	m, err := manifest.BuildManifest(t.TempDir(), root)
+	if err != nil {
+		t.Fatalf("failed to build manifest: %v", err)
+	}
+	return m
+}
+
+// Creates a file or directory (if name ends in /) last modified age ago.
+func createAged(t *testing.T, path string, age time.Duration) {
+	if strings.HasSuffix(path, "/") {
+		if err := os.MkdirAll(filepath.Join(path, "contents"), 0755); err != nil {
+			t.Fatalf("failed to create %s: %v", path, err)
+		}
+	} else {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create %s: %v", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(path, []byte("left behind\n"), 0644); err != nil {
+			t.Fatalf("failed to create %s: %v", path, err)
+		}
+	}
+	modTime := time.Now().Add(-age)
+	if err := os.Chtimes(filepath.Clean(path), modTime, modTime); err != nil {
+		t.Fatalf("failed to set times on %s: %v", path, err)
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestCollect(t *testing.T) {
+	s3Server := s3test.NewServer(t, "bucket")
+	s3Server.SetEnv(t)
+	stagingA, stagingB, chunkDir := t.TempDir(), t.TempDir(), t.TempDir()
+
+	oldPaths := []string{
+		filepath.Join(stagingA, "deploy-assets-2025-06-01T120000.000000001Z-1a2b3c4d") + "/",
+		filepath.Join(stagingB, "deploy-assets-2025-06-02T120000.000000001Z-5e6f7a8b") + "/",
+		filepath.Join(chunkDir, strings.Repeat("4f1c2a9e", 8)+".part"),
+		filepath.Join(chunkDir, strings.Repeat("0b7d3e5f", 8)),
+	}
+	keptPaths := []string{
+		// Recent, so possibly in use by a run that's still going.
+		filepath.Join(stagingA, "deploy-assets-2025-06-03T120000.000000001Z-9c0d1e2f") + "/",
+		filepath.Join(chunkDir, strings.Repeat("8be03d61", 8)),
+		// Not made by deploy-assets, e.g. because chunk_dir is shared.
+		filepath.Join(stagingB, "deploy-assets-fixtures") + "/",
+		filepath.Join(stagingB, "unrelated.txt"),
+		filepath.Join(chunkDir, "unrelated.txt"),
+		filepath.Join(chunkDir, "cafe.part"),
+		filepath.Join(chunkDir, "other-program") + "/",
+	}
+	for _, p := range oldPaths {
+		createAged(t, p, 48*time.Hour)
+	}
+	for i, p := range keptPaths {
+		age := 48 * time.Hour
+		if i < 2 {
+			age = time.Minute
+		}
+		createAged(t, p, age)
+	}
+	s3Server.PutObject("bucket", "deploy/transfer-2025-06-01T120000.000000001Z-1a2b3c4d", []byte("old"))
+	s3Server.SetModTime("bucket", "deploy/transfer-2025-06-01T120000.000000001Z-1a2b3c4d", time.Now().Add(-48*time.Hour))
+	s3Server.PutObject("bucket", "deploy/transfer-2025-06-03T120000.000000001Z-9c0d1e2f", []byte("recent"))
+	s3Server.PutObject("bucket", "deploy/release.tar.gz", []byte("not ours"))
+	s3Server.SetModTime("bucket", "deploy/release.tar.gz", time.Now().Add(-48*time.Hour))
+
+	raw := map[string]any{
+		"locations": []map[string]any{
+			{"type": "local", "name": "a", "staging_dir": stagingA},
+			{"type": "local", "name": "b", "staging_dir": stagingB},
+		},
+		"transport": map[string]any{
+			"type":       "s3",
+			"bucket_url": "s3://bucket/deploy",
+			"endpoint":   s3Server.URL,
+			"chunk_size": "1MiB",
+			"chunk_dir":  chunkDir,
+		},
+		"assets": []map[string]any{},
+	}
+
+	if err := Collect(buildManifest(t, raw), 24*time.Hour, true); err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+	for _, p := range append(oldPaths, keptPaths...) {
+		if !exists(p) {
+			t.Errorf("expected dry run to leave %s", p)
+		}
+	}
+	if keys := s3Server.Keys("bucket"); len(keys) != 3 {
+		t.Errorf("expected dry run to leave every object, found %v", keys)
+	}
+
+	if err := Collect(buildManifest(t, raw), 24*time.Hour, false); err != nil {
+		t.Fatalf("collection failed: %v", err)
+	}
+	for _, p := range oldPaths {
+		if exists(p) {
+			t.Errorf("expected %s to be removed", p)
+		}
+	}
+	for _, p := range keptPaths {
+		if !exists(p) {
+			t.Errorf("expected %s to be kept", p)
+		}
+	}
+	keys := s3Server.Keys("bucket")
+	if len(keys) != 2 || keys[0] != "deploy/release.tar.gz" || keys[1] != "deploy/transfer-2025-06-03T120000.000000001Z-9c0d1e2f" {
+		t.Errorf("expected only the old transfer object to be removed, found %v", keys)
+	}
+}
@@ -0,0 +1,70 @@
+package manifest
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mrshanahan/deploy-assets/internal/util"
+)
+
+const (
+	allLocationsRef  string = "*"
+	excludeRefPrefix string = "!"
+)
+
+// Expands a list of destination references into location names. Each
+// reference is either a location name, a group name, or `*` (all locations
+// other than the source); any reference prefixed with `!` removes the matching
+// location(s) from the result regardless of where it appears in the list.
+// Results follow the order in which locations were declared in the manifest.
+func (m *Manifest) ResolveDestinations(src string, refs []string) ([]string, error) {
+	included := util.NewSet[string]()
+	excluded := util.NewSet[string]()
+	for _, ref := range refs {
+		target, isExclusion := strings.CutPrefix(ref, excludeRefPrefix)
+		names, err := m.resolveReference(src, target)
+		if err != nil {
+			return nil, err
+		}
+		if isExclusion {
+			excluded.Add(names...)
+		} else {
+			included.Add(names...)
+		}
+	}
+
+	resolved := []string{}
+	for _, name := range m.Locations {
+		if included.Contains(name) && !excluded.Contains(name) {
+			resolved = append(resolved, name)
+		}
+	}
+	return resolved, nil
+}
+
+func (m *Manifest) resolveReference(src string, ref string) ([]string, error) {
+	if ref == allLocationsRef {
+		return util.Filter(m.Locations, func(l string) bool { return l != src }), nil
+	}
+	if _, prs := m.Executors[ref]; prs {
+		return []string{ref}, nil
+	}
+	if members, prs := m.Groups[ref]; prs {
+		// As with '*', the source never receives its own asset via a group.
+		return util.Filter(members, func(l string) bool { return l != src }), nil
+	}
+	return nil, fmt.Errorf("no such location or group: %s", ref)
+}
+
+func validateGroups(manifest *Manifest) []error {
+	errs := []error{}
+	for group := range manifest.Groups {
+		if _, prs := manifest.Executors[group]; prs {
+			errs = append(errs, fmt.Errorf("group '%s' has the same name as a location", group))
+		}
+		if group == allLocationsRef || strings.HasPrefix(group, excludeRefPrefix) {
+			errs = append(errs, fmt.Errorf("invalid group name: %s", group))
+		}
+	}
+	return errs
+}
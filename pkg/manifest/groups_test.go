@@ -0,0 +1,107 @@
+package manifest
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func buildTestManifest(t *testing.T, json string) (*Manifest, error) {
+	root, err := ParseManifest([]byte(json))
+	if err != nil {
+		t.Fatalf("failed to parse manifest: %v", err)
+	}
+	return BuildManifest("/tmp", root)
+}
+
+const groupsTestLocations = `
+	"locations": [
+		{ "type": "local", "name": "src" },
+		{ "type": "local", "name": "web1", "groups": ["web", "prod"] },
+		{ "type": "local", "name": "web2", "groups": ["web"] },
+		{ "type": "local", "name": "db1", "groups": ["prod"] },
+		{ "type": "local", "name": "staging", "groups": ["web"] }
+	],
+	"transport": { "type": "s3", "bucket_url": "s3://test" }`
+
+func TestResolveDestinations(t *testing.T) {
+	m, err := buildTestManifest(t, `{`+groupsTestLocations+`, "assets": []}`)
+	if err != nil {
+		t.Fatalf("failed to build manifest: %v", err)
+	}
+	defer func() {
+		for _, e := range m.Executors {
+			e.Close()
+		}
+	}()
+
+	var tests = []struct {
+		name     string
+		refs     []string
+		expected []string
+	}{
+		{"single location", []string{"db1"}, []string{"db1"}},
+		{"source explicitly", []string{"src"}, []string{"src"}},
+		{"wildcard skips source", []string{"*"}, []string{"web1", "web2", "db1", "staging"}},
+		{"group", []string{"web"}, []string{"web1", "web2", "staging"}},
+		{"group & location in declaration order", []string{"db1", "web"}, []string{"web1", "web2", "db1", "staging"}},
+		{"overlapping groups", []string{"web", "prod"}, []string{"web1", "web2", "db1", "staging"}},
+		{"wildcard with exclusion", []string{"*", "!staging"}, []string{"web1", "web2", "db1"}},
+		{"exclusion before inclusion", []string{"!staging", "*"}, []string{"web1", "web2", "db1"}},
+		{"group with group exclusion", []string{"web", "!prod"}, []string{"web2", "staging"}},
+		{"everything excluded", []string{"web", "!*"}, []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(s *testing.T) {
+			actual, err := m.ResolveDestinations("src", test.refs)
+			if err != nil {
+				s.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(test.expected, actual) {
+				s.Errorf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestBuildManifestDestinationValidation(t *testing.T) {
+	var tests = []struct {
+		name   string
+		json   string
+		errMsg string
+	}{
+		{
+			"unknown group",
+			`{` + groupsTestLocations + `, "assets": [{ "type": "file", "src": "src", "dst": ["web", "nope"], "src_path": "x", "dst_path": "/tmp/x" }]}`,
+			"no such location or group: nope",
+		},
+		{
+			"unknown exclusion",
+			`{` + groupsTestLocations + `, "assets": [{ "type": "file", "src": "src", "dst": ["*", "!nope"], "src_path": "x", "dst_path": "/tmp/x" }]}`,
+			"no such location or group: nope",
+		},
+		{
+			"group shadowing location",
+			`{"locations": [{ "type": "local", "name": "a" }, { "type": "local", "name": "b", "groups": ["a"] }], "transport": { "type": "s3", "bucket_url": "s3://test" }, "assets": []}`,
+			"group 'a' has the same name as a location",
+		},
+		{
+			"duplicate location",
+			`{"locations": [{ "type": "local", "name": "a" }, { "type": "local", "name": "a" }], "transport": { "type": "s3", "bucket_url": "s3://test" }, "assets": []}`,
+			"duplicate location name: a",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(s *testing.T) {
+			_, err := buildTestManifest(s, test.json)
+			if err == nil {
+				s.Fatalf("expected error containing '%s', got nil", test.errMsg)
+			}
+			if !strings.Contains(err.Error(), test.errMsg) {
+				s.Errorf("expected error to contain '%s', but did not: %v", test.errMsg, err)
+			}
+		})
+	}
+}
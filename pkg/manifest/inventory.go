@@ -0,0 +1,294 @@
+package manifest
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/mrshanahan/deploy-assets/internal/util"
+	"github.com/mrshanahan/deploy-assets/pkg/executor"
+)
+
+// Dynamic inventories produce ssh locations at build time. Both supported
+// formats describe the same thing: a set of hosts, each with optional
+// per-host attributes & variables, and a set of groups listing host names.
+//
+// JSON:
+//
+//	{
+//	    "hosts": { "web1": { "server": "10.0.0.1:22", "run_elevated": true, "vars": { "role": "primary" } } },
+//	    "groups": { "web": ["web1"] }
+//	}
+//
+// INI (hosts listed before any section are ungrouped):
+//
+//	web1 server=10.0.0.1:22 vars.role=primary
+//	[web]
+//	web1 run_elevated=true
+
+type inventoryHost struct {
+	name       string
+	attributes map[string]string
+	// Host variables, which the host's post-commands get as environment
+	// variables. Nil if the host has none.
+	vars   map[string]string
+	groups []string
+}
+
+type sshLocationConfig struct {
+	name          string
+	addr          string
+	user          string
+	keyPath       string
+	keyPassphrase string
+	runElevated   bool
+	stagingDir    string
+	groups        []string
+	// In bytes per second, or 0 for no limit.
+	bandwidthLimit int64
+	// Exported to post-commands run on the location; nil if there are none.
+	vars map[string]string
+}
+
+var inventoryHostAttributeNames = []string{"server", "username", "key_file", "key_file_passphrase", "run_elevated", "staging_dir", "bandwidth_limit"}
+var inventoryHostAttributes = util.NewSet(inventoryHostAttributeNames...)
+
+// Var names have to be usable as environment variable names in the shell.
+var inventoryVarNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func (h *inventoryHost) setVar(name string, value string) error {
+	if !inventoryVarNamePattern.MatchString(name) {
+		return fmt.Errorf("host '%s': invalid var name '%s': expected letters, digits & underscores, not starting with a digit", h.name, name)
+	}
+	if h.vars == nil {
+		h.vars = map[string]string{}
+	}
+	h.vars[name] = value
+	return nil
+}
+
+func loadInventory(manifestDir string, item *ItemNode) ([]*inventoryHost, error) {
+	switch item.Type {
+	case "exec":
+		command := item.Attributes["command"].GetValue().(string)
+		exec := executor.NewLocalExecutor("inventory", "", 0)
+		defer exec.Close()
+		stdout, stderr, err := exec.ExecuteShellInDir(manifestDir, command)
+		if err != nil {
+			return nil, fmt.Errorf("inventory command failed (stderr: %s): %w", stderr, err)
+		}
+		return parseJSONInventory([]byte(stdout))
+	case "file":
+		path := item.Attributes["path"].GetValue().(string)
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(manifestDir, path)
+		}
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read inventory file: %w", err)
+		}
+		format := item.Attributes["format"].GetValue().(string)
+		if format == "" {
+			format = strings.TrimPrefix(filepath.Ext(path), ".")
+		}
+		switch format {
+		case "json":
+			return parseJSONInventory(raw)
+		case "ini":
+			return parseINIInventory(raw)
+		default:
+			return nil, fmt.Errorf("unknown inventory format '%s' for %s; specify the format attribute as 'json' or 'ini'", format, path)
+		}
+	default:
+		return nil, fmt.Errorf("unknown inventory type: %s", item.Type)
+	}
+}
+
+func parseJSONInventory(raw []byte) ([]*inventoryHost, error) {
+	var inventory struct {
+		Hosts  map[string]map[string]any `json:"hosts"`
+		Groups map[string][]string       `json:"groups"`
+	}
+	if err := json.Unmarshal(raw, &inventory); err != nil {
+		return nil, fmt.Errorf("failed to parse inventory JSON: %w", err)
+	}
+
+	hosts := map[string]*inventoryHost{}
+	for name, rawAttrs := range inventory.Hosts {
+		host := &inventoryHost{name, map[string]string{}, nil, []string{}}
+		for k, v := range rawAttrs {
+			if k == "vars" {
+				vars, ok := v.(map[string]any)
+				if !ok {
+					return nil, fmt.Errorf("host '%s': vars must be an object", name)
+				}
+				for varName, varValue := range vars {
+					switch varValue := varValue.(type) {
+					case string, bool, float64:
+						if err := host.setVar(varName, fmt.Sprint(varValue)); err != nil {
+							return nil, err
+						}
+					default:
+						return nil, fmt.Errorf("host '%s': var '%s' must be a string, number or bool", name, varName)
+					}
+				}
+				continue
+			}
+			switch v := v.(type) {
+			case string:
+				host.attributes[k] = v
+			case bool:
+				host.attributes[k] = strconv.FormatBool(v)
+			default:
+				return nil, fmt.Errorf("host '%s': attribute '%s' must be a string or bool", name, k)
+			}
+		}
+		hosts[name] = host
+	}
+
+	groupNames := util.Keys(inventory.Groups)
+	slices.Sort(groupNames)
+	for _, group := range groupNames {
+		for _, name := range inventory.Groups[group] {
+			host, prs := hosts[name]
+			if !prs {
+				return nil, fmt.Errorf("group '%s' references unknown host '%s'", group, name)
+			}
+			host.groups = append(host.groups, group)
+		}
+	}
+
+	names := util.Keys(hosts)
+	slices.Sort(names)
+	return util.Map(names, func(n string) *inventoryHost { return hosts[n] }), nil
+}
+
+func parseINIInventory(raw []byte) ([]*inventoryHost, error) {
+	hosts := map[string]*inventoryHost{}
+	order := []string{}
+	group := ""
+
+	scanner := bufio.NewScanner(strings.NewReader(string(raw)))
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum += 1
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %d: unterminated section header: %s", lineNum, line)
+			}
+			group = strings.TrimSpace(line[1 : len(line)-1])
+			if group == "" {
+				return nil, fmt.Errorf("line %d: empty section name", lineNum)
+			}
+			continue
+		}
+
+		fields := strings.Fields(line)
+		name := fields[0]
+		host, prs := hosts[name]
+		if !prs {
+			host = &inventoryHost{name, map[string]string{}, nil, []string{}}
+			hosts[name] = host
+			order = append(order, name)
+		}
+		for _, f := range fields[1:] {
+			k, v, ok := strings.Cut(f, "=")
+			if !ok {
+				return nil, fmt.Errorf("line %d: expected key=value, got '%s'", lineNum, f)
+			}
+			if varName, isVar := strings.CutPrefix(k, "vars."); isVar {
+				if existing, prs := host.vars[varName]; prs && existing != v {
+					return nil, fmt.Errorf("line %d: conflicting values for var '%s' on host '%s' (%s vs. %s)", lineNum, varName, name, existing, v)
+				}
+				if err := host.setVar(varName, v); err != nil {
+					return nil, fmt.Errorf("line %d: %w", lineNum, err)
+				}
+				continue
+			}
+			if existing, prs := host.attributes[k]; prs && existing != v {
+				return nil, fmt.Errorf("line %d: conflicting values for '%s' on host '%s' (%s vs. %s)", lineNum, k, name, existing, v)
+			}
+			host.attributes[k] = v
+		}
+		if group != "" && !slices.Contains(host.groups, group) {
+			host.groups = append(host.groups, group)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read inventory: %w", err)
+	}
+
+	return util.Map(order, func(n string) *inventoryHost { return hosts[n] }), nil
+}
+
+// Combines the inventory item's defaults with each host's own attributes.
+func resolveInventoryHosts(item *ItemNode, hosts []*inventoryHost) ([]*sshLocationConfig, error) {
+	defaultGroups := item.Attributes["groups"].GetValue().([]string)
+	configs := []*sshLocationConfig{}
+	for _, h := range hosts {
+		for k := range h.attributes {
+			if !inventoryHostAttributes.Contains(k) {
+				return nil, fmt.Errorf("host '%s': unrecognized attribute '%s'; only %s can be set per host, & anything else belongs under vars",
+					h.name, k, strings.Join(inventoryHostAttributeNames, ", "))
+			}
+		}
+
+		getAttr := func(k string) string {
+			if v, prs := h.attributes[k]; prs {
+				return v
+			}
+			return item.Attributes[k].GetValue().(string)
+		}
+
+		runElevated := item.Attributes["run_elevated"].GetValue().(bool)
+		if v, prs := h.attributes["run_elevated"]; prs {
+			parsed, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("host '%s': invalid run_elevated value '%s'", h.name, v)
+			}
+			runElevated = parsed
+		}
+
+		bandwidthLimit, err := parseBandwidthLimit(getAttr("bandwidth_limit"))
+		if err != nil {
+			return nil, fmt.Errorf("host '%s': %w", h.name, err)
+		}
+
+		addr := h.name
+		if v, prs := h.attributes["server"]; prs {
+			addr = v
+		}
+
+		cfg := &sshLocationConfig{
+			name:          h.name,
+			addr:          addr,
+			user:          getAttr("username"),
+			keyPath:       getAttr("key_file"),
+			keyPassphrase: getAttr("key_file_passphrase"),
+			runElevated:   runElevated,
+			stagingDir:    getAttr("staging_dir"),
+			groups:        append(slices.Clone(defaultGroups), h.groups...),
+
+			bandwidthLimit: bandwidthLimit,
+			vars:           h.vars,
+		}
+		if cfg.user == "" {
+			return nil, fmt.Errorf("host '%s': no username provided by inventory or defaults", h.name)
+		}
+		if cfg.keyPath == "" {
+			return nil, fmt.Errorf("host '%s': no key_file provided by inventory or defaults", h.name)
+		}
+		configs = append(configs, cfg)
+	}
+	return configs, nil
+}
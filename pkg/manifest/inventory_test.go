@@ -0,0 +1,213 @@
+package manifest
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func parseInventoryItem(t *testing.T, itemJson string) *ItemNode {
+	json := `{"locations": [], "locations_from": [` + itemJson + `], "transport": { "type": "s3", "bucket_url": "s3://test" }, "assets": []}`
+	root, err := ParseManifest([]byte(json))
+	if err != nil {
+		t.Fatalf("failed to parse manifest: %v", err)
+	}
+	return root.Kinds["locations_from"].Items[0]
+}
+
+func TestParseManifestWithoutLocationsFrom(t *testing.T) {
+	root, err := ParseManifest([]byte(`{"locations": [], "transport": { "type": "s3", "bucket_url": "s3://test" }, "assets": []}`))
+	if err != nil {
+		t.Fatalf("failed to parse manifest: %v", err)
+	}
+	if len(root.Kinds["locations_from"].Items) != 0 {
+		t.Errorf("expected no inventory items, got %d", len(root.Kinds["locations_from"].Items))
+	}
+}
+
+func TestParseJSONInventory(t *testing.T) {
+	raw := `{
+		"hosts": {
+			"web2": {},
+			"web1": { "server": "10.0.0.1:22", "run_elevated": true, "vars": { "role": "primary", "weight": 2 } }
+		},
+		"groups": {
+			"web": ["web1", "web2"],
+			"canary": ["web2"]
+		}
+	}`
+	hosts, err := parseJSONInventory([]byte(raw))
+	if err != nil {
+		t.Fatalf("failed to parse inventory: %v", err)
+	}
+	expected := []*inventoryHost{
+		{"web1", map[string]string{"server": "10.0.0.1:22", "run_elevated": "true"}, map[string]string{"role": "primary", "weight": "2"}, []string{"web"}},
+		{"web2", map[string]string{}, nil, []string{"canary", "web"}},
+	}
+	if !reflect.DeepEqual(expected, hosts) {
+		t.Errorf("expected %+v, got %+v", expected, hosts)
+	}
+}
+
+func TestParseJSONInventoryInvalidVars(t *testing.T) {
+	_, err := parseJSONInventory([]byte(`{"hosts": {"web1": {"vars": {"ports": [80, 443]}}}}`))
+	if err == nil || !strings.Contains(err.Error(), "var 'ports' must be a string, number or bool") {
+		t.Errorf("expected invalid var error, got %v", err)
+	}
+	_, err = parseJSONInventory([]byte(`{"hosts": {"web1": {"vars": {"app-role": "primary"}}}}`))
+	if err == nil || !strings.Contains(err.Error(), "host 'web1': invalid var name 'app-role'") {
+		t.Errorf("expected invalid var name error, got %v", err)
+	}
+}
+
+func TestParseJSONInventoryUnknownHost(t *testing.T) {
+	_, err := parseJSONInventory([]byte(`{"hosts": {"web1": {}}, "groups": {"web": ["web2"]}}`))
+	if err == nil || !strings.Contains(err.Error(), "unknown host 'web2'") {
+		t.Errorf("expected unknown host error, got %v", err)
+	}
+}
+
+func TestParseINIInventory(t *testing.T) {
+	raw := `
+# ungrouped
+db1 server=10.0.0.3:2222
+
+[web]
+web1 server=10.0.0.1 username=deploy vars.role=primary
+web2
+
+; web1 appears again without conflicting attributes
+[prod]
+web1 server=10.0.0.1
+db1
+`
+	hosts, err := parseINIInventory([]byte(raw))
+	if err != nil {
+		t.Fatalf("failed to parse inventory: %v", err)
+	}
+	expected := []*inventoryHost{
+		{"db1", map[string]string{"server": "10.0.0.3:2222"}, nil, []string{"prod"}},
+		{"web1", map[string]string{"server": "10.0.0.1", "username": "deploy"}, map[string]string{"role": "primary"}, []string{"web", "prod"}},
+		{"web2", map[string]string{}, nil, []string{"web"}},
+	}
+	if !reflect.DeepEqual(expected, hosts) {
+		t.Errorf("expected %+v, got %+v", expected, hosts)
+	}
+}
+
+func TestParseINIInventoryErrors(t *testing.T) {
+	var tests = []struct {
+		raw    string
+		errMsg string
+	}{
+		{"[web\nweb1", "unterminated section header"},
+		{"web1 server", "expected key=value"},
+		{"web1 server=a\n[web]\nweb1 server=b", "conflicting values for 'server'"},
+		{"web1 vars.role=a\n[web]\nweb1 vars.role=b", "conflicting values for var 'role'"},
+		{"web1 vars.1st=a", "line 1: host 'web1': invalid var name '1st'"},
+	}
+	for _, test := range tests {
+		t.Run(test.errMsg, func(s *testing.T) {
+			_, err := parseINIInventory([]byte(test.raw))
+			if err == nil || !strings.Contains(err.Error(), test.errMsg) {
+				s.Errorf("expected error containing '%s', got %v", test.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestResolveInventoryHosts(t *testing.T) {
+	item := parseInventoryItem(t, `{"type": "exec", "command": "true", "username": "ubuntu", "key_file": "/keys/default.pem", "groups": ["dynamic"]}`)
+	hosts := []*inventoryHost{
+		{"web1", map[string]string{"server": "10.0.0.1:22", "run_elevated": "true"}, map[string]string{"role": "primary"}, []string{"web"}},
+		{"web2.internal", map[string]string{"username": "deploy", "staging_dir": "/var/tmp", "bandwidth_limit": "1MiB/s"}, nil, []string{}},
+	}
+
+	configs, err := resolveInventoryHosts(item, hosts)
+	if err != nil {
+		t.Fatalf("failed to resolve hosts: %v", err)
+	}
+	expected := []*sshLocationConfig{
+		{"web1", "10.0.0.1:22", "ubuntu", "/keys/default.pem", "", true, "/tmp", []string{"dynamic", "web"}, 0, map[string]string{"role": "primary"}},
+		{"web2.internal", "web2.internal", "deploy", "/keys/default.pem", "", false, "/var/tmp", []string{"dynamic"}, 1024 * 1024, nil},
+	}
+	if !reflect.DeepEqual(expected, configs) {
+		t.Errorf("expected %+v, got %+v", expected, configs)
+	}
+}
+
+func TestResolveInventoryHostsErrors(t *testing.T) {
+	var tests = []struct {
+		name     string
+		itemJson string
+		host     *inventoryHost
+		errMsg   string
+	}{
+		{
+			"unknown attribute",
+			`{"type": "exec", "command": "true", "username": "u", "key_file": "k"}`,
+			&inventoryHost{"web1", map[string]string{"flavor": "large"}, nil, []string{}},
+			"unrecognized attribute 'flavor'",
+		},
+		{
+			"invalid bandwidth limit",
+			`{"type": "exec", "command": "true", "username": "u", "key_file": "k"}`,
+			&inventoryHost{"web1", map[string]string{"bandwidth_limit": "fast"}, nil, []string{}},
+			"host 'web1': invalid bandwidth_limit",
+		},
+		{
+			"missing username",
+			`{"type": "exec", "command": "true", "key_file": "k"}`,
+			&inventoryHost{"web1", map[string]string{}, nil, []string{}},
+			"no username",
+		},
+		{
+			"invalid run_elevated",
+			`{"type": "exec", "command": "true", "username": "u", "key_file": "k"}`,
+			&inventoryHost{"web1", map[string]string{"run_elevated": "sometimes"}, nil, []string{}},
+			"invalid run_elevated",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(s *testing.T) {
+			item := parseInventoryItem(s, test.itemJson)
+			_, err := resolveInventoryHosts(item, []*inventoryHost{test.host})
+			if err == nil || !strings.Contains(err.Error(), test.errMsg) {
+				s.Errorf("expected error containing '%s', got %v", test.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestLoadInventory(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "hosts.ini"), []byte("[web]\nweb1\n"), 0600); err != nil {
+		t.Fatalf("failed to write inventory file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "hosts.txt"), []byte(`{"hosts": {"web1": {}}, "groups": {"web": ["web1"]}}`), 0600); err != nil {
+		t.Fatalf("failed to write inventory file: %v", err)
+	}
+
+	expected := []*inventoryHost{{"web1", map[string]string{}, nil, []string{"web"}}}
+	var tests = []struct {
+		name     string
+		itemJson string
+	}{
+		{"exec", `{"type": "exec", "command": "cat hosts.txt"}`},
+		{"file (format from extension)", `{"type": "file", "path": "hosts.ini"}`},
+		{"file (explicit format)", `{"type": "file", "path": "hosts.txt", "format": "json"}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(s *testing.T) {
+			hosts, err := loadInventory(dir, parseInventoryItem(s, test.itemJson))
+			if err != nil {
+				s.Fatalf("failed to load inventory: %v", err)
+			}
+			if !reflect.DeepEqual(expected, hosts) {
+				s.Errorf("expected %+v, got %+v", expected, hosts)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"os"
 	"reflect"
 	"regexp"
@@ -49,6 +50,12 @@ func (a *AttributeNode) GetValue() any {
 		}
 		return finalValStrs
 	}
+	if a.MatchingValueType == "int" {
+		if f, ok := a.value.(float64); ok {
+			return int(f)
+		}
+		return a.value
+	}
 	if a.MatchingValueType == "[]object" {
 		// This is a bit crazy. For the separate casting paths see the comment in checkValueType.
 		// For the nested map conversion - we need to do a pretty deep copy-and-cast to get a
@@ -124,9 +131,12 @@ func ParseManifest(raw []byte) (*ManifestNode, error) {
 	for _, kindSpec := range manifestSpec.Kinds {
 		kindName := kindSpec.Name()
 		kindJson, prs := manifestObj[kindName]
-		if !prs {
+		if !prs && kindSpec.IsRequired() && !hasAlternative(kindSpec, manifestObj) {
 			errs = append(errs, fmt.Errorf("<root>: missing required top-level key '%s'", kindName))
 			continue
+		} else if !prs {
+			manifestNode.Kinds[kindName] = &KindNode{Items: []*ItemNode{}}
+			continue
 		}
 
 		kindNode := &KindNode{
@@ -182,6 +192,15 @@ func ParseManifest(raw []byte) (*ManifestNode, error) {
 	return manifestNode, nil
 }
 
+func hasAlternative(kindSpec ManifestKindSpec, manifestObj map[string]any) bool {
+	alternativeSpec, ok := kindSpec.(AlternativeKindSpec)
+	if !ok {
+		return false
+	}
+	_, prs := manifestObj[alternativeSpec.Alternative()]
+	return prs
+}
+
 func buildItemNode(itemJson map[string]any, itemSpecs map[string]ManifestItemSpec, jsonPath string) (*ItemNode, error) {
 	var typ string
 	if err := getRequiredField(itemJson, "type", &typ); err != nil {
@@ -270,7 +289,14 @@ func checkValueType(v any, types []string) (string, error) {
 				validType = t
 			}
 		case "int":
-			_, ok := v.(int)
+			// Numbers in JSON are decoded as float64; defaults are ints.
+			ok := false
+			switch n := v.(type) {
+			case int:
+				ok = true
+			case float64:
+				ok = n == math.Trunc(n)
+			}
 			if ok && validType == "" {
 				validType = t
 			}
@@ -151,6 +151,20 @@ func TestGetValue(t *testing.T) {
 			},
 			[]string{"foo/bar/baz", "foo/bar/bing:1.2"},
 		},
+		{
+			"int",
+			func(manifest *ManifestNode) *AttributeNode {
+				return manifest.Kinds["assets"].Items[1].Attributes["max_deletes"]
+			},
+			5,
+		},
+		{
+			"int (default)",
+			func(manifest *ManifestNode) *AttributeNode {
+				return manifest.Kinds["assets"].Items[2].Attributes["max_deletes"]
+			},
+			100,
+		},
 		{
 			"[]object (empty)",
 			func(manifest *ManifestNode) *AttributeNode {
@@ -207,10 +221,18 @@ func TestGetValue(t *testing.T) {
 				"dst": "remote",
 				"src_path": "package",
 				"dst_path": "/etc/package",
+				"max_deletes": 5,
 				"post_command": [
 					{ "command": "echo 'Yay!'", "trigger": "on_changed" },
 					{ "command": "echo 'Woah!'", "trigger": "always" }
 				]
+			},
+			{
+				"type": "file",
+				"src": "local",
+				"dst": "remote",
+				"src_path": "other",
+				"dst_path": "/etc/other"
 			}
 		]
 	}`
@@ -228,3 +250,24 @@ func TestGetValue(t *testing.T) {
 		})
 	}
 }
+
+func TestBuildManifestMaxDeletes(t *testing.T) {
+	for maxDeletes, errMsg := range map[string]string{
+		"1":  "",
+		"0":  "app: max_deletes must be at least 1: 0",
+		"-1": "app: max_deletes must be at least 1: -1",
+	} {
+		t.Run(maxDeletes, func(s *testing.T) {
+			_, err := buildTestManifest(s, `{
+				"locations": [{ "type": "local", "name": "local" }],
+				"transport": { "type": "stream" },
+				"assets": [{ "type": "file", "name": "app", "src": "local", "dst": "local", "src_path": "app", "dst_path": "/srv/app", "delete_extraneous": true, "max_deletes": `+maxDeletes+` }]
+			}`)
+			if errMsg == "" && err != nil {
+				s.Errorf("expected manifest to build, got %v", err)
+			} else if errMsg != "" && (err == nil || !strings.Contains(err.Error(), errMsg)) {
+				s.Errorf("expected error containing '%s', got %v", errMsg, err)
+			}
+		})
+	}
+}
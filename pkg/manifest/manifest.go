@@ -3,8 +3,13 @@ package manifest
 import (
 	"errors"
 	"fmt"
+	"log/slog"
 	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/mrshanahan/deploy-assets/internal/util"
 	"github.com/mrshanahan/deploy-assets/pkg/config"
 	"github.com/mrshanahan/deploy-assets/pkg/executor"
 	"github.com/mrshanahan/deploy-assets/pkg/provider"
@@ -13,8 +18,17 @@ import (
 
 type Manifest struct {
 	Executors map[string]config.Executor
-	Transport config.Transport
-	Providers []*config.ProviderConfig
+	Locations []string
+	Groups    map[string][]string
+	// Transports by name, in declaration order, along with the name of the one
+	// used when nothing else selects one.
+	Transports       map[string]*TransportConfig
+	TransportOrder   []string
+	DefaultTransport string
+	Providers        []*config.ProviderConfig
+	// Host variables of locations from inventories, by location name, which
+	// post-commands run on them get as environment variables.
+	Vars map[string]map[string]string
 }
 
 type defaultNameTracker struct {
@@ -38,14 +52,20 @@ func BuildManifest(manifestDir string, root *ManifestNode) (*Manifest, error) {
 	}
 
 	manifest := &Manifest{
-		Executors: map[string]config.Executor{},
-		Transport: nil,
-		Providers: []*config.ProviderConfig{},
+		Executors:      map[string]config.Executor{},
+		Locations:      []string{},
+		Groups:         map[string][]string{},
+		Transports:     map[string]*TransportConfig{},
+		TransportOrder: []string{},
+		Providers:      []*config.ProviderConfig{},
+		Vars:           map[string]map[string]string{},
 	}
 
-	errs := buildExecutors(root, manifest)
-	errs = append(errs, buildTransport(root, manifest)...)
+	errs := buildExecutors(manifestDir, root, manifest)
+	errs = append(errs, validateGroups(manifest)...)
+	errs = append(errs, buildTransports(root, manifest)...)
 	errs = append(errs, buildProviders(manifestDir, root, manifest)...)
+	errs = append(errs, validateTransports(manifest)...)
 
 	if len(errs) > 0 {
 		return nil, errors.Join(errs...)
@@ -54,7 +74,7 @@ func BuildManifest(manifestDir string, root *ManifestNode) (*Manifest, error) {
 	}
 }
 
-func buildExecutors(root *ManifestNode, manifest *Manifest) []error {
+func buildExecutors(manifestDir string, root *ManifestNode, manifest *Manifest) []error {
 	locationsNode := root.Kinds["locations"]
 	errs := []error{}
 	defaultNames := newDefaultNameTracker()
@@ -66,61 +86,353 @@ func buildExecutors(root *ManifestNode, manifest *Manifest) []error {
 		} else {
 			name = nameAttr.GetValue().(string)
 		}
+		if err := registerLocation(manifest, name, l.Attributes["groups"].GetValue().([]string)); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		stagingDir := l.Attributes["staging_dir"].GetValue().(string)
+		bandwidthLimit, err := parseBandwidthLimit(l.Attributes["bandwidth_limit"].GetValue().(string))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
 		switch l.Type {
 		case "local":
-			manifest.Executors[name] = executor.NewLocalExecutor(name)
+			manifest.Executors[name] = executor.NewLocalExecutor(name, stagingDir, bandwidthLimit)
 		case "ssh":
-			addr := l.Attributes["server"].GetValue().(string)
-			user := l.Attributes["username"].GetValue().(string)
-			keyPath := l.Attributes["key_file"].GetValue().(string)
-			keyPassphrase := l.Attributes["key_file_passphrase"].GetValue().(string)
-			runElevated := l.Attributes["run_elevated"].GetValue().(bool)
-			exec, err := executor.NewSSHExecutor(name, addr, user, keyPath, keyPassphrase, runElevated)
-			if err != nil {
-				errs = append(errs, fmt.Errorf("failed to initialize executor for location '%s': %v", name, err))
-			} else {
-				manifest.Executors[name] = exec
-			}
+			errs = append(errs, buildSSHExecutor(manifest, &sshLocationConfig{
+				name:          name,
+				addr:          l.Attributes["server"].GetValue().(string),
+				user:          l.Attributes["username"].GetValue().(string),
+				keyPath:       l.Attributes["key_file"].GetValue().(string),
+				keyPassphrase: l.Attributes["key_file_passphrase"].GetValue().(string),
+				runElevated:   l.Attributes["run_elevated"].GetValue().(bool),
+				stagingDir:    stagingDir,
+
+				bandwidthLimit: bandwidthLimit,
+			})...)
 		default:
 			errs = append(errs, fmt.Errorf("unknown executor type: %s", l.Type))
 		}
 	}
+
+	inventoryNames := newDefaultNameTracker()
+	for _, i := range root.Kinds["locations_from"].Items {
+		var name string
+		nameAttr, prs := i.Attributes["name"]
+		if !prs || !nameAttr.Present {
+			name = inventoryNames.GetName("locations_from", i.Type)
+		} else {
+			name = nameAttr.GetValue().(string)
+		}
+
+		hosts, err := loadInventory(manifestDir, i)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		configs, err := resolveInventoryHosts(i, hosts)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		slog.Debug("loaded locations from inventory", "inventory", name, "num-locations", len(configs))
+		for _, c := range configs {
+			if err := registerLocation(manifest, c.name, c.groups); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				continue
+			}
+			errs = append(errs, buildSSHExecutor(manifest, c)...)
+		}
+	}
 	return errs
 }
 
-func buildTransport(root *ManifestNode, manifest *Manifest) []error {
-	transportNode := root.Kinds["transport"]
+func registerLocation(manifest *Manifest, name string, groups []string) error {
+	if util.NewSet(manifest.Locations...).Contains(name) {
+		return fmt.Errorf("duplicate location name: %s", name)
+	}
+	manifest.Locations = append(manifest.Locations, name)
+	for _, g := range groups {
+		manifest.Groups[g] = append(manifest.Groups[g], name)
+	}
+	return nil
+}
+
+func buildSSHExecutor(manifest *Manifest, c *sshLocationConfig) []error {
+	exec, err := executor.NewSSHExecutor(c.name, c.addr, c.user, c.keyPath, c.keyPassphrase, c.runElevated, c.stagingDir, c.bandwidthLimit)
+	if err != nil {
+		return []error{fmt.Errorf("failed to initialize executor for location '%s': %v", c.name, err)}
+	}
+	manifest.Executors[c.name] = exec
+	if c.vars != nil {
+		manifest.Vars[c.name] = c.vars
+	}
+	return nil
+}
+
+func buildTransports(root *ManifestNode, manifest *Manifest) []error {
+	// "transport" is the original single-transport form of "transports"; the
+	// two are otherwise identical, but only one may be used.
+	single, multiple := root.Kinds["transport"], root.Kinds["transports"]
+	var transportsNode *KindNode
+	switch {
+	case len(single.Items) > 0 && len(multiple.Items) > 0:
+		return []error{fmt.Errorf("manifest may have either 'transport' or 'transports', not both")}
+	case len(single.Items) > 0:
+		transportsNode = single
+	case len(multiple.Items) > 0:
+		transportsNode = multiple
+	default:
+		return []error{fmt.Errorf("manifest must have at least one transport")}
+	}
+
 	errs := []error{}
 	defaultNames := newDefaultNameTracker()
+	autoNames, autoItems := []string{}, []*ItemNode{}
+	for _, t := range transportsNode.Items {
+		var name string
+		nameAttr, prs := t.Attributes["name"]
+		if !prs || !nameAttr.Present {
+			name = defaultNames.GetName("transport", t.Type)
+		} else {
+			name = nameAttr.GetValue().(string)
+		}
+		if _, prs := manifest.Transports[name]; prs {
+			errs = append(errs, fmt.Errorf("duplicate transport name: %s", name))
+			continue
+		}
 
-	t := transportNode.Items[0]
-	var name string
-	nameAttr, prs := t.Attributes["name"]
-	if !prs || !nameAttr.Present {
-		name = defaultNames.GetName("transport", t.Type)
-	} else {
-		name = nameAttr.GetValue().(string)
+		// Auto transports have no transport of their own; what they pick from
+		// is resolved once every other transport has been built.
+		var builtTransport config.Transport
+		if t.Type == "auto" {
+			autoNames = append(autoNames, name)
+			autoItems = append(autoItems, t)
+		} else {
+			var err error
+			builtTransport, err = buildTransport(t, name)
+			if err == nil {
+				builtTransport, err = wrapTransport(t, name, builtTransport)
+			}
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+		}
+
+		routes := []*TransportRoute{}
+		for i, r := range t.Attributes["routes"].GetValue().([]map[string]string) {
+			route := &TransportRoute{Src: allLocationsRef, Dst: allLocationsRef}
+			for k, v := range r {
+				switch k {
+				case "src":
+					route.Src = v
+				case "dst":
+					route.Dst = v
+				default:
+					errs = append(errs, fmt.Errorf("%s: route[%d]: unrecognized key '%s'", name, i, k))
+				}
+			}
+			routes = append(routes, route)
+		}
+
+		manifest.TransportOrder = append(manifest.TransportOrder, name)
+		manifest.Transports[name] = &TransportConfig{
+			Transport: builtTransport,
+			Routes:    routes,
+			Fallback:  getStringOrStrings(t.Attributes["fallback"]),
+		}
+		if t.Type == "auto" {
+			manifest.Transports[name].Prefer = []string{}
+		}
+		if t.Attributes["default"].GetValue().(bool) {
+			if manifest.DefaultTransport != "" {
+				errs = append(errs, fmt.Errorf("%s: only one transport may be the default, but %s already is", name, manifest.DefaultTransport))
+			}
+			manifest.DefaultTransport = name
+		}
+	}
+	if manifest.DefaultTransport == "" && len(manifest.TransportOrder) > 0 {
+		manifest.DefaultTransport = manifest.TransportOrder[0]
+	}
+	for i, t := range autoItems {
+		errs = append(errs, resolveAutoTransport(t, autoNames[i], manifest)...)
+	}
+
+	return errs
+}
+
+// Transports an auto transport picks from, cheapest first, when it doesn't
+// say otherwise. Every other transport in the manifest comes after the first
+// of these, in order, & before the last, since stream works between any two
+// locations but sends everything through this machine.
+var defaultAutoPreference = []string{"local", "rsync", "stream"}
+
+// Resolves the transports the auto transport picks from. Each entry in
+// "prefer" is either another transport in the manifest or one of the types in
+// defaultAutoPreference, which is built just for the auto transport & named
+// "<auto transport>/<type>".
+func resolveAutoTransport(t *ItemNode, name string, manifest *Manifest) []error {
+	prefer := getStringOrStrings(t.Attributes["prefer"])
+	if len(prefer) == 0 {
+		last := len(defaultAutoPreference) - 1
+		prefer = append([]string{}, defaultAutoPreference[:last]...)
+		for _, n := range manifest.TransportOrder {
+			if manifest.Transports[n].Prefer == nil && !slices.Contains(defaultAutoPreference, n) {
+				prefer = append(prefer, n)
+			}
+		}
+		prefer = append(prefer, defaultAutoPreference[last])
+	}
+
+	errs := []error{}
+	candidates := []string{}
+	for _, p := range prefer {
+		if declared, prs := manifest.Transports[p]; prs {
+			if declared.Prefer != nil || p == name {
+				errs = append(errs, fmt.Errorf("%s: prefer: cannot pick another auto transport: %s", name, p))
+				continue
+			}
+			candidates = append(candidates, p)
+			continue
+		}
+		candidateName := name + "/" + p
+		candidate, err := buildAutoCandidate(t, name, candidateName, p)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		manifest.Transports[candidateName] = &TransportConfig{Transport: candidate}
+		candidates = append(candidates, candidateName)
+	}
+	manifest.Transports[name].Prefer = candidates
+	return errs
+}
+
+// Builds a transport of the given type with its default settings, other than
+// the ones every transport type has (e.g. verify), which come from the auto
+// transport.
+func buildAutoCandidate(t *ItemNode, name string, candidateName string, transportType string) (config.Transport, error) {
+	bandwidthLimit, err := parseBandwidthLimit(t.Attributes["bandwidth_limit"].GetValue().(string))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	var candidate config.Transport
+	switch transportType {
+	case "local":
+		candidate = transport.NewLocalTransport()
+	case "rsync":
+		// Anything rsync can't copy directly is better left to the transports
+		// after it than streamed.
+		candidate = transport.NewRsyncOnlyTransport(candidateName, bandwidthLimit)
+	case "stream":
+		candidate = transport.NewStreamTransport(candidateName, bandwidthLimit)
+	default:
+		return nil, fmt.Errorf("%s: prefer: no such transport: %s", name, transportType)
+	}
+	return wrapTransport(t, name, candidate)
+}
+
+// Wraps the built transport according to the settings every transport type
+// has.
+func wrapTransport(t *ItemNode, name string, builtTransport config.Transport) (config.Transport, error) {
+	if chunkSizeStr := t.Attributes["chunk_size"].GetValue().(string); chunkSizeStr != "" {
+		chunkSize, err := util.ParseSize(chunkSizeStr)
+		if err == nil {
+			builtTransport, err = transport.NewChunkedTransport(builtTransport, chunkSize, t.Attributes["chunk_dir"].GetValue().(string))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: chunk_size: %w", name, err)
+		}
+	}
+	if t.Attributes["verify"].GetValue().(bool) {
+		builtTransport = transport.NewChecksumTransport(builtTransport)
+	}
+	return builtTransport, nil
+}
+
+func buildTransport(t *ItemNode, name string) (config.Transport, error) {
+	bandwidthLimit, err := parseBandwidthLimit(t.Attributes["bandwidth_limit"].GetValue().(string))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
 	}
 	switch t.Type {
 	case "s3":
 		bucketUrl := t.Attributes["bucket_url"].GetValue().(string)
-		manifest.Transport = transport.NewS3Transport(name, bucketUrl)
+		endpoint := t.Attributes["endpoint"].GetValue().(string)
+		region := t.Attributes["region"].GetValue().(string)
+		profile := t.Attributes["profile"].GetValue().(string)
+		mode := t.Attributes["mode"].GetValue().(string)
+		var urlExpiry time.Duration
+		if urlExpiryStr := t.Attributes["url_expiry"].GetValue().(string); urlExpiryStr != "" {
+			if urlExpiry, err = time.ParseDuration(urlExpiryStr); err != nil {
+				return nil, fmt.Errorf("%s: invalid url_expiry '%s': %w", name, urlExpiryStr, err)
+			}
+		}
+		encrypt := t.Attributes["encrypt"].GetValue().(bool)
+		// The encryption isn't authenticated, so it's only comparing checksums
+		// that catches anyone with write access to the bucket tampering with
+		// files.
+		if encrypt && !t.Attributes["verify"].GetValue().(bool) {
+			return nil, fmt.Errorf("%s: encrypt requires verify to be on", name)
+		}
+		return transport.NewS3Transport(name, bucketUrl, endpoint, region, profile, mode, urlExpiry, encrypt, bandwidthLimit)
 	case "scp":
 		addr := t.Attributes["server"].GetValue().(string)
 		user := t.Attributes["username"].GetValue().(string)
 		keyPath := t.Attributes["key_file"].GetValue().(string)
 		keyPassphrase := t.Attributes["key_file_passphrase"].GetValue().(string)
-		scpTransport, err := transport.NewScpTransport(name, addr, user, keyPath, keyPassphrase)
-		if err != nil {
-			errs = append(errs, err)
-		} else {
-			manifest.Transport = scpTransport
-		}
+		insecureSkipHostKeyCheck := t.Attributes["insecure_skip_host_key_check"].GetValue().(bool)
+		return transport.NewScpTransport(name, addr, user, keyPath, keyPassphrase, insecureSkipHostKeyCheck, bandwidthLimit)
+	case "stream":
+		return transport.NewStreamTransport(name, bandwidthLimit), nil
+	case "rsync":
+		compress := t.Attributes["compress"].GetValue().(bool)
+		checksum := t.Attributes["checksum"].GetValue().(bool)
+		partial := t.Attributes["partial"].GetValue().(bool)
+		insecureSkipHostKeyCheck := t.Attributes["insecure_skip_host_key_check"].GetValue().(bool)
+		return transport.NewRsyncTransport(name, compress, checksum, partial, insecureSkipHostKeyCheck, bandwidthLimit), nil
+	case "http":
+		listen := t.Attributes["listen"].GetValue().(string)
+		url := t.Attributes["url"].GetValue().(string)
+		forward := t.Attributes["forward"].GetValue().(bool)
+		return transport.NewHttpTransport(name, listen, url, forward, bandwidthLimit)
 	default:
-		errs = append(errs, fmt.Errorf("unknown transport type: %s", t.Type))
+		return nil, fmt.Errorf("unknown transport type: %s", t.Type)
+	}
+}
+
+// Parses a bandwidth_limit like "10MiB/s" (the "/s" being optional) into bytes
+// per second. An empty limit is no limit.
+func parseBandwidthLimit(limit string) (int64, error) {
+	if limit == "" {
+		return 0, nil
+	}
+	bytesPerSec, err := util.ParseSize(strings.TrimSuffix(strings.TrimSpace(limit), "/s"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid bandwidth_limit: %w", err)
 	}
+	return bytesPerSec, nil
+}
 
-	return errs
+// For attributes typed "string|[]string".
+func getStringOrStrings(attr *AttributeNode) []string {
+	if attr.MatchingValueType == "string" {
+		return []string{attr.GetValue().(string)}
+	}
+	return attr.GetValue().([]string)
+}
+
+// For the backup & keep_backups attributes of assets that support them.
+func getBackupAttributes(a *ItemNode) (bool, int, error) {
+	backup := a.Attributes["backup"].GetValue().(bool)
+	keepBackups := a.Attributes["keep_backups"].GetValue().(int)
+	if keepBackups < 1 {
+		return false, 0, fmt.Errorf("keep_backups must be at least 1: %d", keepBackups)
+	}
+	return backup, keepBackups, nil
 }
 
 func buildProviders(manifestDir string, root *ManifestNode, manifest *Manifest) []error {
@@ -137,14 +449,14 @@ func buildProviders(manifestDir string, root *ManifestNode, manifest *Manifest)
 		}
 
 		src := a.Attributes["src"].GetValue().(string)
-		dst := a.Attributes["dst"].GetValue().(string)
+		dst := getStringOrStrings(a.Attributes["dst"])
 
 		if _, prs := manifest.Executors[src]; !prs {
 			errs = append(errs, fmt.Errorf("%s: no such location: %s", name, src))
 			continue
 		}
-		if _, prs := manifest.Executors[dst]; !prs && dst != "*" {
-			errs = append(errs, fmt.Errorf("%s: no such location: %s", name, dst))
+		if _, err := manifest.ResolveDestinations(src, dst); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
 			continue
 		}
 
@@ -174,6 +486,7 @@ func buildProviders(manifestDir string, root *ManifestNode, manifest *Manifest)
 		providerConfig := &config.ProviderConfig{
 			Src:          src,
 			Dst:          dst,
+			Transports:   getStringOrStrings(a.Attributes["transport"]),
 			PostCommands: postCommands,
 		}
 
@@ -183,20 +496,95 @@ func buildProviders(manifestDir string, root *ManifestNode, manifest *Manifest)
 			dstPath := a.Attributes["dst_path"].GetValue().(string)
 			recursive := a.Attributes["recursive"].GetValue().(bool)
 			force := a.Attributes["force"].GetValue().(bool)
-			providerConfig.Provider = provider.NewFileProvider(name, manifestDir, srcPath, dstPath, recursive, force)
+			compare := a.Attributes["compare"].GetValue().(string)
+			if compare != provider.FileCompareMtime && compare != provider.FileCompareSizeMtime && compare != provider.FileCompareHash {
+				errs = append(errs, fmt.Errorf("%s: invalid compare mode '%s': expected %s, %s or %s", name, compare, provider.FileCompareMtime, provider.FileCompareSizeMtime, provider.FileCompareHash))
+				continue
+			}
+			symlinks := a.Attributes["symlinks"].GetValue().(string)
+			if symlinks != provider.FileSymlinksPreserve && symlinks != provider.FileSymlinksFollow && symlinks != provider.FileSymlinksSkip {
+				errs = append(errs, fmt.Errorf("%s: invalid symlinks policy '%s': expected %s, %s or %s", name, symlinks, provider.FileSymlinksPreserve, provider.FileSymlinksFollow, provider.FileSymlinksSkip))
+				continue
+			}
+			deleteExtraneous := a.Attributes["delete_extraneous"].GetValue().(bool)
+			maxDeletes := a.Attributes["max_deletes"].GetValue().(int)
+			if maxDeletes < 1 {
+				errs = append(errs, fmt.Errorf("%s: max_deletes must be at least 1: %d", name, maxDeletes))
+				continue
+			}
+			include := getStringOrStrings(a.Attributes["include"])
+			exclude := getStringOrStrings(a.Attributes["exclude"])
+			patternErrs := []error{}
+			for _, pattern := range append(slices.Clone(include), exclude...) {
+				if err := provider.ValidatePattern(pattern); err != nil {
+					patternErrs = append(patternErrs, fmt.Errorf("%s: %w", name, err))
+				}
+			}
+			if len(patternErrs) > 0 {
+				errs = append(errs, patternErrs...)
+				continue
+			}
+			attributes := provider.FileAttributes{
+				Owner:    a.Attributes["owner"].GetValue().(string),
+				Group:    a.Attributes["group"].GetValue().(string),
+				FileMode: a.Attributes["file_mode"].GetValue().(string),
+				DirMode:  a.Attributes["dir_mode"].GetValue().(string),
+			}
+			if err := attributes.Validate(); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				continue
+			}
+			release := a.Attributes["release"].GetValue().(bool)
+			keepReleases := a.Attributes["keep_releases"].GetValue().(int)
+			if keepReleases < 1 {
+				errs = append(errs, fmt.Errorf("%s: keep_releases must be at least 1: %d", name, keepReleases))
+				continue
+			}
+			backup, keepBackups, err := getBackupAttributes(a)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				continue
+			}
+			if release && backup {
+				errs = append(errs, fmt.Errorf("%s: backup cannot be used with release, which keeps previous releases already", name))
+				continue
+			}
+			providerConfig.Provider = provider.NewFileProvider(name, manifestDir, srcPath, dstPath, provider.FileProviderOptions{
+				Recursive:        recursive,
+				Force:            force,
+				Compare:          compare,
+				DeleteExtraneous: deleteExtraneous,
+				MaxDeletes:       maxDeletes,
+				Include:          include,
+				Exclude:          exclude,
+				Attributes:       attributes,
+				Release:          release,
+				KeepReleases:     keepReleases,
+				Backup:           backup,
+				KeepBackups:      keepBackups,
+				Symlinks:         symlinks,
+			})
 		case "literal":
 			value := a.Attributes["value"].GetValue().(string)
 			dstPath := a.Attributes["dst_path"].GetValue().(string)
-			providerConfig.Provider = provider.NewLiteralProvider(name, value, dstPath)
+			attributes := provider.FileAttributes{
+				Owner:    a.Attributes["owner"].GetValue().(string),
+				Group:    a.Attributes["group"].GetValue().(string),
+				FileMode: a.Attributes["file_mode"].GetValue().(string),
+			}
+			if err := attributes.Validate(); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				continue
+			}
+			backup, keepBackups, err := getBackupAttributes(a)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				continue
+			}
+			providerConfig.Provider = provider.NewLiteralProvider(name, value, dstPath, attributes, backup, keepBackups)
 		case "docker_image":
 			compareLabel := a.Attributes["compare_label"].GetValue().(string)
-			repositoryAttr := a.Attributes["repository"]
-			var repositories []string
-			if repositoryAttr.MatchingValueType == "string" {
-				repositories = []string{repositoryAttr.GetValue().(string)}
-			} else {
-				repositories = repositoryAttr.GetValue().([]string)
-			}
+			repositories := getStringOrStrings(a.Attributes["repository"])
 			providerConfig.Provider = provider.NewDockerProvider(name, repositories, compareLabel)
 		default:
 			errs = append(errs, fmt.Errorf("unknown provider type: %s", a.Type))
@@ -0,0 +1,109 @@
+package manifest
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/mrshanahan/deploy-assets/internal/util"
+	"github.com/mrshanahan/deploy-assets/pkg/config"
+)
+
+type TransportConfig struct {
+	Transport config.Transport
+	// Source & destination pairs this transport is picked for. Each side is a
+	// location name, a group name or `*`.
+	Routes []*TransportRoute
+	// Transports to try, in order, when this one fails validation on either
+	// location.
+	Fallback []string
+	// Set only for auto transports, which have no Transport of their own: the
+	// transports picked from for each source & destination, cheapest first.
+	Prefer []string
+}
+
+type TransportRoute struct {
+	Src string
+	Dst string
+}
+
+// Returns the names of the transports to try for copying the asset from src to
+// dst, in order. The first is the asset's own transport if it names one,
+// otherwise that of the first declared transport with a matching route,
+// otherwise the default; the rest are its fallbacks (and theirs, & so on).
+func (m *Manifest) SelectTransports(asset *config.ProviderConfig, src string, dst string) []string {
+	candidates := asset.Transports
+	if len(candidates) == 0 {
+		candidates = []string{m.routeTransport(src, dst)}
+	}
+
+	selected := []string{}
+	seen := util.NewSet[string]()
+	var visit func(name string)
+	visit = func(name string) {
+		if seen.Contains(name) {
+			return
+		}
+		seen.Add(name)
+		selected = append(selected, name)
+		if t, prs := m.Transports[name]; prs {
+			for _, f := range t.Fallback {
+				visit(f)
+			}
+		}
+	}
+	for _, c := range candidates {
+		visit(c)
+	}
+	return selected
+}
+
+func (m *Manifest) routeTransport(src string, dst string) string {
+	for _, name := range m.TransportOrder {
+		for _, r := range m.Transports[name].Routes {
+			if m.matchesLocationRef(src, r.Src) && m.matchesLocationRef(dst, r.Dst) {
+				return name
+			}
+		}
+	}
+	return m.DefaultTransport
+}
+
+func (m *Manifest) matchesLocationRef(location string, ref string) bool {
+	if ref == allLocationsRef || ref == location {
+		return true
+	}
+	members, prs := m.Groups[ref]
+	return prs && slices.Contains(members, location)
+}
+
+func validateTransports(manifest *Manifest) []error {
+	errs := []error{}
+	isLocationRef := func(ref string) bool {
+		_, isLocation := manifest.Executors[ref]
+		_, isGroup := manifest.Groups[ref]
+		return ref == allLocationsRef || isLocation || isGroup
+	}
+	for _, name := range manifest.TransportOrder {
+		t := manifest.Transports[name]
+		for _, f := range t.Fallback {
+			if _, prs := manifest.Transports[f]; !prs {
+				errs = append(errs, fmt.Errorf("%s: fallback is not a transport: %s", name, f))
+			}
+		}
+		for _, r := range t.Routes {
+			for _, ref := range []string{r.Src, r.Dst} {
+				if !isLocationRef(ref) {
+					errs = append(errs, fmt.Errorf("%s: route refers to no such location or group: %s", name, ref))
+				}
+			}
+		}
+	}
+	for _, p := range manifest.Providers {
+		for _, ref := range p.Transports {
+			if _, prs := manifest.Transports[ref]; !prs {
+				errs = append(errs, fmt.Errorf("%s: no such transport: %s", p.Provider.Name(), ref))
+			}
+		}
+	}
+	return errs
+}
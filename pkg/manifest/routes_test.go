@@ -0,0 +1,199 @@
+package manifest
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mrshanahan/deploy-assets/pkg/config"
+)
+
+const routesTestLocations = `
+	"locations": [
+		{ "type": "local", "name": "src" },
+		{ "type": "local", "name": "onprem1", "groups": ["onprem"] },
+		{ "type": "local", "name": "onprem2", "groups": ["onprem"] },
+		{ "type": "local", "name": "cloud1", "groups": ["cloud"] },
+		{ "type": "local", "name": "other" }
+	]`
+
+const routesTestTransports = `
+	"transports": [
+		{ "type": "stream", "name": "pipe", "routes": [{ "dst": "onprem" }], "fallback": "bucket" },
+		{ "type": "s3", "name": "bucket", "bucket_url": "s3://test", "routes": [{ "src": "src", "dst": "cloud" }], "fallback": ["pipe", "copy"] },
+		{ "type": "stream", "name": "copy", "default": true },
+		{ "type": "stream", "name": "spare" }
+	]`
+
+func TestSelectTransports(t *testing.T) {
+	m, err := buildTestManifest(t, `{`+routesTestLocations+`,`+routesTestTransports+`, "assets": [
+		{ "type": "literal", "name": "routed", "src": "src", "dst": "*", "value": "x", "dst_path": "/tmp/x" },
+		{ "type": "literal", "name": "pinned", "src": "src", "dst": "*", "value": "x", "dst_path": "/tmp/x", "transport": "spare" },
+		{ "type": "literal", "name": "pinned-many", "src": "src", "dst": "*", "value": "x", "dst_path": "/tmp/x", "transport": ["spare", "bucket"] }
+	]}`)
+	if err != nil {
+		t.Fatalf("failed to build manifest: %v", err)
+	}
+	defer func() {
+		for _, e := range m.Executors {
+			e.Close()
+		}
+	}()
+
+	routed, pinned, pinnedMany := m.Providers[0], m.Providers[1], m.Providers[2]
+	var tests = []struct {
+		name     string
+		src      string
+		dst      string
+		expected []string
+	}{
+		{"route by group", "src", "onprem1", []string{"pipe", "bucket", "copy"}},
+		{"route by src & group", "src", "cloud1", []string{"bucket", "pipe", "copy"}},
+		{"route src mismatch uses default", "onprem1", "cloud1", []string{"copy"}},
+		{"no route uses default", "src", "other", []string{"copy"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(s *testing.T) {
+			actual := m.SelectTransports(routed, test.src, test.dst)
+			if !reflect.DeepEqual(test.expected, actual) {
+				s.Errorf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+
+	if actual := m.SelectTransports(pinned, "src", "onprem1"); !reflect.DeepEqual([]string{"spare"}, actual) {
+		t.Errorf("expected asset's own transport to win over routes, got %v", actual)
+	}
+	if actual := m.SelectTransports(pinnedMany, "src", "onprem1"); !reflect.DeepEqual([]string{"spare", "bucket", "pipe", "copy"}, actual) {
+		t.Errorf("expected asset's transports followed by fallbacks, got %v", actual)
+	}
+}
+
+func TestSelectTransportsDefaultsToFirst(t *testing.T) {
+	m, err := buildTestManifest(t, `{`+routesTestLocations+`, "transports": [
+		{ "type": "stream", "name": "first" },
+		{ "type": "stream", "name": "second" }
+	], "assets": []}`)
+	if err != nil {
+		t.Fatalf("failed to build manifest: %v", err)
+	}
+	if m.DefaultTransport != "first" {
+		t.Errorf("expected first transport to be the default, got %s", m.DefaultTransport)
+	}
+
+	m, err = buildTestManifest(t, `{`+routesTestLocations+`, "transport": { "type": "stream" }, "assets": []}`)
+	if err != nil {
+		t.Fatalf("failed to build manifest: %v", err)
+	}
+	if !reflect.DeepEqual([]string{"stream1"}, m.TransportOrder) || m.DefaultTransport != "stream1" {
+		t.Errorf("expected single transport to be the default, got %v (default %s)", m.TransportOrder, m.DefaultTransport)
+	}
+}
+
+func TestBuildManifestAutoTransport(t *testing.T) {
+	m, err := buildTestManifest(t, `{`+routesTestLocations+`, "transports": [
+		{ "type": "auto", "name": "any" },
+		{ "type": "auto", "name": "picky", "prefer": ["bucket", "stream"], "verify": false },
+		{ "type": "s3", "name": "bucket", "bucket_url": "s3://test" }
+	], "assets": []}`)
+	if err != nil {
+		t.Fatalf("failed to build manifest: %v", err)
+	}
+
+	if expected, actual := []string{"any/local", "any/rsync", "bucket", "any/stream"}, m.Transports["any"].Prefer; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected default preference %v, got %v", expected, actual)
+	}
+	if expected, actual := []string{"bucket", "picky/stream"}, m.Transports["picky"].Prefer; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected preference %v, got %v", expected, actual)
+	}
+	if m.Transports["any"].Transport != nil {
+		t.Errorf("expected auto transport to have no transport of its own")
+	}
+	if _, ok := m.Transports["any/stream"].Transport.(config.WrappingTransport); !ok {
+		t.Errorf("expected built transport to be verified like the auto transport")
+	}
+	if _, ok := m.Transports["picky/stream"].Transport.(config.WrappingTransport); ok {
+		t.Errorf("expected built transport not to be verified like the auto transport")
+	}
+	if expected := []string{"any", "picky", "bucket"}; !reflect.DeepEqual(expected, m.TransportOrder) {
+		t.Errorf("expected transports built for auto transports to be left out of %v, got %v", expected, m.TransportOrder)
+	}
+}
+
+func TestBuildManifestTransportValidation(t *testing.T) {
+	var tests = []struct {
+		name   string
+		json   string
+		errMsg string
+	}{
+		{
+			"both transport & transports",
+			`"transport": { "type": "stream" }, "transports": [{ "type": "stream" }], "assets": []`,
+			"either 'transport' or 'transports'",
+		},
+		{
+			"no transports",
+			`"transports": [], "assets": []`,
+			"at least one transport",
+		},
+		{
+			"duplicate name",
+			`"transports": [{ "type": "stream", "name": "a" }, { "type": "stream", "name": "a" }], "assets": []`,
+			"duplicate transport name: a",
+		},
+		{
+			"multiple defaults",
+			`"transports": [{ "type": "stream", "name": "a", "default": true }, { "type": "stream", "name": "b", "default": true }], "assets": []`,
+			"only one transport may be the default",
+		},
+		{
+			"unknown fallback",
+			`"transports": [{ "type": "stream", "name": "a", "fallback": "nope" }], "assets": []`,
+			"fallback is not a transport: nope",
+		},
+		{
+			"unknown route location",
+			`"transports": [{ "type": "stream", "name": "a", "routes": [{ "dst": "nope" }] }], "assets": []`,
+			"no such location or group: nope",
+		},
+		{
+			"unknown route key",
+			`"transports": [{ "type": "stream", "name": "a", "routes": [{ "to": "onprem" }] }], "assets": []`,
+			"unrecognized key 'to'",
+		},
+		{
+			"unknown asset transport",
+			`"transports": [{ "type": "stream" }], "assets": [{ "type": "literal", "name": "x", "src": "src", "dst": "*", "value": "x", "dst_path": "/tmp/x", "transport": "nope" }]`,
+			"x: no such transport: nope",
+		},
+		{
+			"invalid chunk size",
+			`"transports": [{ "type": "stream", "name": "a", "chunk_size": "64MB" }], "assets": []`,
+			"a: chunk_size: invalid size '64MB'",
+		},
+		{
+			"unknown auto preference",
+			`"transports": [{ "type": "auto", "name": "a", "prefer": ["local", "carrier-pigeon"] }], "assets": []`,
+			"a: prefer: no such transport: carrier-pigeon",
+		},
+		{
+			"encryption without verification",
+			`"transports": [{ "type": "s3", "name": "a", "bucket_url": "s3://test", "encrypt": true, "verify": false }], "assets": []`,
+			"a: encrypt requires verify to be on",
+		},
+		{
+			"auto preferring auto",
+			`"transports": [{ "type": "auto", "name": "a" }, { "type": "auto", "name": "b", "prefer": "a" }], "assets": []`,
+			"b: prefer: cannot pick another auto transport: a",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(s *testing.T) {
+			_, err := buildTestManifest(s, `{`+routesTestLocations+`, `+test.json+`}`)
+			if err == nil || !strings.Contains(err.Error(), test.errMsg) {
+				s.Errorf("expected error containing '%s', got %v", test.errMsg, err)
+			}
+		})
+	}
+}
@@ -11,18 +11,29 @@ func NewManifestSpec() *ManifestSpec {
 					},
 				},
 			},
-			&TransportKindSpec{
+			&LocationsFromKindSpec{
 				GenericKindSpec: GenericKindSpec{
 					itemSpecs: []ManifestItemSpec{
-						&S3TransportItemSpec{},
-						&ScpTransportItemSpec{},
+						&ExecInventoryItemSpec{},
+						&FileInventoryItemSpec{},
 					},
 				},
 			},
+			&TransportKindSpec{
+				GenericKindSpec: GenericKindSpec{
+					itemSpecs: transportItemSpecs(),
+				},
+			},
+			&TransportsKindSpec{
+				GenericKindSpec: GenericKindSpec{
+					itemSpecs: transportItemSpecs(),
+				},
+			},
 			&AssetsKindSpec{
 				GenericKindSpec: GenericKindSpec{
 					itemSpecs: []ManifestItemSpec{
 						&FileAssetItemSpec{},
+						&LiteralAssetItemSpec{},
 						&DockerImageAssetItemSpec{},
 					},
 				},
@@ -38,9 +49,16 @@ type ManifestSpec struct {
 type ManifestKindSpec interface {
 	Name() string
 	IsCollection() bool
+	IsRequired() bool
 	ItemSpecs() map[string]ManifestItemSpec
 }
 
+// Implemented by required kinds that may be left out if the named kind is
+// given instead.
+type AlternativeKindSpec interface {
+	Alternative() string
+}
+
 type GenericKindSpec struct {
 	itemSpecs []ManifestItemSpec
 }
@@ -61,6 +79,18 @@ func (s *LocationKindSpec) Name() string { return "locations" }
 
 func (s *LocationKindSpec) IsCollection() bool { return true }
 
+func (s *LocationKindSpec) IsRequired() bool { return true }
+
+type LocationsFromKindSpec struct {
+	GenericKindSpec
+}
+
+func (s *LocationsFromKindSpec) Name() string { return "locations_from" }
+
+func (s *LocationsFromKindSpec) IsCollection() bool { return true }
+
+func (s *LocationsFromKindSpec) IsRequired() bool { return false }
+
 type TransportKindSpec struct {
 	GenericKindSpec
 }
@@ -69,6 +99,20 @@ func (s *TransportKindSpec) Name() string { return "transport" }
 
 func (s *TransportKindSpec) IsCollection() bool { return false }
 
+func (s *TransportKindSpec) IsRequired() bool { return true }
+
+func (s *TransportKindSpec) Alternative() string { return "transports" }
+
+type TransportsKindSpec struct {
+	GenericKindSpec
+}
+
+func (s *TransportsKindSpec) Name() string { return "transports" }
+
+func (s *TransportsKindSpec) IsCollection() bool { return true }
+
+func (s *TransportsKindSpec) IsRequired() bool { return false }
+
 type AssetsKindSpec struct {
 	GenericKindSpec
 }
@@ -77,6 +121,8 @@ func (s *AssetsKindSpec) Name() string { return "assets" }
 
 func (s *AssetsKindSpec) IsCollection() bool { return true }
 
+func (s *AssetsKindSpec) IsRequired() bool { return true }
+
 type AttributeSpec struct {
 	Name         string
 	ValueType    string
@@ -110,6 +156,9 @@ type LocalLocationItemSpec struct {
 func GetDefaultLocationItemAttributes() []AttributeSpec {
 	return []AttributeSpec{
 		RequiredAttribute("name", "string"),
+		OptionalAttribute("staging_dir", "string", "/tmp"),
+		OptionalAttribute("groups", "[]string", []any{}),
+		OptionalAttribute("bandwidth_limit", "string", ""),
 	}
 }
 
@@ -139,15 +188,104 @@ func (s *SSHLocationItemSpec) Attributes() []AttributeSpec {
 	)
 }
 
+// Attributes shared by every location produced from an inventory. Hosts may
+// override any of these (other than groups, which are added to) individually.
+func GetDefaultInventoryItemAttributes() []AttributeSpec {
+	return append(
+		GetDefaultItemAttributes(),
+		[]AttributeSpec{
+			OptionalAttribute("username", "string", ""),
+			OptionalAttribute("key_file", "string", ""),
+			OptionalAttribute("key_file_passphrase", "string", ""),
+			OptionalAttribute("run_elevated", "bool", false),
+			OptionalAttribute("staging_dir", "string", "/tmp"),
+			OptionalAttribute("groups", "[]string", []any{}),
+			OptionalAttribute("bandwidth_limit", "string", ""),
+		}...,
+	)
+}
+
+type ExecInventoryItemSpec struct{}
+
+func (s *ExecInventoryItemSpec) Type() string { return "exec" }
+
+func (s *ExecInventoryItemSpec) Attributes() []AttributeSpec {
+	return append(
+		GetDefaultInventoryItemAttributes(),
+		[]AttributeSpec{
+			RequiredAttribute("command", "string"),
+		}...,
+	)
+}
+
+type FileInventoryItemSpec struct{}
+
+func (s *FileInventoryItemSpec) Type() string { return "file" }
+
+func (s *FileInventoryItemSpec) Attributes() []AttributeSpec {
+	return append(
+		GetDefaultInventoryItemAttributes(),
+		[]AttributeSpec{
+			RequiredAttribute("path", "string"),
+			OptionalAttribute("format", "string", ""),
+		}...,
+	)
+}
+
+func transportItemSpecs() []ManifestItemSpec {
+	return []ManifestItemSpec{
+		&S3TransportItemSpec{},
+		&ScpTransportItemSpec{},
+		&StreamTransportItemSpec{},
+		&RsyncTransportItemSpec{},
+		&HttpTransportItemSpec{},
+		&AutoTransportItemSpec{},
+	}
+}
+
+func GetDefaultTransportItemAttributes() []AttributeSpec {
+	return append(
+		GetDefaultItemAttributes(),
+		[]AttributeSpec{
+			OptionalAttribute("routes", "[]object", []map[string]string{}),
+			OptionalAttribute("default", "bool", false),
+			OptionalAttribute("fallback", "string|[]string", []any{}),
+			OptionalAttribute("verify", "bool", true),
+			OptionalAttribute("chunk_size", "string", ""),
+			OptionalAttribute("chunk_dir", "string", ""),
+			OptionalAttribute("bandwidth_limit", "string", ""),
+		}...,
+	)
+}
+
+type AutoTransportItemSpec struct{}
+
+func (s *AutoTransportItemSpec) Type() string { return "auto" }
+
+func (s *AutoTransportItemSpec) Attributes() []AttributeSpec {
+	return append(
+		GetDefaultTransportItemAttributes(),
+		[]AttributeSpec{
+			OptionalAttribute("prefer", "string|[]string", []any{}),
+		}...,
+	)
+}
+
 type S3TransportItemSpec struct{}
 
 func (s *S3TransportItemSpec) Type() string { return "s3" }
 
 func (s *S3TransportItemSpec) Attributes() []AttributeSpec {
 	return append(
-		GetDefaultItemAttributes(),
+		GetDefaultTransportItemAttributes(),
 		[]AttributeSpec{
 			RequiredAttribute("bucket_url", "string"),
+			OptionalAttribute("endpoint", "string", ""),
+			OptionalAttribute("region", "string", ""),
+			OptionalAttribute("profile", "string", ""),
+			OptionalAttribute("mode", "string", "auto"),
+			OptionalAttribute("url_expiry", "string", "15m"),
+			OptionalAttribute("encrypt", "bool", false),
 		}...,
 	)
 }
@@ -158,12 +296,52 @@ func (s *ScpTransportItemSpec) Type() string { return "scp" }
 
 func (s *ScpTransportItemSpec) Attributes() []AttributeSpec {
 	return append(
-		GetDefaultItemAttributes(),
+		GetDefaultTransportItemAttributes(),
 		[]AttributeSpec{
 			RequiredAttribute("server", "string"),
 			RequiredAttribute("username", "string"),
 			RequiredAttribute("key_file", "string"),
 			OptionalAttribute("key_file_passphrase", "string", ""),
+			OptionalAttribute("insecure_skip_host_key_check", "bool", false),
+		}...,
+	)
+}
+
+type StreamTransportItemSpec struct{}
+
+func (s *StreamTransportItemSpec) Type() string { return "stream" }
+
+func (s *StreamTransportItemSpec) Attributes() []AttributeSpec {
+	return GetDefaultTransportItemAttributes()
+}
+
+type RsyncTransportItemSpec struct{}
+
+func (s *RsyncTransportItemSpec) Type() string { return "rsync" }
+
+func (s *RsyncTransportItemSpec) Attributes() []AttributeSpec {
+	return append(
+		GetDefaultTransportItemAttributes(),
+		[]AttributeSpec{
+			OptionalAttribute("compress", "bool", true),
+			OptionalAttribute("checksum", "bool", false),
+			OptionalAttribute("partial", "bool", true),
+			OptionalAttribute("insecure_skip_host_key_check", "bool", false),
+		}...,
+	)
+}
+
+type HttpTransportItemSpec struct{}
+
+func (s *HttpTransportItemSpec) Type() string { return "http" }
+
+func (s *HttpTransportItemSpec) Attributes() []AttributeSpec {
+	return append(
+		GetDefaultTransportItemAttributes(),
+		[]AttributeSpec{
+			OptionalAttribute("listen", "string", "127.0.0.1:0"),
+			OptionalAttribute("url", "string", ""),
+			OptionalAttribute("forward", "bool", true),
 		}...,
 	)
 }
@@ -173,7 +351,8 @@ func GetDefaultAssetItemAttributes() []AttributeSpec {
 		GetDefaultItemAttributes(),
 		[]AttributeSpec{
 			RequiredAttribute("src", "string"),
-			RequiredAttribute("dst", "string"),
+			RequiredAttribute("dst", "string|[]string"),
+			OptionalAttribute("transport", "string|[]string", []any{}),
 			OptionalAttribute("post_command", "[]object", []map[string]string{}),
 		}...,
 	)
@@ -191,6 +370,20 @@ func (s *FileAssetItemSpec) Attributes() []AttributeSpec {
 			RequiredAttribute("dst_path", "string"),
 			OptionalAttribute("recursive", "bool", false),
 			OptionalAttribute("force", "bool", false),
+			OptionalAttribute("compare", "string", "mtime"),
+			OptionalAttribute("delete_extraneous", "bool", false),
+			OptionalAttribute("max_deletes", "int", 100),
+			OptionalAttribute("include", "string|[]string", []any{}),
+			OptionalAttribute("exclude", "string|[]string", []any{}),
+			OptionalAttribute("owner", "string", ""),
+			OptionalAttribute("group", "string", ""),
+			OptionalAttribute("file_mode", "string", ""),
+			OptionalAttribute("dir_mode", "string", ""),
+			OptionalAttribute("release", "bool", false),
+			OptionalAttribute("keep_releases", "int", 5),
+			OptionalAttribute("backup", "bool", false),
+			OptionalAttribute("keep_backups", "int", 5),
+			OptionalAttribute("symlinks", "string", "preserve"),
 		}...,
 	)
 }
@@ -205,6 +398,11 @@ func (s *LiteralAssetItemSpec) Attributes() []AttributeSpec {
 		[]AttributeSpec{
 			RequiredAttribute("value", "string"),
 			RequiredAttribute("dst_path", "string"),
+			OptionalAttribute("owner", "string", ""),
+			OptionalAttribute("group", "string", ""),
+			OptionalAttribute("file_mode", "string", ""),
+			OptionalAttribute("backup", "bool", false),
+			OptionalAttribute("keep_backups", "int", 5),
 		}...,
 	)
 }
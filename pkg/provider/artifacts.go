@@ -0,0 +1,17 @@
+package provider
+
+import (
+	"github.com/mrshanahan/deploy-assets/pkg/artifact"
+	"github.com/mrshanahan/deploy-assets/pkg/config"
+)
+
+// Returns the cache shared by the asset's destinations, or one that only
+// lives as long as this sync if there isn't one, along with the function to
+// call once the sync is done with it.
+func artifactCache(cfg config.SyncConfig) (config.ArtifactCache, func()) {
+	if cfg.Artifacts != nil {
+		return cfg.Artifacts, func() {}
+	}
+	cache := artifact.NewCache()
+	return cache, cache.Close
+}
@@ -0,0 +1,152 @@
+package provider
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mrshanahan/deploy-assets/pkg/config"
+	"github.com/mrshanahan/deploy-assets/pkg/executor"
+)
+
+// Owner, group & modes that deployed files & directories should have. Unset
+// (empty) fields are left as they are. Owner & group may be names or ids;
+// modes are octal, e.g. "0640".
+type FileAttributes struct {
+	Owner    string
+	Group    string
+	FileMode string
+	DirMode  string
+}
+
+// Checks that the owner & group are plausible user & group names or ids & the
+// modes are valid octal modes.
+func (a FileAttributes) Validate() error {
+	for _, n := range []struct{ name, value string }{{"owner", a.Owner}, {"group", a.Group}} {
+		if !namePatt.MatchString(n.value) {
+			return fmt.Errorf("invalid %s '%s': expected a name or id", n.name, n.value)
+		}
+	}
+	for _, m := range []struct{ name, mode string }{{"file_mode", a.FileMode}, {"dir_mode", a.DirMode}} {
+		if m.mode == "" {
+			continue
+		}
+		if _, err := parseMode(m.mode); err != nil {
+			return fmt.Errorf("invalid %s '%s': expected an octal mode, e.g. 0640", m.name, m.mode)
+		}
+	}
+	return nil
+}
+
+var namePatt = regexp.MustCompile(`^([A-Za-z0-9_][A-Za-z0-9_.-]*\$?)?$`)
+
+func (a FileAttributes) IsSet() bool {
+	return a.Owner != "" || a.Group != "" || a.FileMode != "" || a.DirMode != ""
+}
+
+func parseMode(mode string) (uint64, error) {
+	return strconv.ParseUint(mode, 8, 12)
+}
+
+// What a path on a location has, as reported by stat.
+type pathAttributes struct {
+	mode  uint64
+	owner string
+	uid   string
+	group string
+	gid   string
+}
+
+// Whether the path's owner, group or mode differ from those wanted, given the
+// mode wanted for this kind of path.
+func (a FileAttributes) differ(actual *pathAttributes, mode string) bool {
+	if a.Owner != "" && a.Owner != actual.owner && a.Owner != actual.uid {
+		return true
+	}
+	if a.Group != "" && a.Group != actual.group && a.Group != actual.gid {
+		return true
+	}
+	if mode != "" {
+		wanted, _ := parseMode(mode)
+		return wanted != actual.mode
+	}
+	return false
+}
+
+// Returns the files & directories (all of which must exist) whose owner, group
+// or mode differ from those wanted.
+func findAttributeDrift(exec config.Executor, attrs FileAttributes, files []string, dirs []string) ([]string, []string, error) {
+	if !attrs.IsSet() || len(files)+len(dirs) == 0 {
+		return nil, nil, nil
+	}
+	actual, err := statPaths(exec, append(append([]string{}, files...), dirs...))
+	if err != nil {
+		return nil, nil, err
+	}
+	driftedFiles, driftedDirs := []string{}, []string{}
+	for _, f := range files {
+		if a, prs := actual[f]; !prs || attrs.differ(a, attrs.FileMode) {
+			driftedFiles = append(driftedFiles, f)
+		}
+	}
+	for _, d := range dirs {
+		if a, prs := actual[d]; !prs || attrs.differ(a, attrs.DirMode) {
+			driftedDirs = append(driftedDirs, d)
+		}
+	}
+	return driftedFiles, driftedDirs, nil
+}
+
+// Stats the paths in one go.
+func statPaths(exec config.Executor, paths []string) (map[string]*pathAttributes, error) {
+	var stdout strings.Builder
+	if err := executor.Xargs(exec, "stat -c '%a %U %u %G %g %n'", paths, &stdout); err != nil {
+		return nil, fmt.Errorf("failed to get owners & modes: %w", err)
+	}
+
+	attrs := make(map[string]*pathAttributes)
+	for _, line := range strings.Split(strings.TrimSpace(stdout.String()), "\n") {
+		fields := strings.SplitN(line, " ", 6)
+		if len(fields) < 6 {
+			continue
+		}
+		mode, err := parseMode(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("unexpected mode '%s' for %s on %s", fields[0], fields[5], exec.Name())
+		}
+		attrs[fields[5]] = &pathAttributes{mode: mode, owner: fields[1], uid: fields[2], group: fields[3], gid: fields[4]}
+	}
+	return attrs, nil
+}
+
+// Sets the owner, group & mode of the files & directories to those wanted.
+func applyAttributes(exec config.Executor, attrs FileAttributes, files []string, dirs []string) error {
+	all := append(append([]string{}, files...), dirs...)
+	if len(all) == 0 {
+		return nil
+	}
+	slog.Debug("setting owners & modes", "server", exec.Name(), "num-files", len(files), "num-dirs", len(dirs))
+
+	if attrs.Owner != "" || attrs.Group != "" {
+		owner := attrs.Owner
+		if attrs.Group != "" {
+			owner += ":" + attrs.Group
+		}
+		if err := executor.Xargs(exec, "chown '"+owner+"'", all, nil); err != nil {
+			return err
+		}
+	}
+	if attrs.FileMode != "" && len(files) > 0 {
+		if err := executor.Xargs(exec, "chmod "+attrs.FileMode, files, nil); err != nil {
+			return err
+		}
+	}
+	if attrs.DirMode != "" && len(dirs) > 0 {
+		if err := executor.Xargs(exec, "chmod "+attrs.DirMode, dirs, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
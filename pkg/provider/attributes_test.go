@@ -0,0 +1,29 @@
+package provider
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFileAttributesValidate(t *testing.T) {
+	var tests = []struct {
+		attributes FileAttributes
+		errMsg     string
+	}{
+		{FileAttributes{}, ""},
+		{FileAttributes{Owner: "www-data", Group: "1000", FileMode: "0640", DirMode: "750"}, ""},
+		{FileAttributes{Owner: "root; rm -rf /"}, "invalid owner"},
+		{FileAttributes{Group: "-g"}, "invalid group"},
+		{FileAttributes{FileMode: "0648"}, "invalid file_mode '0648'"},
+		{FileAttributes{DirMode: "u+rwx"}, "invalid dir_mode 'u+rwx'"},
+		{FileAttributes{FileMode: "17777"}, "invalid file_mode"},
+	}
+	for _, test := range tests {
+		err := test.attributes.Validate()
+		if test.errMsg == "" && err != nil {
+			t.Errorf("expected %+v to be valid, got %v", test.attributes, err)
+		} else if test.errMsg != "" && (err == nil || !strings.Contains(err.Error(), test.errMsg)) {
+			t.Errorf("expected error containing '%s' for %+v, got %v", test.errMsg, test.attributes, err)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package provider
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mrshanahan/deploy-assets/internal/util"
+	"github.com/mrshanahan/deploy-assets/pkg/config"
+	"github.com/mrshanahan/deploy-assets/pkg/executor"
+)
+
+// Backups of a destination path are kept beside it, in
+// <dir>/.<name>.backups/<id>, each holding:
+//
+//	changes		what the sync did to each path, each NUL-terminated
+//	files.tar.gz	the previous versions of the paths it overwrote or deleted
+const (
+	backupChangesFileName = "changes"
+	backupFilesFileName   = "files.tar.gz"
+)
+
+type changeKind string
+
+const (
+	changeCreated changeKind = "created"
+	changeUpdated changeKind = "updated"
+	changeDeleted changeKind = "deleted"
+)
+
+// Something a sync does to a path on the destination.
+type fileChange struct {
+	kind changeKind
+	path string
+}
+
+func backupDirFor(dstPath string) string {
+	return filepath.Join(filepath.Dir(dstPath), "."+filepath.Base(dstPath)+".backups")
+}
+
+// Returns the paths whose previous versions have to be kept to undo the
+// changes.
+func previousPaths(changes []fileChange) []string {
+	paths := []string{}
+	for _, c := range changes {
+		if c.kind != changeCreated {
+			paths = append(paths, c.path)
+		}
+	}
+	return paths
+}
+
+// Archives the previous versions of the paths about to be changed under
+// dstPath's backup directory, along with the changes themselves, & removes all
+// but the newest keep backups. Returns the new backup's id.
+func createBackup(exec config.Executor, dstPath string, changes []fileChange, keep int) (string, error) {
+	dir := backupDirFor(dstPath)
+	ids, err := listTimestampIDs(exec, dir)
+	if err != nil {
+		return "", err
+	}
+	id := newTimestampID(time.Now(), ids)
+	backupPath := filepath.Join(dir, id)
+	slog.Info("backing up files", "dst", exec.Name(), "path", dstPath, "backup", id, "num-files", len(previousPaths(changes)))
+
+	err = writeBackup(exec, backupPath, changes)
+	if err != nil {
+		exec.ExecuteCommand("rm", "-rf", backupPath)
+		return "", err
+	}
+
+	if old := oldestIDs(append(ids, id), keep, id); len(old) > 0 {
+		slog.Info("removing old backups", "dst", exec.Name(), "path", dstPath, "backups", old)
+		if err := removeIDs(exec, dir, old); err != nil {
+			slog.Warn("failed to remove old backups", "dst", exec.Name(), "path", dstPath, "err", err)
+		}
+	}
+	return id, nil
+}
+
+func writeBackup(exec config.Executor, backupPath string, changes []fileChange) error {
+	if _, _, err := exec.ExecuteCommand("mkdir", "-p", backupPath); err != nil {
+		return fmt.Errorf("failed to create backup directory %s on %s: %w", backupPath, exec.Name(), err)
+	}
+
+	changesPath := filepath.Join(backupPath, backupChangesFileName)
+	// Paths can contain newlines, so changes are NUL-terminated.
+	records := util.Map(changes, func(c fileChange) string { return string(c.kind) + " " + c.path + "\x00" })
+	stdin := strings.NewReader(strings.Join(records, ""))
+	if stderr, err := exec.ExecuteShellStreaming("cat > "+executor.ShellQuote(changesPath), stdin, nil); err != nil {
+		return fmt.Errorf("failed to write %s on %s (stderr: %s): %w", changesPath, exec.Name(), strings.TrimSpace(stderr), err)
+	}
+
+	// Paths are archived as they are (-P), absolute or not, so that they're
+	// extracted back to the same place.
+	if previous := previousPaths(changes); len(previous) > 0 {
+		filesPath := filepath.Join(backupPath, backupFilesFileName)
+		stdin := strings.NewReader(strings.Join(previous, "\x00") + "\x00")
+		if stderr, err := exec.ExecuteShellStreaming(fmt.Sprintf("tar czPf %s --null -T -", executor.ShellQuote(filesPath)), stdin, nil); err != nil {
+			return fmt.Errorf("failed to archive files into %s on %s (stderr: %s): %w", filesPath, exec.Name(), strings.TrimSpace(stderr), err)
+		}
+	}
+	return nil
+}
+
+// Undoes the changes recorded in the backup of dstPath with the given id, or
+// the latest if it's empty: created files are removed & overwritten or deleted
+// ones put back as they were.
+func restoreBackup(exec config.Executor, name string, dstPath string, id string, dryRun bool) error {
+	dir := backupDirFor(dstPath)
+	ids, err := listTimestampIDs(exec, dir)
+	if err != nil {
+		return err
+	}
+	if id == "" {
+		if len(ids) == 0 {
+			return fmt.Errorf("no backups of asset %s on %s", name, exec.Name())
+		}
+		id = ids[len(ids)-1]
+	} else if !slices.Contains(ids, id) {
+		return fmt.Errorf("no backup %s of asset %s on %s; backups: %s", id, name, exec.Name(), strings.Join(ids, ", "))
+	}
+	backupPath := filepath.Join(dir, id)
+
+	changesPath := filepath.Join(backupPath, backupChangesFileName)
+	stdout, stderr, err := exec.ExecuteCommand("cat", changesPath)
+	if err != nil {
+		return fmt.Errorf("failed to read %s on %s (stderr: %s): %w", changesPath, exec.Name(), strings.TrimSpace(stderr), err)
+	}
+	changes, err := parseChanges(stdout)
+	if err != nil {
+		return fmt.Errorf("invalid backup %s of asset %s on %s: %w", id, name, exec.Name(), err)
+	}
+
+	if dryRun {
+		slog.Info("DRY RUN: restoring backup", "name", name, "dst", exec.Name(), "backup", id, "num-files", len(changes))
+		for _, c := range changes {
+			slog.Info("DRY RUN: restore", "dst-path", c.path, "change", c.kind)
+		}
+		return nil
+	}
+
+	slog.Info("restoring backup", "name", name, "dst", exec.Name(), "backup", id, "num-files", len(changes))
+	created := []string{}
+	for _, c := range changes {
+		if c.kind == changeCreated {
+			created = append(created, c.path)
+		}
+	}
+	if len(created) > 0 {
+		if err := executor.Xargs(exec, "rm -f", created, nil); err != nil {
+			return err
+		}
+	}
+	if len(previousPaths(changes)) > 0 {
+		filesPath := filepath.Join(backupPath, backupFilesFileName)
+		if _, stderr, err := exec.ExecuteShell("tar xzPpf " + executor.ShellQuote(filesPath)); err != nil {
+			return fmt.Errorf("failed to extract %s on %s (stderr: %s): %w", filesPath, exec.Name(), strings.TrimSpace(stderr), err)
+		}
+	}
+	return nil
+}
+
+func parseChanges(contents string) ([]fileChange, error) {
+	changes := []fileChange{}
+	for _, record := range strings.Split(contents, "\x00") {
+		if record == "" {
+			continue
+		}
+		kind, path, ok := strings.Cut(record, " ")
+		switch c := changeKind(kind); {
+		case !ok || path == "":
+			return nil, fmt.Errorf("malformed change: '%s'", record)
+		case c != changeCreated && c != changeUpdated && c != changeDeleted:
+			return nil, fmt.Errorf("unknown change '%s' to %s", kind, path)
+		default:
+			changes = append(changes, fileChange{c, path})
+		}
+	}
+	return changes, nil
+}
@@ -0,0 +1,182 @@
+package provider
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mrshanahan/deploy-assets/pkg/config"
+	"github.com/mrshanahan/deploy-assets/pkg/executor"
+	"github.com/mrshanahan/deploy-assets/pkg/transport"
+)
+
+func TestFileSyncBackup(t *testing.T) {
+	// Quotes & a $ in the path, which is spliced into the backup commands.
+	rootPath := filepath.Join(t.TempDir(), `it's "$HOME"`)
+	srcRootPath, dstRootPath := filepath.Join(rootPath, "src"), filepath.Join(rootPath, "dst")
+	for _, e := range []fileDef{
+		{"app/kept.conf", EARLY_MOD_TIME, "kept"},
+		{"app/changed.conf", LATER_MOD_TIME, "new"},
+		{"app/nested/created.conf", EARLY_MOD_TIME, "created"},
+		{"app/created\nline.conf", EARLY_MOD_TIME, "created"},
+	} {
+		if err := createTestFile(srcRootPath, e); err != nil {
+			t.Fatalf("failed to create src test file: %v", err)
+		}
+	}
+	for _, e := range []fileDef{
+		{"app/kept.conf", EARLY_MOD_TIME, "kept"},
+		{"app/changed.conf", EARLY_MOD_TIME, "old"},
+		{"app/deleted.conf", EARLY_MOD_TIME, "deleted"},
+		{"app/deleted\nline.conf", EARLY_MOD_TIME, "deleted"},
+	} {
+		if err := createTestFile(dstRootPath, e); err != nil {
+			t.Fatalf("failed to create dst test file: %v", err)
+		}
+	}
+
+	srcExecutor := executor.NewLocalExecutor("src", t.TempDir(), 0)
+	dstExecutor := executor.NewLocalExecutor("dst", t.TempDir(), 0)
+	defer srcExecutor.Close()
+	defer dstExecutor.Close()
+	dstPath := filepath.Join(dstRootPath, "app")
+	sut := NewFileProvider("test", "", filepath.Join(srcRootPath, "app"), dstPath, FileProviderOptions{Recursive: true, DeleteExtraneous: true, Backup: true, KeepBackups: 1})
+	cfg := config.SyncConfig{
+		SrcExecutor: srcExecutor,
+		DstExecutor: dstExecutor,
+		Transport:   transport.NewLocalTransport(),
+		DryRun:      false,
+	}
+
+	if _, err := sut.Sync(cfg); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	assertFileContents(t, filepath.Join(dstPath, "changed.conf"), "new")
+	backups, err := listTimestampIDs(dstExecutor, backupDirFor(dstPath))
+	if err != nil || len(backups) != 1 {
+		t.Fatalf("expected one backup, got %v (err: %v)", backups, err)
+	}
+	if result, err := sut.Sync(cfg); err != nil || result != config.SYNC_RESULT_NOCHANGE {
+		t.Fatalf("expected no change, got %v (err: %v)", result, err)
+	}
+	if again, _ := listTimestampIDs(dstExecutor, backupDirFor(dstPath)); !reflect.DeepEqual(backups, again) {
+		t.Errorf("expected no backup when nothing changed, got %v", again)
+	}
+
+	restore := sut.(config.BackupProvider)
+	if err := restore.Restore(dstExecutor, "", true); err != nil {
+		t.Fatalf("dry run restore failed: %v", err)
+	}
+	assertFileContents(t, filepath.Join(dstPath, "changed.conf"), "new")
+	if err := restore.Restore(dstExecutor, "", false); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+
+	dstFiles, err := readDirR(dstPath)
+	if err != nil {
+		t.Fatalf("failed to read dst: %v", err)
+	}
+	actual := flattenDirEntry(dstFiles)
+	sort.Strings(actual)
+	if expected := []string{"changed.conf", "deleted\nline.conf", "deleted.conf", "kept.conf"}; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected restore to leave %v, got %v", expected, actual)
+	}
+	assertFileContents(t, filepath.Join(dstPath, "changed.conf"), "old")
+	assertFileContents(t, filepath.Join(dstPath, "deleted.conf"), "deleted")
+	assertFileContents(t, filepath.Join(dstPath, "deleted\nline.conf"), "deleted")
+	earlyModTime, _ := time.Parse(time.RFC3339, EARLY_MOD_TIME)
+	if info, err := os.Stat(filepath.Join(dstPath, "changed.conf")); err != nil || !info.ModTime().Equal(earlyModTime) {
+		t.Errorf("expected restored file to keep its modification time, got %v (err: %v)", info, err)
+	}
+
+	if err := restore.Restore(dstExecutor, "20000101T000000Z", false); err == nil || !strings.Contains(err.Error(), "no backup") {
+		t.Errorf("expected restore of a missing backup to fail, got %v", err)
+	}
+}
+
+func TestLiteralSyncBackup(t *testing.T) {
+	dstPath := filepath.Join(t.TempDir(), "motd")
+	dstExecutor := executor.NewLocalExecutor("dst", t.TempDir(), 0)
+	defer dstExecutor.Close()
+	cfg := config.SyncConfig{DstExecutor: dstExecutor}
+
+	first := NewLiteralProvider("test", "first", dstPath, FileAttributes{}, true, 2)
+	if _, err := first.Sync(cfg); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	second := NewLiteralProvider("test", "second", dstPath, FileAttributes{}, true, 2)
+	if _, err := second.Sync(cfg); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	assertFileContents(t, dstPath, "second")
+
+	if err := second.(config.BackupProvider).Restore(dstExecutor, "", false); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	assertFileContents(t, dstPath, "first")
+
+	// The oldest backup is of the sync that created the file.
+	backups, err := listTimestampIDs(dstExecutor, backupDirFor(dstPath))
+	if err != nil || len(backups) != 2 {
+		t.Fatalf("expected two backups, got %v (err: %v)", backups, err)
+	}
+	if err := second.(config.BackupProvider).Restore(dstExecutor, backups[0], false); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	if _, err := os.Stat(dstPath); !os.IsNotExist(err) {
+		t.Errorf("expected restoring before the file was created to remove it, got %v", err)
+	}
+}
+
+func TestLiteralSyncBackupUnchanged(t *testing.T) {
+	dstPath := filepath.Join(t.TempDir(), "motd")
+	dstExecutor := executor.NewLocalExecutor("dst", t.TempDir(), 0)
+	defer dstExecutor.Close()
+	cfg := config.SyncConfig{DstExecutor: dstExecutor}
+
+	first := NewLiteralProvider("test", "first", dstPath, FileAttributes{}, true, 2)
+	if _, err := first.Sync(cfg); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	second := NewLiteralProvider("test", "second", dstPath, FileAttributes{}, true, 2)
+	for i := 0; i < 4; i++ {
+		result, err := second.Sync(cfg)
+		if err != nil {
+			t.Fatalf("sync failed: %v", err)
+		}
+		if expected := map[bool]config.SyncResult{true: config.SYNC_RESULT_UPDATED, false: config.SYNC_RESULT_NOCHANGE}[i == 0]; result != expected {
+			t.Errorf("expected sync %d to be %v, got %v", i+1, expected, result)
+		}
+	}
+
+	// Unchanged syncs take no backups, so the latest is still of the value
+	// before the last change.
+	if err := second.(config.BackupProvider).Restore(dstExecutor, "", false); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	assertFileContents(t, dstPath, "first")
+}
+
+func TestParseChanges(t *testing.T) {
+	changes, err := parseChanges("updated /etc/app.conf\x00created /etc/with space.conf\x00deleted rel/new\nline\x00")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	expected := []fileChange{
+		{changeUpdated, "/etc/app.conf"},
+		{changeCreated, "/etc/with space.conf"},
+		{changeDeleted, "rel/new\nline"},
+	}
+	if !reflect.DeepEqual(expected, changes) {
+		t.Errorf("expected %v, got %v", expected, changes)
+	}
+	for _, bad := range []string{"updated", "moved /etc/app.conf"} {
+		if _, err := parseChanges(bad); err == nil {
+			t.Errorf("expected '%s' to be rejected", bad)
+		}
+	}
+}
@@ -11,6 +11,7 @@ import (
 
 	"github.com/mrshanahan/deploy-assets/internal/util"
 	"github.com/mrshanahan/deploy-assets/pkg/config"
+	"github.com/mrshanahan/deploy-assets/pkg/executor"
 )
 
 func NewDockerProvider(name string, repositories []string, compareLabel string) config.Provider {
@@ -79,61 +80,72 @@ func (p *dockerProvider) Sync(cfg config.SyncConfig) (config.SyncResult, error)
 		return changeType, nil
 	}
 
-	tempPath := util.GetTempFilePath("deploy-assets-docker")
-	if _, _, err := cfg.SrcExecutor.ExecuteCommand("mkdir", "-p", tempPath); err != nil {
-		slog.Error("could not create src temp directory", "dir", tempPath, "err", err)
+	dstStagingDir, err := cfg.DstExecutor.StagingDir()
+	if err != nil {
+		return config.SYNC_RESULT_NOCHANGE, err
+	}
+
+	tempName := util.GetRandomFileName("docker")
+	dstTempPath := filepath.Join(dstStagingDir, tempName)
+	if _, _, err := cfg.DstExecutor.ExecuteCommand("mkdir", "-p", dstTempPath); err != nil {
+		slog.Error("could not create dst temp directory", "dst", cfg.DstExecutor.Name(), "dir", dstTempPath, "err", err)
 		return config.SYNC_RESULT_NOCHANGE, err
 	}
-	defer cfg.SrcExecutor.ExecuteCommand("rm", "-rf", tempPath)
+	defer cfg.DstExecutor.ExecuteCommand("rm", "-rf", dstTempPath)
+
+	artifacts, closeArtifacts := artifactCache(cfg)
+	defer closeArtifacts()
 
 	srcName := cfg.SrcExecutor.Name()
 	dstName := cfg.DstExecutor.Name()
 
 	slog.Info("syncing docker images", "src", srcName, "dst", dstName)
 
-	// TODO: Cache comparisons, and then don't need to re-export each time for multiple dsts
 	// TODO: Sub-logger with src/dst/image
 	for _, e := range entriesToTransfer {
 		// docker save "$I" -o "./$FILENAME"
 		fileName := strings.Replace(e.Repository, "/", "_", -1) + ".tar.gz"
-		filePath := filepath.Join(tempPath, fileName)
-
-		if _, stderr, err := cfg.SrcExecutor.ExecuteCommand("docker", "save", e.Repository, "-o", filePath); err != nil {
-			slog.Error("failed to export image", "src", srcName, "dst", dstName, "image", e.Repository, "stderr", stderr, "err", err)
-		}
+		dstFilePath := filepath.Join(dstTempPath, fileName)
+
+		// The image ID changes with the image, so an export can be shared by
+		// every destination that needs this image.
+		filePath, err := artifacts.Get(cfg.SrcExecutor, "docker:"+e.Repository+"@"+e.ID, fileName, func(path string) error {
+			if _, stderr, err := cfg.SrcExecutor.ExecuteCommand("docker", "save", e.Repository, "-o", path); err != nil {
+				slog.Error("failed to export image", "src", srcName, "image", e.Repository, "stderr", stderr, "err", err)
+				return fmt.Errorf("failed to export image %s on %s: %w", e.Repository, srcName, err)
+			}
 
-		fileSize := ""
-		stdout, _, err := cfg.SrcExecutor.ExecuteCommand("stat", "-c", "%s", filePath)
-		if err != nil {
-			slog.Warn("failed to get file size; continuing without it", "src", srcName, "dst", dstName, "image", e.Repository, "err", err)
-		} else {
-			fileSizeBytes, err := strconv.ParseInt(strings.TrimSpace(stdout), 10, 64)
+			fileSize := ""
+			stdout, _, err := cfg.SrcExecutor.ExecuteCommand("stat", "-c", "%s", path)
 			if err != nil {
-				slog.Warn("failed to parse file size; continuing without it", "src", srcName, "dst", dstName, "image", e.Repository, "err", err)
+				slog.Warn("failed to get file size; continuing without it", "src", srcName, "image", e.Repository, "err", err)
 			} else {
-				fileSize = util.HumanReadableSize(fileSizeBytes)
+				fileSizeBytes, err := strconv.ParseInt(strings.TrimSpace(stdout), 10, 64)
+				if err != nil {
+					slog.Warn("failed to parse file size; continuing without it", "src", srcName, "image", e.Repository, "err", err)
+				} else {
+					fileSize = util.HumanReadableSize(fileSizeBytes)
+				}
 			}
-		}
-
-		if _, _, err := cfg.DstExecutor.ExecuteCommand("mkdir", "-p", tempPath); err != nil {
-			slog.Error("could not create dst temp directory", "dst", dstName, "dir", tempPath, "err", err)
+			slog.Info("exported image", "src", srcName, "image", e.Repository, "file-size", fileSize)
+			return nil
+		})
+		if err != nil {
 			return config.SYNC_RESULT_NOCHANGE, err
 		}
-		defer cfg.DstExecutor.ExecuteCommand("rm", "-rf", tempPath)
 
 		slog.Info("transferring image",
 			"src", srcName,
 			"dst", dstName,
-			"image", e.Repository,
-			"file-size", fileSize)
+			"image", e.Repository)
 
-		if err := cfg.Transport.TransferFile(cfg.SrcExecutor, filePath, cfg.DstExecutor, filePath); err != nil {
-			slog.Error("failed to transfer file", "dst", dstName, "file", filePath, "err", err)
+		if err := artifacts.Transfer(cfg.Transport, cfg.SrcExecutor, filePath, cfg.DstExecutor, dstFilePath); err != nil {
+			slog.Error("failed to transfer file", "dst", dstName, "file", dstFilePath, "err", err)
 			return config.SYNC_RESULT_NOCHANGE, err
 		}
 
-		if _, stderr, err := cfg.DstExecutor.ExecuteShell(fmt.Sprintf("cat %s | sudo docker load", filePath)); err != nil {
-			slog.Error("failed to load image on remote", "dst", dstName, "file", filePath, "image", e.Repository, "stderr", stderr, "err", err)
+		if _, stderr, err := cfg.DstExecutor.ExecuteShell(fmt.Sprintf("cat %s | sudo docker load", executor.ShellQuote(dstFilePath))); err != nil {
+			slog.Error("failed to load image on remote", "dst", dstName, "file", dstFilePath, "image", e.Repository, "stderr", stderr, "err", err)
 			return config.SYNC_RESULT_NOCHANGE, err
 		}
 	}
@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/mrshanahan/deploy-assets/internal/util"
+	"github.com/mrshanahan/deploy-assets/pkg/config"
 )
 
 func TestDockerYamlDefault(t *testing.T) {
@@ -50,3 +51,29 @@ func TestDockerYamlNoLabel(t *testing.T) {
 		t.Errorf("yaml contents not equal:\nexpected:\n=======\n%s\n=======\ngot:\n=======\n%s\n=======", expected, actual)
 	}
 }
+
+// Regression test against command output; see newFixtureExecutors. Unlike the
+// other fixtures, the docker ones are synthetic: they were written by hand to
+// match the shape of docker's output (the image ids & staging paths in them
+// are made up), not recorded, so they're kept up to date by hand too. Only
+// example/app differs (by version label), so only it should be exported.
+func TestDockerSyncFixture(t *testing.T) {
+	if *recordFixtures {
+		t.Skip("docker fixtures are written by hand, not recorded")
+	}
+
+	executors := newFixtureExecutors(t, "docker-sync", "build", "web1")
+	sut := NewDockerProvider("test", []string{"example/app", "example/worker:1.4"}, "org.opencontainers.image.version")
+	result, err := sut.Sync(config.SyncConfig{
+		SrcExecutor: executors[0],
+		DstExecutor: executors[1],
+		Transport:   &fixtureTransport{},
+		DryRun:      false,
+	})
+	if err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	if result != config.SYNC_RESULT_UPDATED {
+		t.Errorf("expected result %v, got %v", config.SYNC_RESULT_UPDATED, result)
+	}
+}
@@ -1,30 +1,120 @@
 package provider
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/mrshanahan/deploy-assets/internal/util"
 	"github.com/mrshanahan/deploy-assets/pkg/config"
+	"github.com/mrshanahan/deploy-assets/pkg/executor"
 )
 
-func NewFileProvider(name, srcDir, srcPath, dstPath string, recursive bool, force bool) config.Provider {
-	return &fileProvider{name, srcDir, srcPath, dstPath, recursive, force, make(map[string]*fileEntry), make(map[string]map[string]*fileEntry)}
+// How the file provider decides whether a file that exists on both locations
+// has changed.
+const (
+	// Its modification time differs.
+	FileCompareMtime = "mtime"
+	// Its size or modification time differs.
+	FileCompareSizeMtime = "size_mtime"
+	// Its size or SHA-256 differs, whatever its modification time.
+	FileCompareHash = "hash"
+)
+
+// What the file provider does with symlinks in a source directory.
+const (
+	// Deploys them as symlinks with the same targets.
+	FileSymlinksPreserve = "preserve"
+	// Deploys what they point to in their place.
+	FileSymlinksFollow = "follow"
+	// Leaves them out, on both locations.
+	FileSymlinksSkip = "skip"
+)
+
+// How a file provider deploys its source; see the fields of fileProvider.
+// Compare & Symlinks default to FileCompareMtime & FileSymlinksPreserve.
+type FileProviderOptions struct {
+	Recursive        bool
+	Force            bool
+	Compare          string
+	DeleteExtraneous bool
+	MaxDeletes       int
+	Include          []string
+	Exclude          []string
+	Attributes       FileAttributes
+	Release          bool
+	KeepReleases     int
+	Backup           bool
+	KeepBackups      int
+	Symlinks         string
+}
+
+func NewFileProvider(name, srcDir, srcPath, dstPath string, opts FileProviderOptions) config.Provider {
+	if opts.Compare == "" {
+		opts.Compare = FileCompareMtime
+	}
+	if opts.Symlinks == "" {
+		opts.Symlinks = FileSymlinksPreserve
+	}
+	return &fileProvider{
+		name:             name,
+		srcDir:           srcDir,
+		srcPath:          srcPath,
+		dstPath:          dstPath,
+		recursive:        opts.Recursive,
+		force:            opts.Force,
+		compare:          opts.Compare,
+		deleteExtraneous: opts.DeleteExtraneous,
+		maxDeletes:       opts.MaxDeletes,
+		include:          opts.Include,
+		exclude:          opts.Exclude,
+		attributes:       opts.Attributes,
+		release:          opts.Release,
+		keepReleases:     opts.KeepReleases,
+		backup:           opts.Backup,
+		keepBackups:      opts.KeepBackups,
+		symlinks:         opts.Symlinks,
+		srcEntries:       make(map[string]*fileEntry),
+		dstEntries:       make(map[string]map[string]*fileEntry),
+	}
 }
 
 type fileProvider struct {
-	name       string
-	srcDir     string
-	srcPath    string
-	dstPath    string
-	recursive  bool
-	force      bool
-	srcEntries map[string]*fileEntry
-	dstEntries map[string]map[string]*fileEntry
+	name      string
+	srcDir    string
+	srcPath   string
+	dstPath   string
+	recursive bool
+	force     bool
+	compare   string
+	// Whether to remove files from the destination directory that aren't in
+	// the source, refusing to if there are more than maxDeletes. 0 means no
+	// limit, which manifests can't ask for (max_deletes must be at least 1)
+	// but release mode uses for its syncs into new release directories.
+	deleteExtraneous bool
+	maxDeletes       int
+	// Patterns for the files in a source directory to deploy (all, if there
+	// are none) & those not to; see fileFilter.
+	include    []string
+	exclude    []string
+	attributes FileAttributes
+	// Whether to deploy a source directory into a new release under the
+	// destination each time it changes, keeping the last keepReleases; see
+	// syncRelease.
+	release      bool
+	keepReleases int
+	// Whether to back up the files a sync overwrites or deletes first,
+	// keeping the last keepBackups backups; see createBackup.
+	backup      bool
+	keepBackups int
+	symlinks    string
+	srcEntries  map[string]*fileEntry
+	dstEntries  map[string]map[string]*fileEntry
 }
 
 type targetFileEntry struct {
@@ -36,6 +126,24 @@ type fileEntry struct {
 	path         string
 	relativePath string
 	modifiedAt   time.Time
+	size         int64
+	kind         fileKind
+	// What a symlink points to, as it's written.
+	target string
+}
+
+// What a path is, as find's %y reports it. Anything else, e.g. a device or a
+// FIFO, can't be deployed.
+type fileKind string
+
+const (
+	fileKindFile fileKind = "f"
+	fileKindDir  fileKind = "d"
+	fileKindLink fileKind = "l"
+)
+
+func (e *fileEntry) isSpecial() bool {
+	return e.kind != fileKindFile && e.kind != fileKindDir && e.kind != fileKindLink
 }
 
 type mappedFileEntry struct {
@@ -54,10 +162,14 @@ type fileInfo struct {
 // TODO: This is all fucked up. There shouldn't be all this random branching for dir/non-dir & we should just
 // treat it as a collection of absolute paths mapped from one to the other. Fix this!
 
-func loadFileEntries(finfo *fileInfo, executor config.Executor, recursive bool) (map[string]*fileEntry, error) {
+// Loads the files, directories & symlinks under a directory (or the file
+// itself), leaving out those the filter doesn't match. Subdirectories are only
+// loaded when recursive. With the follow symlink policy, what symlinks point to
+// is loaded in their place; with skip, they're left out.
+func loadFileEntries(finfo *fileInfo, exec config.Executor, recursive bool, filter *fileFilter, symlinks string) (map[string]*fileEntry, error) {
 	// NB: We do not set workingDir here as we should be solely using absolute paths.
 
-	server := executor.Name()
+	server := exec.Name()
 	entries := make(map[string]*fileEntry)
 
 	var dirPath string
@@ -71,46 +183,83 @@ func loadFileEntries(finfo *fileInfo, executor config.Executor, recursive bool)
 	}
 	dirPath = strings.TrimRight(dirPath, "/") + "/"
 
-	maxDepthArg := ""
+	followArg := ""
+	if symlinks == FileSymlinksFollow {
+		followArg = "-L "
+	}
+	depthArgs := ""
+	if finfo.IsDirectory {
+		depthArgs = "-mindepth 1 "
+	}
 	if !recursive {
-		maxDepthArg = "-maxdepth 1 "
+		depthArgs += "-maxdepth 1 "
 	}
-	cmd := fmt.Sprintf("find \"%s\" -type f %s-exec ls -l --time-style=+%%s '{}' \\; | sed -E 's/ +/ /g' | cut -d ' ' -f6-", finfo.FullPath, maxDepthArg)
+	// Fields are NUL-terminated, since paths & link targets can contain
+	// anything else.
+	cmd := fmt.Sprintf("find %s%s %s-printf '%%y %%s %%T@ %%p\\0%%l\\0'", followArg, executor.ShellQuote(finfo.FullPath), depthArgs)
 	slog.Debug("executing file discovery", "server", server, "cmd", cmd)
-	stdout, stderr, err := executor.ExecuteShell(cmd)
+	stdout, stderr, err := exec.ExecuteShell(cmd)
 	if err != nil {
 		slog.Error("failed to perform file discovery", "server", server, "stdout", stdout, "stderr", stderr, "err", err)
 		return nil, err
 	}
-	files := strings.Split(strings.TrimSpace(stdout), "\n")
-	slog.Debug("found files", "server", server, "num-files", len(files))
-	for _, f := range files {
-		if f == "" {
-			continue
+	fields := strings.Split(stdout, "\x00")
+	slog.Debug("found files", "server", server, "num-files", len(fields)/2)
+	for i := 0; i+1 < len(fields); i += 2 {
+		comps := strings.SplitN(fields[i], " ", 4)
+		if len(comps) < 4 {
+			return nil, fmt.Errorf("unexpected file discovery output on %s: '%s'", server, fields[i])
+		}
+		kind := fileKind(comps[0])
+		size, err := strconv.ParseInt(comps[1], 10, 64)
+		if err != nil {
+			return nil, err
 		}
-		comps := strings.Split(f, " ")
-		timestampStr := comps[0]
+		timestampStr, _, _ := strings.Cut(comps[2], ".")
 		timestamp, err := strconv.ParseInt(timestampStr, 10, 64)
 		if err != nil {
 			return nil, err
 		}
-		filePath := strings.Join(comps[1:], " ")
-		relativePath := strings.Replace(filePath, dirPath, "", -1)
-		entries[relativePath] = &fileEntry{filePath, relativePath, time.Unix(timestamp, 0)}
+		filePath := comps[3]
+		relativePath := strings.TrimPrefix(filePath, dirPath)
+
+		switch {
+		case kind == fileKindDir && !recursive:
+			continue
+		case kind == fileKindLink && symlinks == FileSymlinksSkip:
+			slog.Debug("skipping symlink", "server", server, "relative-path", relativePath)
+			continue
+		case kind == fileKindLink && symlinks == FileSymlinksFollow:
+			return nil, fmt.Errorf("cannot follow symlink %s on %s: it's broken or loops", filePath, server)
+		}
+		if finfo.IsDirectory && filter != nil && !filter.Matches(relativePath, kind == fileKindDir) {
+			slog.Debug("skipping filtered file", "server", server, "relative-path", relativePath)
+			continue
+		}
+		entries[relativePath] = &fileEntry{
+			path:         filePath,
+			relativePath: relativePath,
+			modifiedAt:   time.Unix(timestamp, 0),
+			size:         size,
+			kind:         kind,
+			target:       fields[i+1],
+		}
 		slog.Debug("file entry",
 			"server", server,
 			"relative-path", entries[relativePath].relativePath,
 			"full-path", entries[relativePath].path,
-			"modified-at", entries[relativePath].modifiedAt.UTC().Format(time.RFC3339))
+			"kind", kind,
+			"modified-at", entries[relativePath].modifiedAt.UTC().Format(time.RFC3339),
+			"size", entries[relativePath].size)
 	}
 	return entries, nil
 }
 
-func getFileInfo(workingDir string, path string, executor config.Executor) (*fileInfo, error) {
-	server := executor.Name()
+func getFileInfo(workingDir string, path string, exec config.Executor) (*fileInfo, error) {
+	server := exec.Name()
 
 	// TODO: Paths ending in a return/newline will be incorrect after trim. I _hope_ we don't have to worry about this.
-	canonPath, stderr, err := executor.ExecuteShellInDir(workingDir, fmt.Sprintf("realpath -m \"%s\"", path))
+	canonPath, stderr, err := exec.ExecuteShellInDir(workingDir, "realpath -m "+executor.ShellQuote(path))
 	if err != nil {
 		slog.Error("failed to canonicalize path", "stderr", stderr, "err", err)
 		return nil, err
@@ -119,7 +268,7 @@ func getFileInfo(workingDir string, path string, executor config.Executor) (*fil
 
 	dirName := filepath.Dir(canonPath)
 
-	stdout, stderr, err := executor.ExecuteShellInDir(workingDir, fmt.Sprintf("test -e \"%s\"", dirName))
+	stdout, stderr, err := exec.ExecuteShellInDir(workingDir, "test -e "+executor.ShellQuote(dirName))
 	if err != nil && stderr == "" {
 		return &fileInfo{
 			FullPath:    canonPath,
@@ -133,7 +282,7 @@ func getFileInfo(workingDir string, path string, executor config.Executor) (*fil
 		return nil, err
 	}
 
-	stdout, stderr, err = executor.ExecuteShellInDir(workingDir, fmt.Sprintf("test -e \"%s\"", canonPath))
+	stdout, stderr, err = exec.ExecuteShellInDir(workingDir, "test -e "+executor.ShellQuote(canonPath))
 	if err != nil && stderr == "" {
 		return &fileInfo{
 			FullPath:    canonPath,
@@ -146,7 +295,7 @@ func getFileInfo(workingDir string, path string, executor config.Executor) (*fil
 		return nil, err
 	}
 
-	fileType, stderr, err := executor.ExecuteShellInDir(workingDir, fmt.Sprintf("stat \"%s\" -c %%F", canonPath))
+	fileType, stderr, err := exec.ExecuteShellInDir(workingDir, fmt.Sprintf("stat %s -c %%F", executor.ShellQuote(canonPath)))
 	if err != nil {
 		slog.Error("failed to get file type", "server", server, "path", canonPath, "stdout", fileType, "stderr", stderr, "err", err)
 		return nil, err
@@ -172,14 +321,76 @@ func (p *fileProvider) Yaml(indent int) string {
 %ssrc_path: %s
 %sdst_path: %s
 %srecursive: %t
-%sforce: %t`,
+%sforce: %t
+%scompare: %s
+%sdelete_extraneous: %t
+%smax_deletes: %d
+%sinclude: %s
+%sexclude: %s
+%sowner: %s
+%sgroup: %s
+%sfile_mode: %s
+%sdir_mode: %s
+%srelease: %t
+%skeep_releases: %d
+%sbackup: %t
+%skeep_backups: %d
+%ssymlinks: %s`,
 		util.YamlIndentString(indent),
 		propIndent, p.name,
 		propIndent, p.srcDir,
 		propIndent, p.srcPath,
 		propIndent, p.dstPath,
 		propIndent, p.recursive,
-		propIndent, p.force)
+		propIndent, p.force,
+		propIndent, p.compare,
+		propIndent, p.deleteExtraneous,
+		propIndent, p.maxDeletes,
+		propIndent, buildPatternsYaml(p.include),
+		propIndent, buildPatternsYaml(p.exclude),
+		propIndent, p.attributes.Owner,
+		propIndent, p.attributes.Group,
+		propIndent, p.attributes.FileMode,
+		propIndent, p.attributes.DirMode,
+		propIndent, p.release,
+		propIndent, p.keepReleases,
+		propIndent, p.backup,
+		propIndent, p.keepBackups,
+		propIndent, p.symlinks)
+}
+
+// Puts back the files overwritten or deleted by the sync that took the backup
+// with the given id (or the latest), & removes those it created.
+func (p *fileProvider) Restore(dst config.Executor, id string, dryRun bool) error {
+	dstFileInfo, err := getFileInfo("", p.dstPath, dst)
+	if err != nil {
+		return err
+	}
+	return restoreBackup(dst, p.Name(), dstFileInfo.FullPath, id, dryRun)
+}
+
+// Builds the filter for the source directory from the include & exclude
+// patterns & its ignore file, if it has one.
+func (p *fileProvider) loadFilter(exec config.Executor, srcFileInfo *fileInfo) (*fileFilter, error) {
+	ignorePath := filepath.Join(srcFileInfo.FullPath, DeployIgnoreFileName)
+	quoted := executor.ShellQuote(ignorePath)
+	ignoreFile, stderr, err := exec.ExecuteShell(fmt.Sprintf("if [ -f %s ]; then cat %s; fi", quoted, quoted))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s on %s (stderr: %s): %w", ignorePath, exec.Name(), strings.TrimSpace(stderr), err)
+	}
+	return newFileFilter(p.include, p.exclude, ignoreFile), nil
+}
+
+func buildPatternsYaml(patterns []string) string {
+	return "[" + strings.Join(util.Map(patterns, func(p string) string { return strconv.Quote(p) }), ", ") + "]"
+}
+
+// Files are listed with find's -printf, which only GNU find (findutils) has.
+func (p *fileProvider) Validate(exec config.Executor) error {
+	if _, stderr, err := exec.ExecuteShell("find / -maxdepth 0 -printf ''"); err != nil {
+		return fmt.Errorf("find on %s does not support -printf; GNU findutils is required (stderr: %s): %w", exec.Name(), strings.TrimSpace(stderr), err)
+	}
+	return nil
 }
 
 // TODO: Combine tmp file usage, both in code & on system
@@ -198,152 +409,556 @@ func (p *fileProvider) Sync(cfg config.SyncConfig) (config.SyncResult, error) {
 		return config.SYNC_RESULT_NOCHANGE, err
 	}
 
-	var dstEntries map[string]*fileEntry
-	if dstFileInfo.Exists {
-		if dstFileInfo.IsDirectory != srcFileInfo.IsDirectory {
-			return config.SYNC_RESULT_NOCHANGE, fmt.Errorf("mismatch in file type")
-		}
-
-		// NB: This should work the same way whether or not the source
-		// is a file or a directory.
-		dstEntries, err = loadFileEntries(dstFileInfo, cfg.DstExecutor, p.recursive)
+	var filter *fileFilter
+	if srcFileInfo.IsDirectory {
+		filter, err = p.loadFilter(cfg.SrcExecutor, srcFileInfo)
 		if err != nil {
 			return config.SYNC_RESULT_NOCHANGE, err
 		}
+	}
 
-	} else {
-		if !dstFileInfo.DirExists && !p.force {
-			return config.SYNC_RESULT_NOCHANGE, fmt.Errorf("target base directory '%s' does not exist; if you want to forcibly create this directory, specify the force attribute", dstFileInfo.FullPath)
+	srcEntries, err := loadFileEntries(srcFileInfo, cfg.SrcExecutor, p.recursive, filter, p.symlinks)
+	if err != nil {
+		return config.SYNC_RESULT_NOCHANGE, err
+	}
+	for _, e := range srcEntries {
+		if e.isSpecial() {
+			return config.SYNC_RESULT_NOCHANGE, fmt.Errorf("cannot deploy %s: only regular files, directories & symlinks are supported (found type '%s')", e.path, e.kind)
 		}
+	}
 
-		dstEntries = make(map[string]*fileEntry)
+	if dstFileInfo.Exists && dstFileInfo.IsDirectory != srcFileInfo.IsDirectory {
+		return config.SYNC_RESULT_NOCHANGE, fmt.Errorf("mismatch in file type")
+	}
+	if !dstFileInfo.Exists && !dstFileInfo.DirExists && !p.force {
+		return config.SYNC_RESULT_NOCHANGE, fmt.Errorf("target base directory '%s' does not exist; if you want to forcibly create this directory, specify the force attribute", dstFileInfo.FullPath)
+	}
+
+	if p.release {
+		return p.syncRelease(cfg, srcFileInfo, srcEntries, dstFileInfo, filter)
 	}
 
-	srcEntries, err := loadFileEntries(srcFileInfo, cfg.SrcExecutor, p.recursive)
+	plan, err := p.planSync(cfg, srcFileInfo, srcEntries, dstFileInfo, filter, p.deleteExtraneous, p.maxDeletes)
 	if err != nil {
 		return config.SYNC_RESULT_NOCHANGE, err
 	}
-
-	entriesToTransfer, changeType := compareFilesForTransfer(srcEntries, dstEntries, srcFileInfo, dstFileInfo)
-
-	if len(entriesToTransfer) == 0 {
+	if plan.result == config.SYNC_RESULT_NOCHANGE {
 		slog.Info("no files to transfer", "name", p.Name(), "src", cfg.SrcExecutor.Name(), "dst", cfg.DstExecutor.Name())
 		return config.SYNC_RESULT_NOCHANGE, nil
 	}
-
 	if cfg.DryRun {
-		slog.Info("DRY RUN: copying files", "name", p.Name(), "src", cfg.SrcExecutor.Name(), "dst", cfg.DstExecutor.Name(), "num-files", len(entriesToTransfer))
-		for _, e := range entriesToTransfer {
-			var dstModifiedAt, dstPath any
-			srcPath, srcModifiedAt := e.Src.path, e.Src.modifiedAt.Format(time.RFC3339)
-			if e.Dst.fileEntry != nil {
-				dstPath, dstModifiedAt = e.Dst.path, e.Dst.fileEntry.modifiedAt.Format(time.RFC3339)
-			} else if !dstFileInfo.DirExists {
-				dstPath, dstModifiedAt = filepath.Join(dstFileInfo.FullPath, e.Src.relativePath), nil
-			} else {
-				dstPath, dstModifiedAt = nil, nil
-			}
-			slog.Info("DRY RUN: copy",
-				"src-path", srcPath, "src-modified-at", srcModifiedAt,
-				"dst-path", dstPath, "dst-modified-at", dstModifiedAt)
+		p.logPlan(cfg, plan)
+		if p.backup {
+			slog.Info("DRY RUN: backing up files", "name", p.Name(), "dst", cfg.DstExecutor.Name(), "num-files", len(previousPaths(plan.changes())))
+		}
+		return plan.result, nil
+	}
+	if changes := plan.changes(); p.backup && len(changes) > 0 {
+		if _, err := createBackup(cfg.DstExecutor, dstFileInfo.FullPath, changes, p.keepBackups); err != nil {
+			return config.SYNC_RESULT_NOCHANGE, err
 		}
+	}
+	if err := p.applyPlan(cfg, srcFileInfo, srcEntries, plan); err != nil {
+		return config.SYNC_RESULT_NOCHANGE, err
+	}
+	return plan.result, nil
+}
+
+// What syncing the source into a destination involves.
+type syncPlan struct {
+	dstFileInfo *fileInfo
+	dstEntries  map[string]*fileEntry
+	// Regular files to copy, directories to create & symlinks to (re)point.
+	// Those whose destination is something else already are replaced.
+	transfer     []*mappedFileEntry
+	dirs         []*mappedFileEntry
+	links        []*mappedFileEntry
+	delete       []*fileEntry
+	driftedFiles []string
+	driftedDirs  []string
+	result       config.SyncResult
+}
 
-		return changeType, nil
+// Works out what has to change on the destination for it to match the source,
+// without changing anything. With mirror, that includes deleting files that
+// aren't in the source, unless there are more than maxDeletes (& it isn't 0).
+func (p *fileProvider) planSync(cfg config.SyncConfig, srcFileInfo *fileInfo, srcEntries map[string]*fileEntry, dstFileInfo *fileInfo, filter *fileFilter, mirror bool, maxDeletes int) (*syncPlan, error) {
+	plan := &syncPlan{dstFileInfo: dstFileInfo, dstEntries: make(map[string]*fileEntry)}
+	if dstFileInfo.Exists {
+		// NB: This should work the same way whether or not the source
+		// is a file or a directory. Symlinks on the destination are only
+		// ever what we put there, so they're never followed.
+		dstSymlinks := p.symlinks
+		if dstSymlinks == FileSymlinksFollow {
+			dstSymlinks = FileSymlinksPreserve
+		}
+		dstEntries, err := loadFileEntries(dstFileInfo, cfg.DstExecutor, p.recursive, filter, dstSymlinks)
+		if err != nil {
+			return nil, err
+		}
+		plan.dstEntries = dstEntries
 	}
 
-	tempFolderPath := util.GetTempFilePath("deploy-assets-file")
-	tempPackageFolderName := "package"
-	tempPackageFolderPath := filepath.Join(tempFolderPath, tempPackageFolderName)
-	if _, _, err := cfg.SrcExecutor.ExecuteCommand("mkdir", "-p", tempPackageFolderPath); err != nil {
-		slog.Error("could not create src temp directory", "dir", tempPackageFolderPath, "err", err)
-		return config.SYNC_RESULT_NOCHANGE, err
+	plan.transfer = compareFilesForTransfer(srcEntries, plan.dstEntries, srcFileInfo, dstFileInfo, p.compare)
+	if p.compare == FileCompareHash {
+		transfer, err := skipIdenticalFiles(cfg, plan.transfer)
+		if err != nil {
+			return nil, err
+		}
+		plan.transfer = transfer
+	}
+	plan.dirs, plan.links = compareDirsAndLinks(srcEntries, plan.dstEntries, dstFileInfo)
+	plan.result = syncResultFor(slices.Concat(plan.transfer, plan.dirs, plan.links))
+	if srcFileInfo.IsDirectory && !dstFileInfo.Exists {
+		plan.result = config.SYNC_RESULT_CREATED
 	}
-	defer cfg.SrcExecutor.ExecuteCommand("rm", "-rf", tempFolderPath)
 
-	srcServerName := cfg.SrcExecutor.Name()
-	dstServerName := cfg.DstExecutor.Name()
+	// Replacing a directory removes everything in it, none of which is in the
+	// source, so it's only done when deleting such files is.
+	replacedFiles, err := plan.replacedDirContents(cfg.DstExecutor)
+	if err != nil {
+		return nil, err
+	}
+	if len(replacedFiles) > 0 && !(mirror && srcFileInfo.IsDirectory) {
+		return nil, fmt.Errorf("refusing to replace directories on %s holding %d files that aren't in the source, e.g. %s (set delete_extraneous to allow it)",
+			cfg.DstExecutor.Name(), len(replacedFiles), replacedFiles[0])
+	}
 
-	slog.Info("syncing files", "name", p.Name(), "src", srcServerName, "dst", dstServerName, "num-files", len(entriesToTransfer))
-	for _, mapped := range entriesToTransfer {
-		src := mapped.Src
-		dir := filepath.Dir(src.relativePath)
-		targetDir := filepath.Join(tempPackageFolderPath, dir)
-		_, _, err := cfg.SrcExecutor.ExecuteCommand("mkdir", "-p", targetDir)
+	if mirror && srcFileInfo.IsDirectory {
+		// Anything under a path that's replaced goes with it.
+		replaced := plan.replaced()
+		plan.delete = slices.DeleteFunc(findExtraneousFiles(srcEntries, plan.dstEntries), func(e *fileEntry) bool {
+			return slices.ContainsFunc(replaced, func(r string) bool { return strings.HasPrefix(e.path, r+"/") })
+		})
+		numFiles := len(slices.DeleteFunc(slices.Clone(plan.delete), func(e *fileEntry) bool { return e.kind == fileKindDir })) + len(replacedFiles)
+		if maxDeletes > 0 && numFiles > maxDeletes {
+			return nil, fmt.Errorf("refusing to delete %d files from %s, more than max_deletes (%d)", numFiles, dstFileInfo.FullPath, maxDeletes)
+		}
+		if plan.result == config.SYNC_RESULT_NOCHANGE && len(plan.delete) > 0 {
+			plan.result = config.SYNC_RESULT_DELETED
+		}
+	}
+
+	if p.attributes.IsSet() {
+		files, dirs := dstManagedPaths(srcEntries, plan.dstEntries, srcFileInfo, dstFileInfo, true)
+		driftedFiles, driftedDirs, err := findAttributeDrift(cfg.DstExecutor, p.attributes, files, dirs)
 		if err != nil {
-			return config.SYNC_RESULT_NOCHANGE, err
+			return nil, err
+		}
+		plan.driftedFiles, plan.driftedDirs = driftedFiles, driftedDirs
+		if (plan.result == config.SYNC_RESULT_NOCHANGE || plan.result == config.SYNC_RESULT_DELETED) && len(driftedFiles)+len(driftedDirs) > 0 {
+			plan.result = config.SYNC_RESULT_UPDATED
+		}
+	}
+	return plan, nil
+}
+
+// Returns the destination paths that are something other than what the source
+// has there, which have to be removed first.
+func (plan *syncPlan) replaced() []string {
+	paths := []string{}
+	for _, e := range slices.Concat(plan.transfer, plan.dirs, plan.links) {
+		if e.Dst.fileEntry != nil && e.Dst.fileEntry.kind != e.Src.kind {
+			paths = append(paths, e.Dst.path)
+		}
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+// Returns everything other than directories under the destination directories
+// that are replaced, which is removed along with them. Those left out of the
+// asset, e.g. by exclude patterns, are never removed, so the sync is refused
+// if there are any.
+func (plan *syncPlan) replacedDirContents(exec config.Executor) ([]string, error) {
+	dirs := []string{}
+	for _, e := range slices.Concat(plan.transfer, plan.links) {
+		if e.Dst.fileEntry != nil && e.Dst.fileEntry.kind == fileKindDir {
+			dirs = append(dirs, e.Dst.path)
+		}
+	}
+	if len(dirs) == 0 {
+		return nil, nil
+	}
+
+	// Listed afresh, since the destination's entries leave out what the asset
+	// does.
+	var stdout strings.Builder
+	if err := executor.Xargs(exec, `sh -c 'find "$@" -mindepth 1 ! -type d -print0' sh`, dirs, &stdout); err != nil {
+		return nil, fmt.Errorf("failed to list the contents of replaced directories: %w", err)
+	}
+	known := make(map[string]bool)
+	for _, e := range plan.dstEntries {
+		known[e.path] = true
+	}
+	paths := []string{}
+	for _, path := range strings.Split(stdout.String(), "\x00") {
+		if path == "" {
+			continue
+		}
+		if !known[path] {
+			return nil, fmt.Errorf("refusing to replace directory on %s holding %s, which is left out of the asset", exec.Name(), path)
+		}
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+	return paths, nil
+}
+
+// What applying the plan does to each destination path, other than changing
+// its owner or mode. Directories that are only created aren't included.
+func (plan *syncPlan) changes() []fileChange {
+	changes := []fileChange{}
+	for _, e := range slices.Concat(plan.transfer, plan.dirs, plan.links) {
+		if e.Dst.fileEntry != nil {
+			changes = append(changes, fileChange{changeUpdated, e.Dst.path})
+		} else if e.Src.kind != fileKindDir {
+			changes = append(changes, fileChange{changeCreated, e.Dst.path})
+		}
+	}
+	for _, e := range plan.delete {
+		if e.kind != fileKindDir {
+			changes = append(changes, fileChange{changeDeleted, e.path})
 		}
+	}
+	return changes
+}
 
-		_, _, err = cfg.SrcExecutor.ExecuteCommand("cp", "-a", src.path, targetDir)
+func (p *fileProvider) logPlan(cfg config.SyncConfig, plan *syncPlan) {
+	dstFileInfo := plan.dstFileInfo
+	for _, path := range plan.replaced() {
+		slog.Info("DRY RUN: replace", "dst-path", path)
+	}
+	for _, e := range plan.dirs {
+		slog.Info("DRY RUN: create directory", "dst-path", e.Dst.path)
+	}
+	slog.Info("DRY RUN: copying files", "name", p.Name(), "src", cfg.SrcExecutor.Name(), "dst", cfg.DstExecutor.Name(), "num-files", len(plan.transfer))
+	for _, e := range plan.transfer {
+		var dstModifiedAt, dstPath any
+		srcPath, srcModifiedAt := e.Src.path, e.Src.modifiedAt.Format(time.RFC3339)
+		if e.Dst.fileEntry != nil {
+			dstPath, dstModifiedAt = e.Dst.path, e.Dst.fileEntry.modifiedAt.Format(time.RFC3339)
+		} else if !dstFileInfo.DirExists {
+			dstPath, dstModifiedAt = filepath.Join(dstFileInfo.FullPath, e.Src.relativePath), nil
+		} else {
+			dstPath, dstModifiedAt = nil, nil
+		}
+		slog.Info("DRY RUN: copy",
+			"src-path", srcPath, "src-modified-at", srcModifiedAt,
+			"dst-path", dstPath, "dst-modified-at", dstModifiedAt)
+	}
+	for _, e := range plan.links {
+		slog.Info("DRY RUN: link", "dst-path", e.Dst.path, "target", e.Src.target)
+	}
+	if len(plan.delete) > 0 {
+		slog.Info("DRY RUN: deleting extraneous files", "name", p.Name(), "dst", cfg.DstExecutor.Name(), "num-files", len(plan.delete))
+		for _, e := range plan.delete {
+			slog.Info("DRY RUN: delete", "dst-path", e.path, "dst-modified-at", e.modifiedAt.Format(time.RFC3339))
+		}
+	}
+	for _, path := range append(plan.driftedFiles, plan.driftedDirs...) {
+		slog.Info("DRY RUN: set owner & mode", "dst-path", path)
+	}
+}
+
+func (p *fileProvider) applyPlan(cfg config.SyncConfig, srcFileInfo *fileInfo, srcEntries map[string]*fileEntry, plan *syncPlan) error {
+	dstFileInfo := plan.dstFileInfo
+	if replaced := plan.replaced(); len(replaced) > 0 {
+		slog.Info("replacing files of another type", "name", p.Name(), "dst", cfg.DstExecutor.Name(), "num-files", len(replaced))
+		if err := executor.Xargs(cfg.DstExecutor, "rm -rf", replaced, nil); err != nil {
+			return err
+		}
+	}
+	if srcFileInfo.IsDirectory && !dstFileInfo.Exists {
+		if _, _, err := cfg.DstExecutor.ExecuteCommand("mkdir", "-p", dstFileInfo.FullPath); err != nil {
+			slog.Error("could not create dst directory", "dst", cfg.DstExecutor.Name(), "dir", dstFileInfo.FullPath, "err", err)
+			return err
+		}
+		dstFileInfo = &fileInfo{FullPath: dstFileInfo.FullPath, DirPath: dstFileInfo.DirPath, IsDirectory: true, Exists: true, DirExists: true}
+	}
+	if len(plan.dirs) > 0 {
+		if err := executor.Xargs(cfg.DstExecutor, "mkdir -p", util.Map(plan.dirs, func(e *mappedFileEntry) string { return e.Dst.path }), nil); err != nil {
+			return err
+		}
+	}
+	if len(plan.transfer) > 0 {
+		if err := p.transferFiles(cfg, srcFileInfo, dstFileInfo, plan.transfer); err != nil {
+			return err
+		}
+	}
+	if len(plan.links) > 0 {
+		if err := createLinks(cfg.DstExecutor, plan.links); err != nil {
+			return err
+		}
+	}
+	// Only once everything else is in place, so that a failed transfer doesn't
+	// leave the destination with neither the old files nor the new.
+	if len(plan.delete) > 0 {
+		slog.Info("deleting extraneous files", "name", p.Name(), "dst", cfg.DstExecutor.Name(), "num-files", len(plan.delete))
+		if err := deleteFiles(cfg.DstExecutor, plan.delete); err != nil {
+			return err
+		}
+	}
+	if p.attributes.IsSet() {
+		files, dirs := dstManagedPaths(srcEntries, plan.dstEntries, srcFileInfo, dstFileInfo, false)
+		driftedFiles, driftedDirs, err := findAttributeDrift(cfg.DstExecutor, p.attributes, files, dirs)
 		if err != nil {
-			return config.SYNC_RESULT_NOCHANGE, err
+			return err
+		}
+		if err := applyAttributes(cfg.DstExecutor, p.attributes, driftedFiles, driftedDirs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Copies each staged file (the first of each pair) to its destination path
+// (the second) by way of a temporary file beside it, which is renamed over the
+// path, so that a file is never there half-copied. Owners & modes are copied
+// along with the contents.
+func placeFiles(exec config.Executor, files [][2]string) error {
+	args := []string{}
+	for _, f := range files {
+		args = append(args, f[0], tempPathFor(f[1]), f[1])
+	}
+	stdin := strings.NewReader(strings.Join(args, "\x00") + "\x00")
+	cmd := `xargs -0 -n 3 sh -c 'cp -a "$0" "$1" && mv -f "$1" "$2" || { rm -f "$1"; exit 255; }'`
+	if stderr, err := exec.ExecuteShellStreaming(cmd, stdin, nil); err != nil {
+		return fmt.Errorf("failed to put files in place on %s (stderr: %s): %w", exec.Name(), strings.TrimSpace(stderr), err)
+	}
+	return nil
+}
+
+// Where a file is written before being renamed over path: beside it, since
+// renames are only atomic within a filesystem.
+func tempPathFor(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".deploy-assets-tmp")
+}
+
+// Points each symlink at its source's target, replacing any already there. The
+// targets & paths are handed to ln in pairs on stdin.
+func createLinks(exec config.Executor, links []*mappedFileEntry) error {
+	args := []string{}
+	for _, e := range links {
+		slog.Debug("creating symlink", "server", exec.Name(), "path", e.Dst.path, "target", e.Src.target)
+		args = append(args, e.Src.target, e.Dst.path)
+	}
+	stdin := strings.NewReader(strings.Join(args, "\x00") + "\x00")
+	if stderr, err := exec.ExecuteShellStreaming("xargs -0 -n 2 ln -sfn --", stdin, nil); err != nil {
+		return fmt.Errorf("failed to create symlinks on %s (stderr: %s): %w", exec.Name(), strings.TrimSpace(stderr), err)
+	}
+	return nil
+}
+
+// Returns the destination paths of the source's files & of the directories
+// they're in, from dst_path down (for a directory), sorted. Symlinks are left
+// alone, since changing their owner or mode would change their targets'. With
+// existing, only those already on the destination before the sync are
+// returned.
+func dstManagedPaths(srcEntries, dstEntries map[string]*fileEntry, srcFileInfo, dstFileInfo *fileInfo, existing bool) ([]string, []string) {
+	files, dirs := []string{}, util.NewSet[string]()
+	if existing && !dstFileInfo.Exists {
+		return files, nil
+	}
+	if !srcFileInfo.IsDirectory {
+		return []string{dstFileInfo.FullPath}, nil
+	}
+
+	root := dstFileInfo.FullPath
+	dirs.Add(root)
+	for rel, srce := range srcEntries {
+		if dste, prs := dstEntries[rel]; existing && (!prs || dste.kind != srce.kind) {
+			continue
+		}
+		path := filepath.Join(root, rel)
+		switch srce.kind {
+		case fileKindFile:
+			files = append(files, path)
+		case fileKindDir:
+			dirs.Add(path)
+		}
+		for dir := filepath.Dir(path); strings.HasPrefix(dir, root+"/"); dir = filepath.Dir(dir) {
+			dirs.Add(dir)
+		}
+	}
+	dirPaths := dirs.AsSlice()
+	slices.Sort(files)
+	slices.Sort(dirPaths)
+	return files, dirPaths
+}
+
+// Transfers the files, straight to their destination paths if the transport
+// can, otherwise in a package that's unpacked over the destination.
+func (p *fileProvider) transferFiles(cfg config.SyncConfig, srcFileInfo, dstFileInfo *fileInfo, entriesToTransfer []*mappedFileEntry) error {
+	// Transports copy symlinks as they are, so followed ones have to be
+	// packaged up, as do files that need owners or modes set before they're
+	// put in place.
+	if directTransport, ok := cfg.Transport.(config.DirectTransport); ok && p.symlinks != FileSymlinksFollow && !p.attributes.IsSet() {
+		err := p.syncDirect(cfg, directTransport, srcFileInfo, dstFileInfo, entriesToTransfer)
+		if err == nil {
+			return nil
+		} else if !errors.Is(err, errors.ErrUnsupported) {
+			return err
 		}
+		slog.Info("transport cannot copy files directly between locations; packaging them instead",
+			"name", p.Name(), "src", cfg.SrcExecutor.Name(), "dst", cfg.DstExecutor.Name(), "reason", err)
 	}
 
+	dstStagingDir, err := cfg.DstExecutor.StagingDir()
+	if err != nil {
+		return err
+	}
+
+	srcServerName := cfg.SrcExecutor.Name()
+	dstServerName := cfg.DstExecutor.Name()
+
+	tempFolderName := util.GetRandomFileName("file")
+	dstTempFolderPath := filepath.Join(dstStagingDir, tempFolderName)
+	tempPackageFolderName := "package"
+	dstTempPackageFolderPath := filepath.Join(dstTempFolderPath, tempPackageFolderName)
 	tempPackageName := tempPackageFolderName + ".tar"
-	tempPackagePath := filepath.Join(tempFolderPath, tempPackageName)
-	if _, _, err := cfg.SrcExecutor.ExecuteCommand("tar", "cvf", tempPackagePath, "-C", tempFolderPath, tempPackageFolderName); err != nil {
-		return config.SYNC_RESULT_NOCHANGE, err
+	dstTempPackagePath := filepath.Join(dstTempFolderPath, tempPackageName)
+	dstCompressedPackagePath := dstTempPackagePath + ".gz"
+
+	artifacts, closeArtifacts := artifactCache(cfg)
+	defer closeArtifacts()
+
+	slog.Info("syncing files", "name", p.Name(), "src", srcServerName, "dst", dstServerName, "num-files", len(entriesToTransfer))
+	srcCompressedPackagePath, err := artifacts.Get(cfg.SrcExecutor, packageArtifactID(srcFileInfo, entriesToTransfer), tempPackageName+".gz", func(path string) error {
+		srcTempFolderPath := filepath.Dir(path)
+		srcTempPackageFolderPath := filepath.Join(srcTempFolderPath, tempPackageFolderName)
+		for _, mapped := range entriesToTransfer {
+			src := mapped.Src
+			dir := filepath.Dir(src.relativePath)
+			targetDir := filepath.Join(srcTempPackageFolderPath, dir)
+			if _, _, err := cfg.SrcExecutor.ExecuteCommand("mkdir", "-p", targetDir); err != nil {
+				return err
+			}
+			// -L has to come after -a, which implies -P.
+			cpArgs := []string{"-a"}
+			if p.symlinks == FileSymlinksFollow {
+				cpArgs = append(cpArgs, "-L")
+			}
+			if _, _, err := cfg.SrcExecutor.ExecuteCommand("cp", append(cpArgs, src.path, targetDir)...); err != nil {
+				return err
+			}
+		}
+
+		srcTempPackagePath := filepath.Join(srcTempFolderPath, tempPackageName)
+		if _, _, err := cfg.SrcExecutor.ExecuteCommand("tar", "cvf", srcTempPackagePath, "-C", srcTempFolderPath, tempPackageFolderName); err != nil {
+			return err
+		}
+		_, _, err := cfg.SrcExecutor.ExecuteCommand("gzip", srcTempPackagePath)
+		return err
+	})
+	if err != nil {
+		return err
 	}
 
-	if _, _, err := cfg.SrcExecutor.ExecuteCommand("gzip", tempPackagePath); err != nil {
-		return config.SYNC_RESULT_NOCHANGE, err
+	if _, _, err := cfg.DstExecutor.ExecuteCommand("mkdir", "-p", dstTempFolderPath); err != nil {
+		slog.Error("could not create dst temp directory", "dst", dstServerName, "dir", dstTempFolderPath, "err", err)
+		return err
 	}
-	compressedPackagePath := tempPackagePath + ".gz"
+	defer cfg.DstExecutor.ExecuteCommand("rm", "-rf", dstTempFolderPath)
 
-	if _, _, err := cfg.DstExecutor.ExecuteCommand("mkdir", "-p", tempFolderPath); err != nil {
-		slog.Error("could not create dst temp directory", "dst", dstServerName, "dir", tempFolderPath, "err", err)
-		return config.SYNC_RESULT_NOCHANGE, err
+	if err := artifacts.Transfer(cfg.Transport, cfg.SrcExecutor, srcCompressedPackagePath, cfg.DstExecutor, dstCompressedPackagePath); err != nil {
+		return err
 	}
-	defer cfg.DstExecutor.ExecuteCommand("rm", "-rf", tempFolderPath)
 
-	if err := cfg.Transport.TransferFile(cfg.SrcExecutor, compressedPackagePath, cfg.DstExecutor, compressedPackagePath); err != nil {
-		return config.SYNC_RESULT_NOCHANGE, err
+	if _, _, err := cfg.DstExecutor.ExecuteCommand("gunzip", dstCompressedPackagePath); err != nil {
+		return err
 	}
 
-	if _, _, err := cfg.DstExecutor.ExecuteCommand("gunzip", compressedPackagePath); err != nil {
-		return config.SYNC_RESULT_NOCHANGE, err
+	if _, _, err := cfg.DstExecutor.ExecuteCommand("tar", "xvf", dstTempPackagePath, "-C", dstTempFolderPath); err != nil {
+		return err
 	}
 
-	if _, _, err := cfg.DstExecutor.ExecuteCommand("tar", "xvf", tempPackagePath, "-C", tempFolderPath); err != nil {
-		return config.SYNC_RESULT_NOCHANGE, err
+	// Each file is in the package where it was put on the source, under the
+	// directory of its path relative to the source.
+	staged := [][2]string{}
+	for _, mapped := range entriesToTransfer {
+		stagedPath := filepath.Join(dstTempPackageFolderPath, filepath.Dir(mapped.Src.relativePath), filepath.Base(mapped.Src.path))
+		staged = append(staged, [2]string{stagedPath, mapped.Dst.path})
 	}
 
-	// If dir & dst doesn't exist: 	package 	-> dst
-	// If dir & dst exists: 		package/* 	-> dst	(otherwise if dst exists then we would get dst/package)
-	// If file:						package/src	-> dst
+	if !dstFileInfo.DirExists {
+		if _, _, err := cfg.DstExecutor.ExecuteCommand("mkdir", "-p", dstFileInfo.DirPath); err != nil {
+			slog.Error("could not create dst parent directory", "dst", dstServerName, "dir", dstFileInfo.DirPath, "err", err)
+			return err
+		}
+	}
 
-	var srcCopyPath string
-	if srcFileInfo.IsDirectory && dstFileInfo.Exists {
-		srcCopyPath = filepath.Join(tempPackageFolderPath, "*")
-	} else if srcFileInfo.IsDirectory {
-		srcCopyPath = tempPackageFolderPath
-	} else {
-		srcName := filepath.Base(p.srcPath)
-		srcCopyPath = filepath.Join(tempPackageFolderPath, srcName)
+	// Given their owners & modes while they're still staged, so that they
+	// already have them when they're put in place.
+	if p.attributes.IsSet() {
+		if err := applyAttributes(cfg.DstExecutor, p.attributes, util.Map(staged, func(f [2]string) string { return f[0] }), nil); err != nil {
+			return err
+		}
+	}
+	return placeFiles(cfg.DstExecutor, staged)
+}
+
+// Identifies a package of the given entries, so that destinations needing the
+// same files from the same source share one package.
+func packageArtifactID(srcFileInfo *fileInfo, entries []*mappedFileEntry) string {
+	parts := []string{"file:" + srcFileInfo.FullPath}
+	for _, e := range entries {
+		parts = append(parts, fmt.Sprintf("%s@%d", e.Src.relativePath, e.Src.modifiedAt.UnixNano()))
 	}
+	slices.Sort(parts[1:])
+	return strings.Join(parts, "\n")
+}
 
+// Copies changed files straight to their destination paths, rather than
+// packaging them up, so that the transport only has to send what differs.
+func (p *fileProvider) syncDirect(cfg config.SyncConfig, transport config.DirectTransport, srcFileInfo, dstFileInfo *fileInfo, entries []*mappedFileEntry) error {
+	dstServerName := cfg.DstExecutor.Name()
 	if !dstFileInfo.DirExists {
 		if _, _, err := cfg.DstExecutor.ExecuteCommand("mkdir", "-p", dstFileInfo.DirPath); err != nil {
 			slog.Error("could not create dst parent directory", "dst", dstServerName, "dir", dstFileInfo.DirPath, "err", err)
-			return config.SYNC_RESULT_NOCHANGE, err
+			return err
 		}
 	}
 
-	if _, _, err := cfg.DstExecutor.ExecuteShell(fmt.Sprintf("cp -ar %s %s", srcCopyPath, p.dstPath)); err != nil {
-		return config.SYNC_RESULT_NOCHANGE, err
+	slog.Info("syncing files", "name", p.Name(), "src", cfg.SrcExecutor.Name(), "dst", dstServerName, "num-files", len(entries), "direct", true)
+	if !srcFileInfo.IsDirectory {
+		return transport.TransferFile(cfg.SrcExecutor, srcFileInfo.FullPath, cfg.DstExecutor, dstFileInfo.FullPath)
 	}
 
-	return changeType, nil
+	if _, _, err := cfg.DstExecutor.ExecuteCommand("mkdir", "-p", dstFileInfo.FullPath); err != nil {
+		slog.Error("could not create dst directory", "dst", dstServerName, "dir", dstFileInfo.FullPath, "err", err)
+		return err
+	}
+	relativePaths := util.Map(entries, func(e *mappedFileEntry) string { return e.Src.relativePath })
+	return transport.SyncFiles(cfg.SrcExecutor, srcFileInfo.FullPath, cfg.DstExecutor, dstFileInfo.FullPath, relativePaths)
 }
 
-func compareFilesForTransfer(src, dst map[string]*fileEntry, srcFileInfo, dstFileInfo *fileInfo) ([]*mappedFileEntry, config.SyncResult) {
+// Returns the source files to transfer & where to. Files that exist on both
+// locations are transferred if they differ according to compare; in hash mode,
+// that's every one of the same size, to be checked by skipIdenticalFiles. Those
+// where the destination has something other than a regular file always are.
+func compareFilesForTransfer(src, dst map[string]*fileEntry, srcFileInfo, dstFileInfo *fileInfo, compare string) []*mappedFileEntry {
+	differs := func(srce, dste *fileEntry) bool {
+		switch {
+		case dste.kind != fileKindFile:
+			return true
+		case compare == FileCompareSizeMtime:
+			return srce.size != dste.size || srce.modifiedAt != dste.modifiedAt
+		case compare == FileCompareHash:
+			return true
+		default:
+			return srce.modifiedAt != dste.modifiedAt
+		}
+	}
+
 	entries := []*mappedFileEntry{}
-	changeType := config.SYNC_RESULT_NOCHANGE
 	if srcFileInfo.IsDirectory {
 		for k, srce := range src {
+			if srce.kind != fileKindFile {
+				continue
+			}
 			dste, existse := dst[k]
 			if !existse {
-				changeType = config.SYNC_RESULT_CREATED
 				dstTargetPath := filepath.Join(dstFileInfo.FullPath, srce.relativePath)
 				entries = append(entries, &mappedFileEntry{
 					Src: srce,
@@ -352,11 +967,7 @@ func compareFilesForTransfer(src, dst map[string]*fileEntry, srcFileInfo, dstFil
 						fileEntry: nil,
 					},
 				})
-				// } else if srce.modifiedAt.After(dste.modifiedAt) {
-			} else if srce.modifiedAt != dste.modifiedAt {
-				if changeType == config.SYNC_RESULT_NOCHANGE {
-					changeType = config.SYNC_RESULT_UPDATED
-				}
+			} else if differs(srce, dste) {
 				entries = append(entries, &mappedFileEntry{
 					Src: srce,
 					Dst: &targetFileEntry{
@@ -374,7 +985,6 @@ func compareFilesForTransfer(src, dst map[string]*fileEntry, srcFileInfo, dstFil
 		dste, existse := dst[dstk]
 		srce := util.Values(src)[0]
 		if !existse {
-			changeType = config.SYNC_RESULT_CREATED
 			entries = append(entries, &mappedFileEntry{
 				Src: srce,
 				Dst: &targetFileEntry{
@@ -382,10 +992,7 @@ func compareFilesForTransfer(src, dst map[string]*fileEntry, srcFileInfo, dstFil
 					fileEntry: nil,
 				},
 			})
-		} else if srce.modifiedAt != dste.modifiedAt {
-			if changeType != config.SYNC_RESULT_NOCHANGE {
-				changeType = config.SYNC_RESULT_UPDATED
-			}
+		} else if differs(srce, dste) {
 			entries = append(entries, &mappedFileEntry{
 				Src: srce,
 				Dst: &targetFileEntry{
@@ -396,5 +1003,128 @@ func compareFilesForTransfer(src, dst map[string]*fileEntry, srcFileInfo, dstFil
 		}
 	}
 
-	return entries, changeType
+	return entries
+}
+
+// Returns the source directories missing from the destination & the symlinks
+// that are missing or point elsewhere, sorted by path.
+func compareDirsAndLinks(src, dst map[string]*fileEntry, dstFileInfo *fileInfo) ([]*mappedFileEntry, []*mappedFileEntry) {
+	dirs, links := []*mappedFileEntry{}, []*mappedFileEntry{}
+	for k, srce := range src {
+		if srce.kind != fileKindDir && srce.kind != fileKindLink {
+			continue
+		}
+		dste, existse := dst[k]
+		if existse && dste.kind == srce.kind && (srce.kind == fileKindDir || dste.target == srce.target) {
+			continue
+		}
+		mapped := &mappedFileEntry{Src: srce, Dst: &targetFileEntry{path: filepath.Join(dstFileInfo.FullPath, srce.relativePath)}}
+		if existse {
+			mapped.Dst = &targetFileEntry{path: dste.path, fileEntry: dste}
+		}
+		if srce.kind == fileKindDir {
+			dirs = append(dirs, mapped)
+		} else {
+			links = append(links, mapped)
+		}
+	}
+	byPath := func(a, b *mappedFileEntry) int { return strings.Compare(a.Dst.path, b.Dst.path) }
+	slices.SortFunc(dirs, byPath)
+	slices.SortFunc(links, byPath)
+	return dirs, links
+}
+
+// Created if any of the files is new to the destination, otherwise updated if
+// there are any at all.
+func syncResultFor(entries []*mappedFileEntry) config.SyncResult {
+	changeType := config.SYNC_RESULT_NOCHANGE
+	for _, e := range entries {
+		if e.Dst.fileEntry == nil {
+			return config.SYNC_RESULT_CREATED
+		}
+		changeType = config.SYNC_RESULT_UPDATED
+	}
+	return changeType
+}
+
+// Drops the files whose contents are the same on both locations, hashing those
+// of the same size on each location in one go.
+func skipIdenticalFiles(cfg config.SyncConfig, entries []*mappedFileEntry) ([]*mappedFileEntry, error) {
+	srcPaths, dstPaths := []string{}, []string{}
+	for _, e := range entries {
+		if e.Dst.fileEntry != nil && e.Dst.fileEntry.kind == fileKindFile && e.Dst.fileEntry.size == e.Src.size {
+			srcPaths = append(srcPaths, e.Src.path)
+			dstPaths = append(dstPaths, e.Dst.path)
+		}
+	}
+	if len(srcPaths) == 0 {
+		return entries, nil
+	}
+
+	srcSums, err := executor.SHA256Sums(cfg.SrcExecutor, "", srcPaths)
+	if err != nil {
+		return nil, err
+	}
+	dstSums, err := executor.SHA256Sums(cfg.DstExecutor, "", dstPaths)
+	if err != nil {
+		return nil, err
+	}
+
+	changed := []*mappedFileEntry{}
+	for _, e := range entries {
+		if e.Dst.fileEntry != nil && e.Dst.fileEntry.kind == fileKindFile {
+			srcSum, srcPrs := srcSums[e.Src.path]
+			dstSum, dstPrs := dstSums[e.Dst.path]
+			if srcPrs && dstPrs && srcSum == dstSum {
+				slog.Debug("skipping identical file", "src-path", e.Src.path, "dst-path", e.Dst.path, "sha256", srcSum)
+				continue
+			}
+		}
+		changed = append(changed, e)
+	}
+	return changed, nil
+}
+
+// Returns the destination files, symlinks & directories that aren't in the
+// source. Anything else there (e.g. a socket) isn't ours to delete.
+func findExtraneousFiles(src, dst map[string]*fileEntry) []*fileEntry {
+	extraneous := []*fileEntry{}
+	for k, dste := range dst {
+		if _, prs := src[k]; !prs && !dste.isSpecial() {
+			extraneous = append(extraneous, dste)
+		}
+	}
+	slices.SortFunc(extraneous, func(a, b *fileEntry) int { return strings.Compare(a.path, b.path) })
+	return extraneous
+}
+
+// Removes the files & symlinks from the location, & then the directories, if
+// they're empty by then.
+func deleteFiles(exec config.Executor, entries []*fileEntry) error {
+	paths, dirPaths := []string{}, []string{}
+	for _, e := range entries {
+		slog.Debug("deleting extraneous file", "server", exec.Name(), "path", e.path)
+		if e.kind == fileKindDir {
+			dirPaths = append(dirPaths, e.path)
+		} else {
+			paths = append(paths, e.path)
+		}
+	}
+	if len(paths) > 0 {
+		if err := executor.Xargs(exec, "rm -f", paths, nil); err != nil {
+			return fmt.Errorf("failed to delete extraneous files: %w", err)
+		}
+	}
+	if len(dirPaths) == 0 {
+		return nil
+	}
+
+	// Deepest first, so that directories only left empty by removing their
+	// subdirectories are removed too. Those with filtered-out files in them
+	// are kept.
+	slices.SortFunc(dirPaths, func(a, b string) int { return strings.Count(b, "/") - strings.Count(a, "/") })
+	if err := executor.Xargs(exec, "rmdir --ignore-fail-on-non-empty", dirPaths, nil); err != nil {
+		return fmt.Errorf("failed to remove empty directories: %w", err)
+	}
+	return nil
 }
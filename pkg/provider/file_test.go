@@ -1,12 +1,16 @@
 package provider
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"reflect"
 	"runtime"
+	"sort"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
 
@@ -46,7 +50,7 @@ const (
 )
 
 func TestFileYamlDefault(t *testing.T) {
-	p := NewFileProvider("foobar", "/flim/flam", "boop/bap.txt", "blap/", true, false)
+	p := NewFileProvider("foobar", "/flim/flam", "boop/bap.txt", "blap/", FileProviderOptions{Recursive: true, KeepReleases: 5, KeepBackups: 5})
 	expected :=
 		`file:
     name: foobar
@@ -54,7 +58,21 @@ func TestFileYamlDefault(t *testing.T) {
     src_path: boop/bap.txt
     dst_path: blap/
     recursive: true
-    force: false`
+    force: false
+    compare: mtime
+    delete_extraneous: false
+    max_deletes: 0
+    include: []
+    exclude: []
+    owner: 
+    group: 
+    file_mode: 
+    dir_mode: 
+    release: false
+    keep_releases: 5
+    backup: false
+    keep_backups: 5
+    symlinks: preserve`
 	actual := p.Yaml(0)
 	if expected != actual {
 		t.Errorf("yaml contents not equal:\nexpected:\n=======\n%s\n=======\ngot:\n=======\n%s\n=======", expected, actual)
@@ -62,7 +80,7 @@ func TestFileYamlDefault(t *testing.T) {
 }
 
 func TestFileYamlDeep(t *testing.T) {
-	p := NewFileProvider("foobar", "/flim/flam", "boop/bap.txt", "blap/", true, false)
+	p := NewFileProvider("foobar", "/flim/flam", "boop/bap.txt", "blap/", FileProviderOptions{Recursive: true, KeepReleases: 5, KeepBackups: 5})
 	expected :=
 		`        file:
             name: foobar
@@ -70,7 +88,21 @@ func TestFileYamlDeep(t *testing.T) {
             src_path: boop/bap.txt
             dst_path: blap/
             recursive: true
-            force: false`
+            force: false
+            compare: mtime
+            delete_extraneous: false
+            max_deletes: 0
+            include: []
+            exclude: []
+            owner: 
+            group: 
+            file_mode: 
+            dir_mode: 
+            release: false
+            keep_releases: 5
+            backup: false
+            keep_backups: 5
+            symlinks: preserve`
 	actual := p.Yaml(util.TabsToIndent(2))
 	if expected != actual {
 		t.Errorf("yaml contents not equal:\nexpected:\n=======\n%s\n=======\ngot:\n=======\n%s\n=======", expected, actual)
@@ -319,8 +351,10 @@ func executeTest(test testDef, t *testing.T) {
 	}
 	defer os.RemoveAll(testRunDir)
 
-	srcExecutor, srcRootPath := executor.NewLocalExecutor("src"), filepath.Join(testRunDir, "src")
-	dstExecutor, dstRootPath := executor.NewLocalExecutor("dst"), filepath.Join(testRunDir, "dst")
+	srcExecutor, srcRootPath := executor.NewLocalExecutor("src", testRunDir, 0), filepath.Join(testRunDir, "src")
+	dstExecutor, dstRootPath := executor.NewLocalExecutor("dst", testRunDir, 0), filepath.Join(testRunDir, "dst")
+	defer srcExecutor.Close()
+	defer dstExecutor.Close()
 
 	for _, d := range []string{srcRootPath, dstRootPath} {
 		if err := os.MkdirAll(d, 0700); err != nil {
@@ -344,7 +378,7 @@ func executeTest(test testDef, t *testing.T) {
 	dstFile := filepath.Join(dstRootPath, test.dstRelativePath)
 
 	// TODO: Look at how we parameterize these guys. This is a little awkward.
-	sut := NewFileProvider("test", "", srcFile, dstFile, test.recursive, false)
+	sut := NewFileProvider("test", "", srcFile, dstFile, FileProviderOptions{Recursive: test.recursive})
 	config := config.SyncConfig{
 		SrcExecutor: srcExecutor,
 		DstExecutor: dstExecutor,
@@ -463,3 +497,617 @@ func readDirR(path string) (*dirEntry, error) {
 	}
 	return root, nil
 }
+
+// Regression test against captured command output; see newFixtureExecutors.
+func TestFileSyncFixture(t *testing.T) {
+	rootPath := filepath.Join(fixtureStagingRoot, "file-sync")
+	srcRootPath, dstRootPath := filepath.Join(rootPath, "src"), filepath.Join(rootPath, "dst")
+	if *recordFixtures {
+		os.RemoveAll(rootPath)
+		defer os.RemoveAll(rootPath)
+		for _, e := range []fileDef{
+			{"app/unchanged.conf", EARLY_MOD_TIME, "same"},
+			{"app/changed.conf", LATER_MOD_TIME, "new"},
+			{"app/nested/created.conf", EARLY_MOD_TIME, "created"},
+		} {
+			if err := createTestFile(srcRootPath, e); err != nil {
+				t.Fatalf("failed to create src test file: %v", err)
+			}
+		}
+		for _, e := range []fileDef{
+			{"app/unchanged.conf", EARLY_MOD_TIME, "same"},
+			{"app/changed.conf", EARLY_MOD_TIME, "old"},
+		} {
+			if err := createTestFile(dstRootPath, e); err != nil {
+				t.Fatalf("failed to create dst test file: %v", err)
+			}
+		}
+	}
+
+	executors := newFixtureExecutors(t, "file-sync", "src", "dst")
+	sut := NewFileProvider("test", "", filepath.Join(srcRootPath, "app"), filepath.Join(dstRootPath, "app"), FileProviderOptions{Recursive: true})
+	result, err := sut.Sync(config.SyncConfig{
+		SrcExecutor: executors[0],
+		DstExecutor: executors[1],
+		Transport:   &fixtureTransport{},
+		DryRun:      false,
+	})
+	if err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	if result != config.SYNC_RESULT_CREATED {
+		t.Errorf("expected result %v, got %v", config.SYNC_RESULT_CREATED, result)
+	}
+}
+
+// Copies files with cp, recording what it was asked to sync, or claims it
+// can't if unsupported is set.
+type directTestTransport struct {
+	config.Transport
+	unsupported bool
+	synced      []string
+}
+
+func (t *directTestTransport) SyncFiles(src config.Executor, srcDir string, dst config.Executor, dstDir string, relativePaths []string) error {
+	if t.unsupported {
+		return fmt.Errorf("not between these locations: %w", errors.ErrUnsupported)
+	}
+	for _, p := range relativePaths {
+		t.synced = append(t.synced, p)
+		dstPath := filepath.Join(dstDir, p)
+		if _, _, err := dst.ExecuteCommand("mkdir", "-p", filepath.Dir(dstPath)); err != nil {
+			return err
+		}
+		if _, _, err := dst.ExecuteCommand("cp", "-a", filepath.Join(srcDir, p), dstPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestFileSyncDirect(t *testing.T) {
+	for _, unsupported := range []bool{false, true} {
+		t.Run(fmt.Sprintf("unsupported=%t", unsupported), func(s *testing.T) {
+			rootPath := s.TempDir()
+			srcRootPath, dstRootPath := filepath.Join(rootPath, "src"), filepath.Join(rootPath, "dst")
+			for _, e := range []fileDef{
+				{"app/unchanged.conf", EARLY_MOD_TIME, "same"},
+				{"app/changed.conf", LATER_MOD_TIME, "new"},
+				{"app/nested/created.conf", EARLY_MOD_TIME, "created"},
+			} {
+				if err := createTestFile(srcRootPath, e); err != nil {
+					s.Fatalf("failed to create src test file: %v", err)
+				}
+			}
+			for _, e := range []fileDef{
+				{"app/unchanged.conf", EARLY_MOD_TIME, "same"},
+				{"app/changed.conf", EARLY_MOD_TIME, "old"},
+			} {
+				if err := createTestFile(dstRootPath, e); err != nil {
+					s.Fatalf("failed to create dst test file: %v", err)
+				}
+			}
+
+			srcExecutor := executor.NewLocalExecutor("src", s.TempDir(), 0)
+			dstExecutor := executor.NewLocalExecutor("dst", s.TempDir(), 0)
+			defer srcExecutor.Close()
+			defer dstExecutor.Close()
+			direct := &directTestTransport{Transport: transport.NewLocalTransport(), unsupported: unsupported}
+			sut := NewFileProvider("test", "", filepath.Join(srcRootPath, "app"), filepath.Join(dstRootPath, "app"), FileProviderOptions{Recursive: true})
+			result, err := sut.Sync(config.SyncConfig{
+				SrcExecutor: srcExecutor,
+				DstExecutor: dstExecutor,
+				Transport:   direct,
+				DryRun:      false,
+			})
+			if err != nil {
+				s.Fatalf("sync failed: %v", err)
+			}
+			if result != config.SYNC_RESULT_CREATED {
+				s.Errorf("expected result %v, got %v", config.SYNC_RESULT_CREATED, result)
+			}
+
+			for name, expected := range map[string]string{"unchanged.conf": "same", "changed.conf": "new", "nested/created.conf": "created"} {
+				actual, err := os.ReadFile(filepath.Join(dstRootPath, "app", name))
+				if err != nil || string(actual) != expected {
+					s.Errorf("expected %s to contain %q, got %q (err: %v)", name, expected, string(actual), err)
+				}
+			}
+			sort.Strings(direct.synced)
+			if unsupported && len(direct.synced) != 0 {
+				s.Errorf("expected nothing to be synced directly, got %v", direct.synced)
+			} else if !unsupported && !reflect.DeepEqual([]string{"changed.conf", "nested/created.conf"}, direct.synced) {
+				s.Errorf("expected only changed files to be synced directly, got %v", direct.synced)
+			}
+		})
+	}
+}
+
+func TestFileSyncCompare(t *testing.T) {
+	var tests = []struct {
+		compare  string
+		expected []string
+	}{
+		{FileCompareMtime, []string{"created.conf", "touched.conf"}},
+		{FileCompareSizeMtime, []string{"created.conf", "grown.conf", "touched.conf"}},
+		{FileCompareHash, []string{"created.conf", "edited.conf", "grown.conf"}},
+	}
+	for _, test := range tests {
+		t.Run(test.compare, func(s *testing.T) {
+			rootPath := s.TempDir()
+			srcRootPath, dstRootPath := filepath.Join(rootPath, "src"), filepath.Join(rootPath, "dst")
+			for _, e := range []fileDef{
+				{"app/touched.conf", LATER_MOD_TIME, "same"},
+				{"app/edited.conf", EARLY_MOD_TIME, "new"},
+				{"app/grown.conf", EARLY_MOD_TIME, "longer"},
+				{"app/created.conf", EARLY_MOD_TIME, "created"},
+			} {
+				if err := createTestFile(srcRootPath, e); err != nil {
+					s.Fatalf("failed to create src test file: %v", err)
+				}
+			}
+			for _, e := range []fileDef{
+				{"app/touched.conf", EARLY_MOD_TIME, "same"},
+				{"app/edited.conf", EARLY_MOD_TIME, "old"},
+				{"app/grown.conf", EARLY_MOD_TIME, "short"},
+			} {
+				if err := createTestFile(dstRootPath, e); err != nil {
+					s.Fatalf("failed to create dst test file: %v", err)
+				}
+			}
+
+			srcExecutor := executor.NewLocalExecutor("src", s.TempDir(), 0)
+			dstExecutor := executor.NewLocalExecutor("dst", s.TempDir(), 0)
+			defer srcExecutor.Close()
+			defer dstExecutor.Close()
+			direct := &directTestTransport{Transport: transport.NewLocalTransport()}
+			sut := NewFileProvider("test", "", filepath.Join(srcRootPath, "app"), filepath.Join(dstRootPath, "app"), FileProviderOptions{Recursive: true, Compare: test.compare})
+			result, err := sut.Sync(config.SyncConfig{
+				SrcExecutor: srcExecutor,
+				DstExecutor: dstExecutor,
+				Transport:   direct,
+				DryRun:      false,
+			})
+			if err != nil {
+				s.Fatalf("sync failed: %v", err)
+			}
+			if result != config.SYNC_RESULT_CREATED {
+				s.Errorf("expected result %v, got %v", config.SYNC_RESULT_CREATED, result)
+			}
+			sort.Strings(direct.synced)
+			if !reflect.DeepEqual(test.expected, direct.synced) {
+				s.Errorf("expected %v to be synced, got %v", test.expected, direct.synced)
+			}
+		})
+	}
+}
+
+func TestFileSyncDeleteExtraneous(t *testing.T) {
+	var tests = []struct {
+		name       string
+		src        []fileDef
+		maxDeletes int
+		dryRun     bool
+		result     config.SyncResult
+		errMsg     string
+		remaining  []string
+	}{
+		{
+			"deletes files & emptied directories",
+			[]fileDef{{"app/kept.conf", EARLY_MOD_TIME, "kept"}},
+			0, false, config.SYNC_RESULT_DELETED, "",
+			[]string{"app/kept.conf", "app/other/kept.conf"},
+		},
+		{
+			"created wins over deleted",
+			[]fileDef{{"app/kept.conf", EARLY_MOD_TIME, "kept"}, {"app/renamed.conf", EARLY_MOD_TIME, "renamed"}},
+			0, false, config.SYNC_RESULT_CREATED, "",
+			[]string{"app/kept.conf", "app/other/kept.conf", "app/renamed.conf"},
+		},
+		{
+			"within max deletes",
+			[]fileDef{{"app/kept.conf", EARLY_MOD_TIME, "kept"}},
+			3, false, config.SYNC_RESULT_DELETED, "",
+			[]string{"app/kept.conf", "app/other/kept.conf"},
+		},
+		{
+			"over max deletes",
+			[]fileDef{{"app/kept.conf", EARLY_MOD_TIME, "kept"}},
+			2, false, config.SYNC_RESULT_NOCHANGE, "refusing to delete 3 files",
+			[]string{"app/kept.conf", "app/old.conf", "app/nested/deeper/old.conf", "app/other/kept.conf", "app/other/old.conf"},
+		},
+		{
+			"dry run",
+			[]fileDef{{"app/kept.conf", EARLY_MOD_TIME, "kept"}},
+			0, true, config.SYNC_RESULT_DELETED, "",
+			[]string{"app/kept.conf", "app/old.conf", "app/nested/deeper/old.conf", "app/other/kept.conf", "app/other/old.conf"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(s *testing.T) {
+			rootPath := s.TempDir()
+			srcRootPath, dstRootPath := filepath.Join(rootPath, "src"), filepath.Join(rootPath, "dst")
+			for _, e := range append(test.src, fileDef{"app/other/kept.conf", EARLY_MOD_TIME, "kept"}) {
+				if err := createTestFile(srcRootPath, e); err != nil {
+					s.Fatalf("failed to create src test file: %v", err)
+				}
+			}
+			for _, e := range []fileDef{
+				{"app/kept.conf", EARLY_MOD_TIME, "kept"},
+				{"app/old.conf", EARLY_MOD_TIME, "old"},
+				{"app/nested/deeper/old.conf", EARLY_MOD_TIME, "old"},
+				{"app/other/kept.conf", EARLY_MOD_TIME, "kept"},
+				{"app/other/old.conf", EARLY_MOD_TIME, "old"},
+			} {
+				if err := createTestFile(dstRootPath, e); err != nil {
+					s.Fatalf("failed to create dst test file: %v", err)
+				}
+			}
+
+			srcExecutor := executor.NewLocalExecutor("src", s.TempDir(), 0)
+			dstExecutor := executor.NewLocalExecutor("dst", s.TempDir(), 0)
+			defer srcExecutor.Close()
+			defer dstExecutor.Close()
+			sut := NewFileProvider("test", "", filepath.Join(srcRootPath, "app"), filepath.Join(dstRootPath, "app"), FileProviderOptions{Recursive: true, DeleteExtraneous: true, MaxDeletes: test.maxDeletes})
+			result, err := sut.Sync(config.SyncConfig{
+				SrcExecutor: srcExecutor,
+				DstExecutor: dstExecutor,
+				Transport:   transport.NewLocalTransport(),
+				DryRun:      test.dryRun,
+			})
+			if test.errMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), test.errMsg) {
+					s.Errorf("expected error containing '%s', got %v", test.errMsg, err)
+				}
+			} else if err != nil {
+				s.Fatalf("sync failed: %v", err)
+			}
+			if result != test.result {
+				s.Errorf("expected result %v, got %v", test.result, result)
+			}
+
+			dstFiles, err := readDirR(dstRootPath)
+			if err != nil {
+				s.Fatalf("failed to read dst: %v", err)
+			}
+			remaining := flattenDirEntry(dstFiles)
+			sort.Strings(remaining)
+			sort.Strings(test.remaining)
+			if !reflect.DeepEqual(test.remaining, remaining) {
+				s.Errorf("expected %v to remain, got %v", test.remaining, remaining)
+			}
+			if _, err := os.Stat(filepath.Join(dstRootPath, "app", "nested")); !test.dryRun && test.errMsg == "" && !os.IsNotExist(err) {
+				s.Errorf("expected emptied directories to be removed, got %v", err)
+			}
+		})
+	}
+}
+
+func TestFileSyncFiltered(t *testing.T) {
+	// Quotes & a $ in the path, which is spliced into the ignore file's read.
+	rootPath := filepath.Join(t.TempDir(), `it's "$HOME"`)
+	srcRootPath, dstRootPath := filepath.Join(rootPath, "src"), filepath.Join(rootPath, "dst")
+	for _, e := range []fileDef{
+		{"app/" + DeployIgnoreFileName, EARLY_MOD_TIME, "*.log\n"},
+		{"app/main.py", EARLY_MOD_TIME, "main"},
+		{"app/main.pyc", EARLY_MOD_TIME, "compiled"},
+		{"app/debug.log", EARLY_MOD_TIME, "log"},
+		{"app/.git/HEAD", EARLY_MOD_TIME, "ref"},
+	} {
+		if err := createTestFile(srcRootPath, e); err != nil {
+			t.Fatalf("failed to create src test file: %v", err)
+		}
+	}
+	for _, e := range []fileDef{
+		{"app/local.pyc", EARLY_MOD_TIME, "local"},
+		{"app/old.py", EARLY_MOD_TIME, "old"},
+		{"app/server.log", EARLY_MOD_TIME, "log"},
+	} {
+		if err := createTestFile(dstRootPath, e); err != nil {
+			t.Fatalf("failed to create dst test file: %v", err)
+		}
+	}
+
+	srcExecutor := executor.NewLocalExecutor("src", t.TempDir(), 0)
+	dstExecutor := executor.NewLocalExecutor("dst", t.TempDir(), 0)
+	defer srcExecutor.Close()
+	defer dstExecutor.Close()
+	sut := NewFileProvider("test", "", filepath.Join(srcRootPath, "app"), filepath.Join(dstRootPath, "app"), FileProviderOptions{Recursive: true, DeleteExtraneous: true, Exclude: []string{"*.pyc", ".git"}})
+	if _, err := sut.Sync(config.SyncConfig{
+		SrcExecutor: srcExecutor,
+		DstExecutor: dstExecutor,
+		Transport:   transport.NewLocalTransport(),
+		DryRun:      false,
+	}); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+
+	dstFiles, err := readDirR(dstRootPath)
+	if err != nil {
+		t.Fatalf("failed to read dst: %v", err)
+	}
+	// Filtered files are neither copied nor deleted.
+	expected := []string{"app/local.pyc", "app/main.py", "app/server.log"}
+	actual := flattenDirEntry(dstFiles)
+	sort.Strings(actual)
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected dst to contain %v, got %v", expected, actual)
+	}
+}
+
+func TestFileSyncAttributes(t *testing.T) {
+	rootPath := t.TempDir()
+	srcRootPath, dstRootPath := filepath.Join(rootPath, "src"), filepath.Join(rootPath, "dst")
+	for _, e := range []fileDef{
+		{"app/unchanged.conf", EARLY_MOD_TIME, "same"},
+		{"app/nested/created.conf", EARLY_MOD_TIME, "created"},
+	} {
+		if err := createTestFile(srcRootPath, e); err != nil {
+			t.Fatalf("failed to create src test file: %v", err)
+		}
+	}
+	if err := createTestFile(dstRootPath, fileDef{"app/unchanged.conf", EARLY_MOD_TIME, "same"}); err != nil {
+		t.Fatalf("failed to create dst test file: %v", err)
+	}
+	if err := os.Chmod(filepath.Join(dstRootPath, "app", "unchanged.conf"), 0644); err != nil {
+		t.Fatalf("failed to chmod dst test file: %v", err)
+	}
+
+	srcExecutor := executor.NewLocalExecutor("src", t.TempDir(), 0)
+	dstExecutor := executor.NewLocalExecutor("dst", t.TempDir(), 0)
+	defer srcExecutor.Close()
+	defer dstExecutor.Close()
+	attributes := FileAttributes{Owner: fmt.Sprint(os.Getuid()), FileMode: "0640", DirMode: "0750"}
+	sut := NewFileProvider("test", "", filepath.Join(srcRootPath, "app"), filepath.Join(dstRootPath, "app"), FileProviderOptions{Recursive: true, Attributes: attributes})
+	cfg := config.SyncConfig{
+		SrcExecutor: srcExecutor,
+		DstExecutor: dstExecutor,
+		Transport:   transport.NewLocalTransport(),
+		DryRun:      false,
+	}
+
+	// Only the destination file's mode has drifted.
+	os.RemoveAll(filepath.Join(srcRootPath, "app", "nested"))
+	if result, err := sut.Sync(cfg); err != nil || result != config.SYNC_RESULT_UPDATED {
+		t.Fatalf("expected drifted mode to be an update, got %v (err: %v)", result, err)
+	}
+	if err := createTestFile(srcRootPath, fileDef{"app/nested/created.conf", EARLY_MOD_TIME, "created"}); err != nil {
+		t.Fatalf("failed to create src test file: %v", err)
+	}
+	if result, err := sut.Sync(cfg); err != nil || result != config.SYNC_RESULT_CREATED {
+		t.Fatalf("expected new file to be created, got %v (err: %v)", result, err)
+	}
+
+	for path, expected := range map[string]os.FileMode{
+		"app":                     0750,
+		"app/nested":              0750,
+		"app/unchanged.conf":      0640,
+		"app/nested/created.conf": 0640,
+	} {
+		info, err := os.Stat(filepath.Join(dstRootPath, path))
+		if err != nil {
+			t.Errorf("failed to stat %s: %v", path, err)
+		} else if info.Mode().Perm() != expected {
+			t.Errorf("expected %s to have mode %o, got %o", path, expected, info.Mode().Perm())
+		}
+	}
+
+	if result, err := sut.Sync(cfg); err != nil || result != config.SYNC_RESULT_NOCHANGE {
+		t.Errorf("expected no change once attributes are in place, got %v (err: %v)", result, err)
+	}
+	if dstFiles, err := readDirR(dstRootPath); err != nil || len(flattenDirEntry(dstFiles)) != 2 {
+		t.Errorf("expected no temporary files to be left behind, got %v (err: %v)", flattenDirEntry(dstFiles), err)
+	}
+}
+
+func TestFileSyncSymlinksAndDirs(t *testing.T) {
+	var tests = []struct {
+		name     string
+		symlinks string
+		// Relative paths on the destination, with symlinks as "path -> target"
+		// & directories ending in /.
+		expected []string
+	}{
+		{"preserve", FileSymlinksPreserve, []string{"dirlink -> empty", "empty/", "link -> main.conf", "main.conf"}},
+		{"follow", FileSymlinksFollow, []string{"dirlink/", "empty/", "link", "main.conf"}},
+		// Symlinks already on the destination are left alone.
+		{"skip", FileSymlinksSkip, []string{"empty/", "link -> elsewhere", "main.conf", "stale -> elsewhere"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(s *testing.T) {
+			// Quotes & a $ in the path, which is spliced into find's command.
+			rootPath := filepath.Join(s.TempDir(), `it's "$HOME"`)
+			srcRootPath, dstRootPath := filepath.Join(rootPath, "src"), filepath.Join(rootPath, "dst")
+			if err := createTestFile(srcRootPath, fileDef{"app/main.conf", EARLY_MOD_TIME, "main"}); err != nil {
+				s.Fatalf("failed to create src test file: %v", err)
+			}
+			// The destination has a file where the source has a directory &
+			// a symlink that points elsewhere.
+			if err := createTestFile(dstRootPath, fileDef{"app/empty", EARLY_MOD_TIME, "not a dir"}); err != nil {
+				s.Fatalf("failed to create dst test file: %v", err)
+			}
+			for _, link := range []struct{ root, path, target string }{
+				{srcRootPath, "app/link", "main.conf"},
+				{srcRootPath, "app/dirlink", "empty"},
+				{dstRootPath, "app/link", "elsewhere"},
+				{dstRootPath, "app/stale", "elsewhere"},
+			} {
+				if err := os.Symlink(link.target, filepath.Join(link.root, link.path)); err != nil {
+					s.Fatalf("failed to create symlink: %v", err)
+				}
+			}
+			if err := os.Mkdir(filepath.Join(srcRootPath, "app", "empty"), 0755); err != nil {
+				s.Fatalf("failed to create empty dir: %v", err)
+			}
+
+			srcExecutor := executor.NewLocalExecutor("src", s.TempDir(), 0)
+			dstExecutor := executor.NewLocalExecutor("dst", s.TempDir(), 0)
+			defer srcExecutor.Close()
+			defer dstExecutor.Close()
+			sut := NewFileProvider("test", "", filepath.Join(srcRootPath, "app"), filepath.Join(dstRootPath, "app"), FileProviderOptions{Recursive: true, DeleteExtraneous: true, Symlinks: test.symlinks})
+			cfg := config.SyncConfig{
+				SrcExecutor: srcExecutor,
+				DstExecutor: dstExecutor,
+				Transport:   transport.NewLocalTransport(),
+				DryRun:      false,
+			}
+			if _, err := sut.Sync(cfg); err != nil {
+				s.Fatalf("sync failed: %v", err)
+			}
+			if result, err := sut.Sync(cfg); err != nil || result != config.SYNC_RESULT_NOCHANGE {
+				s.Errorf("expected second sync to change nothing, got %v (err: %v)", result, err)
+			}
+
+			entries, err := os.ReadDir(filepath.Join(dstRootPath, "app"))
+			if err != nil {
+				s.Fatalf("failed to read dst: %v", err)
+			}
+			actual := []string{}
+			for _, e := range entries {
+				path := filepath.Join(dstRootPath, "app", e.Name())
+				switch {
+				case e.Type()&os.ModeSymlink != 0:
+					target, _ := os.Readlink(path)
+					actual = append(actual, e.Name()+" -> "+target)
+				case e.IsDir():
+					actual = append(actual, e.Name()+"/")
+				default:
+					actual = append(actual, e.Name())
+				}
+			}
+			if !reflect.DeepEqual(test.expected, actual) {
+				s.Errorf("expected dst to contain %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestFileSyncReplacedDirs(t *testing.T) {
+	var tests = []struct {
+		name             string
+		deleteExtraneous bool
+		maxDeletes       int
+		exclude          []string
+		errMsg           string
+	}{
+		{"mirrored", true, 2, nil, ""},
+		{"over max deletes", true, 1, nil, "refusing to delete 2 files"},
+		{"not mirrored", false, 0, nil, "holding 2 files that aren't in the source"},
+		{"holding excluded files", true, 0, []string{"*.log"}, "which is left out of the asset"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(s *testing.T) {
+			rootPath := s.TempDir()
+			srcRootPath, dstRootPath := filepath.Join(rootPath, "src"), filepath.Join(rootPath, "dst")
+			// The destination has a directory where the source has a file.
+			if err := createTestFile(srcRootPath, fileDef{"app/conf", EARLY_MOD_TIME, "file"}); err != nil {
+				s.Fatalf("failed to create src test file: %v", err)
+			}
+			for _, e := range []fileDef{
+				{"app/conf/main.conf", EARLY_MOD_TIME, "main"},
+				{"app/conf/nested/app.log", EARLY_MOD_TIME, "log"},
+			} {
+				if err := createTestFile(dstRootPath, e); err != nil {
+					s.Fatalf("failed to create dst test file: %v", err)
+				}
+			}
+
+			srcExecutor := executor.NewLocalExecutor("src", s.TempDir(), 0)
+			dstExecutor := executor.NewLocalExecutor("dst", s.TempDir(), 0)
+			defer srcExecutor.Close()
+			defer dstExecutor.Close()
+			sut := NewFileProvider("test", "", filepath.Join(srcRootPath, "app"), filepath.Join(dstRootPath, "app"), FileProviderOptions{
+				Recursive:        true,
+				DeleteExtraneous: test.deleteExtraneous,
+				MaxDeletes:       test.maxDeletes,
+				Exclude:          test.exclude,
+			})
+			_, err := sut.Sync(config.SyncConfig{
+				SrcExecutor: srcExecutor,
+				DstExecutor: dstExecutor,
+				Transport:   transport.NewLocalTransport(),
+				DryRun:      false,
+			})
+			if test.errMsg == "" {
+				if err != nil {
+					s.Fatalf("sync failed: %v", err)
+				}
+				assertFileContents(s, filepath.Join(dstRootPath, "app", "conf"), "file")
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.errMsg) {
+				s.Errorf("expected error containing '%s', got %v", test.errMsg, err)
+			}
+			assertFileContents(s, filepath.Join(dstRootPath, "app", "conf", "nested", "app.log"), "log")
+		})
+	}
+}
+
+func TestFileSyncRefusesSpecialFiles(t *testing.T) {
+	rootPath := t.TempDir()
+	if err := createTestFile(rootPath, fileDef{"src/main.conf", EARLY_MOD_TIME, "main"}); err != nil {
+		t.Fatalf("failed to create src test file: %v", err)
+	}
+	if err := syscall.Mkfifo(filepath.Join(rootPath, "src", "pipe"), 0600); err != nil {
+		t.Fatalf("failed to create fifo: %v", err)
+	}
+
+	srcExecutor := executor.NewLocalExecutor("src", t.TempDir(), 0)
+	dstExecutor := executor.NewLocalExecutor("dst", t.TempDir(), 0)
+	defer srcExecutor.Close()
+	defer dstExecutor.Close()
+	sut := NewFileProvider("test", "", filepath.Join(rootPath, "src"), filepath.Join(rootPath, "dst"), FileProviderOptions{Recursive: true, Force: true})
+	_, err := sut.Sync(config.SyncConfig{
+		SrcExecutor: srcExecutor,
+		DstExecutor: dstExecutor,
+		Transport:   transport.NewLocalTransport(),
+		DryRun:      false,
+	})
+	if err == nil || !strings.Contains(err.Error(), "only regular files, directories & symlinks") {
+		t.Errorf("expected a FIFO to be refused, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(rootPath, "dst")); !os.IsNotExist(err) {
+		t.Errorf("expected nothing to be deployed, got %v", err)
+	}
+}
+
+func TestGetFileInfoQuoting(t *testing.T) {
+	exec := executor.NewLocalExecutor("local", t.TempDir(), 0)
+	defer exec.Close()
+	dir := filepath.Join(t.TempDir(), `it's "$HOME"`)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+
+	info, err := getFileInfo("", dir, exec)
+	if err != nil {
+		t.Fatalf("failed to get file info: %v", err)
+	}
+	if info.FullPath != dir || !info.Exists || !info.IsDirectory {
+		t.Errorf("expected existing directory %s, got %+v", dir, info)
+	}
+	info, err = getFileInfo("", filepath.Join(dir, "missing"), exec)
+	if err != nil || info.Exists || !info.DirExists {
+		t.Errorf("expected missing file in existing directory, got %+v (err: %v)", info, err)
+	}
+}
+
+func TestFileValidate(t *testing.T) {
+	exec := executor.NewLocalExecutor("local", t.TempDir(), 0)
+	defer exec.Close()
+	sut := NewFileProvider("test", "", "src", "dst", FileProviderOptions{}).(config.ValidatingProvider)
+	if err := sut.Validate(exec); err != nil {
+		t.Errorf("expected find on this machine to be usable, got %v", err)
+	}
+
+	// As BSD find reports it.
+	binDir := t.TempDir()
+	fakeFind := "#!/bin/sh\necho 'find: -printf: unknown primary or operator' >&2\nexit 1\n"
+	if err := os.WriteFile(filepath.Join(binDir, "find"), []byte(fakeFind), 0755); err != nil {
+		t.Fatalf("failed to write fake find: %v", err)
+	}
+	t.Setenv("PATH", binDir+":"+os.Getenv("PATH"))
+	if err := sut.Validate(exec); err == nil || !strings.Contains(err.Error(), "GNU findutils is required") {
+		t.Errorf("expected find without -printf to be refused, got %v", err)
+	}
+}
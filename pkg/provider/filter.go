@@ -0,0 +1,153 @@
+package provider
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Name of the file in a source directory listing files not to deploy, with
+// the same syntax & semantics as .gitignore. The file itself isn't deployed.
+const DeployIgnoreFileName = ".deployignore"
+
+// Decides which files under a directory are deployed, by their paths relative
+// to it. The same filter is applied on both locations, so files it leaves out
+// are neither copied nor deleted from the destination.
+type fileFilter struct {
+	include []string
+	exclude []string
+	ignore  []ignoreRule
+}
+
+type ignoreRule struct {
+	pattern string
+	negate  bool
+	// Only matches directories.
+	dirOnly bool
+}
+
+// Checks that the pattern is a valid include or exclude pattern.
+func ValidatePattern(pattern string) error {
+	if strings.Trim(pattern, "/") == "" {
+		return fmt.Errorf("empty pattern: '%s'", pattern)
+	}
+	for _, segment := range strings.Split(strings.Trim(pattern, "/"), "/") {
+		if _, err := path.Match(segment, ""); err != nil {
+			return fmt.Errorf("invalid pattern '%s': %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+func newFileFilter(include []string, exclude []string, ignoreFile string) *fileFilter {
+	return &fileFilter{
+		include: include,
+		exclude: append([]string{"/" + DeployIgnoreFileName}, exclude...),
+		ignore:  parseIgnoreFile(ignoreFile),
+	}
+}
+
+// Parses the contents of a .gitignore-style file: one pattern per line, with
+// blank lines & those starting with # skipped, a leading ! negating the
+// pattern & a trailing / restricting it to directories.
+func parseIgnoreFile(contents string) []ignoreRule {
+	rules := []ignoreRule{}
+	for _, line := range strings.Split(contents, "\n") {
+		line = strings.TrimRight(line, " \r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule := ignoreRule{}
+		if strings.HasPrefix(line, "!") {
+			rule.negate, line = true, line[1:]
+		} else if strings.HasPrefix(line, `\`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly, line = true, strings.TrimRight(line, "/")
+		}
+		if line == "" || ValidatePattern(line) != nil {
+			continue
+		}
+		rule.pattern = line
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// Whether the file (or directory, if isDir) at the relative path is deployed:
+// it, or a directory it's in, must match one of the include patterns (if there
+// are any) & none of the exclude patterns, & it mustn't be ignored by the
+// ignore file.
+func (f *fileFilter) Matches(relativePath string, isDir bool) bool {
+	if len(f.include) > 0 && !matchesAny(f.include, relativePath) {
+		return false
+	}
+	if matchesAny(f.exclude, relativePath) {
+		return false
+	}
+	return !f.ignored(relativePath, isDir)
+}
+
+func matchesAny(patterns []string, relativePath string) bool {
+	segments := strings.Split(relativePath, "/")
+	for _, p := range patterns {
+		for i := 1; i <= len(segments); i++ {
+			if matchPattern(p, segments[:i]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// As with .gitignore, the last rule to match a path decides whether it's
+// ignored, & files in an ignored directory can't be un-ignored.
+func (f *fileFilter) ignored(relativePath string, isDir bool) bool {
+	segments := strings.Split(relativePath, "/")
+	for i := 1; i <= len(segments); i++ {
+		isDir := i < len(segments) || isDir
+		ignored := false
+		for _, r := range f.ignore {
+			if (isDir || !r.dirOnly) && matchPattern(r.pattern, segments[:i]) {
+				ignored = !r.negate
+			}
+		}
+		if ignored {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches the path against the pattern, where * & ? don't match /, & a **
+// segment matches any number of segments. A pattern with no / in it other than
+// at the end matches at any depth; otherwise it's relative to the directory.
+func matchPattern(pattern string, segments []string) bool {
+	pattern = strings.TrimRight(pattern, "/")
+	if !strings.Contains(pattern, "/") {
+		pattern = "**/" + pattern
+	}
+	return matchSegments(strings.Split(strings.TrimLeft(pattern, "/"), "/"), segments)
+}
+
+func matchSegments(pattern []string, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(segments); i++ {
+				if matchSegments(pattern[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segments) == 0 {
+			return false
+		}
+		if matched, _ := path.Match(pattern[0], segments[0]); !matched {
+			return false
+		}
+		pattern, segments = pattern[1:], segments[1:]
+	}
+	return len(segments) == 0
+}
@@ -0,0 +1,60 @@
+package provider
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFileFilter(t *testing.T) {
+	var tests = []struct {
+		name    string
+		include []string
+		exclude []string
+		ignore  string
+		// Directories end with a /.
+		path     string
+		expected bool
+	}{
+		{"no patterns", nil, nil, "", "app/main.py", true},
+		{"exclude by name at any depth", nil, []string{"*.pyc"}, "", "app/lib/main.pyc", false},
+		{"exclude directory", nil, []string{".git"}, "", ".git/objects/ab/cdef", false},
+		{"exclude anchored", nil, []string{"/build"}, "", "app/build/out", true},
+		{"exclude with **", nil, []string{"app/**/local.conf"}, "", "app/a/b/local.conf", false},
+		{"exclude with ** matching nothing", nil, []string{"app/**/local.conf"}, "", "app/local.conf", false},
+		{"include matches", []string{"*.conf"}, nil, "", "etc/app.conf", true},
+		{"include misses", []string{"*.conf"}, nil, "", "etc/app.py", false},
+		{"include directory", []string{"etc/**"}, nil, "", "etc/nested/app.py", true},
+		{"exclude wins over include", []string{"*.conf"}, []string{"local.conf"}, "", "etc/local.conf", false},
+		{"ignore file itself", nil, nil, "", DeployIgnoreFileName, false},
+		{"nested file named like the ignore file", nil, nil, "", "sub/" + DeployIgnoreFileName, true},
+		{"ignored", nil, nil, "# comment\n\n*.log\n", "var/app.log", false},
+		{"ignore negated", nil, nil, "*.log\n!keep.log\n", "var/keep.log", true},
+		{"ignore last rule wins", nil, nil, "!keep.log\n*.log\n", "var/keep.log", false},
+		{"ignore dir only skips files", nil, nil, "cache/\n", "cache", true},
+		{"ignore dir only matches dirs", nil, nil, "cache/\n", "app/cache/data", false},
+		{"ignore dir only matches the dir itself", nil, nil, "cache/\n", "app/cache/", false},
+		{"ignored dir can't be un-ignored", nil, nil, "tmp/\n!tmp/keep\n", "tmp/keep", false},
+		{"ignore anchored by inner slash", nil, nil, "app/local.conf\n", "other/app/local.conf", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(s *testing.T) {
+			filter := newFileFilter(test.include, test.exclude, test.ignore)
+			if actual := filter.Matches(strings.TrimSuffix(test.path, "/"), strings.HasSuffix(test.path, "/")); actual != test.expected {
+				s.Errorf("expected %s to match: %t, got %t", test.path, test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestValidatePattern(t *testing.T) {
+	for _, p := range []string{"*.pyc", "/build/", "app/**/x", "[a-z]*"} {
+		if err := ValidatePattern(p); err != nil {
+			t.Errorf("expected %s to be valid, got %v", p, err)
+		}
+	}
+	for _, p := range []string{"", "/", "[a-"} {
+		if err := ValidatePattern(p); err == nil {
+			t.Errorf("expected %s to be invalid", p)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package provider
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/mrshanahan/deploy-assets/pkg/config"
+	"github.com/mrshanahan/deploy-assets/pkg/executor"
+)
+
+var recordFixtures = flag.Bool("record", false, "re-record executor fixtures in testdata/ by running against the local machine")
+
+const fixtureStagingRoot = "/tmp/deploy-assets-fixtures"
+
+// Returns one executor per location for a fixture-backed test. Normally these
+// replay testdata/<test>.<location>.json & the test fails if the provider runs
+// anything that wasn't recorded (or skips anything that was). With -record the
+// commands run on the local machine instead & the fixtures are rewritten.
+func newFixtureExecutors(t *testing.T, test string, locations ...string) []config.Executor {
+	executors := []config.Executor{}
+	for _, l := range locations {
+		fixturePath := filepath.Join("testdata", fmt.Sprintf("%s.%s.json", test, l))
+		if *recordFixtures {
+			recorder := executor.NewRecordingExecutor(executor.NewLocalExecutor(l, fixtureStagingRoot, 0), fixturePath)
+			t.Cleanup(recorder.Close)
+			executors = append(executors, recorder)
+		} else {
+			replayer, err := executor.NewReplayExecutor(fixturePath)
+			if err != nil {
+				t.Fatalf("failed to load fixture: %v", err)
+			}
+			t.Cleanup(func() {
+				if err := replayer.Verify(); err != nil {
+					t.Errorf("fixture %s did not match: %v", fixturePath, err)
+				}
+			})
+			executors = append(executors, replayer)
+		}
+	}
+	return executors
+}
+
+// Copies files with a single command on the destination, so that transfers
+// show up in the destination's fixture. When recording, this assumes both
+// locations share a filesystem (which they do, being the local machine).
+type fixtureTransport struct{}
+
+func (t *fixtureTransport) Yaml(indent int) string { return "" }
+
+func (t *fixtureTransport) Validate(exec config.Executor) error { return nil }
+
+func (t *fixtureTransport) TransferFile(src config.Executor, srcPath string, dst config.Executor, dstPath string) error {
+	_, _, err := dst.ExecuteCommand("cp", srcPath, dstPath)
+	return err
+}
@@ -1,26 +1,36 @@
 package provider
 
 import (
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"strings"
 
 	"github.com/mrshanahan/deploy-assets/internal/util"
 	"github.com/mrshanahan/deploy-assets/pkg/config"
+	"github.com/mrshanahan/deploy-assets/pkg/executor"
 )
 
-func NewLiteralProvider(name, value, dstPath string) config.Provider {
+// Only the owner, group & file mode of attributes apply.
+func NewLiteralProvider(name, value, dstPath string, attributes FileAttributes, backup bool, keepBackups int) config.Provider {
 	return &literalProvider{
-		name:    name,
-		value:   value,
-		dstPath: dstPath,
+		name:        name,
+		value:       value,
+		dstPath:     dstPath,
+		attributes:  attributes,
+		backup:      backup,
+		keepBackups: keepBackups,
 	}
 }
 
 type literalProvider struct {
-	name    string
-	value   string
-	dstPath string
+	name        string
+	value       string
+	dstPath     string
+	attributes  FileAttributes
+	backup      bool
+	keepBackups int
 }
 
 func (p *literalProvider) Name() string { return p.name }
@@ -32,32 +42,108 @@ func (p *literalProvider) Yaml(indent int) string {
 %sname: %s
 %svalue: |
 %s
-%sdst_path: %s`,
+%sdst_path: %s
+%sowner: %s
+%sgroup: %s
+%sfile_mode: %s
+%sbackup: %t
+%skeep_backups: %d`,
 		util.YamlIndentString(indent),
 		propIndent, p.name,
 		propIndent,
 		util.IndentLines(p.value, indent+util.TabsToIndent(2)),
 		propIndent, p.dstPath,
+		propIndent, p.attributes.Owner,
+		propIndent, p.attributes.Group,
+		propIndent, p.attributes.FileMode,
+		propIndent, p.backup,
+		propIndent, p.keepBackups,
 	)
 }
 
 func (p *literalProvider) Sync(cfg config.SyncConfig) (config.SyncResult, error) {
 	b64Value := base64.StdEncoding.EncodeToString([]byte(p.value))
-	stdoutRaw, _, err := cfg.DstExecutor.ExecuteShell(fmt.Sprintf("(test -e '%s' && echo 'exists') || echo 'not-exists'", p.dstPath))
+	stdoutRaw, _, err := cfg.DstExecutor.ExecuteShell(fmt.Sprintf("(test -e %s && echo 'exists') || echo 'not-exists'", executor.ShellQuote(p.dstPath)))
 	if err != nil {
 		return config.SYNC_RESULT_NOCHANGE, fmt.Errorf("failed to check for existing target file '%s': %w", p.dstPath, err)
 	}
 	stdout := strings.Trim(stdoutRaw, " \n")
 	var successResult config.SyncResult
+	var change fileChange
 	if stdout == "exists" {
+		unchanged, err := p.isUnchanged(cfg.DstExecutor)
+		if err != nil {
+			return config.SYNC_RESULT_NOCHANGE, err
+		}
+		if unchanged {
+			return p.fixAttributes(cfg.DstExecutor)
+		}
 		successResult = config.SYNC_RESULT_UPDATED
+		change = fileChange{changeUpdated, p.dstPath}
 	} else {
 		successResult = config.SYNC_RESULT_CREATED
+		change = fileChange{changeCreated, p.dstPath}
 	}
 
-	if _, _, err := cfg.DstExecutor.ExecuteShell(fmt.Sprintf("echo '%s' | base64 -d > '%s'", b64Value, p.dstPath)); err != nil {
-		return config.SYNC_RESULT_NOCHANGE, fmt.Errorf("failed to write value to %s: %w", p.dstPath, err)
+	if p.backup {
+		if _, err := createBackup(cfg.DstExecutor, p.dstPath, []fileChange{change}, p.keepBackups); err != nil {
+			return config.SYNC_RESULT_NOCHANGE, err
+		}
+	}
+
+	// The value is written beside dstPath & renamed over it once it has the
+	// wanted owner & mode, so that it's never there half-written or with the
+	// wrong ones. Otherwise, those of the file it replaces are kept.
+	tmpPath := tempPathFor(p.dstPath)
+	quotedDstPath, quotedTmpPath := executor.ShellQuote(p.dstPath), executor.ShellQuote(tmpPath)
+	cmd := fmt.Sprintf("(test ! -e %s || cp -p %s %s) && echo '%s' | base64 -d > %s", quotedDstPath, quotedDstPath, quotedTmpPath, b64Value, quotedTmpPath)
+	if _, _, err := cfg.DstExecutor.ExecuteShell(cmd); err != nil {
+		cfg.DstExecutor.ExecuteCommand("rm", "-f", tmpPath)
+		return config.SYNC_RESULT_NOCHANGE, fmt.Errorf("failed to write value to %s: %w", tmpPath, err)
+	}
+	if p.attributes.IsSet() {
+		if err := applyAttributes(cfg.DstExecutor, p.attributes, []string{tmpPath}, nil); err != nil {
+			cfg.DstExecutor.ExecuteCommand("rm", "-f", tmpPath)
+			return config.SYNC_RESULT_NOCHANGE, err
+		}
+	}
+	if _, stderr, err := cfg.DstExecutor.ExecuteCommand("mv", "-f", tmpPath, p.dstPath); err != nil {
+		cfg.DstExecutor.ExecuteCommand("rm", "-f", tmpPath)
+		return config.SYNC_RESULT_NOCHANGE, fmt.Errorf("failed to move value into place at %s (stderr: %s): %w", p.dstPath, strings.TrimSpace(stderr), err)
 	}
 
 	return successResult, nil
 }
+
+// Whether the file already holds the value, in which case there's nothing to
+// write (or back up).
+func (p *literalProvider) isUnchanged(dst config.Executor) (bool, error) {
+	sums, err := executor.SHA256Sums(dst, "", []string{p.dstPath})
+	if err != nil {
+		return false, fmt.Errorf("failed to checksum existing target file '%s': %w", p.dstPath, err)
+	}
+	sum := sha256.Sum256([]byte(p.value))
+	return sums[p.dstPath] == hex.EncodeToString(sum[:]), nil
+}
+
+// Corrects the owner & mode of a file that already holds the value, if they've
+// drifted, which counts as an update.
+func (p *literalProvider) fixAttributes(dst config.Executor) (config.SyncResult, error) {
+	if !p.attributes.IsSet() {
+		return config.SYNC_RESULT_NOCHANGE, nil
+	}
+	drifted, _, err := findAttributeDrift(dst, p.attributes, []string{p.dstPath}, nil)
+	if err != nil || len(drifted) == 0 {
+		return config.SYNC_RESULT_NOCHANGE, err
+	}
+	if err := applyAttributes(dst, p.attributes, drifted, nil); err != nil {
+		return config.SYNC_RESULT_NOCHANGE, err
+	}
+	return config.SYNC_RESULT_UPDATED, nil
+}
+
+// Puts back the file as it was before the sync that took the backup with the
+// given id (or the latest), removing it if that sync created it.
+func (p *literalProvider) Restore(dst config.Executor, id string, dryRun bool) error {
+	return restoreBackup(dst, p.Name(), p.dstPath, id, dryRun)
+}
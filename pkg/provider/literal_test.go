@@ -15,13 +15,18 @@ import (
 )
 
 func TestLiteralYamlSingleLine(t *testing.T) {
-	p := NewLiteralProvider("foobar", "boop sdlkjf lskdfjlksd lkfjsdlkfdsjlksfdj kl", "blap.txt")
+	p := NewLiteralProvider("foobar", "boop sdlkjf lskdfjlksd lkfjsdlkfdsjlksfdj kl", "blap.txt", FileAttributes{}, false, 5)
 	expected :=
 		`literal:
     name: foobar
     value: |
         boop sdlkjf lskdfjlksd lkfjsdlkfdsjlksfdj kl
-    dst_path: blap.txt`
+    dst_path: blap.txt
+    owner: 
+    group: 
+    file_mode: 
+    backup: false
+    keep_backups: 5`
 	actual := p.Yaml(0)
 	if expected != actual {
 		t.Errorf("yaml contents not equal:\nexpected:\n=======\n%s\n=======\ngot:\n=======\n%s\n=======", expected, actual)
@@ -29,7 +34,7 @@ func TestLiteralYamlSingleLine(t *testing.T) {
 }
 
 func TestLiteralYamlMultiLine(t *testing.T) {
-	p := NewLiteralProvider("foobar", "boop sdlkjf\nlskdfjlksd lkfjsdlkfdsjlksfdj\nkl", "blap.txt")
+	p := NewLiteralProvider("foobar", "boop sdlkjf\nlskdfjlksd lkfjsdlkfdsjlksfdj\nkl", "blap.txt", FileAttributes{}, false, 5)
 	expected :=
 		`literal:
     name: foobar
@@ -37,7 +42,12 @@ func TestLiteralYamlMultiLine(t *testing.T) {
         boop sdlkjf
         lskdfjlksd lkfjsdlkfdsjlksfdj
         kl
-    dst_path: blap.txt`
+    dst_path: blap.txt
+    owner: 
+    group: 
+    file_mode: 
+    backup: false
+    keep_backups: 5`
 	actual := p.Yaml(0)
 	if expected != actual {
 		t.Errorf("yaml contents not equal:\nexpected:\n=======\n%s\n=======\ngot:\n=======\n%s\n=======", expected, actual)
@@ -45,7 +55,7 @@ func TestLiteralYamlMultiLine(t *testing.T) {
 }
 
 func TestLiteralYamlTrailingLeadingMultiLine(t *testing.T) {
-	p := NewLiteralProvider("foobar", "\nboop sdlkjf\nlskdfjlksd lkfjsdlkfdsjlksfdj\nkl\n\n", "blap.txt")
+	p := NewLiteralProvider("foobar", "\nboop sdlkjf\nlskdfjlksd lkfjsdlkfdsjlksfdj\nkl\n\n", "blap.txt", FileAttributes{}, false, 5)
 	expected :=
 		`literal:
     name: foobar
@@ -56,7 +66,12 @@ func TestLiteralYamlTrailingLeadingMultiLine(t *testing.T) {
         kl
         
         
-    dst_path: blap.txt`
+    dst_path: blap.txt
+    owner: 
+    group: 
+    file_mode: 
+    backup: false
+    keep_backups: 5`
 	actual := p.Yaml(0)
 	if expected != actual {
 		t.Errorf("yaml contents not equal:\nexpected:\n=======\n%s\n=======\ngot:\n=======\n%s\n=======", expected, actual)
@@ -102,7 +117,7 @@ func TestLiteralSync(t *testing.T) {
 			},
 		},
 		{
-			"update-if-same",
+			"unchanged-if-same",
 			"abc",
 			"foo.bar",
 			[]fileDef{
@@ -116,7 +131,7 @@ func TestLiteralSync(t *testing.T) {
 				{
 					name:    "foo.bar",
 					content: "abc",
-					modTime: "NOW",
+					modTime: EARLY_MOD_TIME,
 				},
 			},
 		},
@@ -136,8 +151,10 @@ func executeLiteralSyncTest(value string, dstPath string, dstEntriesBefore []fil
 	}
 	defer os.RemoveAll(testRunDir)
 
-	srcExecutor, srcRootPath := executor.NewLocalExecutor("src"), filepath.Join(testRunDir, "src")
-	dstExecutor, dstRootPath := executor.NewLocalExecutor("dst"), filepath.Join(testRunDir, "dst")
+	srcExecutor, srcRootPath := executor.NewLocalExecutor("src", testRunDir, 0), filepath.Join(testRunDir, "src")
+	dstExecutor, dstRootPath := executor.NewLocalExecutor("dst", testRunDir, 0), filepath.Join(testRunDir, "dst")
+	defer srcExecutor.Close()
+	defer dstExecutor.Close()
 
 	for _, d := range []string{srcRootPath, dstRootPath} {
 		if err := os.MkdirAll(d, 0700); err != nil {
@@ -153,7 +170,7 @@ func executeLiteralSyncTest(value string, dstPath string, dstEntriesBefore []fil
 
 	dstFile := filepath.Join(dstRootPath, dstPath)
 
-	sut := NewLiteralProvider("test", value, dstFile)
+	sut := NewLiteralProvider("test", value, dstFile, FileAttributes{}, false, 5)
 	config := config.SyncConfig{
 		SrcExecutor: srcExecutor,
 		DstExecutor: dstExecutor,
@@ -204,7 +221,7 @@ func executeLiteralSyncTest(value string, dstPath string, dstEntriesBefore []fil
 					t.Errorf("failed to parse expected mod time %s: %v", e.modTime, err)
 					continue
 				}
-				if expectedModTime != actualModTime {
+				if !expectedModTime.Equal(actualModTime) {
 					t.Errorf("expected mod time %v, got %v (%s)", expectedModTime, actualModTime, path)
 				}
 			}
@@ -219,3 +236,49 @@ func executeLiteralSyncTest(value string, dstPath string, dstEntriesBefore []fil
 		}
 	}
 }
+
+func TestLiteralSyncAttributes(t *testing.T) {
+	dstPath := filepath.Join(t.TempDir(), "foo.bar")
+	dstExecutor := executor.NewLocalExecutor("dst", t.TempDir(), 0)
+	defer dstExecutor.Close()
+
+	sut := NewLiteralProvider("test", "abc", dstPath, FileAttributes{Group: fmt.Sprint(os.Getgid()), FileMode: "0600"}, false, 5)
+	if _, err := sut.Sync(config.SyncConfig{DstExecutor: dstExecutor, Transport: transport.NewLocalTransport()}); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	info, err := os.Stat(dstPath)
+	if err != nil {
+		t.Fatalf("failed to stat %s: %v", dstPath, err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected mode 600, got %o", info.Mode().Perm())
+	}
+}
+
+func TestLiteralSyncReplacesFile(t *testing.T) {
+	dir := t.TempDir()
+	dstPath := filepath.Join(dir, "it's foo.bar")
+	if err := os.WriteFile(dstPath, []byte("old"), 0604); err != nil {
+		t.Fatalf("failed to write %s: %v", dstPath, err)
+	}
+	if err := os.Chmod(dstPath, 0604); err != nil {
+		t.Fatalf("failed to chmod %s: %v", dstPath, err)
+	}
+	dstExecutor := executor.NewLocalExecutor("dst", t.TempDir(), 0)
+	defer dstExecutor.Close()
+
+	sut := NewLiteralProvider("test", "abc", dstPath, FileAttributes{}, false, 5)
+	if _, err := sut.Sync(config.SyncConfig{DstExecutor: dstExecutor, Transport: transport.NewLocalTransport()}); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	if actual, err := os.ReadFile(dstPath); err != nil || string(actual) != "abc" {
+		t.Errorf("expected value to be written, got '%s' (err: %v)", actual, err)
+	}
+	if info, err := os.Stat(dstPath); err != nil || info.Mode().Perm() != 0604 {
+		t.Errorf("expected replaced file's mode to be kept, got %v (err: %v)", info, err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 {
+		t.Errorf("expected no temporary file to be left behind, got %v (err: %v)", entries, err)
+	}
+}
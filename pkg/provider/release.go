@@ -0,0 +1,259 @@
+package provider
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mrshanahan/deploy-assets/internal/util"
+	"github.com/mrshanahan/deploy-assets/pkg/config"
+	"github.com/mrshanahan/deploy-assets/pkg/executor"
+)
+
+// In release mode, the destination path is laid out as:
+//
+//	<dst_path>/releases/<id>	one directory per deploy
+//	<dst_path>/current		symlink to releases/<id>
+const (
+	releasesDirName   = "releases"
+	currentLinkName   = "current"
+	timestampIDFormat = "20060102T150405Z"
+	currentLinkTmpExt = ".tmp"
+)
+
+// Deploys the source directory into a new release, starting from a copy of the
+// current one, & then switches the current symlink to it. Nothing is created
+// if the current release already matches the source.
+func (p *fileProvider) syncRelease(cfg config.SyncConfig, srcFileInfo *fileInfo, srcEntries map[string]*fileEntry, dstFileInfo *fileInfo, filter *fileFilter) (config.SyncResult, error) {
+	if !srcFileInfo.IsDirectory {
+		return config.SYNC_RESULT_NOCHANGE, fmt.Errorf("release mode requires a source directory: %s", srcFileInfo.FullPath)
+	}
+
+	base := dstFileInfo.FullPath
+	releasesDir := filepath.Join(base, releasesDirName)
+	current, releases := "", []string{}
+	if dstFileInfo.Exists {
+		var err error
+		if current, err = currentRelease(cfg.DstExecutor, base); err != nil {
+			return config.SYNC_RESULT_NOCHANGE, err
+		}
+		if releases, err = listReleases(cfg.DstExecutor, base); err != nil {
+			return config.SYNC_RESULT_NOCHANGE, err
+		}
+		if current != "" && !slices.Contains(releases, current) {
+			slog.Warn("current release is missing; starting from scratch", "name", p.Name(), "dst", cfg.DstExecutor.Name(), "release", current)
+			current = ""
+		}
+	}
+
+	id := newTimestampID(time.Now(), releases)
+	newDir := filepath.Join(releasesDir, id)
+	currentInfo := &fileInfo{FullPath: newDir, DirPath: releasesDir}
+	if current != "" {
+		currentInfo = &fileInfo{FullPath: filepath.Join(releasesDir, current), DirPath: releasesDir, IsDirectory: true, Exists: true, DirExists: true}
+	}
+
+	// Each release has exactly the source's files, so anything else carried
+	// over from the current one is always removed.
+	plan, err := p.planSync(cfg, srcFileInfo, srcEntries, currentInfo, filter, true, 0)
+	if err != nil {
+		return config.SYNC_RESULT_NOCHANGE, err
+	}
+	if plan.result == config.SYNC_RESULT_NOCHANGE {
+		slog.Info("current release is up to date", "name", p.Name(), "dst", cfg.DstExecutor.Name(), "release", current)
+		return config.SYNC_RESULT_NOCHANGE, nil
+	}
+	if cfg.DryRun {
+		slog.Info("DRY RUN: creating release", "name", p.Name(), "dst", cfg.DstExecutor.Name(), "release", id, "from", current)
+		p.logPlan(cfg, plan)
+		return plan.result, nil
+	}
+
+	slog.Info("creating release", "name", p.Name(), "dst", cfg.DstExecutor.Name(), "release", id, "from", current)
+	if _, _, err := cfg.DstExecutor.ExecuteCommand("mkdir", "-p", releasesDir); err != nil {
+		return config.SYNC_RESULT_NOCHANGE, fmt.Errorf("failed to create %s on %s: %w", releasesDir, cfg.DstExecutor.Name(), err)
+	}
+	if current != "" {
+		// A full copy rather than hard links, since changed files are
+		// overwritten in place & that would change them in the old release
+		// too.
+		if _, stderr, err := cfg.DstExecutor.ExecuteCommand("cp", "-a", currentInfo.FullPath, newDir); err != nil {
+			return config.SYNC_RESULT_NOCHANGE, fmt.Errorf("failed to copy release %s to %s on %s (stderr: %s): %w", current, id, cfg.DstExecutor.Name(), strings.TrimSpace(stderr), err)
+		}
+		newInfo := &fileInfo{FullPath: newDir, DirPath: releasesDir, IsDirectory: true, Exists: true, DirExists: true}
+		if plan, err = p.planSync(cfg, srcFileInfo, srcEntries, newInfo, filter, true, 0); err != nil {
+			cfg.DstExecutor.ExecuteCommand("rm", "-rf", newDir)
+			return config.SYNC_RESULT_NOCHANGE, err
+		}
+	}
+	if err := p.applyPlan(cfg, srcFileInfo, srcEntries, plan); err != nil {
+		cfg.DstExecutor.ExecuteCommand("rm", "-rf", newDir)
+		return config.SYNC_RESULT_NOCHANGE, err
+	}
+
+	if err := switchRelease(cfg.DstExecutor, base, id); err != nil {
+		return config.SYNC_RESULT_NOCHANGE, err
+	}
+	if err := p.pruneReleases(cfg.DstExecutor, base, id, append(releases, id)); err != nil {
+		slog.Warn("failed to remove old releases", "name", p.Name(), "dst", cfg.DstExecutor.Name(), "err", err)
+	}
+	return plan.result, nil
+}
+
+// Makes the release with the given id current again on dst, so long as it's
+// still there.
+func (p *fileProvider) Rollback(dst config.Executor, id string, dryRun bool) error {
+	if !p.release {
+		return fmt.Errorf("asset %s is not deployed in release mode", p.Name())
+	}
+	dstFileInfo, err := getFileInfo("", p.dstPath, dst)
+	if err != nil {
+		return err
+	}
+	base := dstFileInfo.FullPath
+
+	releases := []string{}
+	if dstFileInfo.Exists {
+		if releases, err = listReleases(dst, base); err != nil {
+			return err
+		}
+	}
+	if !slices.Contains(releases, id) {
+		return fmt.Errorf("no release %s of asset %s on %s; releases: %s", id, p.Name(), dst.Name(), strings.Join(releases, ", "))
+	}
+
+	current, err := currentRelease(dst, base)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		slog.Info("DRY RUN: switching release", "name", p.Name(), "dst", dst.Name(), "release", id, "from", current)
+		return nil
+	}
+	slog.Info("switching release", "name", p.Name(), "dst", dst.Name(), "release", id, "from", current)
+	return switchRelease(dst, base, id)
+}
+
+func (p *fileProvider) HasRelease(dst config.Executor, id string) (bool, error) {
+	if !p.release {
+		return false, fmt.Errorf("asset %s is not deployed in release mode", p.Name())
+	}
+	dstFileInfo, err := getFileInfo("", p.dstPath, dst)
+	if err != nil || !dstFileInfo.Exists {
+		return false, err
+	}
+	releases, err := listReleases(dst, dstFileInfo.FullPath)
+	if err != nil {
+		return false, err
+	}
+	return slices.Contains(releases, id), nil
+}
+
+// Returns the id of the release the current symlink points to, or "" if there
+// isn't one.
+func currentRelease(exec config.Executor, base string) (string, error) {
+	link := filepath.Join(base, currentLinkName)
+	stdout, stderr, err := exec.ExecuteShell(fmt.Sprintf("readlink %s || true", executor.ShellQuote(link)))
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s on %s (stderr: %s): %w", link, exec.Name(), strings.TrimSpace(stderr), err)
+	}
+	target := strings.TrimSpace(stdout)
+	return strings.TrimPrefix(target, releasesDirName+"/"), nil
+}
+
+// Returns the ids of the releases under base, oldest first.
+func listReleases(exec config.Executor, base string) ([]string, error) {
+	return listTimestampIDs(exec, filepath.Join(base, releasesDirName))
+}
+
+// Returns the names in dir, which are ids from newTimestampID, oldest first.
+// There are none if dir doesn't exist.
+func listTimestampIDs(exec config.Executor, dir string) ([]string, error) {
+	stdout, stderr, err := exec.ExecuteShell(fmt.Sprintf("if [ -d %s ]; then ls -1 %s; fi", executor.ShellQuote(dir), executor.ShellQuote(dir)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s on %s (stderr: %s): %w", dir, exec.Name(), strings.TrimSpace(stderr), err)
+	}
+	ids := []string{}
+	for _, id := range strings.Split(strings.TrimSpace(stdout), "\n") {
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+	slices.SortFunc(ids, compareTimestampIDs)
+	return ids, nil
+}
+
+// Orders ids from newTimestampID by when they were made: by timestamp & then
+// by suffix, numerically (so that "-10" comes after "-2").
+func compareTimestampIDs(a, b string) int {
+	aTime, aSuffix := splitTimestampID(a)
+	bTime, bSuffix := splitTimestampID(b)
+	if c := strings.Compare(aTime, bTime); c != 0 {
+		return c
+	}
+	return aSuffix - bSuffix
+}
+
+// An id without a suffix is the first of its second.
+func splitTimestampID(id string) (string, int) {
+	timestamp, suffix, found := strings.Cut(id, "-")
+	if !found {
+		return id, 1
+	}
+	n, err := strconv.Atoi(suffix)
+	if err != nil {
+		return id, 0
+	}
+	return timestamp, n
+}
+
+// Ids are UTC timestamps, so that they sort in the order they were made; a
+// suffix is added if there's already one for this second.
+func newTimestampID(now time.Time, existing []string) string {
+	id := now.UTC().Format(timestampIDFormat)
+	for i := 2; slices.Contains(existing, id); i++ {
+		id = fmt.Sprintf("%s-%d", now.UTC().Format(timestampIDFormat), i)
+	}
+	return id
+}
+
+// Points the current symlink at the release. The new link is made alongside it
+// & renamed over it, so the switch is atomic.
+func switchRelease(exec config.Executor, base string, id string) error {
+	link := filepath.Join(base, currentLinkName)
+	tmpLink := filepath.Join(base, "."+currentLinkName+currentLinkTmpExt)
+	target := releasesDirName + "/" + id
+	cmd := fmt.Sprintf("ln -sfn %s %s && mv -T %s %s",
+		executor.ShellQuote(target), executor.ShellQuote(tmpLink), executor.ShellQuote(tmpLink), executor.ShellQuote(link))
+	if _, stderr, err := exec.ExecuteShell(cmd); err != nil {
+		return fmt.Errorf("failed to switch %s to release %s on %s (stderr: %s): %w", link, id, exec.Name(), strings.TrimSpace(stderr), err)
+	}
+	return nil
+}
+
+// Removes all but the newest keepReleases releases, never removing the
+// current one.
+func (p *fileProvider) pruneReleases(exec config.Executor, base string, current string, releases []string) error {
+	old := oldestIDs(releases, p.keepReleases, current)
+	if len(old) == 0 {
+		return nil
+	}
+	slog.Info("removing old releases", "name", p.Name(), "dst", exec.Name(), "releases", old)
+	return removeIDs(exec, filepath.Join(base, releasesDirName), old)
+}
+
+// Returns all but the newest keep of ids (oldest first), other than current.
+func oldestIDs(ids []string, keep int, current string) []string {
+	if len(ids) <= keep {
+		return nil
+	}
+	return slices.DeleteFunc(slices.Clone(ids[:len(ids)-keep]), func(id string) bool { return id == current })
+}
+
+func removeIDs(exec config.Executor, dir string, ids []string) error {
+	return executor.Xargs(exec, "rm -rf", util.Map(ids, func(id string) string { return filepath.Join(dir, id) }), nil)
+}
@@ -0,0 +1,160 @@
+package provider
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mrshanahan/deploy-assets/pkg/config"
+	"github.com/mrshanahan/deploy-assets/pkg/executor"
+	"github.com/mrshanahan/deploy-assets/pkg/transport"
+)
+
+func TestFileSyncRelease(t *testing.T) {
+	// Quotes & a $ in the path, which is spliced into the release commands.
+	rootPath := filepath.Join(t.TempDir(), `it's "$HOME"`)
+	srcRootPath, dstRootPath := filepath.Join(rootPath, "src"), filepath.Join(rootPath, "dst")
+	for _, e := range []fileDef{
+		{"app/main.conf", EARLY_MOD_TIME, "v1"},
+		{"app/nested/old.conf", EARLY_MOD_TIME, "old"},
+	} {
+		if err := createTestFile(srcRootPath, e); err != nil {
+			t.Fatalf("failed to create src test file: %v", err)
+		}
+	}
+
+	srcExecutor := executor.NewLocalExecutor("src", t.TempDir(), 0)
+	dstExecutor := executor.NewLocalExecutor("dst", t.TempDir(), 0)
+	defer srcExecutor.Close()
+	defer dstExecutor.Close()
+	base := filepath.Join(dstRootPath, "app")
+	sut := NewFileProvider("test", "", filepath.Join(srcRootPath, "app"), base, FileProviderOptions{Recursive: true, Force: true, Release: true, KeepReleases: 2})
+	cfg := config.SyncConfig{
+		SrcExecutor: srcExecutor,
+		DstExecutor: dstExecutor,
+		Transport:   transport.NewLocalTransport(),
+		DryRun:      false,
+	}
+
+	if result, err := sut.Sync(cfg); err != nil || result != config.SYNC_RESULT_CREATED {
+		t.Fatalf("expected first release to be created, got %v (err: %v)", result, err)
+	}
+	first := readCurrentRelease(t, base)
+	if result, err := sut.Sync(cfg); err != nil || result != config.SYNC_RESULT_NOCHANGE {
+		t.Fatalf("expected no new release for an unchanged source, got %v (err: %v)", result, err)
+	}
+
+	if err := createTestFile(srcRootPath, fileDef{"app/main.conf", LATER_MOD_TIME, "v2"}); err != nil {
+		t.Fatalf("failed to update src test file: %v", err)
+	}
+	os.RemoveAll(filepath.Join(srcRootPath, "app", "nested"))
+	if result, err := sut.Sync(cfg); err != nil || result != config.SYNC_RESULT_UPDATED {
+		t.Fatalf("expected second release to be an update, got %v (err: %v)", result, err)
+	}
+	second := readCurrentRelease(t, base)
+	if second == first {
+		t.Fatalf("expected a new release, got %s again", second)
+	}
+	assertFileContents(t, filepath.Join(base, "current", "main.conf"), "v2")
+	if _, err := os.Stat(filepath.Join(base, "current", "nested")); !os.IsNotExist(err) {
+		t.Errorf("expected files missing from the source to be left out of the release, got %v", err)
+	}
+	// The previous release is left as it was.
+	assertFileContents(t, filepath.Join(base, releasesDirName, first, "main.conf"), "v1")
+	assertFileContents(t, filepath.Join(base, releasesDirName, first, "nested", "old.conf"), "old")
+
+	if err := createTestFile(srcRootPath, fileDef{"app/main.conf", EARLY_MOD_TIME, "v3"}); err != nil {
+		t.Fatalf("failed to update src test file: %v", err)
+	}
+	if result, err := sut.Sync(cfg); err != nil || result != config.SYNC_RESULT_UPDATED {
+		t.Fatalf("expected third release to be an update, got %v (err: %v)", result, err)
+	}
+	third := readCurrentRelease(t, base)
+	releases, err := listReleases(dstExecutor, base)
+	if err != nil {
+		t.Fatalf("failed to list releases: %v", err)
+	}
+	if expected := []string{second, third}; !reflect.DeepEqual(expected, releases) {
+		t.Errorf("expected only the last 2 releases %v to be kept, got %v", expected, releases)
+	}
+
+	rollback := sut.(config.ReleaseProvider)
+	if err := rollback.Rollback(dstExecutor, second, true); err != nil {
+		t.Fatalf("dry run rollback failed: %v", err)
+	}
+	if current := readCurrentRelease(t, base); current != third {
+		t.Errorf("expected dry run to leave %s current, got %s", third, current)
+	}
+	if err := rollback.Rollback(dstExecutor, second, false); err != nil {
+		t.Fatalf("rollback failed: %v", err)
+	}
+	if current := readCurrentRelease(t, base); current != second {
+		t.Errorf("expected %s to be current after rollback, got %s", second, current)
+	}
+	assertFileContents(t, filepath.Join(base, "current", "main.conf"), "v2")
+	if err := rollback.Rollback(dstExecutor, first, false); err == nil || !strings.Contains(err.Error(), "no release") {
+		t.Errorf("expected rollback to a removed release to fail, got %v", err)
+	}
+}
+
+func TestFileSyncReleaseRequiresDirectory(t *testing.T) {
+	rootPath := t.TempDir()
+	if err := createTestFile(rootPath, fileDef{"src/main.conf", EARLY_MOD_TIME, "v1"}); err != nil {
+		t.Fatalf("failed to create src test file: %v", err)
+	}
+
+	srcExecutor := executor.NewLocalExecutor("src", t.TempDir(), 0)
+	dstExecutor := executor.NewLocalExecutor("dst", t.TempDir(), 0)
+	defer srcExecutor.Close()
+	defer dstExecutor.Close()
+	sut := NewFileProvider("test", "", filepath.Join(rootPath, "src", "main.conf"), filepath.Join(rootPath, "dst", "main.conf"), FileProviderOptions{Force: true, Release: true, KeepReleases: 5})
+	_, err := sut.Sync(config.SyncConfig{
+		SrcExecutor: srcExecutor,
+		DstExecutor: dstExecutor,
+		Transport:   transport.NewLocalTransport(),
+		DryRun:      false,
+	})
+	if err == nil || !strings.Contains(err.Error(), "requires a source directory") {
+		t.Errorf("expected release mode to reject a source file, got %v", err)
+	}
+}
+
+func TestNewTimestampID(t *testing.T) {
+	now := time.Date(2025, 3, 4, 5, 6, 7, 0, time.UTC)
+	if id := newTimestampID(now, nil); id != "20250304T050607Z" {
+		t.Errorf("expected timestamp id, got %s", id)
+	}
+	if id := newTimestampID(now, []string{"20250304T050607Z", "20250304T050607Z-2"}); id != "20250304T050607Z-3" {
+		t.Errorf("expected suffixed id, got %s", id)
+	}
+
+	ids := []string{"20250304T050608Z", "20250304T050607Z-10", "20250304T050607Z-2", "20250304T050607Z"}
+	slices.SortFunc(ids, compareTimestampIDs)
+	if expected := []string{"20250304T050607Z", "20250304T050607Z-2", "20250304T050607Z-10", "20250304T050608Z"}; !slices.Equal(expected, ids) {
+		t.Errorf("expected ids in the order they were made %v, got %v", expected, ids)
+	}
+}
+
+func readCurrentRelease(t *testing.T, base string) string {
+	t.Helper()
+	target, err := os.Readlink(filepath.Join(base, currentLinkName))
+	if err != nil {
+		t.Fatalf("failed to read current release: %v", err)
+	}
+	return strings.TrimPrefix(target, releasesDirName+"/")
+}
+
+func assertFileContents(t *testing.T, path string, expected string) {
+	t.Helper()
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", path, err)
+	}
+	if string(contents) != expected {
+		t.Errorf("expected %s to contain '%s', got '%s'", path, expected, string(contents))
+	}
+}
@@ -1,121 +1,379 @@
 package runner
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
+	"strings"
 
 	"github.com/mrshanahan/deploy-assets/internal/util"
+	"github.com/mrshanahan/deploy-assets/pkg/artifact"
 	"github.com/mrshanahan/deploy-assets/pkg/config"
+	"github.com/mrshanahan/deploy-assets/pkg/executor"
 	"github.com/mrshanahan/deploy-assets/pkg/manifest"
 )
 
-func Execute(m *manifest.Manifest, dryRun bool, continueOnError bool) error {
+// Syncs every asset to its destinations, returning what happened to each
+// (up to the failure, if one stops the run).
+func Execute(m *manifest.Manifest, dryRun bool, continueOnError bool) (*Summary, error) {
 	for _, e := range util.Values(m.Executors) {
 		defer e.Close()
 	}
 
+	summary := &Summary{}
+	validations := make(map[transportValidation]error)
 	for _, providerConfig := range m.Providers {
 		src, dst := providerConfig.Src, providerConfig.Dst
 		srcExecutor := m.Executors[src]
-		var dstExecutors []config.Executor
-		if dst == "*" {
-			dstExecutors = util.Filter(util.Values(m.Executors), func(e config.Executor) bool { return e.Name() != src })
-		} else {
-			dstExecutors = []config.Executor{m.Executors[dst]}
+		dstNames, err := m.ResolveDestinations(src, dst)
+		if err != nil {
+			return summary, fmt.Errorf("failed to resolve destinations for asset %s: %w", providerConfig.Provider.Name(), err)
+		}
+		dstExecutors := util.Map(dstNames, func(n string) config.Executor { return m.Executors[n] })
+		if len(dstExecutors) == 0 {
+			slog.Warn("asset has no destinations; skipping", "asset", providerConfig.Provider.Name(), "src", src, "dst", dst)
+			continue
 		}
 
-		if err := m.Transport.Validate(srcExecutor); err != nil {
-			return fmt.Errorf("failed to validate transport accessibility from source %s: %w", src, err)
+		if err := syncAsset(m, validations, summary, providerConfig, srcExecutor, dstExecutors, dryRun, continueOnError); err != nil {
+			return summary, err
 		}
-		for _, dstExecutor := range dstExecutors {
-			if err := m.Transport.Validate(dstExecutor); err != nil {
-				if !continueOnError {
-					return fmt.Errorf("failed to validate transport accessibility from destination %s: %w",
-						dstExecutor.Name(), err)
-				} else {
-					slog.Warn("failed to validate transport accessibility from destination; continuing with remaining destinations despite error",
-						"dst", dstExecutor.Name(),
-						"err", err)
-				}
+	}
+
+	return summary, nil
+}
+
+// Makes the given release of the asset current again on each of its
+// destinations & re-runs its post-commands there, as if it had just been
+// updated. Nothing is switched unless every destination has the release.
+func Rollback(m *manifest.Manifest, assetName string, id string, dryRun bool) error {
+	for _, e := range util.Values(m.Executors) {
+		defer e.Close()
+	}
+
+	providerConfig, err := findAsset(m, assetName)
+	if err != nil {
+		return err
+	}
+	releaseProvider, ok := providerConfig.Provider.(config.ReleaseProvider)
+	if !ok {
+		return fmt.Errorf("asset %s cannot be rolled back", assetName)
+	}
+
+	// Checked on every destination first, so that one without the release
+	// doesn't fail the rollback after others have been switched to it.
+	dstNames, err := m.ResolveDestinations(providerConfig.Src, providerConfig.Dst)
+	if err != nil {
+		return fmt.Errorf("failed to resolve destinations for asset %s: %w", assetName, err)
+	}
+	missing := []string{}
+	for _, dstName := range dstNames {
+		found, err := releaseProvider.HasRelease(m.Executors[dstName], id)
+		if err != nil {
+			return fmt.Errorf("failed to look for release %s of asset %s on %s: %w", id, assetName, dstName, err)
+		}
+		if !found {
+			missing = append(missing, dstName)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("no release %s of asset %s on %s; nothing was rolled back", id, assetName, strings.Join(missing, ", "))
+	}
+
+	return revertAsset(m, providerConfig, dryRun, func(dst config.Executor) error {
+		if err := releaseProvider.Rollback(dst, id, dryRun); err != nil {
+			return fmt.Errorf("failed to roll back asset %s on %s: %w", assetName, dst.Name(), err)
+		}
+		return nil
+	})
+}
+
+// Restores the files the asset's backup with the given id (or its latest, if
+// id is empty) was taken of on each of its destinations & re-runs its
+// post-commands there, as if it had just been updated.
+func Restore(m *manifest.Manifest, assetName string, id string, dryRun bool) error {
+	for _, e := range util.Values(m.Executors) {
+		defer e.Close()
+	}
+
+	providerConfig, err := findAsset(m, assetName)
+	if err != nil {
+		return err
+	}
+	backupProvider, ok := providerConfig.Provider.(config.BackupProvider)
+	if !ok {
+		return fmt.Errorf("asset %s cannot be restored", assetName)
+	}
+	return revertAsset(m, providerConfig, dryRun, func(dst config.Executor) error {
+		if err := backupProvider.Restore(dst, id, dryRun); err != nil {
+			return fmt.Errorf("failed to restore asset %s on %s: %w", assetName, dst.Name(), err)
+		}
+		return nil
+	})
+}
+
+func findAsset(m *manifest.Manifest, assetName string) (*config.ProviderConfig, error) {
+	idx := slices.IndexFunc(m.Providers, func(c *config.ProviderConfig) bool { return c.Provider.Name() == assetName })
+	if idx < 0 {
+		return nil, fmt.Errorf("no such asset: %s", assetName)
+	}
+	return m.Providers[idx], nil
+}
+
+// Calls revert for each of the asset's destinations, running its post-commands
+// there after each.
+func revertAsset(m *manifest.Manifest, providerConfig *config.ProviderConfig, dryRun bool, revert func(dst config.Executor) error) error {
+	src, dst := providerConfig.Src, providerConfig.Dst
+	srcExecutor := m.Executors[src]
+	dstNames, err := m.ResolveDestinations(src, dst)
+	if err != nil {
+		return fmt.Errorf("failed to resolve destinations for asset %s: %w", providerConfig.Provider.Name(), err)
+	}
+	for _, dstName := range dstNames {
+		dstExecutor := m.Executors[dstName]
+		if err := revert(dstExecutor); err != nil {
+			return err
+		}
+		if err := runPostCommands(providerConfig, srcExecutor, dstExecutor, m.Vars[dstName], config.SYNC_RESULT_UPDATED, dryRun, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Syncs the asset to each of its destinations in turn. Artifacts the asset
+// produces on its source are shared between the destinations & removed once
+// the last of them has been synced. The outcome for each is added to summary.
+func syncAsset(m *manifest.Manifest, validations map[transportValidation]error, summary *Summary, providerConfig *config.ProviderConfig, srcExecutor config.Executor, dstExecutors []config.Executor, dryRun bool, continueOnError bool) error {
+	artifacts := artifact.NewCache()
+	defer artifacts.Close()
+
+	for _, dstExecutor := range dstExecutors {
+		result := &AssetResult{Asset: providerConfig.Provider.Name(), Src: srcExecutor.Name(), Dst: dstExecutor.Name()}
+		summary.add(result)
+
+		if err := validateProvider(providerConfig.Provider, srcExecutor, dstExecutor); err != nil {
+			result.Err = err
+			if !continueOnError {
+				return fmt.Errorf("failed to sync asset %s (%s -> %s): %w",
+					providerConfig.Provider.Name(),
+					srcExecutor.Name(),
+					dstExecutor.Name(),
+					err)
+			} else {
+				slog.Warn("asset cannot be synced between locations; continuing with remaining destinations despite error",
+					"asset", providerConfig.Provider.Name(),
+					"src", srcExecutor.Name(),
+					"dst", dstExecutor.Name(),
+					"err", err)
+				continue
+			}
+		}
+
+		transportName, transport, err := selectTransport(m, validations, providerConfig, srcExecutor, dstExecutor)
+		result.Transport = transportName
+		if err != nil {
+			result.Err = err
+			if !continueOnError {
+				return fmt.Errorf("failed to find a usable transport for asset %s (%s -> %s): %w",
+					providerConfig.Provider.Name(),
+					srcExecutor.Name(),
+					dstExecutor.Name(),
+					err)
+			} else {
+				slog.Warn("failed to find a usable transport; continuing with remaining destinations despite error",
+					"asset", providerConfig.Provider.Name(),
+					"src", srcExecutor.Name(),
+					"dst", dstExecutor.Name(),
+					"err", err)
+				continue
 			}
+		}
 
-			syncResult, err := providerConfig.Provider.Sync(config.SyncConfig{
-				SrcExecutor: srcExecutor,
-				DstExecutor: dstExecutor,
-				Transport:   m.Transport,
-				DryRun:      dryRun,
-			})
-			if err != nil {
-				if !continueOnError {
-					return fmt.Errorf("failed to sync asset %s (%s -> %s): %w",
-						providerConfig.Provider.Name(),
-						srcExecutor.Name(),
-						dstExecutor.Name(),
-						err)
-				} else {
-					slog.Warn("failed to sync asset; continuing with remaining destinations despite error",
-						"asset", providerConfig.Provider.Name(),
-						"src", srcExecutor.Name(),
-						"dst", dstExecutor.Name(),
-						"err", err)
-				}
+		syncResult, err := providerConfig.Provider.Sync(config.SyncConfig{
+			SrcExecutor: srcExecutor,
+			DstExecutor: dstExecutor,
+			Transport:   transport,
+			DryRun:      dryRun,
+			Artifacts:   artifacts,
+		})
+		result.Result, result.Err, result.Verified = syncResult, err, takeVerified(transport)
+		if err != nil {
+			if !continueOnError {
+				return fmt.Errorf("failed to sync asset %s (%s -> %s): %w",
+					providerConfig.Provider.Name(),
+					srcExecutor.Name(),
+					dstExecutor.Name(),
+					err)
+			} else {
+				slog.Warn("failed to sync asset; continuing with remaining destinations despite error",
+					"asset", providerConfig.Provider.Name(),
+					"src", srcExecutor.Name(),
+					"dst", dstExecutor.Name(),
+					"err", err)
 			}
+		}
 
-			for _, postCommand := range providerConfig.PostCommands {
-				if postCommand.Trigger == "always" ||
-					(syncResult != config.SYNC_RESULT_NOCHANGE && postCommand.Trigger == "on_changed") ||
-					(syncResult == config.SYNC_RESULT_CREATED && postCommand.Trigger == "on_created") ||
-					(syncResult == config.SYNC_RESULT_UPDATED && postCommand.Trigger == "on_updated") {
-
-					if !dryRun {
-						slog.Info("executing post-command",
-							"command", postCommand.Command,
-							"trigger", postCommand.Trigger,
-							"synced", syncResult,
-							"asset", providerConfig.Provider.Name(),
-							"src", srcExecutor.Name(),
-							"dst", dstExecutor.Name())
-						stdout, stderr, err := dstExecutor.ExecuteShell(postCommand.Command)
-						if err != nil {
-							if !continueOnError {
-								return fmt.Errorf("failed to execute post-command on %s (%s -> %s) (stdout: %s) (stderr: %s): %w",
-									providerConfig.Provider.Name(),
-									srcExecutor.Name(),
-									dstExecutor.Name(),
-									stdout,
-									stderr,
-									err)
-							} else {
-								slog.Warn("failed to execute post-command; continuing with remaining destinations despite error",
-									"asset", providerConfig.Provider.Name(),
-									"src", srcExecutor.Name(),
-									"dst", dstExecutor.Name(),
-									"err", err,
-									"stdout", stdout,
-									"stderr", stderr)
-							}
-						}
+		if err := runPostCommands(providerConfig, srcExecutor, dstExecutor, m.Vars[dstExecutor.Name()], syncResult, dryRun, continueOnError); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Runs the asset's post-commands on the destination whose trigger matches the
+// result of syncing it, with the destination's vars exported to them.
+func runPostCommands(providerConfig *config.ProviderConfig, srcExecutor config.Executor, dstExecutor config.Executor, vars map[string]string, syncResult config.SyncResult, dryRun bool, continueOnError bool) error {
+	for _, postCommand := range providerConfig.PostCommands {
+		if postCommand.Trigger == "always" ||
+			(syncResult != config.SYNC_RESULT_NOCHANGE && postCommand.Trigger == "on_changed") ||
+			(syncResult == config.SYNC_RESULT_CREATED && postCommand.Trigger == "on_created") ||
+			(syncResult == config.SYNC_RESULT_UPDATED && postCommand.Trigger == "on_updated") {
+
+			if !dryRun {
+				slog.Info("executing post-command",
+					"command", postCommand.Command,
+					"trigger", postCommand.Trigger,
+					"synced", syncResult,
+					"asset", providerConfig.Provider.Name(),
+					"src", srcExecutor.Name(),
+					"dst", dstExecutor.Name())
+				stdout, stderr, err := dstExecutor.ExecuteShell(withVars(postCommand.Command, vars))
+				if err != nil {
+					if !continueOnError {
+						return fmt.Errorf("failed to execute post-command on %s (%s -> %s) (stdout: %s) (stderr: %s): %w",
+							providerConfig.Provider.Name(),
+							srcExecutor.Name(),
+							dstExecutor.Name(),
+							stdout,
+							stderr,
+							err)
 					} else {
-						slog.Info("DRY RUN: executing post-command",
-							"command", postCommand.Command,
-							"trigger", postCommand.Trigger,
-							"synced", syncResult,
+						slog.Warn("failed to execute post-command; continuing with remaining destinations despite error",
 							"asset", providerConfig.Provider.Name(),
 							"src", srcExecutor.Name(),
-							"dst", dstExecutor.Name())
+							"dst", dstExecutor.Name(),
+							"err", err,
+							"stdout", stdout,
+							"stderr", stderr)
 					}
-				} else {
-					slog.Debug("skipping post-command execution",
-						"command", postCommand.Command,
-						"trigger", postCommand.Trigger,
-						"synced", syncResult,
-						"asset", providerConfig.Provider.Name(),
-						"src", srcExecutor.Name(),
-						"dst", dstExecutor.Name())
 				}
+			} else {
+				slog.Info("DRY RUN: executing post-command",
+					"command", postCommand.Command,
+					"trigger", postCommand.Trigger,
+					"synced", syncResult,
+					"asset", providerConfig.Provider.Name(),
+					"src", srcExecutor.Name(),
+					"dst", dstExecutor.Name())
 			}
+		} else {
+			slog.Debug("skipping post-command execution",
+				"command", postCommand.Command,
+				"trigger", postCommand.Trigger,
+				"synced", syncResult,
+				"asset", providerConfig.Provider.Name(),
+				"src", srcExecutor.Name(),
+				"dst", dstExecutor.Name())
 		}
 	}
+	return nil
+}
+
+// Prefixes the command with exports of the vars, in name order.
+func withVars(command string, vars map[string]string) string {
+	names := util.Keys(vars)
+	slices.Sort(names)
+	exports := util.Map(names, func(n string) string { return "export " + n + "=" + executor.ShellQuote(vars[n]) + "\n" })
+	return strings.Join(exports, "") + command
+}
 
+// Checks that the provider can be used on both locations, if it needs more of
+// them than usual.
+func validateProvider(provider config.Provider, src config.Executor, dst config.Executor) error {
+	validating, ok := provider.(config.ValidatingProvider)
+	if !ok {
+		return nil
+	}
+	for _, e := range []config.Executor{src, dst} {
+		if err := validating.Validate(e); err != nil {
+			return fmt.Errorf("asset %s is not usable from %s: %w", provider.Name(), e.Name(), err)
+		}
+	}
 	return nil
 }
+
+type transportValidation struct {
+	transport string
+	location  string
+}
+
+// Returns the first of the transports selected for the asset that validates on
+// both locations, along with its name, trying those an auto transport picks
+// from in its place. Validation results are cached in validations, so each
+// transport is only validated once per location.
+func selectTransport(m *manifest.Manifest, validations map[transportValidation]error, providerConfig *config.ProviderConfig, src config.Executor, dst config.Executor) (string, config.Transport, error) {
+	errs := []error{}
+	for _, name := range m.SelectTransports(providerConfig, src.Name(), dst.Name()) {
+		if prefer := m.Transports[name].Prefer; prefer != nil {
+			for _, candidate := range prefer {
+				transport, err := validateTransport(m, validations, candidate, src, dst)
+				if err == nil {
+					slog.Info("auto transport picked",
+						"auto", name,
+						"transport", candidate,
+						"asset", providerConfig.Provider.Name(),
+						"src", src.Name(),
+						"dst", dst.Name())
+					return candidate, transport, nil
+				}
+				slog.Debug("transport failed validation", "auto", name, "transport", candidate, "src", src.Name(), "dst", dst.Name(), "err", err)
+				errs = append(errs, err)
+			}
+			continue
+		}
+
+		transport, err := validateTransport(m, validations, name, src, dst)
+		if err == nil {
+			if len(errs) > 0 {
+				slog.Info("falling back to another transport", "transport", name, "src", src.Name(), "dst", dst.Name())
+			}
+			return name, transport, nil
+		}
+		slog.Debug("transport failed validation", "transport", name, "src", src.Name(), "dst", dst.Name(), "err", err)
+		errs = append(errs, err)
+	}
+	return "", nil, errors.Join(errs...)
+}
+
+// Validates the named transport on both locations, & then between them if it
+// (or any transport it wraps) only works between certain pairs of locations.
+func validateTransport(m *manifest.Manifest, validations map[transportValidation]error, name string, src config.Executor, dst config.Executor) (config.Transport, error) {
+	transport := m.Transports[name].Transport
+	for _, e := range []config.Executor{src, dst} {
+		key := transportValidation{name, e.Name()}
+		validationErr, prs := validations[key]
+		if !prs {
+			validationErr = transport.Validate(e)
+			validations[key] = validationErr
+		}
+		if validationErr != nil {
+			return nil, fmt.Errorf("transport %s is not usable from %s: %w", name, e.Name(), validationErr)
+		}
+	}
+	for t := transport; t != nil; {
+		if pair, ok := t.(config.PairTransport); ok {
+			if err := pair.ValidatePair(src, dst); err != nil {
+				return nil, fmt.Errorf("transport %s is not usable from %s to %s: %w", name, src.Name(), dst.Name(), err)
+			}
+		}
+		wrapping, ok := t.(config.WrappingTransport)
+		if !ok {
+			break
+		}
+		t = wrapping.Unwrap()
+	}
+	return transport, nil
+}
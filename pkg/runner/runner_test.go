@@ -0,0 +1,652 @@
+package runner
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mrshanahan/deploy-assets/internal/s3test"
+	"github.com/mrshanahan/deploy-assets/internal/sshtest"
+	"github.com/mrshanahan/deploy-assets/pkg/config"
+	"github.com/mrshanahan/deploy-assets/pkg/manifest"
+)
+
+// Stands in for docker with one file per image under $FAKE_DOCKER_STATE,
+// holding the image's `docker image inspect` line. Only the subcommands &
+// formats used by the docker_image provider are supported.
+const fakeDocker = `#!/bin/bash
+set -e
+state="$FAKE_DOCKER_STATE"
+fullref() { case "$1" in *:*) echo "$1" ;; *) echo "$1:latest" ;; esac; }
+imagefile() { echo "$state/$(fullref "$1" | tr / _)"; }
+case "$1 $2" in
+"image ls")
+    shift 4
+    for f in "$state"/*; do
+        [ -e "$f" ] || continue
+        tag="$(cut -d, -f1 "$f")"
+        for arg in "$@"; do
+            case "$arg" in reference=*) [ "$tag" = "$(fullref "${arg#reference=}")" ] && echo "$tag" ;; esac
+        done
+    done
+    ;;
+"image inspect")
+    shift 4
+    for ref in "$@"; do
+        f="$(imagefile "$ref")"
+        [ -e "$f" ] || { echo "Error: No such image: $ref" >&2; exit 1; }
+        cat "$f"
+    done
+    ;;
+"save "*)
+    cp "$(imagefile "$2")" "$4"
+    ;;
+"load "*)
+    line="$(cat)"
+    echo "$line" > "$(imagefile "$(echo "$line" | cut -d, -f1)")"
+    echo "Loaded image: $(echo "$line" | cut -d, -f1)"
+    ;;
+*)
+    echo "fake docker: unsupported command: $*" >&2
+    exit 1
+    ;;
+esac
+`
+
+type endToEndEnv struct {
+	server         *sshtest.Server
+	srcDir         string
+	srcDockerState string
+	dstDockerState string
+	manifestDir    string
+	// Transport section of built manifests; scp to the server if not set.
+	transport map[string]any
+	// Used instead of transport when set.
+	transports []map[string]any
+}
+
+// Sets up a local source & an SSH destination served by sshtest, each with its
+// own fake docker, for manifests built by buildManifest.
+func newEndToEndEnv(t *testing.T) *endToEndEnv {
+	for _, c := range []string{"bash", "scp"} {
+		if _, err := exec.LookPath(c); err != nil {
+			t.Skipf("%s not found on path", c)
+		}
+	}
+
+	env := &endToEndEnv{
+		server:         sshtest.NewServer(t),
+		srcDir:         t.TempDir(),
+		srcDockerState: t.TempDir(),
+		dstDockerState: t.TempDir(),
+		manifestDir:    t.TempDir(),
+	}
+	if err := env.server.WriteExecutable("docker", fakeDocker); err != nil {
+		t.Fatalf("failed to write fake docker: %v", err)
+	}
+	env.server.Env = append(env.server.Env, "FAKE_DOCKER_STATE="+env.dstDockerState)
+
+	srcBinDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(srcBinDir, "docker"), []byte(fakeDocker), 0755); err != nil {
+		t.Fatalf("failed to write fake docker: %v", err)
+	}
+	t.Setenv("PATH", srcBinDir+":"+os.Getenv("PATH"))
+	t.Setenv("FAKE_DOCKER_STATE", env.srcDockerState)
+
+	for _, d := range []string{"etc", "srv"} {
+		if err := os.Mkdir(filepath.Join(env.server.Root, d), 0755); err != nil {
+			t.Fatalf("failed to create destination dir: %v", err)
+		}
+	}
+	return env
+}
+
+func (env *endToEndEnv) buildManifest(t *testing.T, assets ...map[string]any) *manifest.Manifest {
+	transport := env.transport
+	if transport == nil {
+		transport = map[string]any{
+			"type":     "scp",
+			"server":   env.server.Addr(),
+			"username": "deployer",
+			"key_file": env.server.KeyFile,
+			// The test server's host key is generated for each test.
+			"insecure_skip_host_key_check": true,
+		}
+	}
+	raw := map[string]any{
+		"locations": []map[string]any{
+			{"type": "local", "name": "src", "staging_dir": t.TempDir()},
+			{
+				"type":         "ssh",
+				"name":         "remote",
+				"server":       env.server.Addr(),
+				"username":     "deployer",
+				"key_file":     env.server.KeyFile,
+				"run_elevated": true,
+				"staging_dir":  t.TempDir(),
+			},
+		},
+		"assets": assets,
+	}
+	if env.transports != nil {
+		raw["transports"] = env.transports
+	} else {
+		raw["transport"] = transport
+	}
+	manifestBytes, err := json.Marshal(raw)
+	if err != nil {
+		t.Fatalf("failed to serialize manifest: %v", err)
+	}
+	root, err := manifest.ParseManifest(manifestBytes)
+	if err != nil {
+		t.Fatalf("failed to parse manifest: %v", err)
+	}
+	m, err := manifest.BuildManifest(env.manifestDir, root)
+	if err != nil {
+		t.Fatalf("failed to build manifest: %v", err)
+	}
+	return m
+}
+
+func writeFile(t *testing.T, path string, contents string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("failed to create dir for %s: %v", path, err)
+	}
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+}
+
+func assertFileContents(t *testing.T, path string, expected string) {
+	t.Helper()
+	actual, err := os.ReadFile(path)
+	if err != nil {
+		t.Errorf("failed to read %s: %v", path, err)
+	} else if string(actual) != expected {
+		t.Errorf("expected %s to contain %q, got %q", path, expected, string(actual))
+	}
+}
+
+func TestExecuteEndToEnd(t *testing.T) {
+	env := newEndToEndEnv(t)
+	writeFile(t, filepath.Join(env.srcDir, "app.conf"), "listen 8080\n")
+	writeFile(t, filepath.Join(env.srcDir, "site", "index.html"), "<h1>hi</h1>\n")
+	writeFile(t, filepath.Join(env.srcDir, "site", "css", "main.css"), "body {}\n")
+	writeFile(t, filepath.Join(env.srcDockerState, "example_app:latest"), "example/app:latest,sha256:4f1c2a9e0b7d,2025-05-30T17:22:41.518920375Z,\n")
+
+	root := env.server.Root
+	assets := []map[string]any{
+		{
+			"type":     "file",
+			"name":     "app-conf",
+			"src":      "src",
+			"dst":      "remote",
+			"src_path": filepath.Join(env.srcDir, "app.conf"),
+			"dst_path": filepath.Join(root, "etc", "app.conf"),
+			"post_command": []map[string]string{
+				{"command": "echo conf >> post-commands.log", "trigger": "on_changed"},
+			},
+		},
+		{
+			"type":      "file",
+			"name":      "site",
+			"src":       "src",
+			"dst":       "remote",
+			"src_path":  filepath.Join(env.srcDir, "site"),
+			"dst_path":  filepath.Join(root, "srv", "site"),
+			"recursive": true,
+		},
+		{
+			"type":     "literal",
+			"name":     "motd",
+			"src":      "src",
+			"dst":      "remote",
+			"value":    "welcome",
+			"dst_path": filepath.Join(root, "etc", "motd"),
+		},
+		{
+			"type":       "docker_image",
+			"name":       "app-image",
+			"src":        "src",
+			"dst":        "remote",
+			"repository": "example/app",
+			"post_command": []map[string]string{
+				{"command": "echo image >> post-commands.log", "trigger": "on_changed"},
+			},
+		},
+	}
+
+	summary, err := Execute(env.buildManifest(t, assets...), false, false)
+	if err != nil {
+		t.Fatalf("first run failed: %v", err)
+	}
+	assertFileContents(t, filepath.Join(root, "etc", "app.conf"), "listen 8080\n")
+	assertFileContents(t, filepath.Join(root, "srv", "site", "index.html"), "<h1>hi</h1>\n")
+	assertFileContents(t, filepath.Join(root, "srv", "site", "css", "main.css"), "body {}\n")
+	assertFileContents(t, filepath.Join(root, "etc", "motd"), "welcome")
+	assertFileContents(t, filepath.Join(env.dstDockerState, "example_app:latest"), "example/app:latest,sha256:4f1c2a9e0b7d,2025-05-30T17:22:41.518920375Z,\n")
+	assertFileContents(t, filepath.Join(root, "post-commands.log"), "conf\nimage\n")
+
+	if len(summary.Results) != len(assets) {
+		t.Fatalf("expected a result for each asset, got %d", len(summary.Results))
+	}
+	// Files are packaged before they're transferred, so it's the package
+	// that's verified; literals aren't transferred at all.
+	if r := summary.Results[0]; r.Asset != "app-conf" || r.Result != config.SYNC_RESULT_CREATED || len(r.Verified) != 1 || len(r.Verified[0].SHA256) != 64 {
+		t.Errorf("expected app-conf to be created with its package verified, got %+v", r)
+	}
+	if r := summary.Results[2]; r.Asset != "motd" || len(r.Verified) != 0 {
+		t.Errorf("expected nothing to be verified for motd, got %+v", r)
+	}
+	output := &strings.Builder{}
+	summary.Write(output)
+	for _, expected := range []string{"app-conf (src -> remote via scp1): created\n    verified ", "motd (src -> remote via scp1): created\n"} {
+		if !strings.Contains(output.String(), expected) {
+			t.Errorf("expected summary to contain %q, got:\n%s", expected, output.String())
+		}
+	}
+
+	// Nothing changed at the source, so a second run shouldn't trigger the
+	// post-commands again.
+	if _, err := Execute(env.buildManifest(t, assets...), false, false); err != nil {
+		t.Fatalf("second run failed: %v", err)
+	}
+	assertFileContents(t, filepath.Join(root, "post-commands.log"), "conf\nimage\n")
+
+	// A new image ID at the source is picked up, but a dry run leaves the
+	// destination alone.
+	writeFile(t, filepath.Join(env.srcDockerState, "example_app:latest"), "example/app:latest,sha256:93d0e5b1c7aa,2025-06-02T08:10:00.000000000Z,\n")
+	if _, err := Execute(env.buildManifest(t, assets...), true, false); err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+	assertFileContents(t, filepath.Join(env.dstDockerState, "example_app:latest"), "example/app:latest,sha256:4f1c2a9e0b7d,2025-05-30T17:22:41.518920375Z,\n")
+
+	if _, err := Execute(env.buildManifest(t, assets...), false, false); err != nil {
+		t.Fatalf("update run failed: %v", err)
+	}
+	assertFileContents(t, filepath.Join(env.dstDockerState, "example_app:latest"), "example/app:latest,sha256:93d0e5b1c7aa,2025-06-02T08:10:00.000000000Z,\n")
+	assertFileContents(t, filepath.Join(root, "post-commands.log"), "conf\nimage\nimage\n")
+}
+
+func TestExecuteEndToEndFailures(t *testing.T) {
+	env := newEndToEndEnv(t)
+	root := env.server.Root
+	missing := map[string]any{
+		"type":     "file",
+		"name":     "missing",
+		"src":      "src",
+		"dst":      "remote",
+		"src_path": filepath.Join(env.srcDir, "does-not-exist.conf"),
+		"dst_path": filepath.Join(root, "etc", "missing.conf"),
+	}
+	motd := map[string]any{
+		"type":     "literal",
+		"name":     "motd",
+		"src":      "src",
+		"dst":      "remote",
+		"value":    "welcome",
+		"dst_path": filepath.Join(root, "etc", "motd"),
+		"post_command": []map[string]string{
+			{"command": "exit 4", "trigger": "always"},
+		},
+	}
+
+	_, err := Execute(env.buildManifest(t, missing, motd), false, false)
+	if err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Fatalf("expected failure syncing missing file, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "etc", "motd")); !os.IsNotExist(err) {
+		t.Errorf("expected later assets to be skipped after a failure")
+	}
+
+	if _, err := Execute(env.buildManifest(t, missing, motd), false, true); err != nil {
+		t.Fatalf("expected errors to be tolerated with continueOnError, got %v", err)
+	}
+	assertFileContents(t, filepath.Join(root, "etc", "motd"), "welcome")
+
+	_, err = Execute(env.buildManifest(t, motd), false, false)
+	if err == nil || !strings.Contains(err.Error(), "failed to execute post-command on motd") {
+		t.Errorf("expected post-command failure, got %v", err)
+	}
+}
+
+func TestExecuteEndToEndInventoryVars(t *testing.T) {
+	env := newEndToEndEnv(t)
+	root := env.server.Root
+	inventory := map[string]any{
+		"hosts": map[string]any{
+			"web1": map[string]any{
+				"server": env.server.Addr(),
+				"vars":   map[string]any{"ROLE": "primary", "GREETING": `it's "$HOME"`, "WEIGHT": 2},
+			},
+		},
+	}
+	inventoryBytes, _ := json.Marshal(inventory)
+	writeFile(t, filepath.Join(env.manifestDir, "hosts.json"), string(inventoryBytes))
+
+	raw := map[string]any{
+		"locations": []map[string]any{{"type": "local", "name": "src", "staging_dir": t.TempDir()}},
+		"locations_from": []map[string]any{{
+			"type":         "file",
+			"path":         "hosts.json",
+			"username":     "deployer",
+			"key_file":     env.server.KeyFile,
+			"run_elevated": true,
+			"staging_dir":  t.TempDir(),
+		}},
+		"transport": map[string]any{"type": "stream"},
+		"assets": []map[string]any{{
+			"type":     "literal",
+			"name":     "motd",
+			"src":      "src",
+			"dst":      "web1",
+			"value":    "welcome",
+			"dst_path": filepath.Join(root, "etc", "motd"),
+			"post_command": []map[string]string{
+				{"command": `echo "$ROLE $WEIGHT $GREETING" > vars.log`, "trigger": "on_changed"},
+			},
+		}},
+	}
+	manifestBytes, _ := json.Marshal(raw)
+	parsed, err := manifest.ParseManifest(manifestBytes)
+	if err != nil {
+		t.Fatalf("failed to parse manifest: %v", err)
+	}
+	m, err := manifest.BuildManifest(env.manifestDir, parsed)
+	if err != nil {
+		t.Fatalf("failed to build manifest: %v", err)
+	}
+
+	if _, err := Execute(m, false, false); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	assertFileContents(t, filepath.Join(root, "vars.log"), "primary 2 it's \"$HOME\"\n")
+}
+
+func TestExecuteEndToEndStream(t *testing.T) {
+	env := newEndToEndEnv(t)
+	env.transport = map[string]any{"type": "stream"}
+	writeFile(t, filepath.Join(env.srcDir, "site", "index.html"), "<h1>hi</h1>\n")
+	writeFile(t, filepath.Join(env.srcDockerState, "example_app:latest"), "example/app:latest,sha256:4f1c2a9e0b7d,2025-05-30T17:22:41.518920375Z,\n")
+
+	root := env.server.Root
+	assets := []map[string]any{
+		{
+			"type":      "file",
+			"name":      "site",
+			"src":       "src",
+			"dst":       "remote",
+			"src_path":  filepath.Join(env.srcDir, "site"),
+			"dst_path":  filepath.Join(root, "srv", "site"),
+			"recursive": true,
+		},
+		{
+			"type":       "docker_image",
+			"name":       "app-image",
+			"src":        "src",
+			"dst":        "remote",
+			"repository": "example/app",
+		},
+	}
+
+	if _, err := Execute(env.buildManifest(t, assets...), false, false); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	assertFileContents(t, filepath.Join(root, "srv", "site", "index.html"), "<h1>hi</h1>\n")
+	assertFileContents(t, filepath.Join(env.dstDockerState, "example_app:latest"), "example/app:latest,sha256:4f1c2a9e0b7d,2025-05-30T17:22:41.518920375Z,\n")
+}
+
+func TestExecuteEndToEndS3(t *testing.T) {
+	env := newEndToEndEnv(t)
+	s3Server := s3test.NewServer(t, "deploy-bucket")
+	s3Server.SetEnv(t)
+	env.transport = map[string]any{"type": "s3", "bucket_url": "s3://deploy-bucket/transfers", "endpoint": s3Server.URL}
+	writeFile(t, filepath.Join(env.srcDir, "app.conf"), "listen 8080\n")
+
+	root := env.server.Root
+	asset := map[string]any{
+		"type":     "file",
+		"name":     "app-conf",
+		"src":      "src",
+		"dst":      "remote",
+		"src_path": filepath.Join(env.srcDir, "app.conf"),
+		"dst_path": filepath.Join(root, "etc", "app.conf"),
+	}
+	if _, err := Execute(env.buildManifest(t, asset), false, false); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	assertFileContents(t, filepath.Join(root, "etc", "app.conf"), "listen 8080\n")
+	if keys := s3Server.Keys("deploy-bucket"); len(keys) != 0 {
+		t.Errorf("expected bucket to be cleaned up, found %v", keys)
+	}
+}
+
+func TestExecuteEndToEndTransportFallback(t *testing.T) {
+	env := newEndToEndEnv(t)
+	s3Server := s3test.NewServer(t, "deploy-bucket")
+	s3Server.SetEnv(t)
+	env.transports = []map[string]any{
+		// Fails validation, as the bucket doesn't exist.
+		{"type": "s3", "name": "broken", "bucket_url": "s3://missing-bucket", "endpoint": s3Server.URL, "default": true, "fallback": "pipe"},
+		{"type": "stream", "name": "pipe"},
+		{"type": "scp", "name": "copy", "server": env.server.Addr(), "username": "deployer", "key_file": env.server.KeyFile, "insecure_skip_host_key_check": true},
+		{"type": "s3", "name": "bucket", "bucket_url": "s3://deploy-bucket", "endpoint": s3Server.URL, "routes": []map[string]string{{"src": "src", "dst": "remote"}}, "fallback": "pipe"},
+	}
+	writeFile(t, filepath.Join(env.srcDir, "app.conf"), "listen 8080\n")
+
+	root := env.server.Root
+	routed := map[string]any{
+		"type":     "file",
+		"name":     "routed",
+		"src":      "src",
+		"dst":      "remote",
+		"src_path": filepath.Join(env.srcDir, "app.conf"),
+		"dst_path": filepath.Join(root, "etc", "routed.conf"),
+	}
+	fallback := map[string]any{
+		"type":      "literal",
+		"name":      "fallback",
+		"src":       "src",
+		"dst":       "remote",
+		"value":     "welcome",
+		"dst_path":  filepath.Join(root, "etc", "motd"),
+		"transport": "broken",
+	}
+	pinned := map[string]any{
+		"type":      "literal",
+		"name":      "pinned",
+		"src":       "src",
+		"dst":       "remote",
+		"value":     "pinned",
+		"dst_path":  filepath.Join(root, "etc", "pinned"),
+		"transport": "copy",
+	}
+	if _, err := Execute(env.buildManifest(t, routed, fallback, pinned), false, false); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	assertFileContents(t, filepath.Join(root, "etc", "routed.conf"), "listen 8080\n")
+	assertFileContents(t, filepath.Join(root, "etc", "motd"), "welcome")
+	assertFileContents(t, filepath.Join(root, "etc", "pinned"), "pinned")
+	if requests := s3Server.Requests(); !slices.Contains(requests, "HEAD /deploy-bucket") {
+		t.Errorf("expected routed asset to go through the bucket, got requests %v", requests)
+	}
+
+	env.transports = env.transports[:1]
+	env.transports[0]["fallback"] = []string{}
+	_, err := Execute(env.buildManifest(t, fallback), false, false)
+	if err == nil || !strings.Contains(err.Error(), "failed to find a usable transport for asset fallback") {
+		t.Errorf("expected failure without a usable transport, got %v", err)
+	}
+}
+
+func TestExecuteEndToEndAutoTransport(t *testing.T) {
+	env := newEndToEndEnv(t)
+	env.transports = []map[string]any{
+		{"type": "auto", "name": "auto", "prefer": []string{"local", "stream"}},
+	}
+	localDir := t.TempDir()
+	remote := map[string]any{
+		"type":     "literal",
+		"name":     "remote",
+		"src":      "src",
+		"dst":      "remote",
+		"value":    "remote",
+		"dst_path": filepath.Join(env.server.Root, "etc", "motd"),
+	}
+	local := map[string]any{
+		"type":     "literal",
+		"name":     "local",
+		"src":      "src",
+		"dst":      "src",
+		"value":    "local",
+		"dst_path": filepath.Join(localDir, "motd"),
+	}
+	m := env.buildManifest(t, remote, local)
+
+	validations := make(map[transportValidation]error)
+	src, dst := m.Executors["src"], m.Executors["remote"]
+	if name, transport, err := selectTransport(m, validations, m.Providers[0], src, dst); err != nil || name != "auto/stream" || transport != m.Transports["auto/stream"].Transport {
+		t.Errorf("expected stream to be picked for a remote destination, got %s (err: %v)", name, err)
+	}
+	if name, transport, err := selectTransport(m, validations, m.Providers[1], src, src); err != nil || name != "auto/local" || transport != m.Transports["auto/local"].Transport {
+		t.Errorf("expected local to be picked for a local destination, got %s (err: %v)", name, err)
+	}
+
+	summary, err := Execute(m, false, false)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if transports := []string{summary.Results[0].Transport, summary.Results[1].Transport}; !slices.Equal(transports, []string{"auto/stream", "auto/local"}) {
+		t.Errorf("expected the picked transports to be reported, got %v", transports)
+	}
+	assertFileContents(t, filepath.Join(env.server.Root, "etc", "motd"), "remote")
+	assertFileContents(t, filepath.Join(localDir, "motd"), "local")
+}
+
+func TestRollbackEndToEnd(t *testing.T) {
+	env := newEndToEndEnv(t)
+	writeFile(t, filepath.Join(env.srcDir, "site", "index.html"), "v1")
+
+	root := env.server.Root
+	site := map[string]any{
+		"type":      "file",
+		"name":      "site",
+		"src":       "src",
+		"dst":       "remote",
+		"src_path":  filepath.Join(env.srcDir, "site"),
+		"dst_path":  filepath.Join(root, "srv", "site"),
+		"recursive": true,
+		"release":   true,
+		"post_command": []map[string]string{
+			{"command": "echo reload >> post-commands.log", "trigger": "on_changed"},
+		},
+	}
+	if _, err := Execute(env.buildManifest(t, site), false, false); err != nil {
+		t.Fatalf("first run failed: %v", err)
+	}
+	first, err := os.Readlink(filepath.Join(root, "srv", "site", "current"))
+	if err != nil {
+		t.Fatalf("failed to read current release: %v", err)
+	}
+	// Compared by mtime, which is only to the second.
+	writeFile(t, filepath.Join(env.srcDir, "site", "index.html"), "v2")
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(env.srcDir, "site", "index.html"), later, later); err != nil {
+		t.Fatalf("failed to set mtime: %v", err)
+	}
+	if _, err := Execute(env.buildManifest(t, site), false, false); err != nil {
+		t.Fatalf("second run failed: %v", err)
+	}
+	assertFileContents(t, filepath.Join(root, "srv", "site", "current", "index.html"), "v2")
+
+	firstID := strings.TrimPrefix(first, "releases/")
+	if err := Rollback(env.buildManifest(t, site), "site", firstID, false); err != nil {
+		t.Fatalf("rollback failed: %v", err)
+	}
+	assertFileContents(t, filepath.Join(root, "srv", "site", "current", "index.html"), "v1")
+	assertFileContents(t, filepath.Join(root, "post-commands.log"), "reload\nreload\nreload\n")
+
+	if err := Rollback(env.buildManifest(t, site), "nope", firstID, false); err == nil || !strings.Contains(err.Error(), "no such asset") {
+		t.Errorf("expected rollback of an unknown asset to fail, got %v", err)
+	}
+}
+
+func TestRollbackRequiresReleaseOnEveryDestination(t *testing.T) {
+	env := newEndToEndEnv(t)
+	// The relative dst_path is under the server's root on the remote
+	// destination & under the working directory on the local one.
+	t.Chdir(t.TempDir())
+	writeFile(t, filepath.Join(env.srcDir, "site", "index.html"), "v1")
+
+	root := env.server.Root
+	site := map[string]any{
+		"type":      "file",
+		"name":      "site",
+		"src":       "src",
+		"dst":       "remote",
+		"src_path":  filepath.Join(env.srcDir, "site"),
+		"dst_path":  "srv/site",
+		"recursive": true,
+		"release":   true,
+	}
+	if _, err := Execute(env.buildManifest(t, site), false, false); err != nil {
+		t.Fatalf("first run failed: %v", err)
+	}
+	first, err := os.Readlink(filepath.Join(root, "srv", "site", "current"))
+	if err != nil {
+		t.Fatalf("failed to read current release: %v", err)
+	}
+	writeFile(t, filepath.Join(env.srcDir, "site", "index.html"), "v2")
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(env.srcDir, "site", "index.html"), later, later); err != nil {
+		t.Fatalf("failed to set mtime: %v", err)
+	}
+	if _, err := Execute(env.buildManifest(t, site), false, false); err != nil {
+		t.Fatalf("second run failed: %v", err)
+	}
+
+	site["dst"] = []string{"remote", "src"}
+	firstID := strings.TrimPrefix(first, "releases/")
+	if err := Rollback(env.buildManifest(t, site), "site", firstID, false); err == nil || !strings.Contains(err.Error(), "on src; nothing was rolled back") {
+		t.Fatalf("expected rollback to fail for the destination without the release, got %v", err)
+	}
+	assertFileContents(t, filepath.Join(root, "srv", "site", "current", "index.html"), "v2")
+}
+
+func TestRestoreEndToEnd(t *testing.T) {
+	env := newEndToEndEnv(t)
+	root := env.server.Root
+	motd := func(value string) map[string]any {
+		return map[string]any{
+			"type":     "literal",
+			"name":     "motd",
+			"src":      "src",
+			"dst":      "remote",
+			"value":    value,
+			"dst_path": filepath.Join(root, "etc", "motd"),
+			"backup":   true,
+			"post_command": []map[string]string{
+				{"command": "echo reload >> post-commands.log", "trigger": "on_updated"},
+			},
+		}
+	}
+	if _, err := Execute(env.buildManifest(t, motd("welcome")), false, false); err != nil {
+		t.Fatalf("first run failed: %v", err)
+	}
+	if _, err := Execute(env.buildManifest(t, motd("go away")), false, false); err != nil {
+		t.Fatalf("second run failed: %v", err)
+	}
+	assertFileContents(t, filepath.Join(root, "etc", "motd"), "go away")
+
+	if err := Restore(env.buildManifest(t, motd("go away")), "motd", "", false); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	assertFileContents(t, filepath.Join(root, "etc", "motd"), "welcome")
+	assertFileContents(t, filepath.Join(root, "post-commands.log"), "reload\nreload\n")
+}
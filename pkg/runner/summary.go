@@ -0,0 +1,69 @@
+package runner
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/mrshanahan/deploy-assets/pkg/config"
+)
+
+// What happened to each asset on each of its destinations during a run, in
+// the order they were synced.
+type Summary struct {
+	Results []*AssetResult
+}
+
+type AssetResult struct {
+	Asset string
+	Src   string
+	Dst   string
+	// Name of the transport picked for the destination, e.g. "auto/rsync" for
+	// one an auto transport built; empty if none could be.
+	Transport string
+	Result    config.SyncResult
+	// Why the asset couldn't be synced to the destination, if it couldn't.
+	Err error
+	// Files whose checksums were verified on the destination after they were
+	// transferred, if the transport verifies them.
+	Verified []config.VerifiedFile
+}
+
+func (s *Summary) add(result *AssetResult) {
+	s.Results = append(s.Results, result)
+}
+
+// Writes a line for each asset & destination, followed by the checksums
+// verified there.
+func (s *Summary) Write(w io.Writer) {
+	fmt.Fprintln(w, "Summary:")
+	for _, r := range s.Results {
+		outcome := r.Result.String()
+		if r.Err != nil {
+			outcome = fmt.Sprintf("failed: %v", r.Err)
+		}
+		via := ""
+		if r.Transport != "" {
+			via = " via " + r.Transport
+		}
+		fmt.Fprintf(w, "  %s (%s -> %s%s): %s\n", r.Asset, r.Src, r.Dst, via, outcome)
+		for _, v := range r.Verified {
+			fmt.Fprintf(w, "    verified %s sha256:%s\n", v.Path, v.SHA256)
+		}
+	}
+}
+
+// Returns the files verified by the transport (or any transport it wraps)
+// since they were last taken.
+func takeVerified(transport config.Transport) []config.VerifiedFile {
+	for t := transport; t != nil; {
+		if verifying, ok := t.(config.VerifyingTransport); ok {
+			return verifying.TakeVerified()
+		}
+		wrapping, ok := t.(config.WrappingTransport)
+		if !ok {
+			break
+		}
+		t = wrapping.Unwrap()
+	}
+	return nil
+}
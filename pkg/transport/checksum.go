@@ -0,0 +1,166 @@
+package transport
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/mrshanahan/deploy-assets/pkg/config"
+	"github.com/mrshanahan/deploy-assets/pkg/executor"
+)
+
+// How many times a transfer is retried after its checksums don't match.
+const ChecksumRetries = 2
+
+// Wraps a transport so that every file it transfers is verified by comparing
+// its SHA-256 on the source with that on the destination, transferring it
+// again (up to ChecksumRetries times) on a mismatch. Direct transports stay
+// direct, with each synced file verified the same way.
+func NewChecksumTransport(inner config.Transport) config.Transport {
+	t := &checksumTransport{inner: inner}
+	if direct, ok := inner.(config.DirectTransport); ok {
+		return &checksumDirectTransport{t, direct}
+	}
+	return t
+}
+
+type checksumTransport struct {
+	inner config.Transport
+
+	lock     sync.Mutex
+	verified []config.VerifiedFile
+}
+
+type checksumDirectTransport struct {
+	*checksumTransport
+	direct config.DirectTransport
+}
+
+func (t *checksumTransport) Unwrap() config.Transport { return t.inner }
+
+func (t *checksumTransport) TakeVerified() []config.VerifiedFile {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	verified := t.verified
+	t.verified = nil
+	return verified
+}
+
+func (t *checksumTransport) recordVerified(dst config.Executor, path string, sum string) {
+	slog.Info("verified transfer", "dst", dst.Name(), "dst-path", path, "sha256", sum)
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.verified = append(t.verified, config.VerifiedFile{Path: path, SHA256: sum})
+}
+
+func (t *checksumTransport) Yaml(indent int) string {
+	return t.inner.Yaml(indent)
+}
+
+func (t *checksumTransport) Validate(exec config.Executor) error {
+	if err := t.inner.Validate(exec); err != nil {
+		return err
+	}
+	if _, _, err := exec.ExecuteShell("command -v sha256sum"); err != nil {
+		return fmt.Errorf("could not find sha256sum on path, which is needed to verify transfers (set verify to false to skip verification): %w", err)
+	}
+	return nil
+}
+
+func (t *checksumTransport) TransferFile(src config.Executor, srcPath string, dst config.Executor, dstPath string) error {
+	return t.transfer(src, srcPath, dst, dstPath, false)
+}
+
+func (t *checksumTransport) TransferFileReusing(src config.Executor, srcPath string, dst config.Executor, dstPath string) error {
+	return t.transfer(src, srcPath, dst, dstPath, true)
+}
+
+func (t *checksumTransport) Release(src config.Executor, srcPath string) {
+	release(t.inner, src, srcPath)
+}
+
+func (t *checksumTransport) transfer(src config.Executor, srcPath string, dst config.Executor, dstPath string, reuse bool) error {
+	srcSums, err := executor.SHA256Sums(src, "", []string{srcPath})
+	if err != nil {
+		return fmt.Errorf("failed to checksum %s on %s: %w", srcPath, src.Name(), err)
+	}
+	srcSum := srcSums[srcPath]
+
+	for attempt := 0; ; attempt++ {
+		if err := transferWith(t.inner, src, srcPath, dst, dstPath, reuse); err != nil {
+			return err
+		}
+		dstSums, err := executor.SHA256Sums(dst, "", []string{dstPath})
+		if err != nil {
+			return fmt.Errorf("failed to checksum %s on %s: %w", dstPath, dst.Name(), err)
+		}
+		if dstSums[dstPath] == srcSum {
+			t.recordVerified(dst, dstPath, srcSum)
+			return nil
+		}
+		if attempt == ChecksumRetries {
+			return fmt.Errorf("checksum mismatch after transferring %s from %s to %s on %s %d times (expected sha256 %s, got %s)",
+				srcPath, src.Name(), dstPath, dst.Name(), attempt+1, srcSum, dstSums[dstPath])
+		}
+		slog.Warn("checksum mismatch after transfer; retrying",
+			"src", src.Name(), "src-path", srcPath, "dst", dst.Name(), "dst-path", dstPath,
+			"expected-sha256", srcSum, "actual-sha256", dstSums[dstPath], "attempt", attempt+1)
+		if reuse {
+			// Whatever was staged may be what's corrupt, so stage it afresh.
+			release(t.inner, src, srcPath)
+		}
+	}
+}
+
+func (t *checksumDirectTransport) SyncFiles(src config.Executor, srcDir string, dst config.Executor, dstDir string, relativePaths []string) error {
+	srcSums, err := executor.SHA256Sums(src, srcDir, relativePaths)
+	if err != nil {
+		return fmt.Errorf("failed to checksum files under %s on %s: %w", srcDir, src.Name(), err)
+	}
+
+	remaining := relativePaths
+	for attempt := 0; ; attempt++ {
+		if err := t.direct.SyncFiles(src, srcDir, dst, dstDir, remaining); err != nil {
+			return err
+		}
+		dstSums, err := executor.SHA256Sums(dst, dstDir, remaining)
+		if err != nil {
+			return fmt.Errorf("failed to checksum files under %s on %s: %w", dstDir, dst.Name(), err)
+		}
+		mismatched := []string{}
+		for _, p := range remaining {
+			if dstSums[p] != srcSums[p] {
+				mismatched = append(mismatched, p)
+			} else {
+				t.recordVerified(dst, filepath.Join(dstDir, p), srcSums[p])
+			}
+		}
+		if len(mismatched) == 0 {
+			return nil
+		}
+		if attempt == ChecksumRetries {
+			return fmt.Errorf("checksum mismatch after syncing %s from %s to %s on %s %d times",
+				strings.Join(mismatched, ", "), src.Name(), dstDir, dst.Name(), attempt+1)
+		}
+		slog.Warn("checksum mismatch after sync; retrying mismatched files",
+			"src", src.Name(), "dst", dst.Name(), "paths", mismatched, "attempt", attempt+1)
+		remaining = mismatched
+	}
+}
+
+// Transfers with inner, letting it keep what it staged for srcPath if reuse is
+// set & it's able to.
+func transferWith(inner config.Transport, src config.Executor, srcPath string, dst config.Executor, dstPath string, reuse bool) error {
+	if reusing, ok := inner.(config.ReusingTransport); ok && reuse {
+		return reusing.TransferFileReusing(src, srcPath, dst, dstPath)
+	}
+	return inner.TransferFile(src, srcPath, dst, dstPath)
+}
+
+func release(inner config.Transport, src config.Executor, srcPath string) {
+	if reusing, ok := inner.(config.ReusingTransport); ok {
+		reusing.Release(src, srcPath)
+	}
+}
@@ -0,0 +1,152 @@
+package transport
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mrshanahan/deploy-assets/pkg/config"
+	"github.com/mrshanahan/deploy-assets/pkg/executor"
+)
+
+// Copies files locally, writing garbage instead for the first corruptions
+// transfers (or for the first corruptions of each file, when synced).
+type corruptingTransport struct {
+	corruptions int
+	transfers   int
+	synced      [][]string
+	seen        map[string]int
+}
+
+func (t *corruptingTransport) Yaml(indent int) string { return "" }
+
+func (t *corruptingTransport) Validate(exec config.Executor) error { return nil }
+
+func (t *corruptingTransport) TransferFile(src config.Executor, srcPath string, dst config.Executor, dstPath string) error {
+	t.transfers++
+	if t.transfers <= t.corruptions {
+		return os.WriteFile(dstPath, []byte("garbage"), 0644)
+	}
+	_, _, err := dst.ExecuteCommand("cp", srcPath, dstPath)
+	return err
+}
+
+type corruptingDirectTransport struct {
+	*corruptingTransport
+}
+
+func (t *corruptingDirectTransport) SyncFiles(src config.Executor, srcDir string, dst config.Executor, dstDir string, relativePaths []string) error {
+	t.synced = append(t.synced, relativePaths)
+	for _, p := range relativePaths {
+		dstPath := filepath.Join(dstDir, p)
+		if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+			return err
+		}
+		t.seen[p]++
+		if strings.HasPrefix(filepath.Base(p), "flaky") && t.seen[p] <= t.corruptions {
+			if err := os.WriteFile(dstPath, []byte("garbage"), 0644); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, _, err := dst.ExecuteCommand("cp", filepath.Join(srcDir, p), dstPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestChecksumTransferFile(t *testing.T) {
+	local := executor.NewLocalExecutor("local", t.TempDir(), 0)
+	defer local.Close()
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "src file.txt")
+	if err := os.WriteFile(srcPath, []byte("contents\n"), 0644); err != nil {
+		t.Fatalf("failed to write source file: %v", err)
+	}
+	dstPath := filepath.Join(dir, "dst file.txt")
+
+	var tests = []struct {
+		name              string
+		corruptions       int
+		expectedTransfers int
+		expectErr         bool
+	}{
+		{"intact", 0, 1, false},
+		{"corrupted once", 1, 2, false},
+		{"always corrupted", ChecksumRetries + 1, ChecksumRetries + 1, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(s *testing.T) {
+			inner := &corruptingTransport{corruptions: test.corruptions}
+			transport := NewChecksumTransport(inner)
+			if _, ok := transport.(config.DirectTransport); ok {
+				s.Errorf("expected wrapped transport not to be direct")
+			}
+			err := transport.TransferFile(local, srcPath, local, dstPath)
+			if test.expectErr && (err == nil || !strings.Contains(err.Error(), "checksum mismatch")) {
+				s.Errorf("expected checksum mismatch, got %v", err)
+			} else if !test.expectErr && err != nil {
+				s.Errorf("expected transfer to succeed, got %v", err)
+			}
+			if inner.transfers != test.expectedTransfers {
+				s.Errorf("expected %d transfers, got %d", test.expectedTransfers, inner.transfers)
+			}
+		})
+	}
+}
+
+func TestChecksumSyncFiles(t *testing.T) {
+	local := executor.NewLocalExecutor("local", t.TempDir(), 0)
+	defer local.Close()
+	srcDir, dstDir := t.TempDir(), t.TempDir()
+	paths := []string{"steady.txt", "flaky one.txt", "sub/flaky-two.txt"}
+	for _, p := range paths {
+		path := filepath.Join(srcDir, p)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(p), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", p, err)
+		}
+	}
+
+	inner := &corruptingDirectTransport{&corruptingTransport{corruptions: 1, seen: make(map[string]int)}}
+	transport, ok := NewChecksumTransport(inner).(config.DirectTransport)
+	if !ok {
+		t.Fatalf("expected wrapped direct transport to stay direct")
+	}
+	if err := transport.SyncFiles(local, srcDir, local, dstDir, paths); err != nil {
+		t.Fatalf("expected sync to succeed after retrying, got %v", err)
+	}
+	expected := [][]string{paths, {"flaky one.txt", "sub/flaky-two.txt"}}
+	if !reflect.DeepEqual(expected, inner.synced) {
+		t.Errorf("expected only mismatched files to be retried: expected %v, got %v", expected, inner.synced)
+	}
+	for _, p := range paths {
+		if actual, _ := os.ReadFile(filepath.Join(dstDir, p)); string(actual) != p {
+			t.Errorf("expected %s to contain %q, got %q", p, p, string(actual))
+		}
+	}
+	// Each file is reported once, when it's finally verified.
+	verified := transport.(config.VerifyingTransport).TakeVerified()
+	verifiedPaths := []string{}
+	for _, v := range verified {
+		verifiedPaths = append(verifiedPaths, v.Path)
+	}
+	expectedPaths := []string{filepath.Join(dstDir, "steady.txt"), filepath.Join(dstDir, "flaky one.txt"), filepath.Join(dstDir, "sub/flaky-two.txt")}
+	if !reflect.DeepEqual(expectedPaths, verifiedPaths) {
+		t.Errorf("expected verified files %v, got %v", expectedPaths, verifiedPaths)
+	}
+	if again := transport.(config.VerifyingTransport).TakeVerified(); len(again) != 0 {
+		t.Errorf("expected verified files to only be taken once, got %v", again)
+	}
+
+	inner = &corruptingDirectTransport{&corruptingTransport{corruptions: ChecksumRetries + 1, seen: make(map[string]int)}}
+	err := NewChecksumTransport(inner).(config.DirectTransport).SyncFiles(local, srcDir, local, t.TempDir(), paths)
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch after syncing flaky one.txt, sub/flaky-two.txt") {
+		t.Errorf("expected checksum mismatch, got %v", err)
+	}
+}
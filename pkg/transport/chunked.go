@@ -0,0 +1,261 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mrshanahan/deploy-assets/internal/util"
+	"github.com/mrshanahan/deploy-assets/pkg/config"
+	"github.com/mrshanahan/deploy-assets/pkg/executor"
+)
+
+const DefaultChunkDir = "/var/tmp/deploy-assets-chunks"
+
+// Matches the names of chunks (their SHA-256 in hex), so that only those are
+// ever collected from a chunk_dir that may be shared with other programs.
+var chunkNamePattern = strings.Repeat("[0-9a-f]", 64)
+
+// Wraps a transport so that files larger than chunkSize are split into chunks
+// named by their SHA-256, of which only those not already in chunkDir on the
+// destination are transferred. Each chunk is verified as it arrives & the file
+// is reassembled from them once they're all there, after which they're
+// removed. Chunks that arrived before an interrupted transfer are kept, so
+// the next attempt (e.g. the next run) only sends the rest. Direct transports
+// stay direct; their syncs aren't chunked.
+func NewChunkedTransport(inner config.Transport, chunkSize int64, chunkDir string) (config.Transport, error) {
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("invalid chunk size %d: must be positive", chunkSize)
+	}
+	if chunkDir == "" {
+		chunkDir = DefaultChunkDir
+	}
+	t := &chunkedTransport{inner: inner, chunkSize: chunkSize, chunkDir: chunkDir, extracted: make(map[stagedObject][]string)}
+	if direct, ok := inner.(config.DirectTransport); ok {
+		return &chunkedDirectTransport{t, direct}, nil
+	}
+	return t, nil
+}
+
+type chunkedTransport struct {
+	inner     config.Transport
+	chunkSize int64
+	chunkDir  string
+
+	// Chunks extracted by TransferFileReusing, kept on the source (& staged by
+	// the inner transport) for later destinations until they're released.
+	lock      sync.Mutex
+	extracted map[stagedObject][]string
+}
+
+type chunkedDirectTransport struct {
+	*chunkedTransport
+	direct config.DirectTransport
+}
+
+func (t *chunkedTransport) Unwrap() config.Transport { return t.inner }
+
+func (t *chunkedTransport) Yaml(indent int) string {
+	return t.inner.Yaml(indent)
+}
+
+func (t *chunkedTransport) Validate(exec config.Executor) error {
+	if err := t.inner.Validate(exec); err != nil {
+		return err
+	}
+	for _, tool := range []string{"head", "tail", "sha256sum"} {
+		if _, _, err := exec.ExecuteShell(fmt.Sprintf("command -v %s", tool)); err != nil {
+			return fmt.Errorf("could not find %s on path, which is needed for chunked transfers (unset chunk_size to transfer files whole): %w", tool, err)
+		}
+	}
+	return nil
+}
+
+func (t *chunkedDirectTransport) SyncFiles(src config.Executor, srcDir string, dst config.Executor, dstDir string, relativePaths []string) error {
+	return t.direct.SyncFiles(src, srcDir, dst, dstDir, relativePaths)
+}
+
+func (t *chunkedTransport) TransferFile(src config.Executor, srcPath string, dst config.Executor, dstPath string) error {
+	return t.transfer(src, srcPath, dst, dstPath, false)
+}
+
+func (t *chunkedTransport) TransferFileReusing(src config.Executor, srcPath string, dst config.Executor, dstPath string) error {
+	return t.transfer(src, srcPath, dst, dstPath, true)
+}
+
+func (t *chunkedTransport) Release(src config.Executor, srcPath string) {
+	t.lock.Lock()
+	staged := stagedObject{src.Name(), srcPath}
+	chunkPaths := t.extracted[staged]
+	delete(t.extracted, staged)
+	t.lock.Unlock()
+
+	release(t.inner, src, srcPath)
+	for _, chunkPath := range chunkPaths {
+		release(t.inner, src, chunkPath)
+		src.ExecuteCommand("rm", "-f", chunkPath)
+	}
+}
+
+func (t *chunkedTransport) transfer(src config.Executor, srcPath string, dst config.Executor, dstPath string, reuse bool) error {
+	size, err := fileSize(src, srcPath)
+	if err != nil {
+		return err
+	}
+	if size <= t.chunkSize {
+		return transferWith(t.inner, src, srcPath, dst, dstPath, reuse)
+	}
+
+	chunks, err := t.chunkSums(src, srcPath, size)
+	if err != nil {
+		return err
+	}
+	// Identical chunks (e.g. runs of zeroes) only need to be sent once.
+	unique := []string{}
+	offsets := make(map[string]int64)
+	for i, sum := range chunks {
+		if _, prs := offsets[sum]; !prs {
+			offsets[sum] = int64(i) * t.chunkSize
+			unique = append(unique, sum)
+		}
+	}
+
+	stdout := &strings.Builder{}
+	chunkDir := executor.ShellQuote(t.chunkDir)
+	cmd := fmt.Sprintf(`mkdir -p %s && cd %s && while read -r sum; do [ -f "$sum" ] || echo "$sum"; done`, chunkDir, chunkDir)
+	if stderr, err := dst.ExecuteShellStreaming(cmd, strings.NewReader(strings.Join(unique, "\n")+"\n"), stdout); err != nil {
+		return fmt.Errorf("failed to list chunks in %s on %s (stderr: %s): %w", t.chunkDir, dst.Name(), strings.TrimSpace(stderr), err)
+	}
+	missing := strings.Fields(stdout.String())
+	if len(missing) < len(unique) {
+		slog.Info("resuming chunked transfer", "src", src.Name(), "src-path", srcPath, "dst", dst.Name(), "dst-path", dstPath,
+			"chunks-present", len(unique)-len(missing), "chunks-total", len(unique))
+	}
+
+	srcStagingDir, err := src.StagingDir()
+	if err != nil {
+		return err
+	}
+	for i, sum := range missing {
+		slog.Debug("transferring chunk", "src", src.Name(), "src-path", srcPath, "dst", dst.Name(), "chunk", sum, "index", i+1, "missing", len(missing))
+		if err := t.transferChunk(src, srcPath, srcStagingDir, offsets[sum], dst, sum, reuse); err != nil {
+			return err
+		}
+	}
+
+	cmd = fmt.Sprintf(`cd %s && while read -r sum; do cat "$sum" || exit 1; done > %s`, chunkDir, executor.ShellQuote(dstPath))
+	if stderr, err := dst.ExecuteShellStreaming(cmd, strings.NewReader(strings.Join(chunks, "\n")+"\n"), nil); err != nil {
+		return fmt.Errorf("failed to reassemble %s on %s from chunks in %s (stderr: %s): %w", dstPath, dst.Name(), t.chunkDir, strings.TrimSpace(stderr), err)
+	}
+	cmd = fmt.Sprintf(`cd %s && while read -r sum; do rm -f "$sum"; done`, chunkDir)
+	if stderr, err := dst.ExecuteShellStreaming(cmd, strings.NewReader(strings.Join(unique, "\n")+"\n"), nil); err != nil {
+		slog.Warn("failed to remove chunks after reassembly", "dst", dst.Name(), "dir", t.chunkDir, "stderr", stderr, "err", err)
+	}
+	return nil
+}
+
+// Chunks are kept between runs so that transfers can resume, but those older
+// than cutoff belong to transfers that were never finished. Anything else in
+// chunkDir is left alone.
+func (t *chunkedTransport) FindGarbage(locations []config.Executor, cutoff time.Time) ([]config.Garbage, error) {
+	garbage := []config.Garbage{}
+	errs := []error{}
+	for _, l := range locations {
+		found, err := executor.FindGarbage(l, t.chunkDir, []string{chunkNamePattern, chunkNamePattern + ".part"}, cutoff)
+		garbage = append(garbage, found...)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return garbage, errors.Join(errs...)
+}
+
+// Returns the SHA-256 of each chunkSize-sized piece of the file, in order.
+func (t *chunkedTransport) chunkSums(src config.Executor, srcPath string, size int64) ([]string, error) {
+	count := (size + t.chunkSize - 1) / t.chunkSize
+	cmd := fmt.Sprintf(
+		`i=0; while [ $i -lt %d ]; do tail -c +$((i * %d + 1)) %s | head -c %d | sha256sum | cut -d ' ' -f 1; i=$((i + 1)); done`,
+		count, t.chunkSize, executor.ShellQuote(srcPath), t.chunkSize)
+	stdout, stderr, err := src.ExecuteShell(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to checksum chunks of %s on %s (stderr: %s): %w", srcPath, src.Name(), strings.TrimSpace(stderr), err)
+	}
+	sums := strings.Fields(stdout)
+	if int64(len(sums)) != count {
+		return nil, fmt.Errorf("failed to checksum chunks of %s on %s: expected %d checksums, got %d", srcPath, src.Name(), count, len(sums))
+	}
+	return sums, nil
+}
+
+// Extracts the chunk at the given offset into the source's staging directory
+// & transfers it into the chunk directory, under a temporary name until it's
+// been verified so that a partial chunk is never mistaken for a whole one.
+// With reuse, the extracted chunk is kept for other destinations.
+func (t *chunkedTransport) transferChunk(src config.Executor, srcPath string, srcStagingDir string, offset int64, dst config.Executor, sum string, reuse bool) error {
+	chunkPath, err := t.extractChunk(src, srcPath, srcStagingDir, offset, sum, reuse)
+	if err != nil {
+		return err
+	}
+	if !reuse {
+		defer src.ExecuteCommand("rm", "-f", chunkPath)
+	}
+
+	partPath := filepath.Join(t.chunkDir, sum+".part")
+	for attempt := 0; ; attempt++ {
+		if err := transferWith(t.inner, src, chunkPath, dst, partPath, reuse); err != nil {
+			return fmt.Errorf("failed to transfer chunk %s of %s: %w", sum, srcPath, err)
+		}
+		dstSums, err := executor.SHA256Sums(dst, "", []string{partPath})
+		if err != nil {
+			return fmt.Errorf("failed to checksum chunk %s on %s: %w", partPath, dst.Name(), err)
+		}
+		if dstSums[partPath] == sum {
+			break
+		}
+		if attempt == ChecksumRetries {
+			dst.ExecuteCommand("rm", "-f", partPath)
+			return fmt.Errorf("checksum mismatch after transferring chunk %s of %s to %s %d times (got sha256 %s)",
+				sum, srcPath, dst.Name(), attempt+1, dstSums[partPath])
+		}
+		slog.Warn("checksum mismatch after transferring chunk; retrying", "src", src.Name(), "src-path", srcPath, "dst", dst.Name(), "chunk", sum, "actual-sha256", dstSums[partPath], "attempt", attempt+1)
+		if reuse {
+			release(t.inner, src, chunkPath)
+		}
+	}
+
+	if _, stderr, err := dst.ExecuteCommand("mv", partPath, filepath.Join(t.chunkDir, sum)); err != nil {
+		return fmt.Errorf("failed to move chunk %s into place on %s (stderr: %s): %w", sum, dst.Name(), strings.TrimSpace(stderr), err)
+	}
+	return nil
+}
+
+func (t *chunkedTransport) extractChunk(src config.Executor, srcPath string, srcStagingDir string, offset int64, sum string, reuse bool) (string, error) {
+	staged := stagedObject{src.Name(), srcPath}
+	chunkPath := filepath.Join(srcStagingDir, util.GetRandomFileName("chunk"))
+	if reuse {
+		chunkPath = filepath.Join(srcStagingDir, "chunk-"+sum)
+		t.lock.Lock()
+		extracted := slices.Contains(t.extracted[staged], chunkPath)
+		t.lock.Unlock()
+		if extracted {
+			return chunkPath, nil
+		}
+	}
+
+	cmd := fmt.Sprintf("tail -c +%d %s | head -c %d > %s", offset+1, executor.ShellQuote(srcPath), t.chunkSize, executor.ShellQuote(chunkPath))
+	if _, stderr, err := src.ExecuteShell(cmd); err != nil {
+		src.ExecuteCommand("rm", "-f", chunkPath)
+		return "", fmt.Errorf("failed to extract chunk %s of %s on %s (stderr: %s): %w", sum, srcPath, src.Name(), strings.TrimSpace(stderr), err)
+	}
+	if reuse {
+		t.lock.Lock()
+		t.extracted[staged] = append(t.extracted[staged], chunkPath)
+		t.lock.Unlock()
+	}
+	return chunkPath, nil
+}
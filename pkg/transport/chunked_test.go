@@ -0,0 +1,155 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mrshanahan/deploy-assets/pkg/config"
+	"github.com/mrshanahan/deploy-assets/pkg/executor"
+)
+
+// Copies files locally, failing every transfer after the first failAfter.
+type interruptedTransport struct {
+	corruptingTransport
+	failAfter int
+}
+
+func (t *interruptedTransport) TransferFile(src config.Executor, srcPath string, dst config.Executor, dstPath string) error {
+	if t.transfers >= t.failAfter {
+		return errors.New("connection reset")
+	}
+	return t.corruptingTransport.TransferFile(src, srcPath, dst, dstPath)
+}
+
+func newChunkedTestFile(t *testing.T) (string, []byte) {
+	// 10 chunks of 1KiB plus a partial one; the two chunks of zeroes are
+	// identical, so there are 10 unique chunks.
+	contents := make([]byte, 11*1024-100)
+	if _, err := rand.Read(contents); err != nil {
+		t.Fatalf("failed to generate contents: %v", err)
+	}
+	clear(contents[2*1024 : 4*1024])
+	srcPath := filepath.Join(t.TempDir(), "it's image.tar")
+	if err := os.WriteFile(srcPath, contents, 0644); err != nil {
+		t.Fatalf("failed to write source file: %v", err)
+	}
+	return srcPath, contents
+}
+
+func assertTransferred(t *testing.T, dstPath string, expected []byte) {
+	actual, err := os.ReadFile(dstPath)
+	if err != nil {
+		t.Fatalf("failed to read transferred file: %v", err)
+	}
+	if !bytes.Equal(actual, expected) {
+		t.Errorf("transferred file differs from source (%d bytes vs %d)", len(actual), len(expected))
+	}
+}
+
+func TestChunkedTransferFile(t *testing.T) {
+	local := executor.NewLocalExecutor("local", t.TempDir(), 0)
+	defer local.Close()
+	srcPath, contents := newChunkedTestFile(t)
+	chunkDir := filepath.Join(t.TempDir(), "it's chunks")
+
+	var tests = []struct {
+		name              string
+		chunkSize         int64
+		corruptions       int
+		expectedTransfers int
+	}{
+		{"smaller than a chunk", int64(len(contents)), 0, 1},
+		{"chunked", 1024, 0, 10},
+		{"chunk corrupted once", 1024, 1, 11},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(s *testing.T) {
+			inner := &corruptingTransport{corruptions: test.corruptions}
+			transport, err := NewChunkedTransport(inner, test.chunkSize, chunkDir)
+			if err != nil {
+				s.Fatalf("failed to create transport: %v", err)
+			}
+			if err := transport.Validate(local); err != nil {
+				s.Fatalf("validation failed: %v", err)
+			}
+			dstPath := filepath.Join(s.TempDir(), "it's image.tar")
+			if err := transport.TransferFile(local, srcPath, local, dstPath); err != nil {
+				s.Fatalf("transfer failed: %v", err)
+			}
+			assertTransferred(s, dstPath, contents)
+			if inner.transfers != test.expectedTransfers {
+				s.Errorf("expected %d transfers, got %d", test.expectedTransfers, inner.transfers)
+			}
+			if leftover, _ := os.ReadDir(chunkDir); len(leftover) > 0 {
+				s.Errorf("expected chunks to be removed after reassembly, found %d", len(leftover))
+			}
+		})
+	}
+}
+
+func TestChunkedTransferFileResumes(t *testing.T) {
+	local := executor.NewLocalExecutor("local", t.TempDir(), 0)
+	defer local.Close()
+	srcPath, contents := newChunkedTestFile(t)
+	chunkDir := filepath.Join(t.TempDir(), "chunks")
+	dstPath := filepath.Join(t.TempDir(), "image.tar")
+
+	interrupted := &interruptedTransport{failAfter: 4}
+	transport, err := NewChunkedTransport(interrupted, 1024, chunkDir)
+	if err != nil {
+		t.Fatalf("failed to create transport: %v", err)
+	}
+	if err := transport.TransferFile(local, srcPath, local, dstPath); err == nil {
+		t.Fatalf("expected interrupted transfer to fail")
+	}
+	if _, err := os.Stat(dstPath); err == nil {
+		t.Errorf("expected no file at destination after interrupted transfer")
+	}
+	if kept, _ := os.ReadDir(chunkDir); len(kept) != 4 {
+		t.Errorf("expected the 4 transferred chunks to be kept, found %d", len(kept))
+	}
+
+	resumed := &corruptingTransport{}
+	transport, err = NewChunkedTransport(resumed, 1024, chunkDir)
+	if err != nil {
+		t.Fatalf("failed to create transport: %v", err)
+	}
+	if err := transport.TransferFile(local, srcPath, local, dstPath); err != nil {
+		t.Fatalf("resumed transfer failed: %v", err)
+	}
+	assertTransferred(t, dstPath, contents)
+	if resumed.transfers != 6 {
+		t.Errorf("expected only the 6 missing chunks to be transferred, got %d", resumed.transfers)
+	}
+}
+
+func TestChunkedTransferFileFailures(t *testing.T) {
+	local := executor.NewLocalExecutor("local", t.TempDir(), 0)
+	defer local.Close()
+	srcPath, _ := newChunkedTestFile(t)
+	chunkDir := filepath.Join(t.TempDir(), "chunks")
+
+	if _, err := NewChunkedTransport(&corruptingTransport{}, 0, chunkDir); err == nil {
+		t.Errorf("expected zero chunk size to be rejected")
+	}
+
+	transport, err := NewChunkedTransport(&corruptingTransport{corruptions: 100}, 1024, chunkDir)
+	if err != nil {
+		t.Fatalf("failed to create transport: %v", err)
+	}
+	if err := transport.TransferFile(local, srcPath, local, filepath.Join(t.TempDir(), "image.tar")); err == nil {
+		t.Errorf("expected persistently corrupted chunk to fail")
+	}
+	if kept, _ := os.ReadDir(chunkDir); len(kept) != 0 {
+		t.Errorf("expected corrupted chunk not to be kept, found %d entries", len(kept))
+	}
+
+	bare := newBareLocalExecutor(t)
+	if err := transport.Validate(bare); err == nil {
+		t.Errorf("expected location without head, tail & sha256sum to fail validation")
+	}
+}
@@ -0,0 +1,118 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/mrshanahan/deploy-assets/internal/util"
+	"github.com/mrshanahan/deploy-assets/pkg/config"
+	"github.com/mrshanahan/deploy-assets/pkg/executor"
+)
+
+// Files are encrypted in the format written by `openssl enc` with these
+// arguments, so that locations with openssl can encrypt & decrypt their own
+// files while this process does the same for files it relays: "Salted__", an
+// 8-byte salt, then the ciphertext, with the key & IV derived from the
+// passphrase & salt with PBKDF2. CTR mode isn't authenticated; transfers are
+// verified by their checksums instead.
+const (
+	opensslEncArgs      = "-aes-256-ctr -pbkdf2 -iter 10000 -md sha256"
+	encryptedMagic      = "Salted__"
+	encryptedSaltLength = 8
+	encryptedIterations = 10000
+)
+
+// Returns a random passphrase for a single transfer, which only ever exists
+// in this process & is handed to locations on stdin.
+func newPassphrase() (string, error) {
+	passphrase := make([]byte, 32)
+	if _, err := rand.Read(passphrase); err != nil {
+		return "", fmt.Errorf("failed to generate encryption key: %w", err)
+	}
+	return hex.EncodeToString(passphrase), nil
+}
+
+func encryptionStream(passphrase string, salt []byte) (cipher.Stream, error) {
+	keyIV, err := pbkdf2.Key(sha256.New, passphrase, salt, encryptedIterations, 32+aes.BlockSize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(keyIV[:32])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewCTR(block, keyIV[32:]), nil
+}
+
+// Encrypts everything read from r.
+func encryptReader(r io.Reader, passphrase string) (io.Reader, error) {
+	salt := make([]byte, encryptedSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	stream, err := encryptionStream(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	header := append([]byte(encryptedMagic), salt...)
+	return io.MultiReader(bytes.NewReader(header), &cipher.StreamReader{S: stream, R: r}), nil
+}
+
+// Decrypts everything read from r, which must start with the header written by
+// encryptReader (or openssl).
+func decryptReader(r io.Reader, passphrase string) (io.Reader, error) {
+	header := make([]byte, len(encryptedMagic)+encryptedSaltLength)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("failed to read encryption header: %w", err)
+	}
+	if string(header[:len(encryptedMagic)]) != encryptedMagic {
+		return nil, errors.New("object is not encrypted in the expected format")
+	}
+	stream, err := encryptionStream(passphrase, header[len(encryptedMagic):])
+	if err != nil {
+		return nil, err
+	}
+	return &cipher.StreamReader{S: stream, R: r}, nil
+}
+
+// Whether the location's openssl can encrypt & decrypt files in the expected
+// format (which needs 1.1.1 or later, for -pbkdf2).
+func hasOpenSSL(exec config.Executor) bool {
+	_, _, err := exec.ExecuteShell(fmt.Sprintf("printf x | openssl enc %s -pass pass:probe > /dev/null", opensslEncArgs))
+	return err == nil
+}
+
+// Encrypts srcPath with openssl on the location into a new file in its staging
+// directory, returning the new file's path. The caller removes it.
+func encryptOn(exec config.Executor, srcPath string, passphrase string) (string, error) {
+	stagingDir, err := exec.StagingDir()
+	if err != nil {
+		return "", err
+	}
+	encryptedPath := filepath.Join(stagingDir, util.GetRandomFileName("encrypted"))
+	cmd := fmt.Sprintf("openssl enc %s -pass stdin -in %s -out %s", opensslEncArgs, executor.ShellQuote(srcPath), executor.ShellQuote(encryptedPath))
+	if stderr, err := exec.ExecuteShellStreaming(cmd, strings.NewReader(passphrase+"\n"), nil); err != nil {
+		exec.ExecuteCommand("rm", "-f", encryptedPath)
+		return "", fmt.Errorf("failed to encrypt %s on %s (stderr: %s): %w", srcPath, exec.Name(), strings.TrimSpace(stderr), err)
+	}
+	return encryptedPath, nil
+}
+
+// Decrypts encryptedPath with openssl on the location into dstPath.
+func decryptOn(exec config.Executor, encryptedPath string, dstPath string, passphrase string) error {
+	cmd := fmt.Sprintf("openssl enc -d %s -pass stdin -in %s -out %s", opensslEncArgs, executor.ShellQuote(encryptedPath), executor.ShellQuote(dstPath))
+	if stderr, err := exec.ExecuteShellStreaming(cmd, strings.NewReader(passphrase+"\n"), nil); err != nil {
+		return fmt.Errorf("failed to decrypt %s on %s (stderr: %s): %w", dstPath, exec.Name(), strings.TrimSpace(stderr), err)
+	}
+	return nil
+}
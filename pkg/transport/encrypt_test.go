@@ -0,0 +1,95 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/mrshanahan/deploy-assets/pkg/executor"
+)
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	contents := make([]byte, 100*1024+7)
+	rand.Read(contents)
+	passphrase, err := newPassphrase()
+	if err != nil {
+		t.Fatalf("failed to generate passphrase: %v", err)
+	}
+
+	encrypted, err := encryptReader(bytes.NewReader(contents), passphrase)
+	if err != nil {
+		t.Fatalf("failed to encrypt: %v", err)
+	}
+	ciphertext, _ := io.ReadAll(encrypted)
+	if bytes.Contains(ciphertext, contents[:64]) {
+		t.Errorf("expected contents not to appear in the ciphertext")
+	}
+	decrypted, err := decryptReader(bytes.NewReader(ciphertext), passphrase)
+	if err != nil {
+		t.Fatalf("failed to decrypt: %v", err)
+	}
+	if plaintext, _ := io.ReadAll(decrypted); !bytes.Equal(plaintext, contents) {
+		t.Errorf("expected decrypted contents to match the original")
+	}
+
+	wrong, _ := decryptReader(bytes.NewReader(ciphertext), "not-the-passphrase")
+	if plaintext, _ := io.ReadAll(wrong); bytes.Equal(plaintext, contents) {
+		t.Errorf("expected the wrong passphrase not to decrypt the contents")
+	}
+	if _, err := decryptReader(bytes.NewReader(contents), passphrase); err == nil {
+		t.Errorf("expected unencrypted contents to be rejected")
+	}
+}
+
+// Files encrypted by this process must be readable by openssl on a location &
+// vice versa, since either end of a transfer may be relayed.
+func TestEncryptionMatchesOpenSSL(t *testing.T) {
+	if _, err := exec.LookPath("openssl"); err != nil {
+		t.Skip("openssl not found on path")
+	}
+	local := executor.NewLocalExecutor("local", t.TempDir(), 0)
+	defer local.Close()
+	if !hasOpenSSL(local) {
+		t.Skip("openssl does not support -pbkdf2")
+	}
+	dir := t.TempDir()
+	contents := make([]byte, 50*1024+3)
+	rand.Read(contents)
+	passphrase, _ := newPassphrase()
+
+	srcPath := filepath.Join(dir, "it's src.bin")
+	if err := os.WriteFile(srcPath, contents, 0644); err != nil {
+		t.Fatalf("failed to write source file: %v", err)
+	}
+	encryptedPath, err := encryptOn(local, srcPath, passphrase)
+	if err != nil {
+		t.Fatalf("failed to encrypt with openssl: %v", err)
+	}
+	defer os.Remove(encryptedPath)
+	ciphertext, _ := os.ReadFile(encryptedPath)
+	decrypted, err := decryptReader(bytes.NewReader(ciphertext), passphrase)
+	if err != nil {
+		t.Fatalf("failed to decrypt: %v", err)
+	}
+	if plaintext, _ := io.ReadAll(decrypted); !bytes.Equal(plaintext, contents) {
+		t.Errorf("expected file encrypted by openssl to decrypt to the original")
+	}
+
+	encrypted, _ := encryptReader(bytes.NewReader(contents), passphrase)
+	ciphertext, _ = io.ReadAll(encrypted)
+	encryptedPath = filepath.Join(dir, "it's encrypted.bin")
+	if err := os.WriteFile(encryptedPath, ciphertext, 0644); err != nil {
+		t.Fatalf("failed to write encrypted file: %v", err)
+	}
+	dstPath := filepath.Join(dir, "it's dst.bin")
+	if err := decryptOn(local, encryptedPath, dstPath, passphrase); err != nil {
+		t.Fatalf("failed to decrypt with openssl: %v", err)
+	}
+	if plaintext, _ := os.ReadFile(dstPath); !bytes.Equal(plaintext, contents) {
+		t.Errorf("expected openssl to decrypt to the original")
+	}
+}
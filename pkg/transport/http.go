@@ -0,0 +1,237 @@
+package transport
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mrshanahan/deploy-assets/internal/util"
+	"github.com/mrshanahan/deploy-assets/pkg/config"
+	"github.com/mrshanahan/deploy-assets/pkg/executor"
+)
+
+// Tools a location can use to download a file over HTTP, in order of
+// preference.
+const (
+	httpToolCurl = "curl"
+	httpToolWget = "wget"
+	httpToolNone = ""
+)
+
+const DefaultHttpListenAddr = "127.0.0.1:0"
+
+// Serves each file from a temporary HTTP server in this process, which the
+// destination downloads it from with curl or wget. The file is streamed from
+// the source as it's served, so neither location needs credentials for, or
+// access to, anything other than the server. Destinations reached over SSH
+// connect to it through a remote port forward on their SSH connection unless
+// forward is false, in which case they need to be able to reach the listen
+// address directly (at url, if given). Files are served no faster than
+// bandwidthLimit bytes per second (if positive).
+func NewHttpTransport(name string, listen string, baseUrl string, forward bool, bandwidthLimit int64) (config.Transport, error) {
+	if listen == "" {
+		listen = DefaultHttpListenAddr
+	}
+	if _, _, err := net.SplitHostPort(listen); err != nil {
+		return nil, fmt.Errorf("invalid listen address '%s': %w", listen, err)
+	}
+	if baseUrl != "" {
+		u, err := url.Parse(baseUrl)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid url '%s': expected http[s]://<host>[:<port>]", baseUrl)
+		}
+	}
+	return &httpTransport{
+		name:    name,
+		listen:  listen,
+		baseUrl: strings.TrimRight(baseUrl, "/"),
+		forward: forward,
+		tools:   make(map[string]string),
+
+		bandwidthLimit: bandwidthLimit,
+	}, nil
+}
+
+type httpTransport struct {
+	name    string
+	listen  string
+	baseUrl string
+	forward bool
+
+	bandwidthLimit int64
+
+	lock  sync.Mutex
+	tools map[string]string
+}
+
+func (t *httpTransport) Yaml(indent int) string {
+	propIndent := util.YamlIndentString(indent + util.TabsToIndent(1))
+	return fmt.Sprintf(
+		`%shttp:
+%sname: %s
+%slisten: %s
+%surl: %s
+%sforward: %t`,
+		util.YamlIndentString(indent),
+		propIndent, t.name,
+		propIndent, t.listen,
+		propIndent, t.baseUrl,
+		propIndent, t.forward)
+}
+
+func (t *httpTransport) Validate(exec config.Executor) error {
+	if t.getTool(exec) == httpToolNone {
+		return fmt.Errorf("location '%s' has neither curl nor wget available on PATH, one of which is required by the http transport; install one or update PATH & try again", exec.Name())
+	}
+	_, canForward := exec.(config.PortForwarder)
+	_, isRemote := exec.(config.SSHExecutor)
+	if isRemote && !(canForward && t.forward) && t.baseUrl == "" && isLoopback(t.listen) {
+		return fmt.Errorf("location '%s' can't reach the http transport on %s; enable forward or listen on an address it can reach & set url", exec.Name(), t.listen)
+	}
+	return nil
+}
+
+func (t *httpTransport) getTool(exec config.Executor) string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if tool, prs := t.tools[exec.Name()]; prs {
+		return tool
+	}
+	tool := findHTTPTool(exec)
+	t.tools[exec.Name()] = tool
+	return tool
+}
+
+func (t *httpTransport) TransferFile(src config.Executor, srcPath string, dst config.Executor, dstPath string) error {
+	size, err := fileSize(src, srcPath)
+	if err != nil {
+		return err
+	}
+	token, err := newHttpToken()
+	if err != nil {
+		return err
+	}
+
+	listener, baseUrl, err := t.listenFor(dst)
+	if err != nil {
+		return err
+	}
+	limit := bandwidthLimit(t.bandwidthLimit, src, dst)
+
+	var srcLock sync.Mutex
+	var srcErr error
+	server := &http.Server{
+		ReadHeaderTimeout: 30 * time.Second,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet || r.URL.Path != "/"+token {
+				http.NotFound(w, r)
+				return
+			}
+			// Setting the length means a source failure part way through
+			// shows up as a short read, rather than a complete file.
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+			progress := startProgress(transferLabel(src.Name(), srcPath, dst.Name()), size)
+			defer progress.Finish()
+			if stderr, err := src.ExecuteShellStreaming("cat "+executor.ShellQuote(srcPath), nil, newMeter(progress, limit).Writer(w)); err != nil {
+				srcLock.Lock()
+				srcErr = fmt.Errorf("failed to read %s on %s (stderr: %s): %w", srcPath, src.Name(), strings.TrimSpace(stderr), err)
+				srcLock.Unlock()
+			}
+		}),
+	}
+	go server.Serve(listener)
+	// Shuts the server down & closes the listener (& with it any forward).
+	defer server.Close()
+
+	// The server holds the download to the limit itself.
+	cmd := httpDownloadCommand(t.getTool(dst), baseUrl+"/"+token, dstPath, 0)
+	slog.Debug("downloading file from http transport", "dst", dst.Name(), "url", baseUrl, "path", dstPath)
+	if _, stderr, err := dst.ExecuteShell(cmd); err != nil {
+		srcLock.Lock()
+		defer srcLock.Unlock()
+		if srcErr != nil {
+			// Any download error is most likely a consequence of this one.
+			return srcErr
+		}
+		return fmt.Errorf("failed to download %s to %s on %s (stderr: %s): %w", srcPath, dstPath, dst.Name(), strings.TrimSpace(stderr), err)
+	}
+	return nil
+}
+
+// Returns a listener the destination can reach, along with the URL it reaches
+// it at.
+func (t *httpTransport) listenFor(dst config.Executor) (net.Listener, string, error) {
+	if forwarder, ok := dst.(config.PortForwarder); ok && t.forward {
+		listener, err := forwarder.ListenRemote("127.0.0.1:0")
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to forward a port on %s: %w", dst.Name(), err)
+		}
+		return listener, "http://" + listener.Addr().String(), nil
+	}
+	listener, err := net.Listen("tcp", t.listen)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to listen on %s: %w", t.listen, err)
+	}
+	baseUrl := t.baseUrl
+	if baseUrl == "" {
+		baseUrl = "http://" + listener.Addr().String()
+	}
+	return listener, baseUrl, nil
+}
+
+func newHttpToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func findHTTPTool(exec config.Executor) string {
+	for _, candidate := range []string{httpToolCurl, httpToolWget} {
+		if _, _, err := exec.ExecuteShell(fmt.Sprintf("command -v %s", candidate)); err == nil {
+			return candidate
+		}
+	}
+	return httpToolNone
+}
+
+// Downloads no faster than limit bytes per second, if positive.
+func httpDownloadCommand(tool string, url string, dstPath string, limit int64) string {
+	if tool == httpToolWget {
+		return fmt.Sprintf("wget -q%s -O %s %s", httpLimitRateArg(tool, limit), executor.ShellQuote(dstPath), executor.ShellQuote(url))
+	}
+	return fmt.Sprintf("curl -fsS%s -o %s %s", httpLimitRateArg(tool, limit), executor.ShellQuote(dstPath), executor.ShellQuote(url))
+}
+
+func httpLimitRateArg(tool string, limit int64) string {
+	if limit <= 0 {
+		return ""
+	}
+	if tool == httpToolWget {
+		return fmt.Sprintf(" --limit-rate=%d", limit)
+	}
+	return fmt.Sprintf(" --limit-rate %d", limit)
+}
@@ -0,0 +1,137 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/rand"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mrshanahan/deploy-assets/pkg/config"
+)
+
+func TestHttpTransferFile(t *testing.T) {
+	if _, err := exec.LookPath("curl"); err != nil {
+		t.Skip("curl not found on path")
+	}
+	local, remote := newStreamTestExecutors(t)
+	contents := make([]byte, 2*1024*1024+3)
+	if _, err := rand.Read(contents); err != nil {
+		t.Fatalf("failed to generate contents: %v", err)
+	}
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "it's src.bin")
+	if err := os.WriteFile(srcPath, contents, 0644); err != nil {
+		t.Fatalf("failed to write source file: %v", err)
+	}
+
+	// A fixed port, so that destinations can be told where to find it.
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to find a free port: %v", err)
+	}
+	listen := probe.Addr().String()
+	probe.Close()
+	direct, err := NewHttpTransport("direct", listen, "http://"+listen, false, 0)
+	if err != nil {
+		t.Fatalf("failed to create transport: %v", err)
+	}
+	forwarded, err := NewHttpTransport("forwarded", "", "", true, 0)
+	if err != nil {
+		t.Fatalf("failed to create transport: %v", err)
+	}
+
+	var tests = []struct {
+		name      string
+		transport config.Transport
+		src       config.Executor
+		dst       config.Executor
+	}{
+		{"local to local", forwarded, local, local},
+		{"local to ssh with forward", forwarded, local, remote},
+		{"ssh to ssh with forward", forwarded, remote, remote},
+		{"ssh to local", forwarded, remote, local},
+		{"local to ssh without forward", direct, local, remote},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(s *testing.T) {
+			for _, e := range []config.Executor{test.src, test.dst} {
+				if err := test.transport.Validate(e); err != nil {
+					s.Fatalf("validation failed on %s: %v", e.Name(), err)
+				}
+			}
+			dstPath := filepath.Join(dir, "it's dst.bin")
+			defer os.Remove(dstPath)
+			if err := test.transport.TransferFile(test.src, srcPath, test.dst, dstPath); err != nil {
+				s.Fatalf("transfer failed: %v", err)
+			}
+			actual, err := os.ReadFile(dstPath)
+			if err != nil {
+				s.Fatalf("failed to read transferred file: %v", err)
+			}
+			if !bytes.Equal(actual, contents) {
+				s.Errorf("transferred file differs from source (%d bytes vs %d)", len(actual), len(contents))
+			}
+		})
+	}
+
+	// The server only lives as long as the transfer.
+	if conn, err := net.Dial("tcp", listen); err == nil {
+		conn.Close()
+		t.Errorf("expected server to be shut down after the transfer")
+	}
+}
+
+func TestHttpTransferFileFailures(t *testing.T) {
+	if _, err := exec.LookPath("curl"); err != nil {
+		t.Skip("curl not found on path")
+	}
+	local, remote := newStreamTestExecutors(t)
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "src.txt")
+	if err := os.WriteFile(srcPath, []byte("contents\n"), 0644); err != nil {
+		t.Fatalf("failed to write source file: %v", err)
+	}
+
+	transport, err := NewHttpTransport("http", "", "", true, 0)
+	if err != nil {
+		t.Fatalf("failed to create transport: %v", err)
+	}
+	if err := transport.TransferFile(remote, filepath.Join(dir, "missing.txt"), local, filepath.Join(dir, "out.txt")); err == nil {
+		t.Errorf("expected missing source file to fail")
+	}
+	if err := transport.TransferFile(local, srcPath, remote, filepath.Join(dir, "no-such-dir", "out.txt")); err == nil {
+		t.Errorf("expected unwritable destination to fail")
+	}
+
+	unforwarded, err := NewHttpTransport("http", "", "", false, 0)
+	if err != nil {
+		t.Fatalf("failed to create transport: %v", err)
+	}
+	if err := unforwarded.Validate(remote); err == nil || !strings.Contains(err.Error(), "can't reach") {
+		t.Errorf("expected ssh location to be unable to reach a loopback server without a forward, got %v", err)
+	}
+	if err := unforwarded.Validate(local); err != nil {
+		t.Errorf("expected local location to reach a loopback server, got %v", err)
+	}
+
+	bare := newBareLocalExecutor(t)
+	if err := transport.Validate(bare); err == nil {
+		t.Errorf("expected location without curl or wget to fail validation")
+	}
+}
+
+func TestNewHttpTransportInvalidArgs(t *testing.T) {
+	for _, args := range []struct{ listen, url string }{
+		{"no-port", ""},
+		{"", "ftp://example.com"},
+		{"", "http://"},
+	} {
+		if _, err := NewHttpTransport("http", args.listen, args.url, true, 0); err == nil {
+			t.Errorf("expected transport with %+v to be rejected", args)
+		}
+	}
+}
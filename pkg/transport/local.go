@@ -1,6 +1,7 @@
 package transport
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 
@@ -22,8 +23,23 @@ func (t *localTransport) Validate(exec config.Executor) error {
 	return nil
 }
 
+// Files are copied through this machine's filesystem, so both locations have
+// to be on it.
+func (t *localTransport) ValidatePair(src config.Executor, dst config.Executor) error {
+	for _, e := range []config.Executor{src, dst} {
+		if _, ok := e.(config.SSHExecutor); ok {
+			return fmt.Errorf("local transport can't copy files to or from remote location %s", e.Name())
+		}
+	}
+	return nil
+}
+
 func (t *localTransport) TransferFile(src config.Executor, srcPath string, dst config.Executor, dstPath string) error {
-	intDirPath := util.GetTempFilePath("deploy-assets-local-transfer")
+	stagingDir, err := dst.StagingDir()
+	if err != nil {
+		return err
+	}
+	intDirPath := filepath.Join(stagingDir, util.GetRandomFileName("local-transfer"))
 	if err := os.MkdirAll(intDirPath, 0700); err != nil {
 		return err
 	}
@@ -0,0 +1,274 @@
+package transport
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mrshanahan/deploy-assets/internal/util"
+	"github.com/mrshanahan/deploy-assets/pkg/config"
+	"github.com/mrshanahan/deploy-assets/pkg/executor"
+)
+
+// How often progress is redrawn on a terminal & logged otherwise.
+var (
+	ProgressRedrawInterval = 500 * time.Millisecond
+	ProgressLogInterval    = 10 * time.Second
+)
+
+// Where progress is drawn when it's a terminal. Progress is logged instead
+// when it isn't.
+var progressOutput io.Writer = os.Stderr
+
+func progressIsTerminal() bool {
+	f, ok := progressOutput.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func transferLabel(from string, srcPath string, to string) string {
+	return fmt.Sprintf("%s -> %s: %s", from, to, filepath.Base(srcPath))
+}
+
+// Tracks how much of a single transfer has been done, reporting it until
+// Finish is called.
+type transferProgress struct {
+	label string
+	total int64
+	start time.Time
+
+	lock        sync.Mutex
+	transferred int64
+	done        chan struct{}
+	finished    sync.WaitGroup
+}
+
+// Starts reporting the progress of a transfer of total bytes, or of an unknown
+// amount if total isn't positive.
+func startProgress(label string, total int64) *transferProgress {
+	p := &transferProgress{
+		label: label,
+		total: total,
+		start: time.Now(),
+		done:  make(chan struct{}),
+	}
+	p.finished.Add(1)
+	go p.report(progressIsTerminal())
+	return p
+}
+
+func (p *transferProgress) Add(n int64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.transferred += n
+}
+
+// For transfers whose progress is polled rather than counted.
+func (p *transferProgress) Set(n int64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.transferred = n
+}
+
+func (p *transferProgress) Finish() {
+	close(p.done)
+	p.finished.Wait()
+}
+
+func (p *transferProgress) report(terminal bool) {
+	defer p.finished.Done()
+	interval := ProgressLogInterval
+	if terminal {
+		interval = ProgressRedrawInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	drawn := false
+	for {
+		select {
+		case <-ticker.C:
+			if terminal {
+				fmt.Fprintf(progressOutput, "\r\033[K%s", p.String())
+				drawn = true
+			} else {
+				transferred, rate, eta := p.snapshot()
+				slog.Info("transfer progress", "transfer", p.label, "transferred", transferred, "total", p.total,
+					"rate", util.HumanReadableSize(rate)+"/s", "eta", eta)
+			}
+		case <-p.done:
+			if drawn {
+				fmt.Fprintf(progressOutput, "\r\033[K%s\n", p.String())
+			}
+			return
+		}
+	}
+}
+
+// Returns the bytes transferred so far, the average rate in bytes per second &
+// the estimated time remaining (or -1 if there's no estimate yet).
+func (p *transferProgress) snapshot() (int64, int64, time.Duration) {
+	p.lock.Lock()
+	transferred := p.transferred
+	p.lock.Unlock()
+
+	elapsed := time.Since(p.start)
+	rate := int64(0)
+	if elapsed > 0 {
+		rate = int64(float64(transferred) / elapsed.Seconds())
+	}
+	eta := time.Duration(-1)
+	if rate > 0 && p.total > 0 && p.total >= transferred {
+		eta = time.Duration(float64(p.total-transferred) / float64(rate) * float64(time.Second)).Round(time.Second)
+	}
+	return transferred, rate, eta
+}
+
+func (p *transferProgress) String() string {
+	transferred, rate, eta := p.snapshot()
+	if p.total <= 0 {
+		return fmt.Sprintf("%s  %s  %s/s", p.label, util.HumanReadableSize(transferred), util.HumanReadableSize(rate))
+	}
+	etaStr := "--"
+	if eta >= 0 {
+		etaStr = eta.String()
+	}
+	return fmt.Sprintf("%s  %s / %s  %d%%  %s/s  ETA %s",
+		p.label, util.HumanReadableSize(transferred), util.HumanReadableSize(p.total), transferred*100/p.total, util.HumanReadableSize(rate), etaStr)
+}
+
+// Polls the size of the file at path on the executor, for transfers made by
+// other programs, until the returned function is called.
+func (p *transferProgress) poll(exec config.Executor, path string) func() {
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if size, err := fileSize(exec, path); err == nil {
+					p.Set(size)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
+
+// Counts the bytes passing through a transfer towards its progress & holds
+// them up as needed to stay under limit bytes per second (if positive).
+type meter struct {
+	progress *transferProgress
+	limit    int64
+	start    time.Time
+	passed   int64
+}
+
+func newMeter(progress *transferProgress, limit int64) *meter {
+	return &meter{progress: progress, limit: limit, start: time.Now()}
+}
+
+// The most passed through at once, so that a limited transfer proceeds
+// smoothly rather than in bursts of a whole buffer.
+func (m *meter) maxChunk() int {
+	if m.limit <= 0 || m.limit/10 > 32*1024 {
+		return 32 * 1024
+	}
+	return max(int(m.limit/10), 1)
+}
+
+func (m *meter) count(n int) {
+	m.passed += int64(n)
+	m.progress.Add(int64(n))
+	if m.limit <= 0 {
+		return
+	}
+	due := m.start.Add(time.Duration(float64(m.passed) / float64(m.limit) * float64(time.Second)))
+	if wait := time.Until(due); wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+func (m *meter) Writer(w io.Writer) io.Writer {
+	return &meteredWriter{w, m}
+}
+
+func (m *meter) Reader(r io.Reader) io.Reader {
+	return &meteredReader{r, m}
+}
+
+type meteredWriter struct {
+	w io.Writer
+	m *meter
+}
+
+func (w *meteredWriter) Write(b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		end := min(len(b), written+w.m.maxChunk())
+		n, err := w.w.Write(b[written:end])
+		written += n
+		w.m.count(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+type meteredReader struct {
+	r io.Reader
+	m *meter
+}
+
+func (r *meteredReader) Read(b []byte) (int, error) {
+	if len(b) > r.m.maxChunk() {
+		b = b[:r.m.maxChunk()]
+	}
+	n, err := r.r.Read(b)
+	r.m.count(n)
+	return n, err
+}
+
+// Returns the tightest of the transport's own limit & those of the two
+// locations, in bytes per second, or 0 if none of them has one.
+func bandwidthLimit(transportLimit int64, src config.Executor, dst config.Executor) int64 {
+	limit := transportLimit
+	for _, exec := range []config.Executor{src, dst} {
+		if limited, ok := exec.(config.BandwidthLimiter); ok {
+			if l := limited.BandwidthLimit(); l > 0 && (limit <= 0 || l < limit) {
+				limit = l
+			}
+		}
+	}
+	return max(limit, 0)
+}
+
+func fileSize(exec config.Executor, path string) (int64, error) {
+	sizeStr, stderr, err := exec.ExecuteShell("stat -c %s " + executor.ShellQuote(path))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s on %s (stderr: %s): %w", path, exec.Name(), strings.TrimSpace(stderr), err)
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(sizeStr), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get size of %s on %s: %w", path, exec.Name(), err)
+	}
+	return size, nil
+}
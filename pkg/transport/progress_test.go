@@ -0,0 +1,143 @@
+package transport
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mrshanahan/deploy-assets/pkg/config"
+	"github.com/mrshanahan/deploy-assets/pkg/executor"
+)
+
+func TestMeterLimitsBandwidth(t *testing.T) {
+	progress := startProgress("test", 20*1024)
+	defer progress.Finish()
+	meter := newMeter(progress, 40*1024)
+
+	start := time.Now()
+	n, err := io.Copy(meter.Writer(io.Discard), bytes.NewReader(make([]byte, 10*1024)))
+	if err != nil || n != 10*1024 {
+		t.Fatalf("expected 10KiB to be written, got %d (%v)", n, err)
+	}
+	n, err = io.Copy(io.Discard, meter.Reader(bytes.NewReader(make([]byte, 10*1024))))
+	if err != nil || n != 10*1024 {
+		t.Fatalf("expected 10KiB to be read, got %d (%v)", n, err)
+	}
+	// 20KiB at 40KiB/s.
+	if elapsed := time.Since(start); elapsed < 450*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("expected transfer to take about 500ms, took %s", elapsed)
+	}
+	if transferred, _, _ := progress.snapshot(); transferred != 20*1024 {
+		t.Errorf("expected 20KiB of progress, got %d", transferred)
+	}
+}
+
+func TestBandwidthLimit(t *testing.T) {
+	unlimited := executor.NewLocalExecutor("unlimited", t.TempDir(), 0)
+	slow := executor.NewLocalExecutor("slow", t.TempDir(), 100)
+	slower := executor.NewLocalExecutor("slower", t.TempDir(), 50)
+
+	var tests = []struct {
+		name           string
+		transportLimit int64
+		src            config.Executor
+		dst            config.Executor
+		expected       int64
+	}{
+		{"no limits", 0, unlimited, unlimited, 0},
+		{"transport only", 200, unlimited, unlimited, 200},
+		{"location only", 0, unlimited, slow, 100},
+		{"tightest wins", 200, slower, slow, 50},
+		{"transport tightest", 10, slower, slow, 10},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(s *testing.T) {
+			if actual := bandwidthLimit(test.transportLimit, test.src, test.dst); actual != test.expected {
+				s.Errorf("expected %d, got %d", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestProgressReporting(t *testing.T) {
+	output := &strings.Builder{}
+	original := progressOutput
+	progressOutput = output
+	defer func() { progressOutput = original }()
+	if progressIsTerminal() {
+		t.Fatalf("expected a buffer not to be a terminal")
+	}
+
+	progress := startProgress("src -> dst: image.tar", 4*1024*1024)
+	progress.Add(1024 * 1024)
+	if actual := progress.String(); !strings.HasPrefix(actual, "src -> dst: image.tar  1.0MiB / 4.0MiB  25%  ") {
+		t.Errorf("unexpected progress: %s", actual)
+	}
+	progress.Set(4 * 1024 * 1024)
+	if _, _, eta := progress.snapshot(); eta != 0 {
+		t.Errorf("expected no time remaining, got %s", eta)
+	}
+	progress.Finish()
+	if output.Len() > 0 {
+		t.Errorf("expected progress to be logged rather than drawn, got %q", output.String())
+	}
+
+	unknown := startProgress("src -> dst: 3 files", 0)
+	unknown.Add(2048)
+	if actual := unknown.String(); !strings.HasPrefix(actual, "src -> dst: 3 files  2.0KiB  ") || strings.Contains(actual, "ETA") {
+		t.Errorf("unexpected progress for unknown total: %s", actual)
+	}
+	unknown.Finish()
+}
+
+func TestRsyncProgressWriter(t *testing.T) {
+	progress := startProgress("rsync", 2000000)
+	defer progress.Finish()
+	w := &rsyncProgressWriter{progress: progress}
+
+	w.Write([]byte("\r          0   0%    0.00kB/s    0:00:00\r     1,23"))
+	if transferred, _, _ := progress.snapshot(); transferred != 0 {
+		t.Errorf("expected no progress yet, got %d", transferred)
+	}
+	w.Write([]byte("4,567  61%    1.23MB/s    0:00:01  (xfr#1, to-chk=0/1)\r"))
+	if transferred, _, _ := progress.snapshot(); transferred != 1234567 {
+		t.Errorf("expected 1234567 bytes of progress, got %d", transferred)
+	}
+	w.Write([]byte("\nsent 1,234,700 bytes  received 35 bytes\n"))
+	if transferred, _, _ := progress.snapshot(); transferred != 1234567 {
+		t.Errorf("expected summary lines to be ignored, got %d", transferred)
+	}
+}
+
+func TestHttpDownloadCommandLimit(t *testing.T) {
+	if actual := httpDownloadCommand(httpToolCurl, "http://x/y", "/dst", 2048); actual != "curl -fsS --limit-rate 2048 -o '/dst' 'http://x/y'" {
+		t.Errorf("unexpected curl command: %s", actual)
+	}
+	if actual := httpDownloadCommand(httpToolWget, "http://x/y", "/dst", 2048); actual != "wget -q --limit-rate=2048 -O '/dst' 'http://x/y'" {
+		t.Errorf("unexpected wget command: %s", actual)
+	}
+	if actual := httpDownloadCommand(httpToolCurl, "http://x/y", "/dst", 0); actual != "curl -fsS -o '/dst' 'http://x/y'" {
+		t.Errorf("unexpected unlimited curl command: %s", actual)
+	}
+}
+
+func TestFileSize(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "it's here")
+	if err := os.WriteFile(path, make([]byte, 1234), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	local := executor.NewLocalExecutor("local", dir, 0)
+	defer local.Close()
+
+	if size, err := fileSize(local, path); err != nil || size != 1234 {
+		t.Errorf("expected size 1234, got %d (%v)", size, err)
+	}
+	if _, err := fileSize(local, filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("expected error for missing file")
+	}
+}
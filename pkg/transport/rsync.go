@@ -0,0 +1,306 @@
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mrshanahan/deploy-assets/internal/util"
+	"github.com/mrshanahan/deploy-assets/pkg/config"
+	"github.com/mrshanahan/deploy-assets/pkg/executor"
+)
+
+// Where rsync keeps partially transferred files, relative to the destination
+// directory, so that an interrupted transfer can be resumed without a
+// truncated file ever appearing at the destination path.
+const rsyncPartialDir = ".rsync-partial"
+
+// Picks the major & minor version out of `rsync --version`, whose first line
+// is e.g. "rsync  version 3.2.7  protocol version 31".
+var rsyncVersionPattern = regexp.MustCompile(`version (\d+)\.(\d+)`)
+
+// Copies files with rsync, connecting to SSH locations with the same
+// credentials as their executors. rsync runs on whichever end is local &
+// connects to the other, so it needs to be installed on both. Transfers
+// between two SSH locations, to or from locations without rsync, or over keys
+// protected by a passphrase (which ssh can't be given non-interactively) are
+// streamed through this process instead, as with the stream transport.
+func NewRsyncTransport(name string, compress bool, checksum bool, partial bool, insecureSkipHostKeyCheck bool, bandwidthLimit int64) config.Transport {
+	return &rsyncTransport{
+		name:                     name,
+		compress:                 compress,
+		checksum:                 checksum,
+		partial:                  partial,
+		insecureSkipHostKeyCheck: insecureSkipHostKeyCheck,
+		bandwidthLimit:           bandwidthLimit,
+		stream:                   NewStreamTransport(name, bandwidthLimit),
+		locations:                make(map[string]rsyncLocation),
+	}
+}
+
+// Like NewRsyncTransport with its default settings, but only usable between
+// locations rsync can copy between itself, failing validation for the rest
+// rather than streaming between them. For auto transports, which have other
+// transports to pick for those.
+func NewRsyncOnlyTransport(name string, bandwidthLimit int64) config.Transport {
+	return &rsyncOnlyTransport{NewRsyncTransport(name, true, false, true, false, bandwidthLimit).(*rsyncTransport)}
+}
+
+type rsyncOnlyTransport struct {
+	*rsyncTransport
+}
+
+func (t *rsyncOnlyTransport) Validate(exec config.Executor) error {
+	if !t.hasRsync(exec) {
+		return fmt.Errorf("location %s does not have rsync", exec.Name())
+	}
+	return nil
+}
+
+func (t *rsyncOnlyTransport) ValidatePair(src config.Executor, dst config.Executor) error {
+	_, err := t.command(src, "", dst, "")
+	return err
+}
+
+type rsyncTransport struct {
+	name     string
+	compress bool
+	checksum bool
+	partial  bool
+	// Accept whatever host key an SSH location presents, rather than
+	// requiring it to be in the user's known_hosts.
+	insecureSkipHostKeyCheck bool
+	bandwidthLimit           int64
+	stream                   config.Transport
+
+	lock      sync.Mutex
+	locations map[string]rsyncLocation
+}
+
+// What the rsync on a location can do, keyed by location name.
+type rsyncLocation struct {
+	available bool
+	// Whether it's version 3.1 or later, which --info=progress2 &
+	// --no-inc-recursive need.
+	reportsProgress bool
+}
+
+func (t *rsyncTransport) Yaml(indent int) string {
+	propIndent := util.YamlIndentString(indent + util.TabsToIndent(1))
+	return fmt.Sprintf(
+		`%srsync:
+%sname: %s
+%scompress: %t
+%schecksum: %t
+%spartial: %t
+%sinsecure_skip_host_key_check: %t`,
+		util.YamlIndentString(indent),
+		propIndent, t.name,
+		propIndent, t.compress,
+		propIndent, t.checksum,
+		propIndent, t.partial,
+		propIndent, t.insecureSkipHostKeyCheck)
+}
+
+// Locations without rsync are still valid as long as files can be streamed to
+// & from them.
+func (t *rsyncTransport) Validate(exec config.Executor) error {
+	if t.hasRsync(exec) {
+		return nil
+	}
+	return t.stream.Validate(exec)
+}
+
+func (t *rsyncTransport) hasRsync(exec config.Executor) bool {
+	return t.probe(exec).available
+}
+
+func (t *rsyncTransport) probe(exec config.Executor) rsyncLocation {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if location, prs := t.locations[exec.Name()]; prs {
+		return location
+	}
+	location := rsyncLocation{}
+	stdout, _, err := exec.ExecuteShell("command -v rsync >/dev/null && rsync --version")
+	if err != nil {
+		slog.Info("location does not have rsync; transfers to & from it will be streamed through this machine", "location", exec.Name())
+	} else {
+		location.available = true
+		if m := rsyncVersionPattern.FindStringSubmatch(stdout); m != nil {
+			major, _ := strconv.Atoi(m[1])
+			minor, _ := strconv.Atoi(m[2])
+			location.reportsProgress = major > 3 || (major == 3 && minor >= 1)
+		}
+		if !location.reportsProgress {
+			slog.Info("location's rsync is older than 3.1; transfers to & from it won't report progress", "location", exec.Name())
+		}
+	}
+	t.locations[exec.Name()] = location
+	return location
+}
+
+func (t *rsyncTransport) TransferFile(src config.Executor, srcPath string, dst config.Executor, dstPath string) error {
+	cmd, err := t.command(src, srcPath, dst, dstPath)
+	if errors.Is(err, errors.ErrUnsupported) {
+		slog.Debug("streaming file instead of using rsync", "src", src.Name(), "dst", dst.Name(), "reason", err)
+		return t.stream.TransferFile(src, srcPath, dst, dstPath)
+	} else if err != nil {
+		return err
+	}
+	size, err := fileSize(src, srcPath)
+	if err != nil {
+		return err
+	}
+	return t.run(cmd, nil, transferLabel(src.Name(), srcPath, dst.Name()), size)
+}
+
+func (t *rsyncTransport) SyncFiles(src config.Executor, srcDir string, dst config.Executor, dstDir string, relativePaths []string) error {
+	// The trailing slashes make rsync copy the paths under srcDir, rather
+	// than srcDir itself, into dstDir.
+	cmd, err := t.command(src, strings.TrimRight(srcDir, "/")+"/", dst, strings.TrimRight(dstDir, "/")+"/", "--files-from=-")
+	if err != nil {
+		return err
+	}
+	label := fmt.Sprintf("%s -> %s: %d files under %s", src.Name(), dst.Name(), len(relativePaths), srcDir)
+	return t.run(cmd, strings.NewReader(strings.Join(relativePaths, "\n")+"\n"), label, 0)
+}
+
+type rsyncCommand struct {
+	// Where rsync runs: the local end of the transfer.
+	client config.Executor
+	cmd    string
+}
+
+// rsync reports its overall progress on stdout, which is relayed to the
+// transfer's progress.
+func (t *rsyncTransport) run(cmd *rsyncCommand, stdin io.Reader, label string, total int64) error {
+	progress := startProgress(label, total)
+	defer progress.Finish()
+	if stderr, err := cmd.client.ExecuteShellStreaming(cmd.cmd, stdin, &rsyncProgressWriter{progress: progress}); err != nil {
+		return fmt.Errorf("rsync failed (stderr: %s): %w", strings.TrimSpace(stderr), err)
+	}
+	return nil
+}
+
+// Builds the rsync command for copying between the two locations, or returns
+// an error wrapping errors.ErrUnsupported if rsync can't be used between them.
+func (t *rsyncTransport) command(src config.Executor, srcPath string, dst config.Executor, dstPath string, extraArgs ...string) (*rsyncCommand, error) {
+	srcTarget, srcIsRemote := sshTarget(src)
+	dstTarget, dstIsRemote := sshTarget(dst)
+	if srcIsRemote && dstIsRemote {
+		return nil, fmt.Errorf("rsync can't copy between two SSH locations (%s & %s): %w", src.Name(), dst.Name(), errors.ErrUnsupported)
+	}
+	for _, e := range []config.Executor{src, dst} {
+		if !t.hasRsync(e) {
+			return nil, fmt.Errorf("location %s does not have rsync: %w", e.Name(), errors.ErrUnsupported)
+		}
+	}
+
+	args := []string{"-lpt"}
+	if t.probe(src).reportsProgress && t.probe(dst).reportsProgress {
+		args = append(args, "--info=progress2", "--no-inc-recursive")
+	}
+	if t.compress {
+		args = append(args, "-z")
+	}
+	if t.checksum {
+		args = append(args, "-c")
+	}
+	if t.partial {
+		args = append(args, fmt.Sprintf("--partial-dir=%s", rsyncPartialDir))
+	}
+	if limit := bandwidthLimit(t.bandwidthLimit, src, dst); limit > 0 {
+		// rsync takes KiB per second.
+		args = append(args, fmt.Sprintf("--bwlimit=%d", max(limit/1024, 1)))
+	}
+	args = append(args, extraArgs...)
+
+	client := src
+	srcArg, dstArg := executor.ShellQuote(srcPath), executor.ShellQuote(dstPath)
+	var remote *config.SSHTarget
+	if dstIsRemote {
+		remote = &dstTarget
+		dstArg = executor.ShellQuote(fmt.Sprintf("%s@%s:%s", dstTarget.User, sshHost(dstTarget.Addr), dstPath))
+	} else if srcIsRemote {
+		client = dst
+		remote = &srcTarget
+		srcArg = executor.ShellQuote(fmt.Sprintf("%s@%s:%s", srcTarget.User, sshHost(srcTarget.Addr), srcPath))
+	}
+	if remote != nil {
+		if remote.KeyPassphrase != "" {
+			return nil, fmt.Errorf("ssh can't use the passphrase-protected key for %s: %w", remote.Addr, errors.ErrUnsupported)
+		}
+		args = append(args, "-e "+executor.ShellQuote(strings.Join(sshCommand(remote, t.insecureSkipHostKeyCheck), " ")))
+		if remote.RunElevated {
+			args = append(args, "--rsync-path='sudo rsync'")
+		}
+	}
+
+	cmd := fmt.Sprintf("rsync %s %s %s", strings.Join(args, " "), srcArg, dstArg)
+	return &rsyncCommand{client, cmd}, nil
+}
+
+// Picks the bytes transferred so far out of rsync's --info=progress2 output,
+// whose updates are separated by carriage returns, e.g.
+// "      1,234,567  45%    1.23MB/s    0:00:12".
+type rsyncProgressWriter struct {
+	progress *transferProgress
+	pending  []byte
+}
+
+func (w *rsyncProgressWriter) Write(b []byte) (int, error) {
+	w.pending = append(w.pending, b...)
+	for {
+		end := bytes.IndexAny(w.pending, "\r\n")
+		if end < 0 {
+			return len(b), nil
+		}
+		if fields := strings.Fields(string(w.pending[:end])); len(fields) > 1 && strings.HasSuffix(fields[1], "%") {
+			if n, err := strconv.ParseInt(strings.ReplaceAll(fields[0], ",", ""), 10, 64); err == nil {
+				w.progress.Set(n)
+			}
+		}
+		w.pending = w.pending[end+1:]
+	}
+}
+
+// As with scp, batch mode keeps ssh from hanging on a prompt nobody can
+// answer, so hosts missing from known_hosts fail unless host key checking is
+// turned off.
+func sshCommand(target *config.SSHTarget, insecureSkipHostKeyCheck bool) []string {
+	cmd := []string{
+		"ssh",
+		"-i", target.KeyPath,
+		"-o", "BatchMode=yes",
+	}
+	if insecureSkipHostKeyCheck {
+		cmd = append(cmd, "-o", "StrictHostKeyChecking=no", "-o", "UserKnownHostsFile=/dev/null")
+	}
+	if _, port, err := net.SplitHostPort(target.Addr); err == nil {
+		cmd = append(cmd, "-p", port)
+	}
+	return cmd
+}
+
+func sshHost(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+func sshTarget(exec config.Executor) (config.SSHTarget, bool) {
+	if e, ok := exec.(config.SSHExecutor); ok {
+		return e.SSHTarget(), true
+	}
+	return config.SSHTarget{}, false
+}
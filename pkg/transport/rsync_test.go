@@ -0,0 +1,244 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mrshanahan/deploy-assets/internal/sshtest"
+	"github.com/mrshanahan/deploy-assets/pkg/config"
+	"github.com/mrshanahan/deploy-assets/pkg/executor"
+)
+
+// A local executor posing as one reached over SSH, for checking how rsync
+// commands are built without needing rsync or a server.
+type fakeSSHExecutor struct {
+	config.Executor
+	target config.SSHTarget
+}
+
+func (e *fakeSSHExecutor) SSHTarget() config.SSHTarget { return e.target }
+
+// Puts an rsync on PATH that does nothing but report the given version, so
+// that the transport believes every location has it.
+func installFakeRsync(t *testing.T, version string) {
+	binDir := t.TempDir()
+	script := fmt.Sprintf("#!/bin/bash\necho 'rsync  version %s  protocol version 31'\n", version)
+	if err := os.WriteFile(filepath.Join(binDir, "rsync"), []byte(script), 0755); err != nil {
+		t.Fatalf("failed to write fake rsync: %v", err)
+	}
+	t.Setenv("PATH", binDir+":"+os.Getenv("PATH"))
+}
+
+func TestRsyncCommand(t *testing.T) {
+	installFakeRsync(t, "3.2.7")
+	local := executor.NewLocalExecutor("local", t.TempDir(), 0)
+	defer local.Close()
+	remote := &fakeSSHExecutor{local, config.SSHTarget{Addr: "web1:2222", User: "deployer", KeyPath: "/keys/id", RunElevated: true}}
+	plainRemote := &fakeSSHExecutor{local, config.SSHTarget{Addr: "web2", User: "deployer", KeyPath: "/keys/id"}}
+	lockedRemote := &fakeSSHExecutor{local, config.SSHTarget{Addr: "web3", User: "deployer", KeyPath: "/keys/id", KeyPassphrase: "secret"}}
+	slow := executor.NewLocalExecutor("slow", t.TempDir(), 1024*1024)
+	defer slow.Close()
+	const sshCmd = "-e 'ssh -i /keys/id -o BatchMode=yes"
+
+	var tests = []struct {
+		name      string
+		transport config.Transport
+		src       config.Executor
+		dst       config.Executor
+		client    config.Executor
+		expected  string
+	}{
+		{
+			"local to local",
+			NewRsyncTransport("rsync", true, false, true, false, 0),
+			local, local, local,
+			"rsync -lpt --info=progress2 --no-inc-recursive -z --partial-dir=.rsync-partial '/src/a' '/dst/a'",
+		},
+		{
+			"local to ssh",
+			NewRsyncTransport("rsync", true, false, true, false, 0),
+			local, remote, local,
+			"rsync -lpt --info=progress2 --no-inc-recursive -z --partial-dir=.rsync-partial " + sshCmd + " -p 2222' --rsync-path='sudo rsync' '/src/a' 'deployer@web1:/dst/a'",
+		},
+		{
+			"ssh to local",
+			NewRsyncTransport("rsync", false, true, false, false, 0),
+			plainRemote, local, local,
+			"rsync -lpt --info=progress2 --no-inc-recursive -c " + sshCmd + "' 'deployer@web2:/src/a' '/dst/a'",
+		},
+		{
+			"host key check skipped",
+			NewRsyncTransport("rsync", false, false, false, true, 0),
+			local, plainRemote, local,
+			"rsync -lpt --info=progress2 --no-inc-recursive " + sshCmd + " -o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null' '/src/a' 'deployer@web2:/dst/a'",
+		},
+		{
+			"bandwidth limited by location",
+			NewRsyncTransport("rsync", true, false, true, false, 10*1024*1024),
+			local, slow, local,
+			"rsync -lpt --info=progress2 --no-inc-recursive -z --partial-dir=.rsync-partial --bwlimit=1024 '/src/a' '/dst/a'",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(s *testing.T) {
+			cmd, err := test.transport.(*rsyncTransport).command(test.src, "/src/a", test.dst, "/dst/a")
+			if err != nil {
+				s.Fatalf("unexpected error: %v", err)
+			}
+			if cmd.client != test.client {
+				s.Errorf("expected rsync to run on %s, got %s", test.client.Name(), cmd.client.Name())
+			}
+			if cmd.cmd != test.expected {
+				s.Errorf("expected command:\n%s\ngot:\n%s", test.expected, cmd.cmd)
+			}
+		})
+	}
+
+	transport := NewRsyncTransport("rsync", true, false, true, false, 0).(*rsyncTransport)
+	cmd, err := transport.command(local, "/src/it's", plainRemote, "/dst/it's")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := `'/src/it'\''s' 'deployer@web2:/dst/it'\''s'`; !strings.HasSuffix(cmd.cmd, expected) {
+		t.Errorf("expected command ending in quoted paths:\n%s\ngot:\n%s", expected, cmd.cmd)
+	}
+	for _, pair := range [][2]config.Executor{{remote, plainRemote}, {local, lockedRemote}} {
+		if _, err := transport.command(pair[0], "/src/a", pair[1], "/dst/a"); !errors.Is(err, errors.ErrUnsupported) {
+			t.Errorf("expected rsync from %v to %v to be unsupported, got %v", pair[0], pair[1], err)
+		}
+	}
+}
+
+func TestRsyncCommandWithOldRsync(t *testing.T) {
+	installFakeRsync(t, "3.0.9")
+	local := executor.NewLocalExecutor("local", t.TempDir(), 0)
+	defer local.Close()
+
+	cmd, err := NewRsyncTransport("rsync", false, false, false, false, 0).(*rsyncTransport).command(local, "/src/a", local, "/dst/a")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := "rsync -lpt '/src/a' '/dst/a'"; cmd.cmd != expected {
+		t.Errorf("expected command without progress flags:\n%s\ngot:\n%s", expected, cmd.cmd)
+	}
+}
+
+func TestRsyncOnlyTransport(t *testing.T) {
+	installFakeRsync(t, "3.2.7")
+	local := executor.NewLocalExecutor("local", t.TempDir(), 0)
+	defer local.Close()
+	remote := &fakeSSHExecutor{local, config.SSHTarget{Addr: "web1", User: "deployer", KeyPath: "/keys/id"}}
+
+	transport := NewRsyncOnlyTransport("auto/rsync", 0).(config.PairTransport)
+	if err := transport.ValidatePair(local, remote); err != nil {
+		t.Errorf("expected rsync from a local location to validate, got %v", err)
+	}
+	if err := transport.ValidatePair(remote, remote); err == nil {
+		t.Errorf("expected rsync between two SSH locations not to validate")
+	}
+	if err := NewRsyncOnlyTransport("auto/rsync", 0).Validate(newBareLocalExecutor(t)); err == nil {
+		t.Errorf("expected a location without rsync not to validate")
+	}
+}
+
+func TestRsyncWithoutRsync(t *testing.T) {
+	bare := newBareLocalExecutor(t)
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "src.txt")
+	if err := os.WriteFile(srcPath, []byte("streamed\n"), 0644); err != nil {
+		t.Fatalf("failed to write source file: %v", err)
+	}
+
+	transport := NewRsyncTransport("rsync", true, false, true, false, 0)
+	if err := transport.Validate(bare); err != nil {
+		t.Fatalf("expected location without rsync to validate, got %v", err)
+	}
+	dstPath := filepath.Join(dir, "dst.txt")
+	if err := transport.TransferFile(bare, srcPath, bare, dstPath); err != nil {
+		t.Fatalf("expected transfer to fall back to streaming, got %v", err)
+	}
+	if actual, _ := os.ReadFile(dstPath); string(actual) != "streamed\n" {
+		t.Errorf("expected streamed file contents, got %q", string(actual))
+	}
+
+	err := transport.(config.DirectTransport).SyncFiles(bare, dir, bare, t.TempDir(), []string{"src.txt"})
+	if !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("expected direct sync to be unsupported, got %v", err)
+	}
+}
+
+func TestRsyncTransfer(t *testing.T) {
+	if _, err := exec.LookPath("rsync"); err != nil {
+		t.Skip("rsync not found on path")
+	}
+	server := sshtest.NewServer(t)
+	local := executor.NewLocalExecutor("local", t.TempDir(), 0)
+	defer local.Close()
+	remote, err := executor.NewSSHExecutor("remote", server.Addr(), "deployer", server.KeyFile, "", true, t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("failed to connect to test server: %v", err)
+	}
+	defer remote.Close()
+
+	srcDir := t.TempDir()
+	modTime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, name := range []string{"a.txt", "sub/b.txt", "sub/deeper/c.txt"} {
+		path := filepath.Join(srcDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(strings.Repeat(name, 1000)), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("failed to set times on %s: %v", name, err)
+		}
+	}
+
+	var tests = []struct {
+		name string
+		src  config.Executor
+		dst  config.Executor
+	}{
+		{"local to local", local, local},
+		{"local to ssh", local, remote},
+		{"ssh to local", remote, local},
+	}
+	// The test server's host key is generated for each test.
+	transport := NewRsyncTransport("rsync", true, true, true, true, 0)
+	for _, test := range tests {
+		t.Run(test.name, func(s *testing.T) {
+			dstDir := s.TempDir()
+			if err := transport.TransferFile(test.src, filepath.Join(srcDir, "a.txt"), test.dst, filepath.Join(dstDir, "it's renamed.txt")); err != nil {
+				s.Fatalf("transfer failed: %v", err)
+			}
+			if err := transport.(config.DirectTransport).SyncFiles(test.src, srcDir, test.dst, dstDir, []string{"sub/b.txt", "sub/deeper/c.txt"}); err != nil {
+				s.Fatalf("sync failed: %v", err)
+			}
+
+			for name, srcName := range map[string]string{"it's renamed.txt": "a.txt", "sub/b.txt": "sub/b.txt", "sub/deeper/c.txt": "sub/deeper/c.txt"} {
+				path := filepath.Join(dstDir, name)
+				actual, err := os.ReadFile(path)
+				if err != nil {
+					s.Errorf("failed to read %s: %v", name, err)
+					continue
+				}
+				if string(actual) != strings.Repeat(srcName, 1000) {
+					s.Errorf("unexpected contents in %s", name)
+				}
+				if info, err := os.Stat(path); err == nil && !info.ModTime().Equal(modTime) {
+					s.Errorf("expected %s to keep its modification time, got %v", name, info.ModTime())
+				}
+			}
+			if _, err := os.Stat(filepath.Join(dstDir, "a.txt")); !os.IsNotExist(err) {
+				s.Errorf("expected only the listed files to be synced")
+			}
+		})
+	}
+}
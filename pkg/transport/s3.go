@@ -2,19 +2,122 @@ package transport
 
 import (
 	"fmt"
+	"io"
+	"log/slog"
 	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/mrshanahan/deploy-assets/internal/s3client"
 	"github.com/mrshanahan/deploy-assets/internal/util"
 	"github.com/mrshanahan/deploy-assets/pkg/config"
+	"github.com/mrshanahan/deploy-assets/pkg/executor"
 )
 
-func NewS3Transport(name string, bucketUrl string) config.Transport {
-	return &s3Transport{name, bucketUrl}
+// How long presigned URLs handed to locations stay valid by default. Each is
+// used once, right after it's generated. SigV4 caps the expiry at a week.
+const (
+	DefaultS3URLExpiry = 15 * time.Minute
+	MaxS3URLExpiry     = 7 * 24 * time.Hour
+)
+
+const transferObjectPrefix = "transfer"
+
+// How files get between locations & the bucket:
+//   - auto: presigned URLs on locations with curl or wget, relayed otherwise;
+//   - presigned: presigned URLs only, failing validation on locations
+//     without curl or wget;
+//   - relay: always relayed through this process.
+//
+// In every mode, only the machine running deploy-assets needs credentials.
+const (
+	S3ModeAuto      = "auto"
+	S3ModePresigned = "presigned"
+	S3ModeRelay     = "relay"
+)
+
+// Tools a location can use to move files to & from S3 with a presigned URL.
+// Outside of presigned mode, locations with neither have their files relayed
+// through this process instead.
+const (
+	s3ToolCurl  = httpToolCurl
+	s3ToolWget  = httpToolWget
+	s3ToolRelay = httpToolNone
+)
+
+func NewS3Transport(name string, bucketUrl string, endpoint string, region string, profile string, mode string, urlExpiry time.Duration, encrypt bool, bandwidthLimit int64) (config.Transport, error) {
+	u, err := url.Parse(bucketUrl)
+	if err != nil || u.Scheme != "s3" || u.Host == "" {
+		return nil, fmt.Errorf("invalid bucket_url '%s': expected s3://<bucket>[/<prefix>]", bucketUrl)
+	}
+	if mode == "" {
+		mode = S3ModeAuto
+	}
+	if mode != S3ModeAuto && mode != S3ModePresigned && mode != S3ModeRelay {
+		return nil, fmt.Errorf("invalid s3 transport mode '%s': expected %s, %s or %s", mode, S3ModeAuto, S3ModePresigned, S3ModeRelay)
+	}
+	if urlExpiry == 0 {
+		urlExpiry = DefaultS3URLExpiry
+	}
+	if urlExpiry < time.Second || urlExpiry > MaxS3URLExpiry {
+		return nil, fmt.Errorf("invalid url_expiry %s: must be between 1s & %s", urlExpiry, MaxS3URLExpiry)
+	}
+	return &s3Transport{
+		name:      name,
+		bucketUrl: bucketUrl,
+		bucket:    u.Host,
+		prefix:    strings.Trim(u.Path, "/"),
+		endpoint:  endpoint,
+		region:    region,
+		profile:   profile,
+		mode:      mode,
+		urlExpiry: urlExpiry,
+		encrypt:   encrypt,
+		tools:     make(map[string]string),
+		staged:    make(map[stagedObject]stagedUpload),
+
+		bandwidthLimit: bandwidthLimit,
+	}, nil
 }
 
 type s3Transport struct {
 	name      string
 	bucketUrl string
+	bucket    string
+	prefix    string
+	endpoint  string
+	region    string
+	profile   string
+	mode      string
+	urlExpiry time.Duration
+	// Whether objects are encrypted with a key of their own before they reach
+	// the bucket; see encrypt.go.
+	encrypt bool
+	// Applies to each leg separately, i.e. to & from the bucket.
+	bandwidthLimit int64
+
+	// Credentials are only needed once something is validated or transferred,
+	// so the client is created on first use.
+	lock      sync.Mutex
+	client    *s3client.Client
+	clientErr error
+	tools     map[string]string
+	// Keys of objects kept in the bucket by TransferFileReusing, until they
+	// are released.
+	staged map[stagedObject]stagedUpload
+}
+
+type stagedObject struct {
+	location string
+	path     string
+}
+
+type stagedUpload struct {
+	key        string
+	passphrase string
 }
 
 func (t *s3Transport) Yaml(indent int) string {
@@ -22,47 +125,301 @@ func (t *s3Transport) Yaml(indent int) string {
 	return fmt.Sprintf(
 		`%ss3:
 %sname: %s
-%sbucket_url: %s`,
+%sbucket_url: %s
+%sendpoint: %s
+%sregion: %s
+%sprofile: %s
+%smode: %s
+%surl_expiry: %s
+%sencrypt: %t`,
 		util.YamlIndentString(indent),
 		propIndent, t.name,
-		propIndent, t.bucketUrl)
+		propIndent, t.bucketUrl,
+		propIndent, t.endpoint,
+		propIndent, t.region,
+		propIndent, t.profile,
+		propIndent, t.mode,
+		propIndent, t.urlExpiry,
+		propIndent, t.encrypt)
 }
 
+// Checks access to the bucket (once per run) & works out how the location
+// will reach it. Neither step needs the AWS CLI or credentials on the location.
 func (t *s3Transport) Validate(exec config.Executor) error {
-	if err := ValidateAWSCLIInstallation(exec); err != nil {
-		return fmt.Errorf("location '%s' does not have AWS CLI ('aws') available on PATH; install or update PATH & try again", exec.Name())
+	if _, err := t.getClient(); err != nil {
+		return err
 	}
-	if err := ValidateAWSCLILogin(exec); err != nil {
-		return fmt.Errorf("location '%s' is not authenticated with S3; authenticate & try again", exec.Name())
+	if t.getTool(exec) == s3ToolRelay && t.mode == S3ModePresigned {
+		if t.encrypt {
+			return fmt.Errorf("location '%s' needs curl or wget, & openssl 1.1.1 or later to encrypt, available on PATH for the s3 transport's presigned mode; install them or update PATH & try again", exec.Name())
+		}
+		return fmt.Errorf("location '%s' has neither curl nor wget available on PATH, one of which is required by the s3 transport's presigned mode; install one or update PATH & try again", exec.Name())
 	}
-
 	return nil
 }
 
-func ValidateAWSCLIInstallation(e config.Executor) error {
-	_, _, err := e.ExecuteShell("which aws")
-	return err
+func (t *s3Transport) getClient() (*s3client.Client, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.client != nil || t.clientErr != nil {
+		return t.client, t.clientErr
+	}
+	creds, region, err := s3client.LoadConfig(t.profile, t.region)
+	if err != nil {
+		t.clientErr = fmt.Errorf("failed to load AWS credentials: %w", err)
+		return nil, t.clientErr
+	}
+	client, err := s3client.New(t.endpoint, region, creds)
+	if err != nil {
+		t.clientErr = err
+		return nil, t.clientErr
+	}
+	if err := client.HeadBucket(t.bucket); err != nil {
+		t.clientErr = fmt.Errorf("cannot access bucket %s; check credentials & try again: %w", t.bucket, err)
+		return nil, t.clientErr
+	}
+	t.client = client
+	return t.client, nil
 }
 
-func ValidateAWSCLILogin(e config.Executor) error {
-	_, _, err := e.ExecuteShell("aws sts get-caller-identity")
-	return err
+func (t *s3Transport) getTool(exec config.Executor) string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if tool, prs := t.tools[exec.Name()]; prs {
+		return tool
+	}
+	if t.mode == S3ModeRelay {
+		t.tools[exec.Name()] = s3ToolRelay
+		return s3ToolRelay
+	}
+	tool := findHTTPTool(exec)
+	if tool != s3ToolRelay && t.encrypt && !hasOpenSSL(exec) {
+		if t.mode == S3ModeAuto {
+			slog.Info("location has no openssl to encrypt with; S3 transfers will be relayed & encrypted by this machine", "location", exec.Name())
+		}
+		tool = s3ToolRelay
+	} else if tool == s3ToolRelay && t.mode == S3ModeAuto {
+		slog.Info("location has neither curl nor wget; S3 transfers will be relayed through this machine", "location", exec.Name())
+	} else if tool != s3ToolRelay {
+		slog.Debug("location will transfer to & from S3 with presigned URLs", "location", exec.Name(), "tool", tool)
+	}
+	t.tools[exec.Name()] = tool
+	return tool
 }
 
 func (t *s3Transport) TransferFile(src config.Executor, srcPath string, dst config.Executor, dstPath string) error {
-	bucketSubpath := util.GetTimestampedFileName("transfer")
-	fullBucketPath, err := url.JoinPath(t.bucketUrl, bucketSubpath)
+	return t.transfer(src, srcPath, dst, dstPath, false)
+}
+
+// Uploads srcPath the first time it's transferred & downloads that same object
+// for every destination after, until it's released.
+func (t *s3Transport) TransferFileReusing(src config.Executor, srcPath string, dst config.Executor, dstPath string) error {
+	return t.transfer(src, srcPath, dst, dstPath, true)
+}
+
+func (t *s3Transport) Release(src config.Executor, srcPath string) {
+	t.lock.Lock()
+	staged := stagedObject{src.Name(), srcPath}
+	upload, prs := t.staged[staged]
+	delete(t.staged, staged)
+	client := t.client
+	t.lock.Unlock()
+
+	if prs {
+		if err := client.DeleteObject(t.bucket, upload.key); err != nil {
+			slog.Warn("failed to remove transferred object from bucket", "bucket", t.bucket, "key", upload.key, "err", err)
+		}
+	}
+}
+
+func (t *s3Transport) transfer(src config.Executor, srcPath string, dst config.Executor, dstPath string, keep bool) error {
+	client, err := t.getClient()
 	if err != nil {
 		return err
 	}
-	if _, _, err := src.ExecuteCommand("aws", "s3", "cp", srcPath, fullBucketPath); err != nil {
+	size, err := fileSize(src, srcPath)
+	if err != nil {
 		return err
 	}
-	defer src.ExecuteCommand("aws", "s3", "rm", fullBucketPath)
+	limit := bandwidthLimit(t.bandwidthLimit, src, dst)
 
-	if _, _, err := dst.ExecuteCommand("aws", "s3", "cp", fullBucketPath, dstPath); err != nil {
-		return err
+	staged := stagedObject{src.Name(), srcPath}
+	t.lock.Lock()
+	upload, uploaded := t.staged[staged]
+	t.lock.Unlock()
+	key, passphrase := upload.key, upload.passphrase
+	if uploaded {
+		slog.Info("reusing object already uploaded to bucket", "src", src.Name(), "path", srcPath, "bucket", t.bucket, "key", key)
+	} else {
+		key = path.Join(t.prefix, util.GetRandomFileName(transferObjectPrefix))
+		if t.encrypt {
+			if passphrase, err = newPassphrase(); err != nil {
+				return err
+			}
+		}
+		if err := t.upload(client, src, srcPath, key, size, limit, passphrase); err != nil {
+			return fmt.Errorf("failed to upload %s from %s to s3://%s/%s: %w", srcPath, src.Name(), t.bucket, key, err)
+		}
+		if keep {
+			t.lock.Lock()
+			t.staged[staged] = stagedUpload{key, passphrase}
+			t.lock.Unlock()
+		} else {
+			defer func() {
+				if err := client.DeleteObject(t.bucket, key); err != nil {
+					slog.Warn("failed to remove transferred object from bucket", "bucket", t.bucket, "key", key, "err", err)
+				}
+			}()
+		}
+	}
+
+	progress := startProgress(transferLabel("s3", srcPath, dst.Name()), size)
+	defer progress.Finish()
+	if err := t.download(client, dst, key, dstPath, progress, limit, passphrase); err != nil {
+		return fmt.Errorf("failed to download s3://%s/%s to %s on %s: %w", t.bucket, key, dstPath, dst.Name(), err)
+	}
+	return nil
+}
+
+// Objects are only left in the bucket by runs that didn't finish (or failed to
+// clean up), so any transfer object older than cutoff is garbage.
+func (t *s3Transport) FindGarbage(locations []config.Executor, cutoff time.Time) ([]config.Garbage, error) {
+	client, err := t.getClient()
+	if err != nil {
+		return nil, err
+	}
+	objects, err := client.ListObjects(t.bucket, path.Join(t.prefix, transferObjectPrefix+"-"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects in %s: %w", t.bucketUrl, err)
 	}
+	garbage := []config.Garbage{}
+	for _, o := range objects {
+		if !o.LastModified.Before(cutoff) {
+			continue
+		}
+		key := o.Key
+		garbage = append(garbage, config.Garbage{
+			Location:   "s3://" + t.bucket,
+			Path:       key,
+			ModifiedAt: o.LastModified,
+			Remove:     func() error { return client.DeleteObject(t.bucket, key) },
+		})
+	}
+	return garbage, nil
+}
+
+// Presigned uploads run on the location, so only relayed ones report their
+// progress. With a passphrase, the object is encrypted before it's uploaded:
+// by openssl on the location, or by this process when relayed.
+func (t *s3Transport) upload(client *s3client.Client, src config.Executor, srcPath string, key string, size int64, limit int64, passphrase string) error {
+	tool := t.getTool(src)
+	if tool == s3ToolRelay {
+		if t.mode == S3ModePresigned {
+			return fmt.Errorf("location '%s' has neither curl nor wget for a presigned upload", src.Name())
+		}
+		progress := startProgress(transferLabel(src.Name(), srcPath, "s3"), size)
+		defer progress.Finish()
+		return t.relayUpload(client, src, srcPath, key, newMeter(progress, limit), passphrase)
+	}
+
+	uploadPath := srcPath
+	if passphrase != "" {
+		// S3 needs to know an object's length up front, so it can't be
+		// piped from openssl.
+		encryptedPath, err := encryptOn(src, srcPath, passphrase)
+		if err != nil {
+			return err
+		}
+		defer src.ExecuteCommand("rm", "-f", encryptedPath)
+		uploadPath = encryptedPath
+	}
+	var cmd string
+	switch tool {
+	case s3ToolCurl:
+		cmd = fmt.Sprintf("curl -fsS%s -X PUT -T %s %s", httpLimitRateArg(tool, limit), executor.ShellQuote(uploadPath), executor.ShellQuote(client.PresignPutObject(t.bucket, key, t.urlExpiry)))
+	case s3ToolWget:
+		cmd = fmt.Sprintf("wget -q%s -O /dev/null --method=PUT --body-file=%s %s", httpLimitRateArg(tool, limit), executor.ShellQuote(uploadPath), executor.ShellQuote(client.PresignPutObject(t.bucket, key, t.urlExpiry)))
+	}
+	if _, stderr, err := src.ExecuteShell(cmd); err != nil {
+		return fmt.Errorf("%w (stderr: %s)", err, strings.TrimSpace(stderr))
+	}
+	return nil
+}
 
+func (t *s3Transport) download(client *s3client.Client, dst config.Executor, key string, dstPath string, progress *transferProgress, limit int64, passphrase string) error {
+	tool := t.getTool(dst)
+	if tool == s3ToolRelay {
+		if t.mode == S3ModePresigned {
+			return fmt.Errorf("location '%s' has neither curl nor wget for a presigned download", dst.Name())
+		}
+		return t.relayDownload(client, dst, key, dstPath, newMeter(progress, limit), passphrase)
+	}
+
+	downloadPath := dstPath
+	if passphrase != "" {
+		stagingDir, err := dst.StagingDir()
+		if err != nil {
+			return err
+		}
+		downloadPath = filepath.Join(stagingDir, util.GetRandomFileName("encrypted"))
+		defer dst.ExecuteCommand("rm", "-f", downloadPath)
+	}
+	stopPolling := progress.poll(dst, downloadPath)
+	cmd := httpDownloadCommand(tool, client.PresignGetObject(t.bucket, key, t.urlExpiry), downloadPath, limit)
+	_, stderr, err := dst.ExecuteShell(cmd)
+	stopPolling()
+	if err != nil {
+		return fmt.Errorf("%w (stderr: %s)", err, strings.TrimSpace(stderr))
+	}
+	if passphrase != "" {
+		return decryptOn(dst, downloadPath, dstPath, passphrase)
+	}
+	return nil
+}
+
+func (t *s3Transport) relayUpload(client *s3client.Client, src config.Executor, srcPath string, key string, meter *meter, passphrase string) error {
+	reader, writer := io.Pipe()
+	var body io.Reader = reader
+	if passphrase != "" {
+		var err error
+		if body, err = encryptReader(reader, passphrase); err != nil {
+			return err
+		}
+	}
+	srcDone := make(chan error, 1)
+	go func() {
+		stderr, err := src.ExecuteShellStreaming("cat "+executor.ShellQuote(srcPath), nil, meter.Writer(writer))
+		if err != nil {
+			err = fmt.Errorf("failed to read %s (stderr: %s): %w", srcPath, stderr, err)
+		}
+		writer.CloseWithError(err)
+		srcDone <- err
+	}()
+
+	uploadErr := client.Upload(t.bucket, key, body)
+	reader.CloseWithError(io.ErrClosedPipe)
+	if srcErr := <-srcDone; srcErr != nil {
+		return srcErr
+	}
+	return uploadErr
+}
+
+func (t *s3Transport) relayDownload(client *s3client.Client, dst config.Executor, key string, dstPath string, meter *meter, passphrase string) error {
+	body, err := client.GetObject(t.bucket, key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	contents := meter.Reader(body)
+	if passphrase != "" {
+		if contents, err = decryptReader(contents, passphrase); err != nil {
+			return err
+		}
+	}
+	if stderr, err := dst.ExecuteShellStreaming("cat > "+executor.ShellQuote(dstPath), contents, nil); err != nil {
+		return fmt.Errorf("%w (stderr: %s)", err, strings.TrimSpace(stderr))
+	}
 	return nil
 }
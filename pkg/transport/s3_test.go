@@ -0,0 +1,310 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mrshanahan/deploy-assets/internal/s3test"
+	"github.com/mrshanahan/deploy-assets/pkg/config"
+	"github.com/mrshanahan/deploy-assets/pkg/executor"
+)
+
+func newTestS3Transport(t *testing.T, server *s3test.Server, bucketUrl string, mode string, urlExpiry time.Duration) *s3Transport {
+	server.SetEnv(t)
+	transport, err := NewS3Transport("s3", bucketUrl, server.URL, "", "", mode, urlExpiry, false, 0)
+	if err != nil {
+		t.Fatalf("failed to create transport: %v", err)
+	}
+	return transport.(*s3Transport)
+}
+
+func TestS3TransferFile(t *testing.T) {
+	server := s3test.NewServer(t, "bucket")
+	contents := make([]byte, 200*1024+3)
+	rand.Read(contents)
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "it's src.bin")
+	if err := os.WriteFile(srcPath, contents, 0644); err != nil {
+		t.Fatalf("failed to write source file: %v", err)
+	}
+
+	var tests = []struct {
+		srcTool string
+		dstTool string
+	}{
+		{s3ToolCurl, s3ToolCurl},
+		{s3ToolWget, s3ToolWget},
+		{s3ToolRelay, s3ToolRelay},
+		{s3ToolRelay, s3ToolCurl},
+		{s3ToolCurl, s3ToolRelay},
+	}
+
+	for _, test := range tests {
+		toolName := func(tool string) string {
+			if tool == s3ToolRelay {
+				return "relay"
+			}
+			return tool
+		}
+		name := "src=" + toolName(test.srcTool) + ",dst=" + toolName(test.dstTool)
+		t.Run(name, func(s *testing.T) {
+			for _, tool := range []string{test.srcTool, test.dstTool} {
+				if _, err := exec.LookPath(tool); tool != s3ToolRelay && err != nil {
+					s.Skipf("%s not found on path", tool)
+				}
+			}
+			src, dst := executor.NewLocalExecutor("src", s.TempDir(), 0), executor.NewLocalExecutor("dst", s.TempDir(), 0)
+			defer src.Close()
+			defer dst.Close()
+
+			transport := newTestS3Transport(s, server, "s3://bucket/deploy/", S3ModeAuto, 0)
+			client, err := transport.getClient()
+			if err != nil {
+				s.Fatalf("failed to create client: %v", err)
+			}
+			// Small parts so that relayed uploads go through multipart.
+			client.PartSize = 64 * 1024
+			transport.tools["src"], transport.tools["dst"] = test.srcTool, test.dstTool
+
+			dstPath := filepath.Join(dir, "it's dst.bin")
+			defer os.Remove(dstPath)
+			if err := transport.TransferFile(src, srcPath, dst, dstPath); err != nil {
+				s.Fatalf("transfer failed: %v", err)
+			}
+			actual, err := os.ReadFile(dstPath)
+			if err != nil {
+				s.Fatalf("failed to read transferred file: %v", err)
+			}
+			if !bytes.Equal(actual, contents) {
+				s.Errorf("transferred file differs from source (%d bytes vs %d)", len(actual), len(contents))
+			}
+			if keys := server.Keys("bucket"); len(keys) != 0 {
+				s.Errorf("expected transferred object to be removed, found %v", keys)
+			}
+		})
+	}
+}
+
+func TestS3TransferFileReusing(t *testing.T) {
+	server := s3test.NewServer(t, "bucket")
+	srcPath := filepath.Join(t.TempDir(), "src.bin")
+	if err := os.WriteFile(srcPath, []byte("exported once\n"), 0644); err != nil {
+		t.Fatalf("failed to write source file: %v", err)
+	}
+	src := executor.NewLocalExecutor("src", t.TempDir(), 0)
+	defer src.Close()
+
+	transport := newTestS3Transport(t, server, "s3://bucket/deploy/", S3ModeRelay, 0)
+	for _, name := range []string{"web1", "web2", "web3"} {
+		dst := executor.NewLocalExecutor(name, t.TempDir(), 0)
+		defer dst.Close()
+		dstPath := filepath.Join(t.TempDir(), "dst.bin")
+		if err := transport.TransferFileReusing(src, srcPath, dst, dstPath); err != nil {
+			t.Fatalf("transfer to %s failed: %v", name, err)
+		}
+		if actual, _ := os.ReadFile(dstPath); string(actual) != "exported once\n" {
+			t.Errorf("unexpected contents transferred to %s: %q", name, string(actual))
+		}
+	}
+
+	uploads := 0
+	for _, r := range server.Requests() {
+		if strings.HasPrefix(r, "PUT ") || strings.HasPrefix(r, "POST ") {
+			uploads++
+		}
+	}
+	if uploads != 1 {
+		t.Errorf("expected a single upload for all destinations, got %d", uploads)
+	}
+	if keys := server.Keys("bucket"); len(keys) != 1 {
+		t.Errorf("expected the uploaded object to be kept until released, found %v", keys)
+	}
+	transport.Release(src, srcPath)
+	if keys := server.Keys("bucket"); len(keys) != 0 {
+		t.Errorf("expected released object to be removed, found %v", keys)
+	}
+}
+
+func TestS3EncryptedTransfer(t *testing.T) {
+	server := s3test.NewServer(t, "bucket")
+	contents := bytes.Repeat([]byte("secret config\n"), 10*1024)
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "src.bin")
+	if err := os.WriteFile(srcPath, contents, 0644); err != nil {
+		t.Fatalf("failed to write source file: %v", err)
+	}
+
+	var tests = []struct {
+		name    string
+		srcTool string
+		dstTool string
+	}{
+		{"openssl on both", s3ToolCurl, s3ToolCurl},
+		{"relayed both ways", s3ToolRelay, s3ToolRelay},
+		{"relayed upload", s3ToolRelay, s3ToolCurl},
+		{"relayed download", s3ToolCurl, s3ToolRelay},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(s *testing.T) {
+			for _, tool := range []string{test.srcTool, test.dstTool} {
+				if _, err := exec.LookPath(tool); tool != s3ToolRelay && err != nil {
+					s.Skipf("%s not found on path", tool)
+				}
+			}
+			src, dst := executor.NewLocalExecutor("src", s.TempDir(), 0), executor.NewLocalExecutor("dst", s.TempDir(), 0)
+			defer src.Close()
+			defer dst.Close()
+			for _, e := range []config.Executor{src, dst} {
+				if !hasOpenSSL(e) && (test.srcTool != s3ToolRelay || test.dstTool != s3ToolRelay) {
+					s.Skip("openssl with -pbkdf2 not found on path")
+				}
+			}
+
+			server.SetEnv(s)
+			transport, err := NewS3Transport("s3", "s3://bucket/deploy/", server.URL, "", "", S3ModeAuto, 0, true, 0)
+			if err != nil {
+				s.Fatalf("failed to create transport: %v", err)
+			}
+			s3 := transport.(*s3Transport)
+			s3.tools["src"], s3.tools["dst"] = test.srcTool, test.dstTool
+
+			dstPath := filepath.Join(s.TempDir(), "dst.bin")
+			if err := s3.TransferFileReusing(src, srcPath, dst, dstPath); err != nil {
+				s.Fatalf("transfer failed: %v", err)
+			}
+			defer s3.Release(src, srcPath)
+			if actual, _ := os.ReadFile(dstPath); !bytes.Equal(actual, contents) {
+				s.Errorf("transferred file differs from source (%d bytes vs %d)", len(actual), len(contents))
+			}
+			keys := server.Keys("bucket")
+			if len(keys) != 1 {
+				s.Fatalf("expected a single object in the bucket, found %v", keys)
+			}
+			if object, _ := server.Object("bucket", keys[0]); bytes.Contains(object, []byte("secret config")) {
+				s.Errorf("expected the object in the bucket to be encrypted")
+			}
+			if leftover, _ := os.ReadDir(filepath.Dir(dstPath)); len(leftover) != 1 {
+				s.Errorf("expected only the decrypted file at the destination, found %d files", len(leftover))
+			}
+		})
+	}
+}
+
+func TestS3Validate(t *testing.T) {
+	server := s3test.NewServer(t, "bucket")
+	local := executor.NewLocalExecutor("local", t.TempDir(), 0)
+	defer local.Close()
+
+	transport := newTestS3Transport(t, server, "s3://bucket", S3ModeAuto, 0)
+	if err := transport.Validate(local); err != nil {
+		t.Errorf("expected validation to succeed: %v", err)
+	}
+	if err := transport.Validate(local); err != nil {
+		t.Errorf("expected repeated validation to succeed: %v", err)
+	}
+	headRequests := 0
+	for _, r := range server.Requests() {
+		if r == "HEAD /bucket" {
+			headRequests++
+		}
+	}
+	if headRequests != 1 {
+		t.Errorf("expected bucket to be checked once, got %d checks", headRequests)
+	}
+
+	missing := newTestS3Transport(t, server, "s3://missing-bucket", S3ModeAuto, 0)
+	if err := missing.Validate(local); err == nil {
+		t.Errorf("expected validation to fail for a missing bucket")
+	}
+
+	for _, args := range []struct {
+		bucketUrl string
+		mode      string
+		urlExpiry time.Duration
+	}{
+		{"https://bucket", S3ModeAuto, 0},
+		{"s3://bucket", "sometimes", 0},
+		{"s3://bucket", S3ModePresigned, 8 * 24 * time.Hour},
+	} {
+		if _, err := NewS3Transport("s3", args.bucketUrl, "", "", "", args.mode, args.urlExpiry, false, 0); err == nil {
+			t.Errorf("expected transport with %+v to be rejected", args)
+		}
+	}
+}
+
+// Returns a local executor that can't find curl, wget or rsync, by running
+// with a PATH containing only bash & the basics.
+func newBareLocalExecutor(t *testing.T) config.Executor {
+	binDir := t.TempDir()
+	for _, tool := range []string{"bash", "cat", "which"} {
+		toolPath, err := exec.LookPath(tool)
+		if err != nil {
+			t.Skipf("%s not found on path", tool)
+		}
+		if err := os.Symlink(toolPath, filepath.Join(binDir, tool)); err != nil {
+			t.Fatalf("failed to link %s: %v", tool, err)
+		}
+	}
+	t.Setenv("PATH", binDir)
+	local := executor.NewLocalExecutor("bare", t.TempDir(), 0)
+	t.Cleanup(local.Close)
+	return local
+}
+
+func TestS3PresignedMode(t *testing.T) {
+	if _, err := exec.LookPath("curl"); err != nil {
+		t.Skip("curl not found on path")
+	}
+	server := s3test.NewServer(t, "bucket")
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "src.txt")
+	if err := os.WriteFile(srcPath, []byte("presigned\n"), 0644); err != nil {
+		t.Fatalf("failed to write source file: %v", err)
+	}
+	local := executor.NewLocalExecutor("local", t.TempDir(), 0)
+	defer local.Close()
+
+	transport := newTestS3Transport(t, server, "s3://bucket", S3ModePresigned, 90*time.Second)
+	if err := transport.Validate(local); err != nil {
+		t.Fatalf("expected validation to succeed with curl available: %v", err)
+	}
+	dstPath := filepath.Join(dir, "dst.txt")
+	if err := transport.TransferFile(local, srcPath, local, dstPath); err != nil {
+		t.Fatalf("transfer failed: %v", err)
+	}
+	if actual, _ := os.ReadFile(dstPath); string(actual) != "presigned\n" {
+		t.Errorf("expected transferred contents %q, got %q", "presigned\n", string(actual))
+	}
+	presignedRequests := 0
+	for _, r := range server.Requests() {
+		if strings.Contains(r, "X-Amz-Signature=") {
+			presignedRequests++
+			if !strings.Contains(r, "X-Amz-Expires=90&") {
+				t.Errorf("expected presigned URL to expire after 90s: %s", r)
+			}
+		}
+	}
+	if presignedRequests != 2 {
+		t.Errorf("expected upload & download through presigned URLs, got %d presigned requests", presignedRequests)
+	}
+
+	bare := newBareLocalExecutor(t)
+	err := transport.Validate(bare)
+	if err == nil || !strings.Contains(err.Error(), "neither curl nor wget") {
+		t.Errorf("expected validation to fail without curl or wget, got %v", err)
+	}
+	if err := transport.TransferFile(bare, srcPath, local, dstPath); err == nil {
+		t.Errorf("expected transfer from location without curl or wget to fail")
+	}
+
+	auto := newTestS3Transport(t, server, "s3://bucket", S3ModeAuto, 0)
+	if err := auto.Validate(bare); err != nil {
+		t.Errorf("expected auto mode to fall back to relaying: %v", err)
+	}
+}
@@ -2,22 +2,23 @@ package transport
 
 import (
 	"fmt"
+	"net"
 	"path/filepath"
-	"time"
+	"strconv"
 
 	"github.com/mrshanahan/deploy-assets/internal/sshclient"
 	"github.com/mrshanahan/deploy-assets/internal/util"
 	"github.com/mrshanahan/deploy-assets/pkg/config"
 )
 
-func NewScpTransport(name string, addr string, user string, keyPath string, keyPassphrase string) (config.Transport, error) {
+func NewScpTransport(name string, addr string, user string, keyPath string, keyPassphrase string, insecureSkipHostKeyCheck bool, bandwidthLimit int64) (config.Transport, error) {
 	client, err := sshclient.CreateSshClient(addr, user, keyPath, keyPassphrase)
 	if err != nil {
 		return nil, err
 	}
 	client.Close()
 
-	return &scpTransport{name, addr, user, keyPath, keyPassphrase}, nil
+	return &scpTransport{name, addr, user, keyPath, keyPassphrase, insecureSkipHostKeyCheck, bandwidthLimit}, nil
 }
 
 type scpTransport struct {
@@ -26,6 +27,11 @@ type scpTransport struct {
 	user          string
 	keyPath       string
 	keyPassphrase string
+	// Accept whatever host key the server presents, rather than requiring
+	// it to be in the user's known_hosts.
+	insecureSkipHostKeyCheck bool
+	// In bytes per second; scp itself takes Kbit per second.
+	bandwidthLimit int64
 }
 
 func (t *scpTransport) Yaml(indent int) string {
@@ -34,11 +40,13 @@ func (t *scpTransport) Yaml(indent int) string {
 		`%sscp:
 %sname: %s
 %saddr: %v
-%suser: %s`,
+%suser: %s
+%sinsecure_skip_host_key_check: %t`,
 		util.YamlIndentString(indent),
 		propIndent, t.name,
 		propIndent, t.addr,
-		propIndent, t.user)
+		propIndent, t.user,
+		propIndent, t.insecureSkipHostKeyCheck)
 }
 
 func (t *scpTransport) Validate(exec config.Executor) error {
@@ -50,9 +58,11 @@ func (t *scpTransport) Validate(exec config.Executor) error {
 }
 
 func (t *scpTransport) TransferFile(src config.Executor, srcPath string, dst config.Executor, dstPath string) error {
-	timestamp := time.Now().UnixMicro()
-	dstTmpDirName := fmt.Sprintf("scp_%d", timestamp)
-	dstTmpDirPath := filepath.Join("/tmp/deploy-assets/", dstTmpDirName)
+	stagingDir, err := dst.StagingDir()
+	if err != nil {
+		return fmt.Errorf("failed to get staging directory on remote: %w", err)
+	}
+	dstTmpDirPath := filepath.Join(stagingDir, util.GetRandomFileName("scp"))
 	if _, _, err := dst.ExecuteCommand("mkdir", "-p", dstTmpDirPath); err != nil {
 		return fmt.Errorf("failed to create tmp directory on remote: %w", err)
 	}
@@ -62,15 +72,55 @@ func (t *scpTransport) TransferFile(src config.Executor, srcPath string, dst con
 
 	defer dst.ExecuteCommand("rm", "-rf", dstTmpDirPath)
 
-	if _, _, err := src.ExecuteCommand("scp", "-i", t.keyPath, srcPath, fmt.Sprintf("%s@%s:%s", t.user, t.addr, dstTmpDirPath)); err != nil {
-		return fmt.Errorf("failed to transfer file to remote: %w", err)
+	size, err := fileSize(src, srcPath)
+	if err != nil {
+		return err
 	}
-
 	filename := filepath.Base(srcPath)
 	dstTmpFilePath := filepath.Join(dstTmpDirPath, filename)
+	progress := startProgress(transferLabel(src.Name(), srcPath, dst.Name()), size)
+	defer progress.Finish()
+	stopPolling := progress.poll(dst, dstTmpFilePath)
+
+	scpArgs := t.scpOptions()
+	if limit := bandwidthLimit(t.bandwidthLimit, src, dst); limit > 0 {
+		scpArgs = append(scpArgs, "-l", strconv.FormatInt(max(limit*8/1000, 1), 10))
+	}
+	scpArgs = append(scpArgs, srcPath, fmt.Sprintf("%s@%s:%s", t.user, t.host(), dstTmpDirPath))
+	_, _, err = src.ExecuteCommand("scp", scpArgs...)
+	stopPolling()
+	if err != nil {
+		return fmt.Errorf("failed to transfer file to remote: %w", err)
+	}
+
 	if _, _, err := dst.ExecuteCommand("cp", dstTmpFilePath, dstPath); err != nil {
 		return fmt.Errorf("failed to copy file from temp path to final path on remote: %w", err)
 	}
 
 	return nil
 }
+
+// scp doesn't accept host:port in its destination argument, so any port in
+// the configured address has to be passed separately. Batch mode keeps scp
+// from hanging on a prompt nobody can answer, so hosts missing from
+// known_hosts fail unless host key checking is turned off.
+func (t *scpTransport) scpOptions() []string {
+	opts := []string{
+		"-i", t.keyPath,
+		"-o", "BatchMode=yes",
+	}
+	if t.insecureSkipHostKeyCheck {
+		opts = append(opts, "-o", "StrictHostKeyChecking=no", "-o", "UserKnownHostsFile=/dev/null")
+	}
+	if _, port, err := net.SplitHostPort(t.addr); err == nil {
+		opts = append(opts, "-P", port)
+	}
+	return opts
+}
+
+func (t *scpTransport) host() string {
+	if host, _, err := net.SplitHostPort(t.addr); err == nil {
+		return host
+	}
+	return t.addr
+}
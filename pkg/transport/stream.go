@@ -0,0 +1,80 @@
+package transport
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/mrshanahan/deploy-assets/internal/util"
+	"github.com/mrshanahan/deploy-assets/pkg/config"
+	"github.com/mrshanahan/deploy-assets/pkg/executor"
+)
+
+// Copies files by piping the output of `cat` on the source into `cat` on the
+// destination, relayed through this process. Nothing is stored in between &
+// neither location needs access to the other. The data is metered as it
+// passes through, for progress & to hold it to bandwidthLimit bytes per second
+// (if positive).
+func NewStreamTransport(name string, bandwidthLimit int64) config.Transport {
+	return &streamTransport{name, bandwidthLimit}
+}
+
+type streamTransport struct {
+	name           string
+	bandwidthLimit int64
+}
+
+func (t *streamTransport) Yaml(indent int) string {
+	propIndent := util.YamlIndentString(indent + util.TabsToIndent(1))
+	return fmt.Sprintf(
+		`%sstream:
+%sname: %s`,
+		util.YamlIndentString(indent),
+		propIndent, t.name)
+}
+
+func (t *streamTransport) Validate(exec config.Executor) error {
+	_, _, err := exec.ExecuteShell("which cat")
+	if err != nil {
+		return fmt.Errorf("could not find cat on path: %w", err)
+	}
+	return nil
+}
+
+func (t *streamTransport) TransferFile(src config.Executor, srcPath string, dst config.Executor, dstPath string) error {
+	// Without a size, progress is reported without a total; any real problem
+	// with the file is reported by cat below.
+	size, _ := fileSize(src, srcPath)
+	progress := startProgress(transferLabel(src.Name(), srcPath, dst.Name()), size)
+	defer progress.Finish()
+	meter := newMeter(progress, bandwidthLimit(t.bandwidthLimit, src, dst))
+
+	reader, writer := io.Pipe()
+
+	srcDone := make(chan error, 1)
+	go func() {
+		stderr, err := src.ExecuteShellStreaming("cat "+executor.ShellQuote(srcPath), nil, meter.Writer(writer))
+		if err != nil {
+			err = fmt.Errorf("failed to read %s on %s (stderr: %s): %w", srcPath, src.Name(), stderr, err)
+		}
+		// The destination only sees EOF on success; otherwise its read fails
+		// so that a partial file isn't mistaken for a complete one.
+		writer.CloseWithError(err)
+		srcDone <- err
+	}()
+
+	stderr, dstErr := dst.ExecuteShellStreaming("cat > "+executor.ShellQuote(dstPath), reader, nil)
+	if dstErr != nil {
+		dstErr = fmt.Errorf("failed to write %s on %s (stderr: %s): %w", dstPath, dst.Name(), stderr, dstErr)
+	}
+	// Unblocks the source if the destination stopped reading early.
+	reader.CloseWithError(io.ErrClosedPipe)
+	srcErr := <-srcDone
+
+	if srcErr != nil {
+		// Any destination error is most likely a consequence of this one.
+		slog.Debug("stream transfer failed at source", "src", src.Name(), "dst", dst.Name(), "dst-err", dstErr)
+		return srcErr
+	}
+	return dstErr
+}
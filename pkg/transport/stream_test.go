@@ -0,0 +1,87 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mrshanahan/deploy-assets/internal/sshtest"
+	"github.com/mrshanahan/deploy-assets/pkg/config"
+	"github.com/mrshanahan/deploy-assets/pkg/executor"
+)
+
+func newStreamTestExecutors(t *testing.T) (config.Executor, config.Executor) {
+	server := sshtest.NewServer(t)
+	local := executor.NewLocalExecutor("local", t.TempDir(), 0)
+	t.Cleanup(local.Close)
+	remote, err := executor.NewSSHExecutor("remote", server.Addr(), "deployer", server.KeyFile, "", true, t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("failed to connect to test server: %v", err)
+	}
+	t.Cleanup(remote.Close)
+	return local, remote
+}
+
+func TestStreamTransferFile(t *testing.T) {
+	local, remote := newStreamTestExecutors(t)
+	// Larger than any single pipe or channel buffer, with bytes that would
+	// trip up anything treating the contents as text, & paths that would trip
+	// up anything splicing them into a command unquoted.
+	contents := make([]byte, 3*1024*1024+17)
+	if _, err := rand.Read(contents); err != nil {
+		t.Fatalf("failed to generate contents: %v", err)
+	}
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "it's src.bin")
+	if err := os.WriteFile(srcPath, contents, 0644); err != nil {
+		t.Fatalf("failed to write source file: %v", err)
+	}
+
+	var tests = []struct {
+		name string
+		src  config.Executor
+		dst  config.Executor
+	}{
+		{"local to local", local, local},
+		{"local to ssh", local, remote},
+		{"ssh to local", remote, local},
+		{"ssh to ssh", remote, remote},
+	}
+
+	transport := NewStreamTransport("stream", 0)
+	for _, test := range tests {
+		t.Run(test.name, func(s *testing.T) {
+			dstPath := filepath.Join(dir, "it's dst.bin")
+			defer os.Remove(dstPath)
+			if err := transport.TransferFile(test.src, srcPath, test.dst, dstPath); err != nil {
+				s.Fatalf("transfer failed: %v", err)
+			}
+			actual, err := os.ReadFile(dstPath)
+			if err != nil {
+				s.Fatalf("failed to read transferred file: %v", err)
+			}
+			if !bytes.Equal(actual, contents) {
+				s.Errorf("transferred file differs from source (%d bytes vs %d)", len(actual), len(contents))
+			}
+		})
+	}
+}
+
+func TestStreamTransferFileFailures(t *testing.T) {
+	local, remote := newStreamTestExecutors(t)
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "src.txt")
+	if err := os.WriteFile(srcPath, []byte("contents\n"), 0644); err != nil {
+		t.Fatalf("failed to write source file: %v", err)
+	}
+
+	transport := NewStreamTransport("stream", 0)
+	if err := transport.TransferFile(remote, filepath.Join(dir, "missing.txt"), local, filepath.Join(dir, "out.txt")); err == nil {
+		t.Errorf("expected missing source file to fail")
+	}
+	if err := transport.TransferFile(local, srcPath, remote, filepath.Join(dir, "no-such-dir", "out.txt")); err == nil {
+		t.Errorf("expected unwritable destination to fail")
+	}
+}